@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailVerification(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	assert.False(t, alice.User.EmailVerified, "new accounts are unverified")
+
+	token := server.mailer.token(t, "alice@example.com", "/verify-email")
+	requireStatus(t, alice.do(http.MethodPost, "/api/auth/verify-email", map[string]string{"token": token + "x"}), http.StatusBadRequest)
+
+	// the link works without being signed in
+	requireStatus(t, server.anonymous().do(http.MethodPost, "/api/auth/verify-email", map[string]string{"token": token}), http.StatusOK)
+	alice.loadUser()
+	assert.True(t, alice.User.EmailVerified)
+
+	// but only once
+	requireStatus(t, alice.do(http.MethodPost, "/api/auth/verify-email", map[string]string{"token": token}), http.StatusBadRequest)
+	requireStatus(t, alice.do(http.MethodPost, "/api/auth/verify-email/resend", nil), http.StatusConflict)
+
+	bob := server.register("bob")
+	requireStatus(t, bob.do(http.MethodPost, "/api/auth/verify-email/resend", nil), http.StatusAccepted)
+	assert.Len(t, server.mailer.sent("bob@example.com"), 2)
+	requireStatus(t, server.anonymous().do(http.MethodPost, "/api/auth/verify-email/resend", nil), http.StatusUnauthorized)
+}
+
+func TestPasswordReset(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	otherDevice := server.anonymous()
+	otherDevice.login("alice@example.com", testPassword)
+	bot := server.bot(alice.createAPIToken("bot", constants.SCOPE_READ).Token)
+	bobBot := server.bot(server.register("bob").createAPIToken("bot", constants.SCOPE_READ).Token)
+
+	// unknown emails get the same answer and no email
+	requireStatus(t, server.anonymous().do(http.MethodPost, "/api/auth/forgot-password", map[string]string{"email": "nobody@example.com"}), http.StatusAccepted)
+	assert.Empty(t, server.mailer.sent("nobody@example.com"))
+
+	requireStatus(t, server.anonymous().do(http.MethodPost, "/api/auth/forgot-password", map[string]string{"email": "alice@example.com"}), http.StatusAccepted)
+	token := server.mailer.token(t, "alice@example.com", "/reset-password")
+
+	// a verification link can't reset the password
+	verifyToken := server.mailer.token(t, "bob@example.com", "/verify-email")
+	requireStatus(t, server.anonymous().do(http.MethodPost, "/api/auth/reset-password", map[string]string{
+		"token": verifyToken, "password": "new-password",
+	}), http.StatusBadRequest)
+
+	c := server.anonymous()
+	requireStatus(t, c.do(http.MethodPost, "/api/auth/reset-password", map[string]string{
+		"token": token, "password": "new-password",
+	}), http.StatusOK)
+
+	// every session is signed out
+	requireStatus(t, alice.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+	requireStatus(t, otherDevice.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+	// and API tokens stop working, other users' tokens don't
+	requireStatus(t, bot.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+	requireStatus(t, bobBot.do(http.MethodGet, "/api/users", nil), http.StatusOK)
+
+	requireStatus(t, c.do(http.MethodPost, "/api/auth/login", map[string]string{"email": "alice@example.com", "password": testPassword}), http.StatusUnauthorized)
+	c.login("alice@example.com", "new-password")
+	assert.True(t, c.User.EmailVerified, "resetting by email proves the email")
+
+	// the link works once
+	requireStatus(t, c.do(http.MethodPost, "/api/auth/reset-password", map[string]string{
+		"token": token, "password": "another-password",
+	}), http.StatusBadRequest)
+}
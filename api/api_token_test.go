@@ -0,0 +1,107 @@
+package api_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createdAPIToken is the response to creating a token, the only one with
+// the token itself
+type createdAPIToken struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+func (c *testClient) createAPIToken(name string, scopes ...string) createdAPIToken {
+	c.t.Helper()
+	var created createdAPIToken
+	c.doJSON(http.MethodPost, "/api/auth/tokens", map[string]any{"name": name, "scopes": scopes}, http.StatusCreated, &created)
+	return created
+}
+
+func TestAPITokens(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	target := bob.tweet("like me")
+
+	poster := alice.createAPIToken("poster", constants.SCOPE_TWEET_WRITE, constants.SCOPE_READ, constants.SCOPE_READ)
+	assert.True(t, strings.HasPrefix(poster.Token, constants.API_TOKEN_PREFIX))
+	assert.Equal(t, []string{constants.SCOPE_READ, constants.SCOPE_TWEET_WRITE}, poster.Scopes)
+	reader := alice.createAPIToken("reader", constants.SCOPE_READ)
+
+	t.Run("list", func(t *testing.T) {
+		var tokens []map[string]any
+		alice.getJSON("/api/auth/tokens", &tokens)
+		require.Len(t, tokens, 2)
+		assert.Equal(t, "reader", tokens[0]["name"])
+		assert.NotContains(t, tokens[0], "token", "tokens are only shown once")
+
+		bob.getJSON("/api/auth/tokens", &tokens)
+		assert.Empty(t, tokens)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		requireStatus(t, alice.do(http.MethodPost, "/api/auth/tokens", map[string]any{"name": "bot", "scopes": []string{}}), http.StatusBadRequest)
+		requireStatus(t, alice.do(http.MethodPost, "/api/auth/tokens", map[string]any{"name": "bot", "scopes": []string{"admin"}}), http.StatusBadRequest)
+		requireStatus(t, alice.do(http.MethodPost, "/api/auth/tokens", map[string]any{"scopes": []string{constants.SCOPE_READ}}), http.StatusBadRequest)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		bot := server.bot(poster.Token)
+		var profile models.UserProfile
+		bot.getJSON("/api/users", &profile)
+		assert.Equal(t, alice.User.ID, profile.User.ID)
+		tweet := bot.tweet("posted by a bot")
+		assert.NotEmpty(t, tweet.ID)
+		requireStatus(t, bot.do(http.MethodPost, "/api/tweets/"+target.ID+"/like", nil), http.StatusOK)
+
+		// a read-only token can't write, and no token can reach session-only routes
+		readOnly := server.bot(reader.Token)
+		readOnly.getJSON("/api/tweets/"+tweet.ID, nil)
+		requireStatus(t, readOnly.do(http.MethodPost, "/api/tweets", map[string]string{"content": "hi"}), http.StatusForbidden)
+		requireStatus(t, readOnly.do(http.MethodGet, "/api/notifications/like/"+alice.User.ID, nil), http.StatusForbidden)
+		requireStatus(t, bot.do(http.MethodPut, "/api/users", map[string]string{"name": "Mallory"}), http.StatusForbidden)
+		requireStatus(t, bot.do(http.MethodPost, "/api/auth/tokens", map[string]any{"name": "more", "scopes": []string{constants.SCOPE_READ}}), http.StatusForbidden)
+		requireStatus(t, bot.do(http.MethodGet, "/api/auth/sessions", nil), http.StatusForbidden)
+	})
+
+	t.Run("notifications", func(t *testing.T) {
+		watcher := alice.createAPIToken("watcher", constants.SCOPE_NOTIFICATIONS_READ)
+		likes := server.bot(watcher.Token).stream("/api/notifications/like/" + alice.User.ID)
+		bob.doJSON(http.MethodPost, "/api/tweets/"+alice.tweet("notify me").ID+"/like", nil, http.StatusOK, nil)
+
+		var notification models.Notification
+		likes.next(&notification)
+		assert.Equal(t, bob.User.ID, notification.AuthorUserID)
+	})
+
+	t.Run("bearer token wins over cookies", func(t *testing.T) {
+		// a signed in browser sending a read-only token is limited to it
+		alice.bearer = reader.Token
+		defer func() { alice.bearer = "" }()
+		requireStatus(t, alice.do(http.MethodPost, "/api/tweets", map[string]string{"content": "hi"}), http.StatusForbidden)
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		requireStatus(t, server.bot("nonsense").do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+		forged := poster.Token[:len(poster.Token)-4] + "AAAA"
+		requireStatus(t, server.bot(forged).do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+
+		// optional routes treat them as anonymous
+		requireStatus(t, server.bot("nonsense").do(http.MethodGet, "/api/users/id/"+bob.User.ID, nil), http.StatusOK)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		requireStatus(t, bob.do(http.MethodDelete, "/api/auth/tokens/"+poster.ID, nil), http.StatusNotFound)
+		requireStatus(t, alice.do(http.MethodDelete, "/api/auth/tokens/"+poster.ID, nil), http.StatusOK)
+		requireStatus(t, server.bot(poster.Token).do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+		requireStatus(t, alice.do(http.MethodDelete, "/api/auth/tokens/"+poster.ID, nil), http.StatusNotFound)
+	})
+}
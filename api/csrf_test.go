@@ -0,0 +1,96 @@
+package api_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/aimrintech/x-backend/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forgedPost posts body as c, with only the given headers added, the way a
+// page on another site could make the browser send it
+func forgedPost(c *testClient, path string, body string, header http.Header) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.server.URL+path, bytes.NewReader([]byte(body)))
+	require.NoError(c.t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	res, err := c.http.Do(req)
+	require.NoError(c.t, err)
+	c.t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func forgedTweet(c *testClient, header http.Header) *http.Response {
+	c.t.Helper()
+	return forgedPost(c, "/api/tweets", `{"content": "forged"}`, header)
+}
+
+func TestCSRF(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	token := alice.csrfToken()
+
+	t.Run("token required", func(t *testing.T) {
+		requireStatus(t, forgedTweet(alice, nil), http.StatusForbidden)
+		requireStatus(t, forgedTweet(alice, http.Header{utils.CSRFHeader: {"guess"}}), http.StatusForbidden)
+		requireStatus(t, forgedTweet(alice, http.Header{utils.CSRFHeader: {token}}), http.StatusOK)
+	})
+
+	t.Run("token is kept", func(t *testing.T) {
+		var body struct {
+			CSRFToken string `json:"csrfToken"`
+		}
+		alice.getJSON("/api/auth/csrf", &body)
+		assert.Equal(t, token, body.CSRFToken)
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		tests := map[string]struct {
+			header http.Header
+			status int
+		}{
+			"frontend":        {http.Header{"Origin": {"http://localhost:3000"}}, http.StatusOK},
+			"same origin":     {http.Header{"Origin": {server.URL}}, http.StatusOK},
+			"other site":      {http.Header{"Origin": {"https://evil.example.com"}}, http.StatusForbidden},
+			"opaque origin":   {http.Header{"Origin": {"null"}}, http.StatusForbidden},
+			"frontend page":   {http.Header{"Referer": {"http://localhost:3000/compose"}}, http.StatusOK},
+			"other site page": {http.Header{"Referer": {"https://evil.example.com/x"}}, http.StatusForbidden},
+		}
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				test.header.Set(utils.CSRFHeader, token)
+				requireStatus(t, forgedTweet(alice, test.header), test.status)
+			})
+		}
+	})
+
+	t.Run("bearer tokens are exempt", func(t *testing.T) {
+		// without a valid token, the request fails authentication instead
+		res := forgedTweet(server.anonymous(), http.Header{"Authorization": {"Bearer nonsense"}})
+		requireStatus(t, res, http.StatusUnauthorized)
+	})
+
+	t.Run("safe methods are exempt", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/users", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", "https://evil.example.com")
+		res, err := alice.http.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		requireStatus(t, res, http.StatusOK)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		// signing a victim in to the attacker's account needs a token too
+		c := server.anonymous()
+		login := `{"email": "alice@example.com", "password": "` + testPassword + `"}`
+		requireStatus(t, forgedPost(c, "/api/auth/login", login, nil), http.StatusForbidden)
+		requireStatus(t, forgedPost(c, "/api/auth/login", login, http.Header{utils.CSRFHeader: {c.csrfToken()}}), http.StatusOK)
+	})
+}
@@ -0,0 +1,53 @@
+package api_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/aimrintech/x-backend/services"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMailer keeps the emails the server sends
+type fakeMailer struct {
+	mu     sync.Mutex
+	emails []*services.Email
+}
+
+func (m *fakeMailer) Send(ctx context.Context, email *services.Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emails = append(m.emails, email)
+	return nil
+}
+
+// sent returns the emails sent to to, oldest first
+func (m *fakeMailer) sent(to string) []*services.Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var emails []*services.Email
+	for _, email := range m.emails {
+		if email.To == to {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// token returns the token in the link to the web app page path in the last
+// email sent to to
+func (m *fakeMailer) token(t *testing.T, to string, path string) string {
+	t.Helper()
+	emails := m.sent(to)
+	require.NotEmpty(t, emails, "no email sent to %s", to)
+
+	link, err := url.Parse(linkPattern.FindString(emails[len(emails)-1].Body))
+	require.NoError(t, err)
+	require.Equal(t, path, link.Path)
+	return link.Query().Get("token")
+}
@@ -0,0 +1,208 @@
+package api_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider is a local OAuth provider that answers like Google, GitHub
+// and an OpenID Connect issuer at once. Tests authorize a user with
+// authorize instead of a browser, and the token endpoint enforces PKCE.
+type fakeProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	codes  map[string]fakeGrant
+	tokens map[string]map[string]any
+}
+
+type fakeGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &fakeProvider{key: key, codes: map[string]fakeGrant{}, tokens: map[string]map[string]any{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /userinfo", p.userInfo)
+	mux.HandleFunc("GET /user", p.userInfo)
+	mux.HandleFunc("GET /user/emails", p.gitHubEmails)
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// providers are the providers as the server sees them
+func (p *fakeProvider) providers(t *testing.T) *services.AuthProviders {
+	config := func(name constants.AuthProvider) *oauth2.Config {
+		return &oauth2.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost/api/oauth/" + string(name) + "/callback",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   p.URL + "/authorize",
+				TokenURL:  p.URL + "/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		}
+	}
+
+	google := config(constants.AUTH_PROVIDER_GOOGLE)
+	oidc, err := services.NewOIDCProvider(context.Background(), p.URL, config(constants.AUTH_PROVIDER_OIDC))
+	require.NoError(t, err)
+	return services.NewAuthProviders(
+		&services.OAuthProvider{
+			Name:     constants.AUTH_PROVIDER_GOOGLE,
+			Config:   google,
+			UserInfo: services.NewGoogleUserInfoClient(google, p.URL+"/userinfo"),
+		},
+		services.NewGitHubProvider(config(constants.AUTH_PROVIDER_GITHUB), p.URL),
+		oidc,
+	)
+}
+
+// authorize signs a user in at the provider and returns the code the
+// provider would redirect back with
+func (p *fakeProvider) authorize(challenge string, nonce string, claims map[string]any) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := uuid.New().String()
+	p.codes[code] = fakeGrant{challenge: challenge, nonce: nonce, claims: claims}
+	return code
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	grant, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	// every response carries an ID token, which only the OIDC client reads
+	idClaims := jwt.MapClaims{
+		"iss":   p.URL,
+		"aud":   "client",
+		"nonce": grant.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range grant.claims {
+		idClaims[name] = value
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims)
+	idToken.Header["kid"] = "fake"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken := uuid.New().String()
+	p.tokens[accessToken] = grant.claims
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+// claims returns the account the request's access token was issued for
+func (p *fakeProvider) claims(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	claims, ok := p.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+	return claims, ok
+}
+
+func (p *fakeProvider) userInfo(w http.ResponseWriter, r *http.Request) {
+	if claims, ok := p.claims(w, r); ok {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(claims)
+	}
+}
+
+func (p *fakeProvider) gitHubEmails(w http.ResponseWriter, r *http.Request) {
+	if claims, ok := p.claims(w, r); ok {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(claims["emails"])
+	}
+}
+
+func (p *fakeProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+func (p *fakeProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "fake",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// oauth runs an OAuth flow started at loginPath, signing in at the provider
+// as the account described by claims, and returns the callback's response
+func (c *testClient) oauth(loginPath string, claims map[string]any) *http.Response {
+	c.t.Helper()
+	res := c.do(http.MethodGet, loginPath, nil)
+	requireStatus(c.t, res, http.StatusTemporaryRedirect)
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(c.t, err)
+	query := location.Query()
+	require.Equal(c.t, "S256", query.Get("code_challenge_method"))
+	require.NotEmpty(c.t, query.Get("state"))
+	require.NotEmpty(c.t, query.Get("nonce"))
+
+	code := c.server.provider.authorize(query.Get("code_challenge"), query.Get("nonce"), claims)
+	callback := path.Dir(loginPath) + "/callback?" + url.Values{"state": {query.Get("state")}, "code": {code}}.Encode()
+	return c.do(http.MethodGet, callback, nil)
+}
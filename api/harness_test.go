@@ -0,0 +1,315 @@
+package api_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/api"
+	"github.com/aimrintech/x-backend/config"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores/memory"
+	"github.com/aimrintech/x-backend/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// how long a test waits for an SSE event before failing
+const streamTimeout = 2 * time.Second
+
+const testPassword = "password123"
+
+// testServer runs the API on in-memory stores
+type testServer struct {
+	*httptest.Server
+	t        *testing.T
+	deps     api.Deps
+	api      *api.Server
+	provider *fakeProvider
+	mailer   *fakeMailer
+}
+
+// newTestServer starts a server. options can replace its dependencies.
+func newTestServer(t *testing.T, options ...func(deps *api.Deps)) *testServer {
+	t.Helper()
+
+	db := memory.NewDB()
+	notificationsService := services.NewNotificationsService()
+	feedService := services.NewFeedService()
+	provider := newFakeProvider(t)
+	mailer := &fakeMailer{}
+	deps := api.Deps{
+		UserStore:            memory.NewUserStore(db, notificationsService),
+		TweetStore:           memory.NewTweetStore(db, notificationsService, feedService),
+		NotificationsStore:   memory.NewNotificationsStore(db),
+		SessionStore:         memory.NewSessionStore(db),
+		IdentityStore:        memory.NewIdentityStore(db),
+		TwoFactorStore:       memory.NewTwoFactorStore(db),
+		APITokenStore:        memory.NewAPITokenStore(db),
+		NotificationsService: notificationsService,
+		FeedService:          feedService,
+		AuthProviders:        provider.providers(t),
+		Mailer:               mailer,
+	}
+
+	for _, option := range options {
+		option(&deps)
+	}
+
+	cfg := config.Default()
+	cfg.AppSecret = "test-secret"
+	cfg.JWTIssuer = "https://x.example.com"
+
+	apiServer := api.NewServer(cfg, deps)
+	server := httptest.NewServer(apiServer)
+	t.Cleanup(func() {
+		// end open streams first, Close waits for their requests
+		require.NoError(t, apiServer.Shutdown(context.Background()))
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	return &testServer{Server: server, t: t, deps: deps, api: apiServer, provider: provider, mailer: mailer}
+}
+
+// testClient is a browser-like client with its own cookie jar. User is set
+// once it has signed in. Clients from bot authenticate with a bearer token
+// instead.
+type testClient struct {
+	t      *testing.T
+	server *testServer
+	http   *http.Client
+	User   *models.User
+	bearer string
+}
+
+// anonymous returns a client without a session
+func (s *testServer) anonymous() *testClient {
+	jar, err := cookiejar.New(nil)
+	require.NoError(s.t, err)
+	return &testClient{t: s.t, server: s, http: &http.Client{
+		Jar: jar,
+		// redirects are asserted on, not followed
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+}
+
+// bot returns a client that authenticates with a personal access token
+func (s *testServer) bot(token string) *testClient {
+	c := s.anonymous()
+	c.bearer = token
+	return c
+}
+
+// register signs up a user named username and returns a client signed in as
+// them
+func (s *testServer) register(username string) *testClient {
+	s.t.Helper()
+	c := s.anonymous()
+	res := c.do(http.MethodPost, "/api/auth/register", map[string]string{
+		"username": username,
+		"name":     username,
+		"email":    username + "@example.com",
+		"password": testPassword,
+	})
+	requireStatus(s.t, res, http.StatusOK)
+
+	c.loadUser()
+	return c
+}
+
+// login signs the client in and loads the signed in user
+func (c *testClient) login(email string, password string) {
+	c.t.Helper()
+	res := c.do(http.MethodPost, "/api/auth/login", map[string]string{"email": email, "password": password})
+	requireStatus(c.t, res, http.StatusOK)
+	c.loadUser()
+}
+
+func (c *testClient) loadUser() {
+	c.t.Helper()
+	var profile models.UserProfile
+	c.getJSON("/api/users", &profile)
+	c.User = profile.User
+}
+
+// cookie returns the value of the cookie the client would send to path
+func (c *testClient) cookie(path string, name string) string {
+	c.t.Helper()
+	u, err := url.Parse(c.server.URL + path)
+	require.NoError(c.t, err)
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// setCookie makes the client send a cookie, e.g. one copied from another
+// client
+func (c *testClient) setCookie(path string, name string, value string) {
+	c.t.Helper()
+	u, err := url.Parse(c.server.URL + path)
+	require.NoError(c.t, err)
+	c.http.Jar.SetCookies(u, []*http.Cookie{{Name: name, Value: value, Path: path}})
+}
+
+// do sends a request with body encoded as JSON, if it isn't nil
+func (c *testClient) do(method string, path string, body any) *http.Response {
+	c.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(c.t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.server.URL+path, reader)
+	require.NoError(c.t, err)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	} else if method != http.MethodGet {
+		req.Header.Set(utils.CSRFHeader, c.csrfToken())
+	}
+
+	res, err := c.http.Do(req)
+	require.NoError(c.t, err)
+	c.t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// csrfToken returns the client's CSRF token, fetching one as the web app
+// would if it has none yet
+func (c *testClient) csrfToken() string {
+	c.t.Helper()
+	if token := c.cookie("/", "csrf_token"); token != "" {
+		return token
+	}
+	var body struct {
+		CSRFToken string `json:"csrfToken"`
+	}
+	c.getJSON("/api/auth/csrf", &body)
+	return body.CSRFToken
+}
+
+// doJSON sends a request, requires the given status and decodes the response
+// into out, if it isn't nil
+func (c *testClient) doJSON(method string, path string, body any, status int, out any) {
+	c.t.Helper()
+	res := c.do(method, path, body)
+	requireStatus(c.t, res, status)
+	if out != nil {
+		require.NoError(c.t, json.NewDecoder(res.Body).Decode(out))
+	}
+}
+
+func (c *testClient) getJSON(path string, out any) {
+	c.t.Helper()
+	c.doJSON(http.MethodGet, path, nil, http.StatusOK, out)
+}
+
+func (c *testClient) tweet(content string) models.TweetProps {
+	c.t.Helper()
+	var tweet models.TweetProps
+	c.doJSON(http.MethodPost, "/api/tweets", map[string]string{"content": content}, http.StatusOK, &tweet)
+	return tweet
+}
+
+// stream opens an SSE stream. It returns once the server has subscribed the
+// client, so events published afterwards are delivered.
+func (c *testClient) stream(path string) *eventStream {
+	c.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server.URL+path, nil)
+	require.NoError(c.t, err)
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	}
+	res, err := c.http.Do(req)
+	require.NoError(c.t, err)
+	requireStatus(c.t, res, http.StatusOK)
+	require.Equal(c.t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	lines := make(chan string)
+	go func() {
+		defer res.Body.Close()
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				continue
+			}
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return &eventStream{t: c.t, lines: lines}
+}
+
+// eventStream reads the non-empty lines of an SSE stream
+type eventStream struct {
+	t     *testing.T
+	lines <-chan string
+}
+
+// line returns the next line, or false once the stream has closed
+func (s *eventStream) line() (string, bool) {
+	s.t.Helper()
+	select {
+	case line, ok := <-s.lines:
+		return line, ok
+	case <-time.After(streamTimeout):
+		s.t.Fatal("timed out waiting for the stream")
+		return "", false
+	}
+}
+
+// next decodes the next event into out
+func (s *eventStream) next(out any) {
+	s.t.Helper()
+	for {
+		line, ok := s.line()
+		require.True(s.t, ok, "stream closed")
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			require.NoError(s.t, json.Unmarshal([]byte(data), out))
+			return
+		}
+	}
+}
+
+// requireReconnect requires the server to end the stream with a retry hint
+func (s *eventStream) requireReconnect() {
+	s.t.Helper()
+	line, ok := s.line()
+	require.True(s.t, ok, "stream closed without a retry hint")
+	require.True(s.t, strings.HasPrefix(line, "retry: "), "expected a retry hint, got %q", line)
+	_, ok = s.line()
+	require.False(s.t, ok, "stream still open after the retry hint")
+}
+
+func requireStatus(t *testing.T, res *http.Response, status int) {
+	t.Helper()
+	if res.StatusCode != status {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("%s %s: expected status %d, got %d: %s", res.Request.Method, res.Request.URL.Path, status, res.StatusCode, body)
+	}
+}
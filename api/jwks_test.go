@@ -0,0 +1,48 @@
+package api_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// other services verify access tokens with the published keys alone
+func TestJWKS(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+
+	res := server.anonymous().do(http.MethodGet, "/.well-known/jwks.json", nil)
+	requireStatus(t, res, http.StatusOK)
+	assert.Contains(t, res.Header.Get("Cache-Control"), "max-age")
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+		} `json:"keys"`
+	}
+	alice.getJSON("/.well-known/jwks.json", &set)
+	require.Len(t, set.Keys, 1)
+	key := set.Keys[0]
+	assert.Equal(t, "OKP", key.Kty)
+	assert.Equal(t, "EdDSA", key.Alg)
+	public, err := base64.RawURLEncoding.DecodeString(key.X)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(alice.cookie("/", "token"), claims, func(token *jwt.Token) (any, error) {
+		return ed25519.PublicKey(public), nil
+	}, jwt.WithValidMethods([]string{"EdDSA"}), jwt.WithIssuer("https://x.example.com"), jwt.WithAudience("x-backend"))
+	require.NoError(t, err)
+	assert.Equal(t, key.Kid, token.Header["kid"])
+	assert.Equal(t, alice.User.ID, claims["sub"])
+	assert.NotNil(t, claims["iat"])
+}
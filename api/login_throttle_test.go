@@ -0,0 +1,166 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/api"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withThrottle replaces the login throttle with one using the given policies
+func withThrottle(account services.ThrottlePolicy, ip services.ThrottlePolicy) func(deps *api.Deps) {
+	return func(deps *api.Deps) {
+		deps.LoginThrottle = services.NewLoginThrottle(account, ip)
+	}
+}
+
+var lenientThrottle = services.ThrottlePolicy{FreeAttempts: 1000, LockoutAfter: 1000, ResetAfter: time.Hour}
+
+// loginProblem attempts a login and returns the status and error detail
+func loginProblem(t *testing.T, c *testClient, email string, password string) (int, string) {
+	t.Helper()
+	res := c.do(http.MethodPost, "/api/auth/login", map[string]string{"email": email, "password": password})
+	var problem struct {
+		Detail string `json:"detail"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&problem))
+	return res.StatusCode, problem.Detail
+}
+
+func TestLoginLockout(t *testing.T) {
+	server := newTestServer(t, withThrottle(services.ThrottlePolicy{
+		FreeAttempts:    3,
+		LockoutAfter:    3,
+		LockoutDuration: time.Hour,
+		ResetAfter:      time.Hour,
+	}, lenientThrottle))
+	server.register("alice")
+	c := server.anonymous()
+
+	// an unknown email and a wrong password look the same, lockout included
+	for _, email := range []string{"nobody@example.com", "alice@example.com"} {
+		var answers []string
+		for range 3 {
+			status, detail := loginProblem(t, c, email, "wrong-password")
+			assert.Equal(t, http.StatusUnauthorized, status)
+			answers = append(answers, detail)
+		}
+		assert.Equal(t, []string{"Invalid credentials", "Invalid credentials", "Invalid credentials"}, answers)
+
+		res := c.do(http.MethodPost, "/api/auth/login", map[string]string{"email": email, "password": testPassword})
+		requireStatus(t, res, http.StatusTooManyRequests)
+		assert.Equal(t, "3600", res.Header.Get("Retry-After"))
+	}
+
+	emails := server.mailer.sent("alice@example.com")
+	require.Len(t, emails, 2, "a verification email and a lockout notice")
+	assert.Equal(t, "Suspicious sign in attempts on your account", emails[1].Subject)
+	assert.Empty(t, server.mailer.sent("nobody@example.com"))
+
+	// other accounts are unaffected, and emails are matched case-insensitively
+	server.register("bob")
+	server.anonymous().login("bob@example.com", testPassword)
+	requireStatus(t, c.do(http.MethodPost, "/api/auth/login", map[string]string{"email": "ALICE@example.com", "password": testPassword}), http.StatusTooManyRequests)
+}
+
+func TestLoginThrottlePerIP(t *testing.T) {
+	server := newTestServer(t, withThrottle(lenientThrottle, services.ThrottlePolicy{
+		FreeAttempts:    3,
+		LockoutAfter:    3,
+		LockoutDuration: time.Hour,
+		ResetAfter:      time.Hour,
+	}))
+	server.register("bob")
+
+	// the test clients all share an IP
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		status, _ := loginProblem(t, server.anonymous(), email, "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	}
+	requireStatus(t, server.anonymous().do(http.MethodPost, "/api/auth/login", map[string]string{
+		"email": "bob@example.com", "password": testPassword,
+	}), http.StatusTooManyRequests)
+}
+
+func TestLoginSucceedsAfterFailures(t *testing.T) {
+	server := newTestServer(t)
+	server.register("alice")
+	c := server.anonymous()
+	for range 4 {
+		status, _ := loginProblem(t, c, "alice@example.com", "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	}
+	c.login("alice@example.com", testPassword)
+}
+
+func TestLoginThrottleConcurrentAttempts(t *testing.T) {
+	server := newTestServer(t, withThrottle(services.ThrottlePolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Hour,
+		MaxDelay:     time.Hour,
+		LockoutAfter: 100,
+		ResetAfter:   time.Hour,
+	}, lenientThrottle))
+	server.register("alice")
+
+	// a burst of guesses sent at once gets no more tries than one at a time
+	clients := make([]*testClient, 10)
+	for i := range clients {
+		clients[i] = server.anonymous()
+		clients[i].csrfToken()
+	}
+	statuses := make([]int, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := c.do(http.MethodPost, "/api/auth/login", map[string]string{"email": "alice@example.com", "password": "wrong-password"})
+			statuses[i] = res.StatusCode
+		}()
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{http.StatusUnauthorized: 3, http.StatusTooManyRequests: 7}, counts)
+}
+
+// codes asked for by the signed in 2FA settings count against the same
+// throttle as those entered at sign in
+func TestTwoFactorSettingsThrottle(t *testing.T) {
+	server := newTestServer(t, withThrottle(services.ThrottlePolicy{
+		FreeAttempts:    3,
+		LockoutAfter:    3,
+		LockoutDuration: time.Hour,
+		ResetAfter:      time.Hour,
+	}, lenientThrottle))
+	alice := server.register("alice")
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	alice.doJSON(http.MethodPost, "/api/auth/2fa/enroll", nil, http.StatusOK, &enrollment)
+	alice.doJSON(http.MethodPost, "/api/auth/2fa/confirm", map[string]string{"code": totpCode(t, enrollment.Secret, 0)}, http.StatusOK, nil)
+
+	for _, path := range []string{"/api/auth/2fa/disable", "/api/auth/2fa/recovery-codes", "/api/auth/2fa/disable"} {
+		requireStatus(t, alice.do(http.MethodPost, path, map[string]string{"code": "000000"}), http.StatusForbidden)
+	}
+	res := alice.do(http.MethodPost, "/api/auth/2fa/disable", map[string]string{"code": totpCode(t, enrollment.Secret, 1)})
+	requireStatus(t, res, http.StatusTooManyRequests)
+	assert.Equal(t, "3600", res.Header.Get("Retry-After"))
+
+	var status struct {
+		Enabled bool `json:"enabled"`
+	}
+	alice.getJSON("/api/auth/2fa", &status)
+	assert.True(t, status.Enabled)
+}
@@ -2,9 +2,16 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"net/http"
+	"net/url"
+	"slices"
 
 	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/handlers"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
 	"github.com/aimrintech/x-backend/utils"
 )
 
@@ -17,31 +24,176 @@ func chain(middlewares ...func(http.HandlerFunc) http.HandlerFunc) func(http.Han
 	}
 }
 
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodOptions {
-			if utils.HandleCORSPreflight(w, r) {
+// authenticator checks the credentials a request carries
+type authenticator struct {
+	accessTokens services.AccessTokens
+	revocations  services.RevocationList
+	apiTokens    stores.APITokenStore
+}
+
+var (
+	errNoCredentials      = errors.New("no credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+	errMissingScope       = errors.New("missing scope")
+)
+
+// authenticate returns the request with the caller's user in its context.
+// Browsers authenticate with the access token cookie, which also puts their
+// session in the context. Scripts send a personal access token as a bearer
+// token instead, and cookies are then ignored. The token is only accepted
+// if it has scope, and never when scope is empty.
+func (a *authenticator) authenticate(r *http.Request, scope string) (*http.Request, error) {
+	if bearer, ok := utils.GetBearerToken(r); ok {
+		return a.authenticateAPIToken(r, bearer, scope)
+	}
+
+	tokenString, err := utils.GetAuthCookie(r)
+	if err != nil {
+		return r, errNoCredentials
+	}
+	claims, err := a.accessTokens.Verify(tokenString)
+	if err != nil || a.revocations.IsRevoked(claims.SessionID) {
+		return r, errInvalidCredentials
+	}
+	ctx := context.WithValue(r.Context(), constants.USER_ID_KEY, claims.UserID)
+	ctx = context.WithValue(ctx, constants.SESSION_ID_KEY, claims.SessionID)
+	return r.WithContext(ctx), nil
+}
+
+func (a *authenticator) authenticateAPIToken(r *http.Request, bearer string, scope string) (*http.Request, error) {
+	tokenID, hash, err := utils.ParseAPIToken(bearer)
+	if err != nil {
+		return r, errInvalidCredentials
+	}
+	token, err := a.apiTokens.UseAPIToken(r.Context(), tokenID, hash)
+	if err != nil {
+		return r, errInvalidCredentials
+	}
+	if scope == "" || !token.HasScope(scope) {
+		return r, errMissingScope
+	}
+	return r.WithContext(context.WithValue(r.Context(), constants.USER_ID_KEY, token.UserID)), nil
+}
+
+// writeAuthError answers a request authenticate rejected
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error, scope string) {
+	switch {
+	case errors.Is(err, errNoCredentials):
+		handlers.WriteError(w, r, http.StatusUnauthorized, "Missing authentication token")
+	case errors.Is(err, errMissingScope) && scope == "":
+		handlers.WriteError(w, r, http.StatusForbidden, "API tokens can't be used here, sign in instead")
+	case errors.Is(err, errMissingScope):
+		handlers.WriteError(w, r, http.StatusForbidden, "This token lacks the "+scope+" scope")
+	default:
+		handlers.WriteError(w, r, http.StatusUnauthorized, "Invalid or expired token")
+	}
+}
+
+// authMiddleware rejects requests without a valid session, or a personal
+// access token with scope if it isn't empty
+func authMiddleware(auth *authenticator, scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, err := auth.authenticate(r, scope)
+			if err != nil {
+				writeAuthError(w, r, err, scope)
 				return
 			}
-		}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// optionalAuthMiddleware identifies the caller when valid credentials are
+// present but lets anonymous requests through. A valid token without scope
+// is still rejected, rather than silently treated as anonymous.
+func optionalAuthMiddleware(auth *authenticator, scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, err := auth.authenticate(r, scope)
+			if errors.Is(err, errMissingScope) {
+				writeAuthError(w, r, err, scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
-	})
+// rateLimitMiddleware limits requests per signed in user, or per client IP for
+// anonymous requests
+func rateLimitMiddleware(limiter services.RateLimiter) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + utils.ClientIP(r)
+			if userID, ok := r.Context().Value(constants.USER_ID_KEY).(string); ok {
+				key = "user:" + userID
+			}
+			if !limiter.Allow(key) {
+				handlers.WriteError(w, r, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString, err := utils.GetAuthCookie(r)
-		if err != nil {
-			http.Error(w, "Missing authentication token", http.StatusUnauthorized)
-			return
+// csrfMiddleware stops other sites from making a signed in browser change
+// anything. Requests other than GET, HEAD and OPTIONS must come from the
+// API's own origin or an allowed one, and must copy the CSRF cookie into the
+// CSRF header. Requests authenticated with a bearer token are exempt, since
+// browsers never attach one on their own.
+func csrfMiddleware(allowedOrigins []string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isSafeMethod(r.Method) || hasBearerToken(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !isAllowedOrigin(r, allowedOrigins) {
+				handlers.WriteError(w, r, http.StatusForbidden, "Origin not allowed")
+				return
+			}
+			cookie, err := utils.GetCSRFCookie(r)
+			header := r.Header.Get(utils.CSRFHeader)
+			if err != nil || cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+				handlers.WriteError(w, r, http.StatusForbidden, "Missing or invalid CSRF token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func hasBearerToken(r *http.Request) bool {
+	_, ok := utils.GetBearerToken(r)
+	return ok
+}
+
+// isAllowedOrigin checks the origin the browser says the request came from,
+// falling back to the Referer when there is no Origin header. Requests with
+// neither don't come from a browser page, and are left to the token check.
+func isAllowedOrigin(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
 		}
-		userID, err := utils.ValidateJWT(tokenString)
+		u, err := url.Parse(referer)
 		if err != nil {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-			return
+			return false
 		}
-		ctx := context.WithValue(r.Context(), constants.USER_ID_KEY, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+		origin = u.Scheme + "://" + u.Host
+	}
+	if slices.Contains(allowedOrigins, origin) {
+		return true
+	}
+	// the API's own pages, whatever host it is served on
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && u.Host == r.Host
 }
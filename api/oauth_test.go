@@ -0,0 +1,201 @@
+package api_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireRedirect requires a redirect to the frontend and returns its query
+func requireRedirect(t *testing.T, res *http.Response) url.Values {
+	t.Helper()
+	requireStatus(t, res, http.StatusSeeOther)
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:3000", location.Host)
+	return location.Query()
+}
+
+func TestOAuth(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+
+	t.Run("signs up a new user", func(t *testing.T) {
+		c := server.anonymous()
+		query := requireRedirect(t, c.oauth("/api/oauth/google/login", map[string]any{
+			"id": "google-1", "email": "new@example.com", "verified_email": true, "name": "New User",
+		}))
+		assert.Empty(t, query)
+
+		c.loadUser()
+		assert.Equal(t, "New User", c.User.Name)
+		assert.True(t, strings.HasPrefix(c.User.Username, "new_user_"))
+
+		// the same account signs in as the same user next time
+		again := server.anonymous()
+		requireRedirect(t, again.oauth("/api/oauth/google/login", map[string]any{
+			"id": "google-1", "email": "new@example.com", "verified_email": true,
+		}))
+		again.loadUser()
+		assert.Equal(t, c.User.ID, again.User.ID)
+	})
+
+	t.Run("tolerates odd userinfo fields", func(t *testing.T) {
+		c := server.anonymous()
+		requireRedirect(t, c.oauth("/api/oauth/google/login", map[string]any{
+			"id": 12345, "email": "odd@example.com", "verified_email": true, "name": 42,
+		}))
+		c.loadUser()
+		assert.Equal(t, "odd", c.User.Name)
+	})
+
+	t.Run("makes valid usernames from any name", func(t *testing.T) {
+		names := map[string]string{
+			"accents": "José Müller", "cjk": "山田太郎", "long": strings.Repeat("Bartholomew ", 8), "reserved": "admin",
+		}
+		for id, name := range names {
+			c := server.anonymous()
+			requireRedirect(t, c.oauth("/api/oauth/google/login", map[string]any{
+				"id": "google-" + id, "email": id + "@example.com", "verified_email": true, "name": name,
+			}))
+			c.loadUser()
+			assert.Equal(t, name, c.User.Name)
+			assert.NoError(t, utils.ValidateUsername(c.User.Username), c.User.Username)
+			switch id {
+			case "accents":
+				assert.True(t, strings.HasPrefix(c.User.Username, "jose_muller_"), c.User.Username)
+			case "cjk":
+				assert.True(t, strings.HasPrefix(c.User.Username, "user_"), c.User.Username)
+			}
+		}
+	})
+
+	t.Run("does not sign into an existing account by email", func(t *testing.T) {
+		c := server.anonymous()
+		query := requireRedirect(t, c.oauth("/api/oauth/google/login", map[string]any{
+			"id": "google-2", "email": "alice@example.com", "verified_email": true, "name": "Alice",
+		}))
+		assert.Equal(t, "account_exists", query.Get("oauth_error"))
+		requireStatus(t, c.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+	})
+
+	t.Run("rejects unverified emails", func(t *testing.T) {
+		res := server.anonymous().oauth("/api/oauth/google/login", map[string]any{
+			"id": "google-3", "email": "unverified@example.com", "verified_email": false,
+		})
+		requireStatus(t, res, http.StatusBadRequest)
+	})
+
+	t.Run("links an account explicitly", func(t *testing.T) {
+		query := requireRedirect(t, alice.oauth("/api/oauth/google/link", map[string]any{
+			"id": "google-2", "email": "alice@gmail.com", "verified_email": true,
+		}))
+		assert.Equal(t, "google", query.Get("linked"))
+
+		var identities []models.AuthIdentity
+		alice.getJSON("/api/auth/identities", &identities)
+		require.Len(t, identities, 1)
+		assert.Equal(t, "google", identities[0].Provider)
+		assert.Equal(t, "alice@gmail.com", identities[0].Email)
+
+		c := server.anonymous()
+		requireRedirect(t, c.oauth("/api/oauth/google/login", map[string]any{
+			"id": "google-2", "email": "alice@gmail.com", "verified_email": true,
+		}))
+		c.loadUser()
+		assert.Equal(t, alice.User.ID, c.User.ID)
+
+		// nobody else can link it
+		bob := server.register("bob")
+		query = requireRedirect(t, bob.oauth("/api/oauth/google/link", map[string]any{
+			"id": "google-2", "email": "alice@gmail.com", "verified_email": true,
+		}))
+		assert.Equal(t, "identity_in_use", query.Get("oauth_error"))
+
+		requireStatus(t, server.anonymous().do(http.MethodGet, "/api/oauth/google/link", nil), http.StatusUnauthorized)
+	})
+
+	t.Run("rejects a forged state", func(t *testing.T) {
+		c := server.anonymous()
+		res := c.do(http.MethodGet, "/api/oauth/google/login", nil)
+		requireStatus(t, res, http.StatusTemporaryRedirect)
+		location, err := url.Parse(res.Header.Get("Location"))
+		require.NoError(t, err)
+		code := server.provider.authorize(location.Query().Get("code_challenge"), location.Query().Get("nonce"), map[string]any{
+			"id": "google-4", "email": "forged@example.com", "verified_email": true,
+		})
+
+		requireStatus(t, c.do(http.MethodGet, "/api/oauth/google/callback?state=forged&code="+code, nil), http.StatusBadRequest)
+		// a callback from a browser that didn't start the flow
+		res = server.anonymous().do(http.MethodGet, "/api/oauth/google/callback?"+url.Values{
+			"state": {location.Query().Get("state")}, "code": {code},
+		}.Encode(), nil)
+		requireStatus(t, res, http.StatusBadRequest)
+	})
+}
+
+func TestOAuthProviders(t *testing.T) {
+	server := newTestServer(t)
+
+	var names []string
+	server.anonymous().getJSON("/api/oauth/providers", &names)
+	assert.Equal(t, []string{"github", "google", "oidc"}, names)
+
+	requireStatus(t, server.anonymous().do(http.MethodGet, "/api/oauth/myspace/login", nil), http.StatusNotFound)
+
+	t.Run("GitHub", func(t *testing.T) {
+		c := server.anonymous()
+		requireRedirect(t, c.oauth("/api/oauth/github/login", map[string]any{
+			"id": 583231, "login": "octocat", "name": nil,
+			"emails": []map[string]any{
+				{"email": "octocat@users.noreply.github.com", "primary": false, "verified": true},
+				{"email": "octocat@example.com", "primary": true, "verified": true},
+			},
+		}))
+		c.loadUser()
+		assert.Equal(t, "octocat", c.User.Name)
+		assert.Equal(t, "octocat@example.com", c.User.Email)
+
+		var identities []models.AuthIdentity
+		c.getJSON("/api/auth/identities", &identities)
+		require.Len(t, identities, 1)
+		assert.Equal(t, "github", identities[0].Provider)
+	})
+
+	t.Run("OpenID Connect", func(t *testing.T) {
+		c := server.anonymous()
+		requireRedirect(t, c.oauth("/api/oauth/oidc/login", map[string]any{
+			"sub": "oidc-1", "email": "ada@example.com", "email_verified": true, "name": "Ada",
+		}))
+		c.loadUser()
+		assert.Equal(t, "Ada", c.User.Name)
+
+		// the same email at another provider is another identity
+		res := server.anonymous().oauth("/api/oauth/google/login", map[string]any{
+			"id": "google-ada", "email": "ada@example.com", "verified_email": true,
+		})
+		assert.Equal(t, "account_exists", requireRedirect(t, res).Get("oauth_error"))
+	})
+
+	t.Run("state is bound to the provider", func(t *testing.T) {
+		c := server.anonymous()
+		res := c.do(http.MethodGet, "/api/oauth/google/login", nil)
+		requireStatus(t, res, http.StatusTemporaryRedirect)
+		location, err := url.Parse(res.Header.Get("Location"))
+		require.NoError(t, err)
+		code := server.provider.authorize(location.Query().Get("code_challenge"), location.Query().Get("nonce"), map[string]any{
+			"sub": "oidc-2", "email": "eve@example.com", "email_verified": true,
+		})
+
+		res = c.do(http.MethodGet, "/api/oauth/oidc/callback?"+url.Values{
+			"state": {location.Query().Get("state")}, "code": {code},
+		}.Encode(), nil)
+		requireStatus(t, res, http.StatusBadRequest)
+	})
+}
@@ -1,20 +1,45 @@
 package api
 
 import (
-	"context"
 	"net/http"
 
+	"github.com/aimrintech/x-backend/config"
+	"github.com/aimrintech/x-backend/constants"
 	"github.com/aimrintech/x-backend/handlers"
 	"github.com/aimrintech/x-backend/services"
-	"github.com/aimrintech/x-backend/stores"
-	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
-	"golang.org/x/oauth2"
 )
 
-var chainMiddleware = chain(corsMiddleware, authMiddleware)
+// routeMiddleware is the middleware routes are wrapped in, built from the
+// server config. auth and optionalAuth need a signed in session; the scoped
+// variants also accept personal access tokens with the given scope.
+type routeMiddleware struct {
+	auth              func(http.HandlerFunc) http.HandlerFunc
+	optionalAuth      func(http.HandlerFunc) http.HandlerFunc
+	authScope         func(scope string) func(http.HandlerFunc) http.HandlerFunc
+	optionalAuthScope func(scope string) func(http.HandlerFunc) http.HandlerFunc
+}
+
+func newRouteMiddleware(cfg *config.Config, deps Deps) routeMiddleware {
+	auth := &authenticator{
+		accessTokens: deps.AccessTokens,
+		revocations:  deps.RevocationList,
+		apiTokens:    deps.APITokenStore,
+	}
+	return routeMiddleware{
+		auth:         authMiddleware(auth, ""),
+		optionalAuth: optionalAuthMiddleware(auth, ""),
+		authScope: func(scope string) func(http.HandlerFunc) http.HandlerFunc {
+			return authMiddleware(auth, scope)
+		},
+		optionalAuthScope: func(scope string) func(http.HandlerFunc) http.HandlerFunc {
+			return optionalAuthMiddleware(auth, scope)
+		},
+	}
+}
 
-func setupMux(db *neo4j.DriverWithContext, dbCtx *context.Context, authConfig *oauth2.Config) *http.ServeMux {
+func setupMux(cfg *config.Config, deps Deps) *http.ServeMux {
 	router := http.NewServeMux()
+	mw := newRouteMiddleware(cfg, deps)
 
 	// Health check
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -22,63 +47,112 @@ func setupMux(db *neo4j.DriverWithContext, dbCtx *context.Context, authConfig *o
 		w.Write([]byte("OK"))
 	})
 
-	// Setup services
-	notificationsService := services.NewNotificationsService()
-	feedService := services.NewFeedService()
-
 	// User routes
-	userStore := stores.NewUserStore(db, dbCtx, notificationsService)
-	userHandlers := handlers.NewUserHandlers(&userStore)
-	setupUserRoutes(router, userHandlers)
+	userHandlers := handlers.NewUserHandlers(&deps.UserStore, &deps.TweetStore, deps.ContentFilterChanges)
+	setupUserRoutes(router, mw, userHandlers, deps.UsernameCheckLimiter)
 
 	// Auth routes
-	authHandlers := handlers.NewAuthHandlers(&userStore)
-	setupAuthRoutes(router, authHandlers, authConfig)
+	authHandlers := handlers.NewAuthHandlers(&deps.UserStore, &deps.SessionStore, &deps.IdentityStore, &deps.TwoFactorStore, &deps.APITokenStore, deps.AccessTokens, deps.RevocationList, deps.LoginThrottle, deps.AuthProviders, deps.Mailer, cfg)
+	setupAuthRoutes(router, mw, authHandlers)
+
+	// API token routes
+	apiTokenHandlers := handlers.NewAPITokenHandlers(&deps.APITokenStore)
+	setupAPITokenRoutes(router, mw, apiTokenHandlers)
 
 	// Tweet routes
-	tweetStore := stores.NewTweetStore(db, dbCtx, notificationsService, feedService)
-	tweetHandlers := handlers.NewTweetHandlers(&tweetStore)
-	setupTweetRoutes(router, tweetHandlers)
+	tweetHandlers := handlers.NewTweetHandlers(&deps.TweetStore, deps.TimelineService)
+	setupTweetRoutes(router, mw, tweetHandlers)
 
 	// Notifications routes
-	notificationsStore := stores.NewNotificationsStore(db, dbCtx)
-	notificationsHandlers := handlers.NewNotificationsHandlers(notificationsService, notificationsStore)
-	setupNotificationsRoutes(router, notificationsHandlers)
+	notificationsHandlers := handlers.NewNotificationsHandlers(deps.NotificationsService, deps.NotificationsStore, &deps.UserStore, deps.ContentFilterChanges)
+	setupNotificationsRoutes(router, mw, notificationsHandlers)
 
 	// Feed routes
-	feedHandlers := handlers.NewFeedHandlers(feedService)
-	setupFeedRoutes(router, feedHandlers)
+	feedHandlers := handlers.NewFeedHandlers(deps.FeedService, &deps.UserStore, deps.ContentFilterChanges)
+	setupFeedRoutes(router, mw, feedHandlers)
 
 	return router
 }
 
-func setupAuthRoutes(router *http.ServeMux, authHandlers *handlers.AuthHandlers, authConfig *oauth2.Config) {
-	router.HandleFunc("POST /api/auth/login", corsMiddleware(authHandlers.Login))
-	router.HandleFunc("POST /api/auth/register", corsMiddleware(authHandlers.Register))
-	router.HandleFunc("GET /api/oauth/login", corsMiddleware(authHandlers.OAuthLoginHandler(authConfig)))
-	router.HandleFunc("GET /api/oauth/callback", corsMiddleware(authHandlers.OAuthCallbackHandler(authConfig)))
-	router.HandleFunc("POST /api/auth/logout", corsMiddleware(authHandlers.Logout))
+func setupAuthRoutes(router *http.ServeMux, mw routeMiddleware, authHandlers *handlers.AuthHandlers) {
+	router.HandleFunc("POST /api/auth/login", authHandlers.Login)
+	router.HandleFunc("POST /api/auth/login/2fa", authHandlers.LoginTwoFactor)
+	router.HandleFunc("POST /api/auth/register", authHandlers.Register)
+	router.HandleFunc("GET /api/oauth/providers", authHandlers.GetOAuthProviders)
+	router.HandleFunc("GET /api/oauth/{provider}/login", authHandlers.OAuthLogin)
+	router.HandleFunc("GET /api/oauth/{provider}/link", mw.auth(authHandlers.OAuthLink))
+	router.HandleFunc("GET /api/oauth/{provider}/callback", mw.optionalAuth(authHandlers.OAuthCallback))
+	router.HandleFunc("GET /api/auth/identities", mw.auth(authHandlers.GetIdentities))
+	router.HandleFunc("POST /api/auth/forgot-password", authHandlers.ForgotPassword)
+	router.HandleFunc("POST /api/auth/reset-password", authHandlers.ResetPassword)
+	router.HandleFunc("POST /api/auth/verify-email", authHandlers.VerifyEmail)
+	router.HandleFunc("POST /api/auth/verify-email/resend", mw.auth(authHandlers.ResendVerification))
+	router.HandleFunc("GET /.well-known/jwks.json", authHandlers.GetJWKS)
+	router.HandleFunc("GET /api/auth/csrf", authHandlers.GetCSRFToken)
+	router.HandleFunc("POST /api/auth/refresh", authHandlers.Refresh)
+	router.HandleFunc("POST /api/auth/logout", mw.optionalAuth(authHandlers.Logout))
+	router.HandleFunc("GET /api/auth/2fa", mw.auth(authHandlers.GetTwoFactor))
+	router.HandleFunc("POST /api/auth/2fa/enroll", mw.auth(authHandlers.EnrollTwoFactor))
+	router.HandleFunc("POST /api/auth/2fa/confirm", mw.auth(authHandlers.ConfirmTwoFactor))
+	router.HandleFunc("POST /api/auth/2fa/disable", mw.auth(authHandlers.DisableTwoFactor))
+	router.HandleFunc("POST /api/auth/2fa/recovery-codes", mw.auth(authHandlers.RegenerateRecoveryCodes))
+	router.HandleFunc("GET /api/auth/sessions", mw.auth(authHandlers.GetSessions))
+	router.HandleFunc("DELETE /api/auth/sessions/others", mw.auth(authHandlers.RevokeOtherSessions))
+	router.HandleFunc("DELETE /api/auth/sessions/{id}", mw.auth(authHandlers.RevokeSession))
+}
+
+// API tokens can only be managed from a signed in session
+func setupAPITokenRoutes(router *http.ServeMux, mw routeMiddleware, apiTokenHandlers *handlers.APITokenHandlers) {
+	router.HandleFunc("GET /api/auth/tokens", mw.auth(apiTokenHandlers.GetAPITokens))
+	router.HandleFunc("POST /api/auth/tokens", mw.auth(apiTokenHandlers.CreateAPIToken))
+	router.HandleFunc("DELETE /api/auth/tokens/{id}", mw.auth(apiTokenHandlers.RevokeAPIToken))
 }
 
-func setupUserRoutes(router *http.ServeMux, userHandlers *handlers.UserHandlers) {
-	router.HandleFunc("GET /api/users/id/{id}", corsMiddleware(userHandlers.GetUserByID))
-	router.HandleFunc("GET /api/users/username/{username}", corsMiddleware(userHandlers.GetUserByUsername))
-	router.HandleFunc("GET /api/users", chainMiddleware(userHandlers.GetCurrentUser))
-	router.HandleFunc("PUT /api/users", chainMiddleware(userHandlers.UpdateUser))
+func setupUserRoutes(router *http.ServeMux, mw routeMiddleware, userHandlers *handlers.UserHandlers, usernameCheckLimiter services.RateLimiter) {
+	router.HandleFunc("GET /api/users/id/{id}", mw.optionalAuthScope(constants.SCOPE_READ)(userHandlers.GetUserByID))
+	router.HandleFunc("GET /api/users/username/{username}", mw.optionalAuthScope(constants.SCOPE_READ)(userHandlers.GetUserByUsername))
+	router.HandleFunc("GET /api/users/username-available", chain(mw.optionalAuthScope(constants.SCOPE_READ), rateLimitMiddleware(usernameCheckLimiter))(userHandlers.CheckUsernameAvailability))
+	router.HandleFunc("PUT /api/users/me/username", mw.auth(userHandlers.ChangeUsername))
+	router.HandleFunc("GET /api/users", mw.authScope(constants.SCOPE_READ)(userHandlers.GetCurrentUser))
+	router.HandleFunc("PUT /api/users", mw.auth(userHandlers.UpdateUser))
+	router.HandleFunc("PUT /api/users/me/pinned", mw.auth(userHandlers.PinTweet))
+	router.HandleFunc("DELETE /api/users/me/pinned", mw.auth(userHandlers.UnpinTweet))
+	router.HandleFunc("POST /api/users/{id}/follow", mw.auth(userHandlers.FollowUser))
+	router.HandleFunc("DELETE /api/users/{id}/follow", mw.auth(userHandlers.UnfollowUser))
+	router.HandleFunc("GET /api/follow-requests", mw.auth(userHandlers.GetFollowRequests))
+	router.HandleFunc("POST /api/follow-requests/{id}/approve", mw.auth(userHandlers.ApproveFollowRequest))
+	router.HandleFunc("POST /api/follow-requests/{id}/deny", mw.auth(userHandlers.DenyFollowRequest))
+	router.HandleFunc("POST /api/users/{id}/block", mw.auth(userHandlers.BlockUser))
+	router.HandleFunc("DELETE /api/users/{id}/block", mw.auth(userHandlers.UnblockUser))
+	router.HandleFunc("GET /api/blocks", mw.auth(userHandlers.GetBlockedUsers))
+	router.HandleFunc("GET /api/mutes", mw.auth(userHandlers.GetMutes))
+	router.HandleFunc("POST /api/mutes/users/{id}", mw.auth(userHandlers.MuteUser))
+	router.HandleFunc("DELETE /api/mutes/users/{id}", mw.auth(userHandlers.UnmuteUser))
+	router.HandleFunc("POST /api/mutes/words", mw.auth(userHandlers.AddMutedWord))
+	router.HandleFunc("DELETE /api/mutes/words/{id}", mw.auth(userHandlers.RemoveMutedWord))
+	router.HandleFunc("POST /api/mutes/conversations/{id}", mw.auth(userHandlers.MuteConversation))
+	router.HandleFunc("DELETE /api/mutes/conversations/{id}", mw.auth(userHandlers.UnmuteConversation))
 }
 
-func setupTweetRoutes(router *http.ServeMux, tweetHandlers *handlers.TweetHandlers) {
-	router.HandleFunc("GET /api/tweets", chainMiddleware(tweetHandlers.GetUsersWithTweets))
-	router.HandleFunc("GET /api/tweets/{id}", chainMiddleware(tweetHandlers.GetTweetByID))
-	router.HandleFunc("POST /api/tweets", chainMiddleware(tweetHandlers.CreateTweet))
-	router.HandleFunc("POST /api/tweets/{id}/like", chainMiddleware(tweetHandlers.LikeTweet))
-	router.HandleFunc("POST /api/tweets/{id}/unlike", chainMiddleware(tweetHandlers.UnlikeTweet))
+func setupTweetRoutes(router *http.ServeMux, mw routeMiddleware, tweetHandlers *handlers.TweetHandlers) {
+	router.HandleFunc("GET /api/tweets", mw.authScope(constants.SCOPE_READ)(tweetHandlers.GetUsersWithTweets))
+	router.HandleFunc("GET /api/tweets/for-you", mw.authScope(constants.SCOPE_READ)(tweetHandlers.GetForYouTimeline))
+	router.HandleFunc("GET /api/tweets/{id}", mw.authScope(constants.SCOPE_READ)(tweetHandlers.GetTweetByID))
+	router.HandleFunc("POST /api/tweets", mw.authScope(constants.SCOPE_TWEET_WRITE)(tweetHandlers.CreateTweet))
+	router.HandleFunc("POST /api/tweets/{id}/like", mw.authScope(constants.SCOPE_TWEET_WRITE)(tweetHandlers.LikeTweet))
+	router.HandleFunc("POST /api/tweets/{id}/unlike", mw.authScope(constants.SCOPE_TWEET_WRITE)(tweetHandlers.UnlikeTweet))
+	// the profile tabs (tweets, replies, media, likes) share one pattern,
+	// since /api/users/{id}/tweets would conflict with the /api/users/id/{id}
+	// lookup while /api/users/{id}/{tab} is just less specific than it
+	router.HandleFunc("GET /api/users/{id}/{tab}", mw.authScope(constants.SCOPE_READ)(tweetHandlers.GetProfileTimeline))
+	router.HandleFunc("POST /api/tweets/{id}/replies", mw.authScope(constants.SCOPE_TWEET_WRITE)(tweetHandlers.ReplyToTweet))
+	router.HandleFunc("GET /api/tweets/{id}/replies", mw.authScope(constants.SCOPE_READ)(tweetHandlers.GetReplies))
 }
 
-func setupNotificationsRoutes(router *http.ServeMux, notificationsHandlers *handlers.NotificationsHandlers) {
-	router.HandleFunc("GET /api/notifications/{type}/{userID}", notificationsHandlers.StreamNotifications)
+func setupNotificationsRoutes(router *http.ServeMux, mw routeMiddleware, notificationsHandlers *handlers.NotificationsHandlers) {
+	router.HandleFunc("GET /api/notifications/{type}/{userID}", mw.authScope(constants.SCOPE_NOTIFICATIONS_READ)(notificationsHandlers.StreamNotifications))
 }
 
-func setupFeedRoutes(router *http.ServeMux, feedHandlers *handlers.FeedHandlers) {
-	router.HandleFunc("GET /api/feed", authMiddleware(feedHandlers.StreamFeed))
+func setupFeedRoutes(router *http.ServeMux, mw routeMiddleware, feedHandlers *handlers.FeedHandlers) {
+	router.HandleFunc("GET /api/feed", mw.authScope(constants.SCOPE_READ)(feedHandlers.StreamFeed))
 }
@@ -0,0 +1,475 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	assert.Equal(t, "alice", alice.User.Username)
+	assert.Equal(t, "alice@example.com", alice.User.Email)
+
+	t.Run("register rejects a taken email", func(t *testing.T) {
+		res := server.anonymous().do(http.MethodPost, "/api/auth/register", map[string]string{
+			"username": "alice2",
+			"name":     "alice",
+			"email":    "alice@example.com",
+			"password": testPassword,
+		})
+		requireStatus(t, res, http.StatusConflict)
+		assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+	})
+
+	t.Run("register validates the body", func(t *testing.T) {
+		var problem struct {
+			Errors map[string]string `json:"errors"`
+		}
+		server.anonymous().doJSON(http.MethodPost, "/api/auth/register", map[string]string{
+			"username": "bob",
+			"name":     "bob",
+			"email":    "not an email",
+			"password": "short",
+		}, http.StatusBadRequest, &problem)
+		assert.Contains(t, problem.Errors, "email")
+		assert.Contains(t, problem.Errors, "password")
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		res := server.anonymous().do(http.MethodPost, "/api/auth/login", map[string]string{
+			"email":    "alice@example.com",
+			"password": "wrongpassword",
+		})
+		requireStatus(t, res, http.StatusUnauthorized)
+	})
+
+	t.Run("protected routes need a session", func(t *testing.T) {
+		requireStatus(t, server.anonymous().do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+		requireStatus(t, server.anonymous().do(http.MethodPost, "/api/tweets", map[string]string{"content": "hi"}), http.StatusUnauthorized)
+	})
+
+	t.Run("logout ends the session", func(t *testing.T) {
+		c := server.anonymous()
+		c.login("alice@example.com", testPassword)
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/logout", nil), http.StatusOK)
+		requireStatus(t, c.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	server := newTestServer(t)
+
+	t.Run("refresh rotates the refresh token", func(t *testing.T) {
+		alice := server.register("alice")
+		before := alice.cookie("/api/auth", "refresh_token")
+		require.NotEmpty(t, before)
+		assert.Empty(t, alice.cookie("/api/users", "refresh_token"), "the refresh token is only sent to the auth routes")
+
+		requireStatus(t, alice.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusOK)
+		after := alice.cookie("/api/auth", "refresh_token")
+		assert.NotEqual(t, before, after)
+		alice.getJSON("/api/users", nil)
+	})
+
+	t.Run("reusing a refresh token revokes the session", func(t *testing.T) {
+		bob := server.register("bob")
+		stolen := bob.cookie("/api/auth", "refresh_token")
+		requireStatus(t, bob.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusOK)
+
+		thief := server.anonymous()
+		thief.setCookie("/api/auth", "refresh_token", stolen)
+		requireStatus(t, thief.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+
+		// the legitimate client is signed out too, access token included
+		requireStatus(t, bob.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+		requireStatus(t, bob.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+	})
+
+	t.Run("logout revokes copied tokens", func(t *testing.T) {
+		carol := server.register("carol")
+		copied := server.anonymous()
+		copied.setCookie("/", "token", carol.cookie("/", "token"))
+		copied.setCookie("/api/auth", "refresh_token", carol.cookie("/api/auth", "refresh_token"))
+		copied.getJSON("/api/users", nil)
+
+		requireStatus(t, carol.do(http.MethodPost, "/api/auth/logout", nil), http.StatusOK)
+		requireStatus(t, copied.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+		requireStatus(t, copied.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+	})
+
+	t.Run("garbage refresh tokens are rejected", func(t *testing.T) {
+		c := server.anonymous()
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+		c.setCookie("/api/auth", "refresh_token", "not-a-token")
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+	})
+}
+
+func TestSessionManagement(t *testing.T) {
+	server := newTestServer(t)
+	laptop := server.register("alice")
+	phone := server.anonymous()
+	phone.login("alice@example.com", testPassword)
+	tablet := server.anonymous()
+	tablet.login("alice@example.com", testPassword)
+
+	var sessions []models.Session
+	laptop.getJSON("/api/auth/sessions", &sessions)
+	require.Len(t, sessions, 3)
+	current := 0
+	for _, session := range sessions {
+		assert.Equal(t, "Go-http-client/1.1", session.UserAgent)
+		assert.Equal(t, "127.0.0.1", session.IP)
+		assert.False(t, session.CreatedAt.IsZero())
+		assert.False(t, session.LastSeenAt.IsZero())
+		if session.Current {
+			current++
+		}
+	}
+	assert.Equal(t, 1, current)
+
+	// sign the lost phone out from the laptop
+	var phoneSessions []models.Session
+	phone.getJSON("/api/auth/sessions", &phoneSessions)
+	var phoneID string
+	for _, session := range phoneSessions {
+		if session.Current {
+			phoneID = session.ID
+		}
+	}
+	require.NotEmpty(t, phoneID)
+	laptop.doJSON(http.MethodDelete, "/api/auth/sessions/"+phoneID, nil, http.StatusOK, nil)
+	requireStatus(t, phone.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+	requireStatus(t, phone.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+
+	// other users' sessions can't be revoked
+	bob := server.register("bob")
+	requireStatus(t, bob.do(http.MethodDelete, "/api/auth/sessions/"+sessions[0].ID, nil), http.StatusNotFound)
+
+	// sign out everywhere else
+	var result map[string]int
+	laptop.doJSON(http.MethodDelete, "/api/auth/sessions/others", nil, http.StatusOK, &result)
+	assert.Equal(t, 1, result["revoked"])
+	requireStatus(t, tablet.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+	laptop.getJSON("/api/auth/sessions", &sessions)
+	require.Len(t, sessions, 1)
+	assert.True(t, sessions[0].Current)
+
+	requireStatus(t, server.anonymous().do(http.MethodGet, "/api/auth/sessions", nil), http.StatusUnauthorized)
+}
+
+func TestPublicProfiles(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	anonymous := server.anonymous()
+
+	// profiles can be looked up without signing in, and never leak the email
+	for _, path := range []string{"/api/users/id/" + alice.User.ID, "/api/users/username/alice"} {
+		var profile map[string]any
+		anonymous.getJSON(path, &profile)
+		assert.Equal(t, alice.User.ID, profile["id"], path)
+		assert.NotContains(t, profile, "email", path)
+		assert.NotContains(t, profile, "password", path)
+	}
+
+	requireStatus(t, anonymous.do(http.MethodGet, "/api/users/id/missing", nil), http.StatusNotFound)
+
+	// signed in viewers see how they relate to the user
+	bob.doJSON(http.MethodPost, "/api/users/"+alice.User.ID+"/follow", nil, http.StatusOK, nil)
+	var profile models.PublicProfile
+	bob.getJSON("/api/users/id/"+alice.User.ID, &profile)
+	assert.True(t, profile.IsFollowing)
+	assert.Equal(t, 1, profile.FollowersCount)
+
+	// blocked viewers can't see the profile at all
+	alice.doJSON(http.MethodPost, "/api/users/"+bob.User.ID+"/block", nil, http.StatusOK, nil)
+	requireStatus(t, bob.do(http.MethodGet, "/api/users/id/"+alice.User.ID, nil), http.StatusNotFound)
+}
+
+func TestTweets(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+
+	created := alice.tweet("hello #world")
+	assert.Equal(t, "hello #world", created.Content)
+	assert.Equal(t, []string{"#world"}, created.Hashtags)
+	assert.Equal(t, alice.User.ID, created.Author.ID)
+
+	var tweet models.Tweet
+	bob.getJSON("/api/tweets/"+created.ID, &tweet)
+	assert.Equal(t, created.ID, tweet.ID)
+	requireStatus(t, bob.do(http.MethodGet, "/api/tweets/missing", nil), http.StatusNotFound)
+
+	var tweets []models.TweetProps
+	bob.getJSON("/api/tweets", &tweets)
+	require.Len(t, tweets, 1)
+	assert.Equal(t, created.ID, tweets[0].ID)
+
+	res := alice.do(http.MethodPost, "/api/tweets", map[string]string{})
+	requireStatus(t, res, http.StatusBadRequest)
+
+	var reply models.TweetProps
+	bob.doJSON(http.MethodPost, "/api/tweets/"+created.ID+"/replies", map[string]string{"content": "hi alice"}, http.StatusOK, &reply)
+	require.NotNil(t, reply.InReplyToID)
+	assert.Equal(t, created.ID, *reply.InReplyToID)
+
+	var replies []models.TweetProps
+	alice.getJSON("/api/tweets/"+created.ID+"/replies", &replies)
+	require.Len(t, replies, 1)
+	assert.Equal(t, reply.ID, replies[0].ID)
+
+	var page models.TweetPage
+	bob.getJSON("/api/users/"+alice.User.ID+"/tweets", &page)
+	require.Len(t, page.Tweets, 1)
+	assert.Nil(t, page.NextCursor)
+
+	bob.getJSON("/api/users/"+alice.User.ID+"/likes", &page)
+	assert.Empty(t, page.Tweets)
+	requireStatus(t, bob.do(http.MethodGet, "/api/users/"+alice.User.ID+"/nonsense", nil), http.StatusNotFound)
+	requireStatus(t, bob.do(http.MethodGet, "/api/users/"+alice.User.ID+"/tweets?limit=0", nil), http.StatusBadRequest)
+	bob.getJSON("/api/users/"+alice.User.ID+"/tweets?limit=1000", &page)
+	assert.Len(t, page.Tweets, 1)
+
+	// only your own tweets can be pinned
+	requireStatus(t, bob.do(http.MethodPut, "/api/users/me/pinned", map[string]string{"tweetID": created.ID}), http.StatusNotFound)
+	requireStatus(t, alice.do(http.MethodPut, "/api/users/me/pinned", map[string]string{"tweetID": created.ID}), http.StatusOK)
+}
+
+func TestLikes(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	created := alice.tweet("like me")
+
+	for range 2 {
+		bob.doJSON(http.MethodPost, "/api/tweets/"+created.ID+"/like", nil, http.StatusOK, nil)
+	}
+	var tweet models.Tweet
+	alice.getJSON("/api/tweets/"+created.ID, &tweet)
+	assert.Equal(t, 1, tweet.LikesCount)
+
+	var tweets []models.TweetProps
+	bob.getJSON("/api/tweets", &tweets)
+	require.Len(t, tweets, 1)
+	assert.True(t, tweets[0].IsLiked)
+
+	bob.doJSON(http.MethodPost, "/api/tweets/"+created.ID+"/unlike", nil, http.StatusOK, nil)
+	alice.getJSON("/api/tweets/"+created.ID, &tweet)
+	assert.Zero(t, tweet.LikesCount)
+
+	requireStatus(t, bob.do(http.MethodPost, "/api/tweets/missing/like", nil), http.StatusNotFound)
+}
+
+func TestFollows(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+
+	var follow map[string]models.FollowStatus
+	bob.doJSON(http.MethodPost, "/api/users/"+alice.User.ID+"/follow", nil, http.StatusOK, &follow)
+	assert.Equal(t, models.FollowStatusFollowing, follow["status"])
+	requireStatus(t, bob.do(http.MethodPost, "/api/users/"+bob.User.ID+"/follow", nil), http.StatusBadRequest)
+
+	var profile models.UserProfile
+	alice.getJSON("/api/users", &profile)
+	assert.Equal(t, 1, profile.FollowersCount)
+
+	bob.doJSON(http.MethodDelete, "/api/users/"+alice.User.ID+"/follow", nil, http.StatusOK, nil)
+	alice.getJSON("/api/users", &profile)
+	assert.Zero(t, profile.FollowersCount)
+
+	// blocked users can't follow
+	alice.doJSON(http.MethodPost, "/api/users/"+bob.User.ID+"/block", nil, http.StatusOK, nil)
+	requireStatus(t, bob.do(http.MethodPost, "/api/users/"+alice.User.ID+"/follow", nil), http.StatusForbidden)
+}
+
+func TestNotificationStream(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	created := alice.tweet("notify me")
+
+	likes := alice.stream("/api/notifications/like/" + alice.User.ID)
+	bob.doJSON(http.MethodPost, "/api/tweets/"+created.ID+"/like", nil, http.StatusOK, nil)
+
+	var notification models.Notification
+	likes.next(&notification)
+	assert.Equal(t, models.NotificationTypeLike, notification.Type)
+	assert.Equal(t, bob.User.ID, notification.AuthorUserID)
+	require.NotNil(t, notification.TargetTweetID)
+	assert.Equal(t, created.ID, *notification.TargetTweetID)
+
+	requireStatus(t, server.anonymous().do(http.MethodGet, "/api/notifications/like/"+alice.User.ID, nil), http.StatusUnauthorized)
+}
+
+// following again, or asking again to follow a locked account, doesn't
+// notify the user a second time
+func TestRepeatedFollowNotifiesOnce(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	carol := server.register("carol")
+	dave := server.register("dave")
+	alice.doJSON(http.MethodPut, "/api/users", map[string]any{"isLocked": true}, http.StatusOK, nil)
+	dave.doJSON(http.MethodPost, "/api/users/"+bob.User.ID+"/follow", nil, http.StatusOK, nil)
+
+	requests := alice.stream("/api/notifications/follow_request/" + alice.User.ID)
+	follows := bob.stream("/api/notifications/follow/" + bob.User.ID)
+	for range 2 {
+		bob.doJSON(http.MethodPost, "/api/users/"+alice.User.ID+"/follow", nil, http.StatusOK, nil)
+		dave.doJSON(http.MethodPost, "/api/users/"+bob.User.ID+"/follow", nil, http.StatusOK, nil)
+	}
+	carol.doJSON(http.MethodPost, "/api/users/"+alice.User.ID+"/follow", nil, http.StatusOK, nil)
+	carol.doJSON(http.MethodPost, "/api/users/"+bob.User.ID+"/follow", nil, http.StatusOK, nil)
+
+	var notification models.Notification
+	requests.next(&notification)
+	assert.Equal(t, bob.User.ID, notification.AuthorUserID)
+	requests.next(&notification)
+	assert.Equal(t, carol.User.ID, notification.AuthorUserID)
+
+	// dave already followed bob before the stream was opened
+	follows.next(&notification)
+	assert.Equal(t, carol.User.ID, notification.AuthorUserID)
+}
+
+// likes of tweets in a muted conversation are not streamed
+func TestNotificationStreamMutedConversation(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	muted := alice.tweet("muted thread")
+	created := alice.tweet("notify me")
+	alice.doJSON(http.MethodPost, "/api/mutes/conversations/"+muted.ID, nil, http.StatusOK, nil)
+
+	likes := alice.stream("/api/notifications/like/" + alice.User.ID)
+	bob.doJSON(http.MethodPost, "/api/tweets/"+muted.ID+"/like", nil, http.StatusOK, nil)
+	bob.doJSON(http.MethodPost, "/api/tweets/"+created.ID+"/like", nil, http.StatusOK, nil)
+
+	var notification models.Notification
+	likes.next(&notification)
+	require.NotNil(t, notification.TargetTweetID)
+	assert.Equal(t, created.ID, *notification.TargetTweetID)
+}
+
+func TestFeedStream(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	carol := server.register("carol")
+	alice.doJSON(http.MethodPost, "/api/mutes/users/"+carol.User.ID, nil, http.StatusOK, nil)
+
+	feed := alice.stream("/api/feed")
+	carol.tweet("muted")
+	created := bob.tweet("fresh tweet")
+
+	// the event for carol's tweet is filtered out, so bob's comes first
+	var event models.FeedEvent
+	feed.next(&event)
+	assert.Equal(t, models.FeedEventCreated, event.Type)
+	assert.Equal(t, created.ID, event.Tweet.ID)
+	assert.Equal(t, bob.User.ID, event.ActorID)
+}
+
+// blocks and mutes made while a stream is open apply to its next event, not
+// once the stream's cached content filter expires
+func TestStreamsApplyNewBlocksAndMutes(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+	bob := server.register("bob")
+	carol := server.register("carol")
+	dave := server.register("dave")
+	liked := alice.tweet("like me")
+	alsoLiked := alice.tweet("like me too")
+
+	feed := alice.stream("/api/feed")
+	likes := alice.stream("/api/notifications/like/" + alice.User.ID)
+
+	// the first events load the streams' content filters
+	var event models.FeedEvent
+	first := bob.tweet("first")
+	feed.next(&event)
+	require.Equal(t, first.ID, event.Tweet.ID)
+	var notification models.Notification
+	dave.doJSON(http.MethodPost, "/api/tweets/"+liked.ID+"/like", nil, http.StatusOK, nil)
+	likes.next(&notification)
+	require.Equal(t, dave.User.ID, notification.AuthorUserID)
+	feed.next(&event)
+	require.Equal(t, models.FeedEventLiked, event.Type)
+
+	alice.doJSON(http.MethodPost, "/api/users/"+carol.User.ID+"/block", nil, http.StatusOK, nil)
+	alice.doJSON(http.MethodPost, "/api/mutes/users/"+dave.User.ID, nil, http.StatusOK, nil)
+
+	carol.tweet("blocked")
+	second := bob.tweet("second")
+	feed.next(&event)
+	assert.Equal(t, second.ID, event.Tweet.ID)
+
+	dave.doJSON(http.MethodPost, "/api/tweets/"+alsoLiked.ID+"/like", nil, http.StatusOK, nil)
+	bob.doJSON(http.MethodPost, "/api/tweets/"+alsoLiked.ID+"/like", nil, http.StatusOK, nil)
+	likes.next(&notification)
+	assert.Equal(t, bob.User.ID, notification.AuthorUserID)
+}
+
+// the problem body is JSON even for errors raised by the auth middleware
+func TestUnauthorizedProblem(t *testing.T) {
+	server := newTestServer(t)
+	res := server.anonymous().do(http.MethodGet, "/api/tweets", nil)
+	requireStatus(t, res, http.StatusUnauthorized)
+
+	var problem map[string]any
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&problem))
+	assert.Equal(t, float64(http.StatusUnauthorized), problem["status"])
+	assert.Equal(t, "/api/tweets", problem["instance"])
+}
+
+// preflight requests are answered for the configured origins only
+func TestCORS(t *testing.T) {
+	server := newTestServer(t)
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, server.URL+"/api/tweets", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	res := preflight("http://localhost:3000")
+	assert.Less(t, res.StatusCode, 300)
+	assert.Equal(t, "http://localhost:3000", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+
+	res = preflight("https://evil.example.com")
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+}
+
+// shutting down ends open streams with a reconnect hint instead of waiting
+// for clients to leave
+func TestShutdownEndsStreams(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+
+	feed := alice.stream("/api/feed")
+	likes := alice.stream("/api/notifications/like/" + alice.User.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, server.api.Shutdown(ctx))
+
+	feed.requireReconnect()
+	likes.requireReconnect()
+}
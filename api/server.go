@@ -2,22 +2,234 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/aimrintech/x-backend/config"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/aimrintech/x-backend/utils"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
-	"golang.org/x/oauth2"
 )
 
+// Deps are the stores and services the server's handlers are built on. Main
+// uses NewNeo4jDeps; tests can inject the in-memory stores instead.
+type Deps struct {
+	UserStore            stores.UserStore
+	TweetStore           stores.TweetStore
+	NotificationsStore   stores.NotificationsStore
+	SessionStore         stores.SessionStore
+	IdentityStore        stores.IdentityStore
+	TwoFactorStore       stores.TwoFactorStore
+	APITokenStore        stores.APITokenStore
+	NotificationsService services.Notifications
+	FeedService          services.Feed
+	TimelineService      services.Timeline
+	UsernameCheckLimiter services.RateLimiter
+	RevocationList       services.RevocationList
+	LoginThrottle        services.LoginThrottle
+	ContentFilterChanges services.ContentFilterChanges
+	// AccessTokens signs access tokens. Main uses NewAccessTokens; without
+	// it they are signed with a key generated at startup.
+	AccessTokens services.AccessTokens
+	// AuthProviders are the OAuth providers users can sign in with. Main
+	// uses NewAuthProviders; without it no provider is available.
+	AuthProviders *services.AuthProviders
+	// Mailer defaults to logging emails to stdout
+	Mailer services.Mailer
+}
+
+// withDefaults fills in the services that don't depend on the stores. The
+// notifications and feed services must be the ones the stores publish to, so
+// they have no default.
+func (deps Deps) withDefaults() Deps {
+	if deps.TimelineService == nil {
+		deps.TimelineService = services.NewTimelineService(services.NewWeightedScorer())
+	}
+	if deps.UsernameCheckLimiter == nil {
+		deps.UsernameCheckLimiter = services.NewRateLimiter(30, time.Minute)
+	}
+	if deps.RevocationList == nil {
+		deps.RevocationList = services.NewRevocationList()
+	}
+	if deps.LoginThrottle == nil {
+		deps.LoginThrottle = services.NewLoginThrottle(services.AccountThrottlePolicy, services.IPThrottlePolicy)
+	}
+	if deps.ContentFilterChanges == nil {
+		deps.ContentFilterChanges = services.NewContentFilterChanges()
+	}
+	if deps.AuthProviders == nil {
+		deps.AuthProviders = services.NewAuthProviders()
+	}
+	return deps
+}
+
+// NewNeo4jDeps builds the stores on a Neo4j driver, together with the
+// services they publish to
+func NewNeo4jDeps(driver *neo4j.DriverWithContext) Deps {
+	notificationsService := services.NewNotificationsService()
+	feedService := services.NewFeedService()
+
+	return Deps{
+		UserStore:            stores.NewUserStore(driver, notificationsService),
+		TweetStore:           stores.NewTweetStore(driver, notificationsService, feedService),
+		NotificationsStore:   stores.NewNotificationsStore(driver),
+		SessionStore:         stores.NewSessionStore(driver),
+		IdentityStore:        stores.NewIdentityStore(driver),
+		TwoFactorStore:       stores.NewTwoFactorStore(driver),
+		APITokenStore:        stores.NewAPITokenStore(driver),
+		NotificationsService: notificationsService,
+		FeedService:          feedService,
+	}
+}
+
+// NewAuthProviders registers every OAuth provider the config has a client
+// for. The OpenID Connect provider is configured from its discovery
+// document, so this fails if the issuer can't be reached.
+func NewAuthProviders(ctx context.Context, cfg *config.Config) (*services.AuthProviders, error) {
+	providers := services.NewAuthProviders()
+	if cfg.GoogleClientID != "" {
+		providers.Register(services.NewGoogleProvider(cfg.GoogleOAuth()))
+	}
+	if cfg.GitHubClientID != "" {
+		providers.Register(services.NewGitHubProvider(cfg.GitHubOAuth(), services.GitHubAPIURL))
+	}
+	if cfg.OIDCIssuerURL != "" {
+		provider, err := services.NewOIDCProvider(ctx, cfg.OIDCIssuerURL, cfg.OIDCOAuth())
+		if err != nil {
+			return nil, err
+		}
+		providers.Register(provider)
+	}
+	return providers, nil
+}
+
+// NewAccessTokens signs access tokens with the keys in the configured keys
+// directory or, without one, with a key generated now. A generated key
+// dies with the process: access tokens then need refreshing after a
+// restart, and other instances can't verify them.
+func NewAccessTokens(cfg *config.Config) (services.AccessTokens, error) {
+	var keys *services.KeyRing
+	var err error
+	if cfg.JWTKeysDir != "" {
+		keys, err = services.NewKeyRing(func() ([]*services.SigningKey, error) {
+			return services.LoadSigningKeys(cfg.JWTKeysDir)
+		})
+	} else {
+		keys, err = generatedKeyRing()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return services.NewAccessTokenService(keys, cfg.JWTIssuer, cfg.JWTAudience), nil
+}
+
+func generatedKeyRing() (*services.KeyRing, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	key, err := services.GenerateSigningKey("generated-"+hex.EncodeToString(id), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return services.NewStaticKeyRing(key)
+}
+
+// NewMailer sends email through the configured SMTP server, or else writes
+// it to the mail file or stdout
+func NewMailer(cfg *config.Config) services.Mailer {
+	switch {
+	case cfg.SMTPHost != "":
+		return services.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	case cfg.MailFile != "":
+		return services.NewFileMailer(cfg.MailFile, cfg.MailFrom)
+	default:
+		return services.NewLogMailer(os.Stdout, cfg.MailFrom)
+	}
+}
+
 type Server struct {
-	router *http.ServeMux
+	config     *config.Config
+	deps       Deps
+	handler    http.Handler
+	httpServer *http.Server
 }
 
-func NewServer(driver *neo4j.DriverWithContext, dbCtx *context.Context, authConfig *oauth2.Config) *Server {
+func NewServer(cfg *config.Config, deps Deps) *Server {
+	deps = deps.withDefaults()
+	if deps.Mailer == nil {
+		deps.Mailer = services.NewLogMailer(os.Stdout, cfg.MailFrom)
+	}
+	if deps.AccessTokens == nil {
+		keys, err := generatedKeyRing()
+		if err != nil {
+			panic(err)
+		}
+		deps.AccessTokens = services.NewAccessTokenService(keys, cfg.JWTIssuer, cfg.JWTAudience)
+	}
+	handler := utils.NewCORSHandler(cfg.CORSOrigins, csrfMiddleware(cfg.CORSOrigins)(setupMux(cfg, deps).ServeHTTP))
 	return &Server{
-		router: setupMux(driver, dbCtx, authConfig),
+		config:  cfg,
+		deps:    deps,
+		handler: handler,
+		httpServer: &http.Server{
+			Addr:         cfg.Addr(),
+			Handler:      handler,
+			ReadTimeout:  cfg.ReadTimeout.Duration,
+			WriteTimeout: cfg.WriteTimeout.Duration,
+			IdleTimeout:  cfg.IdleTimeout.Duration,
+		},
 	}
 }
 
-func (s *Server) Start(port string) error {
-	return http.ListenAndServe(port, s.router)
+// ServeHTTP lets the server be mounted on an httptest.Server
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Start serves until Shutdown is called, after which it returns nil
+func (s *Server) Start() error {
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits, until ctx is done, for
+// in-flight requests to finish. SSE streams would never finish on their own,
+// so the feed and notification services are closed, which ends every stream
+// with a reconnect hint, and their background goroutines are drained.
+// Connections still open when ctx is done are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- s.httpServer.Shutdown(ctx)
+	}()
+
+	servicesDone := make(chan struct{})
+	go func() {
+		defer close(servicesDone)
+		s.deps.FeedService.Close()
+		s.deps.NotificationsService.Close()
+	}()
+
+	err := <-httpDone
+	if err != nil {
+		s.httpServer.Close()
+		return fmt.Errorf("shutting down http server: %w", err)
+	}
+
+	select {
+	case <-servicesDone:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("draining services: %w", ctx.Err())
+	}
 }
@@ -0,0 +1,117 @@
+package api_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type loginResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	Challenge         string `json:"challenge"`
+}
+
+// totpCode is the code an authenticator app shows, steps periods from now
+func totpCode(t *testing.T, secret string, steps int64) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+steps)
+	require.NoError(t, err)
+	return code
+}
+
+func TestTwoFactor(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.register("alice")
+
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauthURI"`
+	}
+	alice.doJSON(http.MethodPost, "/api/auth/2fa/enroll", nil, http.StatusOK, &enrollment)
+	assert.True(t, strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/X:alice@example.com?"))
+	assert.Contains(t, enrollment.OTPAuthURI, "secret="+enrollment.Secret)
+
+	// nothing changes until the code is confirmed
+	var login loginResponse
+	server.anonymous().doJSON(http.MethodPost, "/api/auth/login", map[string]string{"email": "alice@example.com", "password": testPassword}, http.StatusOK, &login)
+	assert.False(t, login.TwoFactorRequired)
+
+	requireStatus(t, alice.do(http.MethodPost, "/api/auth/2fa/confirm", map[string]string{"code": "000000"}), http.StatusBadRequest)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	alice.doJSON(http.MethodPost, "/api/auth/2fa/confirm", map[string]string{"code": totpCode(t, enrollment.Secret, 0)}, http.StatusOK, &confirmed)
+	require.Len(t, confirmed.RecoveryCodes, 10)
+	requireStatus(t, alice.do(http.MethodPost, "/api/auth/2fa/enroll", nil), http.StatusConflict)
+
+	var status struct {
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+	}
+	alice.getJSON("/api/auth/2fa", &status)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, 10, status.RecoveryCodesLeft)
+
+	// startLogin enters the password on a new device
+	startLogin := func() (*testClient, string) {
+		t.Helper()
+		c := server.anonymous()
+		var login loginResponse
+		c.doJSON(http.MethodPost, "/api/auth/login", map[string]string{"email": "alice@example.com", "password": testPassword}, http.StatusOK, &login)
+		require.True(t, login.TwoFactorRequired)
+		require.NotEmpty(t, login.Challenge)
+		requireStatus(t, c.do(http.MethodGet, "/api/users", nil), http.StatusUnauthorized)
+		return c, login.Challenge
+	}
+
+	t.Run("login with a code", func(t *testing.T) {
+		c, challenge := startLogin()
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/login/2fa", map[string]string{"challenge": challenge + "x", "code": totpCode(t, enrollment.Secret, 1)}), http.StatusUnauthorized)
+		// the code used to confirm is spent
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/login/2fa", map[string]string{"challenge": challenge, "code": totpCode(t, enrollment.Secret, 0)}), http.StatusUnauthorized)
+
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/login/2fa", map[string]string{"challenge": challenge, "code": totpCode(t, enrollment.Secret, 1)}), http.StatusOK)
+		c.loadUser()
+		assert.Equal(t, alice.User.ID, c.User.ID)
+	})
+
+	t.Run("login with a recovery code", func(t *testing.T) {
+		c, challenge := startLogin()
+		code := strings.ToUpper(confirmed.RecoveryCodes[0])
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/login/2fa", map[string]string{"challenge": challenge, "recoveryCode": code}), http.StatusOK)
+		c.loadUser()
+
+		other, challenge := startLogin()
+		requireStatus(t, other.do(http.MethodPost, "/api/auth/login/2fa", map[string]string{"challenge": challenge, "recoveryCode": code}), http.StatusUnauthorized)
+
+		alice.getJSON("/api/auth/2fa", &status)
+		assert.Equal(t, 9, status.RecoveryCodesLeft)
+	})
+
+	t.Run("regenerate recovery codes", func(t *testing.T) {
+		requireStatus(t, alice.do(http.MethodPost, "/api/auth/2fa/recovery-codes", map[string]string{"recoveryCode": "wrong-code"}), http.StatusForbidden)
+		var regenerated struct {
+			RecoveryCodes []string `json:"recoveryCodes"`
+		}
+		alice.doJSON(http.MethodPost, "/api/auth/2fa/recovery-codes", map[string]string{"recoveryCode": confirmed.RecoveryCodes[1]}, http.StatusOK, &regenerated)
+		require.Len(t, regenerated.RecoveryCodes, 10)
+
+		c, challenge := startLogin()
+		requireStatus(t, c.do(http.MethodPost, "/api/auth/login/2fa", map[string]string{"challenge": challenge, "recoveryCode": confirmed.RecoveryCodes[2]}), http.StatusUnauthorized)
+		confirmed.RecoveryCodes = regenerated.RecoveryCodes
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		requireStatus(t, alice.do(http.MethodPost, "/api/auth/2fa/disable", map[string]string{}), http.StatusBadRequest)
+		requireStatus(t, alice.do(http.MethodPost, "/api/auth/2fa/disable", map[string]string{"recoveryCode": confirmed.RecoveryCodes[0]}), http.StatusOK)
+
+		server.anonymous().login("alice@example.com", testPassword)
+		alice.getJSON("/api/auth/2fa", &status)
+		assert.False(t, status.Enabled)
+	})
+}
@@ -1,19 +1,47 @@
 package config
 
 import (
-	"os"
+	"strings"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
 	"golang.org/x/oauth2/google"
 )
 
-func InitAuthConfig() *oauth2.Config {
-	AuthConfig := &oauth2.Config{
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectURL:  "http://localhost:8080/api/oauth/callback",
+// OAuthRedirectURL is the callback the named provider redirects back to
+func (c *Config) OAuthRedirectURL(provider string) string {
+	return strings.TrimSuffix(c.OAuthBaseURL, "/") + "/" + provider + "/callback"
+}
+
+// GoogleOAuth is the OAuth client used to sign in with Google
+func (c *Config) GoogleOAuth() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.GoogleClientID,
+		ClientSecret: c.GoogleClientSecret,
+		RedirectURL:  c.OAuthRedirectURL("google"),
 		Scopes:       []string{"email", "profile"},
 		Endpoint:     google.Endpoint,
 	}
-	return AuthConfig
+}
+
+// GitHubOAuth is the OAuth client used to sign in with GitHub
+func (c *Config) GitHubOAuth() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.GitHubClientID,
+		ClientSecret: c.GitHubClientSecret,
+		RedirectURL:  c.OAuthRedirectURL("github"),
+		Scopes:       []string{"read:user", "user:email"},
+		Endpoint:     github.Endpoint,
+	}
+}
+
+// OIDCOAuth is the OAuth client used to sign in with the OpenID Connect
+// provider. Its endpoint comes from the issuer's discovery document.
+func (c *Config) OIDCOAuth() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.OIDCClientID,
+		ClientSecret: c.OIDCClientSecret,
+		RedirectURL:  c.OAuthRedirectURL("oidc"),
+		Scopes:       []string{"openid", "email", "profile"},
+	}
 }
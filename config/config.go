@@ -0,0 +1,328 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is everything the server needs to start. It is loaded once in main
+// and passed to whatever needs it.
+type Config struct {
+	Port        string   `json:"port"`
+	FrontendURL string   `json:"frontendURL"`
+	CORSOrigins []string `json:"corsOrigins"`
+
+	// AppSecret is what the keys for signed links, OAuth state and stored
+	// two factor secrets are derived from. Access tokens are signed with
+	// the keys in JWTKeysDir instead.
+	AppSecret string `json:"appSecret"`
+
+	// JWTKeysDir holds the PEM private keys access tokens are signed with,
+	// one per file, each named for the date it starts signing on. Without
+	// it the server signs with a key generated at startup. JWTIssuer and
+	// JWTAudience are the iss and aud claims of access tokens.
+	JWTKeysDir  string `json:"jwtKeysDir"`
+	JWTIssuer   string `json:"jwtIssuer"`
+	JWTAudience string `json:"jwtAudience"`
+
+	// ReadTimeout, WriteTimeout and IdleTimeout bound each connection.
+	// ShutdownTimeout bounds draining requests, streams and jobs on exit.
+	ReadTimeout     Duration `json:"readTimeout"`
+	WriteTimeout    Duration `json:"writeTimeout"`
+	IdleTimeout     Duration `json:"idleTimeout"`
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+
+	Neo4jURI      string `json:"neo4jURI"`
+	Neo4jUsername string `json:"neo4jUsername"`
+	Neo4jPassword string `json:"neo4jPassword"`
+
+	// OAuthBaseURL is where the OAuth routes are mounted. Each provider
+	// redirects back to its own callback under it.
+	OAuthBaseURL string `json:"oauthBaseURL"`
+
+	GoogleClientID     string `json:"googleClientID"`
+	GoogleClientSecret string `json:"googleClientSecret"`
+	GitHubClientID     string `json:"githubClientID"`
+	GitHubClientSecret string `json:"githubClientSecret"`
+
+	// OIDCIssuerURL enables sign in with any OpenID Connect provider, which
+	// is configured from the issuer's discovery document
+	OIDCIssuerURL    string `json:"oidcIssuerURL"`
+	OIDCClientID     string `json:"oidcClientID"`
+	OIDCClientSecret string `json:"oidcClientSecret"`
+
+	// MailFrom is the sender of every email. Emails go through SMTPHost
+	// when it is set, and otherwise are appended to MailFile or, without
+	// one, logged to stdout.
+	MailFrom     string `json:"mailFrom"`
+	SMTPHost     string `json:"smtpHost"`
+	SMTPPort     string `json:"smtpPort"`
+	SMTPUsername string `json:"smtpUsername"`
+	SMTPPassword string `json:"smtpPassword"`
+	MailFile     string `json:"mailFile"`
+}
+
+// Default returns the configuration for local development, without secrets
+func Default() *Config {
+	return &Config{
+		Port:        "8080",
+		FrontendURL: "http://localhost:3000",
+		CORSOrigins: []string{"http://localhost:3000", "http://127.0.0.1:3000"},
+
+		ReadTimeout:     Duration{15 * time.Second},
+		WriteTimeout:    Duration{30 * time.Second},
+		IdleTimeout:     Duration{2 * time.Minute},
+		ShutdownTimeout: Duration{15 * time.Second},
+
+		JWTAudience: "x-backend",
+
+		MailFrom: "X <no-reply@localhost>",
+		SMTPPort: "587",
+	}
+}
+
+// env maps environment variables to the fields they set
+var env = map[string]func(c *Config, value string) error{
+	"PORT":                 setString(func(c *Config) *string { return &c.Port }),
+	"FRONTEND_URL":         setString(func(c *Config) *string { return &c.FrontendURL }),
+	"CORS_ORIGINS":         func(c *Config, v string) error { c.CORSOrigins = splitList(v); return nil },
+	"APP_SECRET":           setString(func(c *Config) *string { return &c.AppSecret }),
+	"JWT_KEYS_DIR":         setString(func(c *Config) *string { return &c.JWTKeysDir }),
+	"JWT_ISSUER":           setString(func(c *Config) *string { return &c.JWTIssuer }),
+	"JWT_AUDIENCE":         setString(func(c *Config) *string { return &c.JWTAudience }),
+	"READ_TIMEOUT":         setDuration(func(c *Config) *Duration { return &c.ReadTimeout }),
+	"WRITE_TIMEOUT":        setDuration(func(c *Config) *Duration { return &c.WriteTimeout }),
+	"IDLE_TIMEOUT":         setDuration(func(c *Config) *Duration { return &c.IdleTimeout }),
+	"SHUTDOWN_TIMEOUT":     setDuration(func(c *Config) *Duration { return &c.ShutdownTimeout }),
+	"NEO4J_URI":            setString(func(c *Config) *string { return &c.Neo4jURI }),
+	"NEO4J_USERNAME":       setString(func(c *Config) *string { return &c.Neo4jUsername }),
+	"NEO4J_PASSWORD":       setString(func(c *Config) *string { return &c.Neo4jPassword }),
+	"OAUTH_BASE_URL":       setString(func(c *Config) *string { return &c.OAuthBaseURL }),
+	"GOOGLE_CLIENT_ID":     setString(func(c *Config) *string { return &c.GoogleClientID }),
+	"GOOGLE_CLIENT_SECRET": setString(func(c *Config) *string { return &c.GoogleClientSecret }),
+	"GITHUB_CLIENT_ID":     setString(func(c *Config) *string { return &c.GitHubClientID }),
+	"GITHUB_CLIENT_SECRET": setString(func(c *Config) *string { return &c.GitHubClientSecret }),
+	"OIDC_ISSUER_URL":      setString(func(c *Config) *string { return &c.OIDCIssuerURL }),
+	"OIDC_CLIENT_ID":       setString(func(c *Config) *string { return &c.OIDCClientID }),
+	"OIDC_CLIENT_SECRET":   setString(func(c *Config) *string { return &c.OIDCClientSecret }),
+	"MAIL_FROM":            setString(func(c *Config) *string { return &c.MailFrom }),
+	"SMTP_HOST":            setString(func(c *Config) *string { return &c.SMTPHost }),
+	"SMTP_PORT":            setString(func(c *Config) *string { return &c.SMTPPort }),
+	"SMTP_USERNAME":        setString(func(c *Config) *string { return &c.SMTPUsername }),
+	"SMTP_PASSWORD":        setString(func(c *Config) *string { return &c.SMTPPassword }),
+	"MAIL_FILE":            setString(func(c *Config) *string { return &c.MailFile }),
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		return field(c).Set(value)
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, a JSON file named by -config or CONFIG_FILE, environment
+// variables and command line flags. It returns the arguments left after the
+// flags, such as a subcommand.
+func Load(args []string, getenv func(string) string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("x-backend", flag.ContinueOnError)
+	file := fs.String("config", getenv("CONFIG_FILE"), "path to a JSON config file")
+	port := fs.String("port", "", "port to listen on")
+	frontendURL := fs.String("frontend-url", "", "URL of the web app, used for redirects")
+	corsOrigins := fs.String("cors-origins", "", "comma separated origins allowed by CORS")
+	shutdownTimeout := fs.String("shutdown-timeout", "", "how long to drain connections on exit, e.g. 15s")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	if *file != "" {
+		if err := cfg.loadFile(*file); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for name, set := range env {
+		if value := getenv(name); value != "" {
+			if err := set(cfg, value); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+
+	if *port != "" {
+		cfg.Port = *port
+	}
+	if *frontendURL != "" {
+		cfg.FrontendURL = *frontendURL
+	}
+	if *corsOrigins != "" {
+		cfg.CORSOrigins = splitList(*corsOrigins)
+	}
+	if *shutdownTimeout != "" {
+		if err := cfg.ShutdownTimeout.Set(*shutdownTimeout); err != nil {
+			return nil, nil, fmt.Errorf("invalid -shutdown-timeout: %w", err)
+		}
+	}
+
+	cfg.fillDerived()
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// fillDerived sets the values that default to other values
+func (c *Config) fillDerived() {
+	if c.OAuthBaseURL == "" {
+		c.OAuthBaseURL = "http://localhost:" + c.Port + "/api/oauth"
+	}
+	if c.JWTIssuer == "" {
+		c.JWTIssuer = "http://localhost:" + c.Port
+	}
+	// the web app always needs to reach the API
+	if c.FrontendURL != "" && !slices.Contains(c.CORSOrigins, c.FrontendURL) {
+		c.CORSOrigins = append(c.CORSOrigins, c.FrontendURL)
+	}
+}
+
+// Validate reports every missing or malformed value at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.AppSecret == "" {
+		errs = append(errs, errors.New("APP_SECRET is required"))
+	}
+	if c.Neo4jURI == "" {
+		errs = append(errs, errors.New("NEO4J_URI is required"))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port %q is not a valid port number", c.Port))
+	}
+	if !isAbsoluteURL(c.FrontendURL) {
+		errs = append(errs, fmt.Errorf("frontend URL %q is not an absolute URL", c.FrontendURL))
+	}
+	if !isAbsoluteURL(c.JWTIssuer) {
+		errs = append(errs, fmt.Errorf("JWT issuer %q is not an absolute URL", c.JWTIssuer))
+	}
+	if c.JWTAudience == "" {
+		errs = append(errs, errors.New("JWT audience is required"))
+	}
+	if !isAbsoluteURL(c.OAuthBaseURL) {
+		errs = append(errs, fmt.Errorf("OAuth base URL %q is not an absolute URL", c.OAuthBaseURL))
+	}
+	if c.OIDCIssuerURL != "" {
+		if !isAbsoluteURL(c.OIDCIssuerURL) {
+			errs = append(errs, fmt.Errorf("OIDC issuer URL %q is not an absolute URL", c.OIDCIssuerURL))
+		}
+		if c.OIDCClientID == "" {
+			errs = append(errs, errors.New("OIDC_CLIENT_ID is required with OIDC_ISSUER_URL"))
+		}
+	}
+	if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+		errs = append(errs, fmt.Errorf("mail sender %q is not an email address", c.MailFrom))
+	}
+	if c.SMTPHost != "" {
+		if port, err := strconv.Atoi(c.SMTPPort); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("SMTP port %q is not a valid port number", c.SMTPPort))
+		}
+	}
+	timeouts := []struct {
+		name  string
+		value Duration
+	}{
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"idle timeout", c.IdleTimeout},
+		{"shutdown timeout", c.ShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", timeout.name))
+		}
+	}
+	for _, origin := range c.CORSOrigins {
+		if !isAbsoluteURL(origin) {
+			errs = append(errs, fmt.Errorf("CORS origin %q is not an absolute URL", origin))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Addr is the address the server listens on
+func (c *Config) Addr() string {
+	return ":" + c.Port
+}
+
+// Duration is a time.Duration written as a string such as "30s" in config
+// files and the environment
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	return d.Set(value)
+}
+
+func isAbsoluteURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
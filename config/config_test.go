@@ -0,0 +1,145 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envFrom returns a getenv backed by a map
+func envFrom(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+var requiredEnv = map[string]string{
+	"APP_SECRET": "secret",
+	"NEO4J_URI":  "neo4j://localhost:7687",
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, args, err := Load(nil, envFrom(requiredEnv))
+	require.NoError(t, err)
+	assert.Empty(t, args)
+	assert.Equal(t, "8080", cfg.Port)
+	assert.Equal(t, ":8080", cfg.Addr())
+	assert.Equal(t, "http://localhost:3000", cfg.FrontendURL)
+	assert.Equal(t, "http://localhost:8080/api/oauth", cfg.OAuthBaseURL)
+	assert.Equal(t, "http://localhost:8080", cfg.JWTIssuer)
+	assert.Equal(t, "x-backend", cfg.JWTAudience)
+	assert.Equal(t, "http://localhost:8080/api/oauth/google/callback", cfg.GoogleOAuth().RedirectURL)
+	assert.Equal(t, "http://localhost:8080/api/oauth/github/callback", cfg.GitHubOAuth().RedirectURL)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"port": "1000",
+		"frontendURL": "https://file.example.com",
+		"googleClientID": "file-client"
+	}`), 0o600))
+
+	env := map[string]string{
+		"CONFIG_FILE":  file,
+		"PORT":         "2000",
+		"FRONTEND_URL": "https://env.example.com",
+	}
+	for name, value := range requiredEnv {
+		env[name] = value
+	}
+
+	cfg, args, err := Load([]string{"-port", "3000", "migrate"}, envFrom(env))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"migrate"}, args)
+	assert.Equal(t, "3000", cfg.Port, "flags override the environment")
+	assert.Equal(t, "https://env.example.com", cfg.FrontendURL, "the environment overrides the file")
+	assert.Equal(t, "file-client", cfg.GoogleClientID, "the file overrides the defaults")
+	assert.Contains(t, cfg.CORSOrigins, "https://env.example.com", "the frontend is always an allowed origin")
+}
+
+func TestLoadCORSOrigins(t *testing.T) {
+	env := map[string]string{"CORS_ORIGINS": " https://a.example.com, https://b.example.com ,"}
+	for name, value := range requiredEnv {
+		env[name] = value
+	}
+
+	cfg, _, err := Load([]string{"-frontend-url", "https://a.example.com"}, envFrom(env))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORSOrigins)
+}
+
+func TestLoadRequiresSecrets(t *testing.T) {
+	_, _, err := Load(nil, envFrom(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "APP_SECRET is required")
+	assert.Contains(t, err.Error(), "NEO4J_URI is required")
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := Default()
+		cfg.AppSecret = "secret"
+		cfg.Neo4jURI = "neo4j://localhost:7687"
+		cfg.fillDerived()
+		return cfg
+	}
+	require.NoError(t, valid().Validate())
+
+	tests := map[string]func(cfg *Config){
+		"port":         func(cfg *Config) { cfg.Port = "http" },
+		"frontend URL": func(cfg *Config) { cfg.FrontendURL = "localhost:3000" },
+		"CORS origin":  func(cfg *Config) { cfg.CORSOrigins = []string{"*"} },
+		"mail sender":  func(cfg *Config) { cfg.MailFrom = "no-reply" },
+		"SMTP port": func(cfg *Config) {
+			cfg.SMTPHost = "smtp.example.com"
+			cfg.SMTPPort = "smtp"
+		},
+		"OAuth base URL": func(cfg *Config) { cfg.OAuthBaseURL = "/api/oauth" },
+		"JWT issuer":     func(cfg *Config) { cfg.JWTIssuer = "x-backend" },
+		"JWT audience":   func(cfg *Config) { cfg.JWTAudience = "" },
+		"OIDC issuer":    func(cfg *Config) { cfg.OIDCIssuerURL = "accounts.example.com" },
+		"OIDC client": func(cfg *Config) {
+			cfg.OIDCIssuerURL = "https://accounts.example.com"
+			cfg.OIDCClientID = ""
+		},
+	}
+	for name, breakConfig := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid()
+			breakConfig(cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.json")}, envFrom(requiredEnv))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLoadTimeouts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"writeTimeout": "1m", "idleTimeout": "90s"}`), 0o600))
+	env := map[string]string{"CONFIG_FILE": file, "IDLE_TIMEOUT": "3m"}
+	for name, value := range requiredEnv {
+		env[name] = value
+	}
+
+	cfg, _, err := Load([]string{"-shutdown-timeout", "5s"}, envFrom(env))
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, cfg.ReadTimeout.Duration)
+	assert.Equal(t, time.Minute, cfg.WriteTimeout.Duration)
+	assert.Equal(t, 3*time.Minute, cfg.IdleTimeout.Duration)
+	assert.Equal(t, 5*time.Second, cfg.ShutdownTimeout.Duration)
+
+	env["READ_TIMEOUT"] = "soon"
+	_, _, err = Load(nil, envFrom(env))
+	assert.ErrorContains(t, err, "READ_TIMEOUT")
+
+	env["READ_TIMEOUT"] = "-1s"
+	_, _, err = Load(nil, envFrom(env))
+	assert.ErrorContains(t, err, "read timeout must be positive")
+}
@@ -6,11 +6,52 @@ type contextKey string
 
 const USER_ID_KEY contextKey = "userID"
 
+// SESSION_ID_KEY holds the session the request's access token belongs to
+const SESSION_ID_KEY contextKey = "sessionID"
+
 type AuthProvider string
 
 const (
 	AUTH_PROVIDER_GOOGLE AuthProvider = "google"
-	AUTH_PROVIDER_CREDS  AuthProvider = "creds"
+	AUTH_PROVIDER_GITHUB AuthProvider = "github"
+	// a generic OpenID Connect provider, configured by its issuer URL
+	AUTH_PROVIDER_OIDC  AuthProvider = "oidc"
+	AUTH_PROVIDER_CREDS AuthProvider = "creds"
+)
+
+// access tokens are short-lived and can't be revoked individually, clients
+// get new ones from /api/auth/refresh for as long as their session lasts
+const ACCESS_TOKEN_EXPIRY = 15 * time.Minute
+const REFRESH_TOKEN_EXPIRY = 30 * 24 * time.Hour
+
+// how many replaced refresh tokens a session remembers, presenting any of
+// them again revokes the session. At one refresh per access token that is
+// about a day of constant use.
+const SPENT_REFRESH_TOKENS = 100
+
+// how long a replaced signing key keeps verifying access tokens, so the last
+// ones it signed stay valid until they expire
+const SIGNING_KEY_OVERLAP = ACCESS_TOKEN_EXPIRY
+
+// how long the links in password reset and verification emails work
+const PASSWORD_RESET_EXPIRY = time.Hour
+const EMAIL_VERIFICATION_EXPIRY = 48 * time.Hour
+
+// how long a user with two-factor authentication has, after entering their
+// password, to enter a code
+const TWO_FACTOR_CHALLENGE_EXPIRY = 5 * time.Minute
+
+// Scopes of personal access tokens. A token can only be used on routes that
+// need one of its scopes; everything else needs a signed in session.
+const (
+	// reading timelines, tweets and profiles as the user
+	SCOPE_READ = "read"
+	// posting, replying to and liking tweets
+	SCOPE_TWEET_WRITE = "tweet:write"
+	// streaming the user's notifications
+	SCOPE_NOTIFICATIONS_READ = "notifications:read"
 )
 
-const AUTH_TOKEN_EXPIRY = 72 * time.Hour
+// personal access tokens start with this, so they are easy to spot in logs
+// and leaked code
+const API_TOKEN_PREFIX = "xpat_"
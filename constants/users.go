@@ -0,0 +1,39 @@
+package constants
+
+import "time"
+
+// how many times a user may change their username within USERNAME_CHANGE_WINDOW
+const USERNAME_CHANGE_LIMIT = 3
+const USERNAME_CHANGE_WINDOW = 24 * time.Hour
+
+// how long an old username keeps redirecting to its owner and stays
+// unavailable to everyone else
+const USERNAME_REDIRECT_GRACE_PERIOD = 30 * 24 * time.Hour
+
+// usernames nobody can register, mostly route names and words that could be
+// used to impersonate the service
+var RESERVED_USERNAMES = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"auth":          true,
+	"explore":       true,
+	"help":          true,
+	"home":          true,
+	"id":            true,
+	"login":         true,
+	"logout":        true,
+	"me":            true,
+	"messages":      true,
+	"moderator":     true,
+	"notifications": true,
+	"root":          true,
+	"search":        true,
+	"security":      true,
+	"settings":      true,
+	"signup":        true,
+	"support":       true,
+	"system":        true,
+	"username":      true,
+	"x":             true,
+}
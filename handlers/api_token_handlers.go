@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/aimrintech/x-backend/utils"
+)
+
+type APITokenHandlers struct {
+	apiTokenStore *stores.APITokenStore
+}
+
+func NewAPITokenHandlers(apiTokenStore *stores.APITokenStore) *APITokenHandlers {
+	return &APITokenHandlers{
+		apiTokenStore: apiTokenStore,
+	}
+}
+
+// GetAPITokens lists the user's personal access tokens
+func (h *APITokenHandlers) GetAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tokens, err := (*h.apiTokenStore).ListAPITokens(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get API tokens")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, tokens)
+}
+
+// CreateAPIToken creates a personal access token. The response is the only
+// time the token is shown.
+func (h *APITokenHandlers) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	type CreateAPITokenRequestBody struct {
+		Name   string   `json:"name" validate:"required,max=100"`
+		Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=read tweet:write notifications:read"`
+	}
+
+	var body CreateAPITokenRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	slices.Sort(body.Scopes)
+	apiToken := models.NewAPIToken(userID, body.Name, slices.Compact(body.Scopes))
+	token, hash, err := utils.NewAPIToken(apiToken.ID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to create API token")
+		return
+	}
+	apiToken.TokenHash = hash
+
+	created, err := (*h.apiTokenStore).CreateAPIToken(r.Context(), apiToken)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to create API token")
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, struct {
+		*models.APIToken
+		Token string `json:"token"`
+	}{created, token})
+}
+
+// RevokeAPIToken deletes one of the user's personal access tokens. Requests
+// made with it fail from then on.
+func (h *APITokenHandlers) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := (*h.apiTokenStore).DeleteAPIToken(r.Context(), userID, r.PathValue("id")); err != nil {
+		writeStoreError(w, r, err, "Failed to revoke API token")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "API token revoked"})
+}
@@ -1,29 +1,55 @@
 package handlers
 
 import (
-	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strconv"
-	"strings"
-	"unicode"
+	"time"
 
+	"github.com/aimrintech/x-backend/config"
 	"github.com/aimrintech/x-backend/constants"
 	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
 	"github.com/aimrintech/x-backend/stores"
 	"github.com/aimrintech/x-backend/utils"
-	"github.com/go-playground/validator/v10"
-	nanoid "github.com/matoous/go-nanoid/v2"
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/oauth2"
 )
 
 type AuthHandlers struct {
-	userStore *stores.UserStore
+	userStore      *stores.UserStore
+	sessionStore   *stores.SessionStore
+	identityStore  *stores.IdentityStore
+	twoFactorStore *stores.TwoFactorStore
+	apiTokenStore  *stores.APITokenStore
+	accessTokens   services.AccessTokens
+	revocations    services.RevocationList
+	throttle       services.LoginThrottle
+	providers      *services.AuthProviders
+	mailer         services.Mailer
+	config         *config.Config
+	// derived from the app secret, which never signs or encrypts anything
+	// itself
+	signingKey    []byte
+	encryptionKey []byte
 }
 
-func NewAuthHandlers(userStore *stores.UserStore) *AuthHandlers {
-	return &AuthHandlers{userStore: userStore}
+func NewAuthHandlers(userStore *stores.UserStore, sessionStore *stores.SessionStore, identityStore *stores.IdentityStore, twoFactorStore *stores.TwoFactorStore, apiTokenStore *stores.APITokenStore, accessTokens services.AccessTokens, revocations services.RevocationList, throttle services.LoginThrottle, providers *services.AuthProviders, mailer services.Mailer, config *config.Config) *AuthHandlers {
+	return &AuthHandlers{
+		userStore:      userStore,
+		sessionStore:   sessionStore,
+		identityStore:  identityStore,
+		twoFactorStore: twoFactorStore,
+		apiTokenStore:  apiTokenStore,
+		accessTokens:   accessTokens,
+		revocations:    revocations,
+		throttle:       throttle,
+		providers:      providers,
+		mailer:         mailer,
+		config:         config,
+		signingKey:     utils.DeriveKey([]byte(config.AppSecret), "signing"),
+		encryptionKey:  utils.DeriveKey([]byte(config.AppSecret), "encryption"),
+	}
 }
 
 func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
@@ -38,35 +64,51 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := validator.New().Struct(body); err != nil {
-		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
 		return
 	}
 
-	user, err := (*h.userStore).GetUserByEmail(body.Email)
-	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "User not found")
+	attempt := h.startLoginAttempt(w, r, loginAccountKey(body.Email))
+	if attempt == nil {
 		return
 	}
+	defer attempt.Release()
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	// unknown emails and wrong passwords get the same answer, in the same time
+	user, err := (*h.userStore).GetUserByEmail(r.Context(), body.Email)
+	if err != nil && !errors.Is(err, stores.ErrNotFound) {
+		writeStoreError(w, r, err, "Failed to log in")
+		return
+	}
+	if !checkPassword(user, body.Password) {
+		h.loginFailed(r, attempt, user)
 		writeError(w, r, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
+	attempt.Succeed()
 
-	token, err := utils.GenerateJWT(user.ID)
+	challenge, err := h.newTwoFactorChallenge(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to generate token")
+		writeStoreError(w, r, err, "Failed to log in")
+		return
+	}
+	if challenge != "" {
+		writeJSON(w, r, http.StatusOK, map[string]any{"twoFactorRequired": true, "challenge": challenge})
+		return
+	}
+
+	if err := h.startSession(w, r, user.ID); err != nil {
+		writeStoreError(w, r, err, "Failed to start session")
 		return
 	}
 
-	utils.SetAuthCookie(w, token)
 	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Login successful"})
 }
 
 func (h *AuthHandlers) Register(w http.ResponseWriter, r *http.Request) {
 	type RegisterRequestBody struct {
-		Username string `json:"username" validate:"required,min=2,max=32,alphanum"`
+		Username string `json:"username" validate:"required"`
 		Name     string `json:"name" validate:"required,min=2,max=32"`
 		Email    string `json:"email" validate:"required,email,min=6,max=255"`
 		Password string `json:"password" validate:"required,min=8,max=255"`
@@ -78,163 +120,237 @@ func (h *AuthHandlers) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validate := validator.New()
-	if err := validate.Struct(body); err != nil {
-		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
 		return
 	}
 
 	// check if user already exists
-	user, err := (*h.userStore).GetUserByEmail(body.Email)
+	user, err := (*h.userStore).GetUserByEmail(r.Context(), body.Email)
 	if err == nil && user != nil {
-		writeError(w, r, http.StatusBadRequest, "User already exists")
+		writeStoreError(w, r, stores.ErrUserExists, "User already exists")
+		return
+	}
+
+	if err := utils.ValidateUsername(body.Username); err != nil {
+		writeStoreError(w, r, stores.NewValidationError("username", err.Error()), "Invalid username")
+		return
+	}
+
+	available, err := (*h.userStore).IsUsernameAvailable(r.Context(), body.Username, "")
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to check username")
+		return
+	}
+	if !available {
+		writeStoreError(w, r, stores.ErrUsernameTaken, "Username is already taken")
 		return
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to hash password")
+		writeStoreError(w, r, err, "Failed to hash password")
 		return
 	}
 
-	user, err = (*h.userStore).CreateUser(&models.User{
+	user, err = (*h.userStore).CreateUser(r.Context(), &models.User{
 		Username: body.Username,
 		Name:     body.Name,
 		Email:    body.Email,
 		Password: string(hashedPassword),
 	}, constants.AUTH_PROVIDER_CREDS)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to create user")
+		writeStoreError(w, r, err, "Failed to create user")
 		return
 	}
 
-	token, err := utils.GenerateJWT(user.ID)
-	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to generate token")
+	if err := h.sendVerificationEmail(r.Context(), user); err != nil {
+		writeStoreError(w, r, err, "Failed to send verification email")
 		return
 	}
 
-	// Set token as HTTP-only cookie
-	w.Header().Set("Set-Cookie", "token="+token+"; HttpOnly; SameSite=Strict; Max-Age="+strconv.Itoa(int(constants.AUTH_TOKEN_EXPIRY.Seconds())))
+	if err := h.startSession(w, r, user.ID); err != nil {
+		writeStoreError(w, r, err, "Failed to start session")
+		return
+	}
 
-	// Return success response without token in body
+	// Return success response without tokens in body
 	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Registration successful"})
 }
 
-func (h *AuthHandlers) OAuthLoginHandler(authConfig *oauth2.Config) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		url := authConfig.AuthCodeURL("state", oauth2.AccessTypeOffline)
-		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+// Refresh trades the refresh token cookie for a new access token and a new
+// refresh token. Each refresh token works once; if an old one comes back,
+// someone copied it and the whole session is revoked.
+func (h *AuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := utils.GetRefreshCookie(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Missing refresh token")
+		return
+	}
+	sessionID, tokenHash, err := utils.ParseRefreshToken(refreshToken)
+	if err != nil {
+		h.clearSessionCookies(w)
+		writeError(w, r, http.StatusUnauthorized, "Invalid refresh token")
+		return
 	}
-}
 
-func (h *AuthHandlers) OAuthCallbackHandler(authConfig *oauth2.Config) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
+	newRefreshToken, newTokenHash, err := utils.NewRefreshToken(sessionID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to generate token")
+		return
+	}
 
-		t, err := authConfig.Exchange(context.Background(), code)
-		if err != nil {
-			writeError(w, r, http.StatusBadRequest, "Failed to exchange code for access token")
-			return
-		}
+	session, err := (*h.sessionStore).RotateSession(r.Context(), sessionID, tokenHash, newTokenHash, utils.ClientIP(r))
+	if errors.Is(err, stores.ErrRefreshTokenReused) {
+		h.revokeAccessTokens(sessionID)
+		h.clearSessionCookies(w)
+		writeError(w, r, http.StatusUnauthorized, "Refresh token was already used, the session has been revoked")
+		return
+	}
+	if errors.Is(err, stores.ErrNotFound) {
+		h.clearSessionCookies(w)
+		writeError(w, r, http.StatusUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to refresh session")
+		return
+	}
 
-		client := authConfig.Client(context.Background(), t)
-		resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-		if err != nil {
-			writeError(w, r, http.StatusBadRequest, "Failed to exchange code for access token")
-			return
-		}
-		defer resp.Body.Close()
+	accessToken, err := h.accessTokens.Issue(session.UserID, session.ID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to generate token")
+		return
+	}
 
-		var v map[string]any
-		err = json.NewDecoder(resp.Body).Decode(&v)
-		if err != nil {
-			writeError(w, r, http.StatusInternalServerError, "Failed to decode response body")
-			return
-		}
+	utils.SetAuthCookie(w, accessToken)
+	utils.SetRefreshCookie(w, newRefreshToken)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Session refreshed"})
+}
 
-		email, ok := v["email"].(string)
-		if !ok {
-			writeError(w, r, http.StatusInternalServerError, "Email not found in OAuth response")
+// Logout revokes the current session, found from the access token or, once
+// that has expired, the refresh token
+func (h *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID := h.currentSession(r)
+	if sessionID != "" {
+		err := (*h.sessionStore).RevokeSession(r.Context(), userID, sessionID)
+		if err != nil && !errors.Is(err, stores.ErrNotFound) {
+			writeStoreError(w, r, err, "Failed to log out")
 			return
 		}
+		h.revokeAccessTokens(sessionID)
+	}
 
-		// Check if user already exists
-		user, err := (*h.userStore).GetUserByEmail(email)
-		if err == nil && user != nil {
-			// User exists, log them in
-			token, err := utils.GenerateJWT(user.ID)
-			if err != nil {
-				writeError(w, r, http.StatusInternalServerError, "Failed to generate token")
-				return
-			}
-			utils.SetAuthCookie(w, token)
-			http.Redirect(w, r, "http://localhost:3000", http.StatusSeeOther)
-			return
-		}
+	h.clearSessionCookies(w)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Logout successful"})
+}
 
-		// If error is not 'no user found', return error
-		if err != nil && err.Error() != "no user found" {
-			writeError(w, r, http.StatusInternalServerError, "Failed to check user existence")
-			return
-		}
+// GetJWKS publishes the public keys access tokens are signed with, so other
+// services can verify them
+func (h *AuthHandlers) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	// verifiers may cache the keys for a while; new keys are published
+	// before they sign anything
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, r, http.StatusOK, h.accessTokens.JWKS())
+}
 
-		// User does not exist, create new user
-		username, err := createUsername(v["name"].(string))
+// GetCSRFToken returns the token to send in the CSRF header with
+// state-changing requests, setting its cookie if the browser has none. A web
+// app on another origin can't read the cookie, so it reads the token here.
+func (h *AuthHandlers) GetCSRFToken(w http.ResponseWriter, r *http.Request) {
+	token, err := utils.GetCSRFCookie(r)
+	if err != nil || token == "" {
+		token, err = randomToken()
 		if err != nil {
-			writeError(w, r, http.StatusInternalServerError, "Failed to create username")
+			writeStoreError(w, r, err, "Failed to create CSRF token")
 			return
 		}
+		utils.SetCSRFCookie(w, token)
+	}
 
-		user, err = (*h.userStore).CreateUser(&models.User{
-			Name:     v["name"].(string),
-			Email:    email,
-			Username: username,
-		}, constants.AUTH_PROVIDER_GOOGLE)
-		if err != nil {
-			writeError(w, r, http.StatusInternalServerError, "Failed to create user")
-			return
-		}
+	writeJSON(w, r, http.StatusOK, map[string]string{"csrfToken": token})
+}
 
-		token, err := utils.GenerateJWT(user.ID)
-		if err != nil {
-			writeError(w, r, http.StatusInternalServerError, "Failed to generate token")
-			return
-		}
+// startSession signs the user in on this device: it stores a new session and
+// sets the access and refresh token cookies
+func (h *AuthHandlers) startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	session := models.NewSession(userID, "", r.UserAgent(), utils.ClientIP(r), time.Now().Add(constants.REFRESH_TOKEN_EXPIRY))
+	refreshToken, tokenHash, err := utils.NewRefreshToken(session.ID)
+	if err != nil {
+		return err
+	}
+	session.TokenHash = tokenHash
 
-		valid, err := utils.VerifyJWT(token)
-		if err != nil {
-			writeError(w, r, http.StatusInternalServerError, "Failed to verify token")
-			return
-		}
-		if !valid {
-			writeError(w, r, http.StatusUnauthorized, "Invalid token")
-			return
-		}
+	session, err = (*h.sessionStore).CreateSession(r.Context(), session)
+	if err != nil {
+		return err
+	}
 
-		utils.SetAuthCookie(w, token)
-		http.Redirect(w, r, "http://localhost:3000", http.StatusSeeOther)
+	accessToken, err := h.accessTokens.Issue(userID, session.ID)
+	if err != nil {
+		return err
 	}
+
+	utils.SetAuthCookie(w, accessToken)
+	utils.SetRefreshCookie(w, refreshToken)
+	return nil
 }
 
-func (h *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+// currentSession returns the user and session the request is signed in
+// with, or empty strings
+func (h *AuthHandlers) currentSession(r *http.Request) (string, string) {
+	userID, _ := r.Context().Value(constants.USER_ID_KEY).(string)
+	sessionID, _ := r.Context().Value(constants.SESSION_ID_KEY).(string)
+	if userID != "" && sessionID != "" {
+		return userID, sessionID
+	}
+
+	refreshToken, err := utils.GetRefreshCookie(r)
+	if err != nil {
+		return "", ""
+	}
+	sessionID, tokenHash, err := utils.ParseRefreshToken(refreshToken)
+	if err != nil {
+		return "", ""
+	}
+	session, err := (*h.sessionStore).GetSession(r.Context(), sessionID)
+	if err != nil || session.TokenHash != tokenHash {
+		return "", ""
+	}
+	return session.UserID, session.ID
+}
+
+// revokeAccessTokens rejects the access tokens already issued to a session
+// for the rest of their lifetime
+func (h *AuthHandlers) revokeAccessTokens(sessionID string) {
+	h.revocations.Revoke(sessionID, time.Now().Add(constants.ACCESS_TOKEN_EXPIRY))
+}
+
+func (h *AuthHandlers) clearSessionCookies(w http.ResponseWriter) {
 	utils.ClearAuthCookie(w)
-	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Logout successful"})
+	utils.ClearRefreshCookie(w)
 }
 
-func createUsername(name string) (string, error) {
-	username, err := nanoid.Generate("0123456789abcdefghijklmnopqrstuvwxyz", 10)
+// signJSON encodes v as a value only this server can have produced, for the
+// given purpose
+func (h *AuthHandlers) signJSON(purpose string, v any) (string, error) {
+	data, err := json.Marshal(v)
 	if err != nil {
 		return "", err
 	}
-	username = name + "_" + username
-	username = strings.ReplaceAll(username, " ", "_")
-	username = strings.Map(func(r rune) rune {
-		if unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_' {
-			return r
-		}
-		return -1
-	}, username)
-	username = strings.ToLower(username)
-	return username, nil
+	return utils.SignValue(h.signingKey, purpose, base64.RawURLEncoding.EncodeToString(data)), nil
+}
+
+// readSignedJSON decodes a value from signJSON into v, if it was signed for
+// purpose
+func (h *AuthHandlers) readSignedJSON(purpose string, signed string, v any) error {
+	value, err := utils.VerifySignedValue(h.signingKey, purpose, signed)
+	if err != nil {
+		return err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
 }
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/aimrintech/x-backend/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetPurpose     = "password-reset"
+	emailVerificationPurpose = "email-verification"
+)
+
+// emailToken is the token in a password reset or verification link. It is
+// signed rather than stored, and Fingerprint makes it single use: it is
+// derived from what using the token changes, the password hash or the
+// unverified email, so it no longer matches afterwards.
+type emailToken struct {
+	UserID      string `json:"userID"`
+	Fingerprint string `json:"fingerprint"`
+	ExpiresAt   int64  `json:"expiresAt"`
+}
+
+func passwordResetFingerprint(user *models.User) string {
+	return utils.HashToken("password:" + user.Password)
+}
+
+// emailVerificationFingerprint is empty once the email is verified, which
+// no token matches
+func emailVerificationFingerprint(user *models.User) string {
+	if user.EmailVerified {
+		return ""
+	}
+	return utils.HashToken("email:" + user.Email)
+}
+
+// ForgotPassword emails a password reset link. It answers the same whether
+// or not the email has an account, so it can't be used to find accounts.
+func (h *AuthHandlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type ForgotPasswordRequestBody struct {
+		Email string `json:"email" validate:"required,email,max=255"`
+	}
+
+	var body ForgotPasswordRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	user, err := (*h.userStore).GetUserByEmail(r.Context(), body.Email)
+	if err != nil && !errors.Is(err, stores.ErrNotFound) {
+		writeStoreError(w, r, err, "Failed to reset password")
+		return
+	}
+	if user != nil {
+		link, err := h.emailLink("/reset-password", passwordResetPurpose, user.ID, passwordResetFingerprint(user), constants.PASSWORD_RESET_EXPIRY)
+		if err != nil {
+			writeStoreError(w, r, err, "Failed to reset password")
+			return
+		}
+		h.sendEmail(r.Context(), &services.Email{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: "Someone asked to reset the password of your account @" + user.Username + ".\n\n" +
+				"Choose a new password here, within the next hour:\n" + link + "\n\n" +
+				"If it wasn't you, ignore this email and your password stays the same.",
+		})
+	}
+
+	writeJSON(w, r, http.StatusAccepted, map[string]string{
+		"message": "If an account uses this email, a link to reset its password is on its way",
+	})
+}
+
+// ResetPassword sets a new password with the token from a reset link. Every
+// session is signed out, since whoever forgot the password may not be the
+// only one who knew it.
+func (h *AuthHandlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	type ResetPasswordRequestBody struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8,max=255"`
+	}
+
+	var body ResetPasswordRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	user, ok := h.userForEmailToken(w, r, passwordResetPurpose, body.Token, passwordResetFingerprint)
+	if !ok {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to hash password")
+		return
+	}
+	if err := (*h.userStore).SetPassword(r.Context(), user.ID, string(hashedPassword)); err != nil {
+		writeStoreError(w, r, err, "Failed to reset password")
+		return
+	}
+	// the link arrived by email, so the email is the user's
+	if err := (*h.userStore).SetEmailVerified(r.Context(), user.ID, user.Email); err != nil {
+		writeStoreError(w, r, err, "Failed to reset password")
+		return
+	}
+
+	revoked, err := (*h.sessionStore).RevokeSessions(r.Context(), user.ID, "")
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to revoke sessions")
+		return
+	}
+	for _, id := range revoked {
+		h.revokeAccessTokens(id)
+	}
+	// whoever reset the password may not be whoever created the tokens
+	if err := (*h.apiTokenStore).DeleteAPITokens(r.Context(), user.ID); err != nil {
+		writeStoreError(w, r, err, "Failed to revoke API tokens")
+		return
+	}
+
+	h.clearSessionCookies(w)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Password reset, sign in with the new password"})
+}
+
+// VerifyEmail marks the user's email as verified with the token from a
+// verification link
+func (h *AuthHandlers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type VerifyEmailRequestBody struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	var body VerifyEmailRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	user, ok := h.userForEmailToken(w, r, emailVerificationPurpose, body.Token, emailVerificationFingerprint)
+	if !ok {
+		return
+	}
+
+	if err := (*h.userStore).SetEmailVerified(r.Context(), user.ID, user.Email); err != nil {
+		writeStoreError(w, r, err, "Failed to verify email")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Email verified"})
+}
+
+// ResendVerification emails the signed in user a new verification link
+func (h *AuthHandlers) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	user, err := (*h.userStore).GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get user")
+		return
+	}
+	if user.EmailVerified {
+		writeError(w, r, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	if err := h.sendVerificationEmail(r.Context(), user); err != nil {
+		writeStoreError(w, r, err, "Failed to send verification email")
+		return
+	}
+
+	writeJSON(w, r, http.StatusAccepted, map[string]string{"message": "Verification email sent"})
+}
+
+func (h *AuthHandlers) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	link, err := h.emailLink("/verify-email", emailVerificationPurpose, user.ID, emailVerificationFingerprint(user), constants.EMAIL_VERIFICATION_EXPIRY)
+	if err != nil {
+		return err
+	}
+	h.sendEmail(ctx, &services.Email{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: "Welcome, @" + user.Username + "!\n\n" +
+			"Confirm this is your email by opening this link within the next 48 hours:\n" + link,
+	})
+	return nil
+}
+
+// emailLink is a link to a page of the web app, carrying a signed token
+func (h *AuthHandlers) emailLink(path string, purpose string, userID string, fingerprint string, expiry time.Duration) (string, error) {
+	token, err := h.signJSON(purpose, &emailToken{
+		UserID:      userID,
+		Fingerprint: fingerprint,
+		ExpiresAt:   time.Now().Add(expiry).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return h.config.FrontendURL + path + "?" + url.Values{"token": {token}}.Encode(), nil
+}
+
+// userForEmailToken returns the user a token from an email link was issued
+// to, or writes an error if the token is invalid, expired or already used
+func (h *AuthHandlers) userForEmailToken(w http.ResponseWriter, r *http.Request, purpose string, signed string, fingerprint func(*models.User) string) (*models.User, bool) {
+	var token emailToken
+	if err := h.readSignedJSON(purpose, signed, &token); err != nil || time.Now().Unix() > token.ExpiresAt {
+		writeError(w, r, http.StatusBadRequest, "Invalid or expired link")
+		return nil, false
+	}
+
+	user, err := (*h.userStore).GetUserByID(r.Context(), token.UserID)
+	if errors.Is(err, stores.ErrNotFound) {
+		writeError(w, r, http.StatusBadRequest, "Invalid or expired link")
+		return nil, false
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get user")
+		return nil, false
+	}
+
+	expected := fingerprint(user)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token.Fingerprint)) != 1 {
+		writeError(w, r, http.StatusBadRequest, "This link was already used")
+		return nil, false
+	}
+	return user, true
+}
+
+// sendEmail sends an email. Failures are logged rather than returned: the
+// request that caused the email has succeeded, and the user can ask again.
+func (h *AuthHandlers) sendEmail(ctx context.Context, email *services.Email) {
+	if err := h.mailer.Send(ctx, email); err != nil {
+		log.Printf("sending %q to %s: %v", email.Subject, email.To, err)
+	}
+}
@@ -4,17 +4,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/aimrintech/x-backend/models"
 	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
 )
 
 type FeedHandlers struct {
-	feedService services.Feed
+	feedService   services.Feed
+	userStore     *stores.UserStore
+	filterChanges services.ContentFilterChanges
 }
 
-func NewFeedHandlers(feedService services.Feed) *FeedHandlers {
+func NewFeedHandlers(feedService services.Feed, userStore *stores.UserStore, filterChanges services.ContentFilterChanges) *FeedHandlers {
 	return &FeedHandlers{
-		feedService: feedService,
+		feedService:   feedService,
+		userStore:     userStore,
+		filterChanges: filterChanges,
 	}
 }
 
@@ -43,6 +50,10 @@ func (h *FeedHandlers) StreamFeed(w http.ResponseWriter, r *http.Request) {
 
 	feedChan := h.feedService.Subscribe(userID)
 	defer h.feedService.Unsubscribe(userID)
+	// send the headers right away so the client knows it is subscribed
+	flusher.Flush()
+
+	filters := newContentFilterCache(r.Context(), h.userStore, h.filterChanges, userID)
 
 	ctx := r.Context()
 	fmt.Printf("StreamFeed: Entering SSE loop for user %s\n", userID)
@@ -55,9 +66,13 @@ func (h *FeedHandlers) StreamFeed(w http.ResponseWriter, r *http.Request) {
 		case event, ok := <-feedChan:
 			if !ok {
 				fmt.Printf("StreamFeed: Channel closed for user %s\n", userID)
+				writeStreamReconnect(w, flusher)
 				return
 			}
 			fmt.Printf("StreamFeed: Received event for user %s: %+v\n", userID, event)
+			if !isFeedEventVisible(filters, userID, &event) {
+				continue
+			}
 			data, err := json.Marshal(event)
 			if err != nil {
 				fmt.Printf("StreamFeed: JSON marshal error: %v\n", err)
@@ -71,10 +86,41 @@ func (h *FeedHandlers) StreamFeed(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// isFeedEventVisible hides events whose tweet or actor is blocked or muted
+// by the subscriber
+func isFeedEventVisible(filters *contentFilterCache, userID string, event *models.FeedEvent) bool {
+	if event.Tweet.Author.ID == userID && event.ActorID == userID {
+		return true
+	}
+
+	filter, err := filters.get()
+	if err != nil {
+		return false
+	}
+
+	if event.ActorID != userID && !filter.AllowsUser(event.ActorID) {
+		return false
+	}
+	return event.Tweet.Author.ID == userID || filter.AllowsTweet(&event.Tweet)
+}
+
+// streamReconnectDelay is how long SSE clients wait before reconnecting when
+// the server ends their stream, e.g. while shutting down
+const streamReconnectDelay = 2 * time.Second
+
 func setStreamHeaders(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	// streams outlive the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// writeStreamReconnect tells the client its stream is over. EventSource
+// reconnects on its own, after the delay given here, which lands it on
+// another instance when this one is shutting down.
+func writeStreamReconnect(w http.ResponseWriter, flusher http.Flusher) {
+	fmt.Fprintf(w, "retry: %d\n\n", streamReconnectDelay.Milliseconds())
+	flusher.Flush()
 }
@@ -1,30 +1,154 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/aimrintech/x-backend/constants"
-	"github.com/aimrintech/x-backend/utils"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/go-playground/validator/v10"
 )
 
 func writeJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
-	// Set CORS headers
-	utils.SetCORSHeaders(w, r)
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
+// problem is an RFC 7807 problem details body
+type problem struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
 func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
-	// Set CORS headers
-	utils.SetCORSHeaders(w, r)
+	writeProblem(w, r, problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   message,
+		Instance: r.URL.Path,
+	})
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+// WriteError is writeError for middleware outside this package, so every
+// error response has the same shape
+func WriteError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeError(w, r, status, message)
+}
+
+// errorStatus maps the kinds of store errors to status codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, stores.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, stores.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, stores.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, stores.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, stores.ErrRateLimited):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeStoreError responds to an error returned by a store. Unexpected errors
+// become a 500 with fallback as the detail, so internals don't leak.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, r, status, fallback)
+		return
+	}
+
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+	var validationErr *stores.ValidationError
+	if errors.As(err, &validationErr) {
+		p.Errors = validationErr.Fields
+	}
+	writeProblem(w, r, p)
+}
+
+var bodyValidator = newBodyValidator()
+
+// newBodyValidator reports fields by their JSON names
+func newBodyValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// validateBody validates a request body against its validate tags and returns
+// a *stores.ValidationError naming the offending fields
+func validateBody(body any) error {
+	err := bodyValidator.Struct(body)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	validationErr := &stores.ValidationError{Fields: map[string]string{}}
+	for _, fieldErr := range fieldErrs {
+		validationErr.Fields[fieldErr.Field()] = fieldErrorMessage(fieldErr)
+	}
+	return validationErr
+}
+
+func fieldErrorMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email"
+	case "url":
+		return "must be a valid URL"
+	case "min":
+		return "must be at least " + fieldErr.Param() + " long"
+	case "max":
+		return "must be at most " + fieldErr.Param() + " long"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fieldErr.Param(), " ", ", ")
+	default:
+		return "is invalid"
+	}
 }
 
 func readJSON(r *http.Request, v any) error {
@@ -38,3 +162,39 @@ func getUserID(r *http.Request) (string, error) {
 	}
 	return userID, nil
 }
+
+// how long a stream keeps using a loaded content filter before reloading it.
+// Changes made through this process reload it right away; the TTL covers
+// those made through other processes.
+const contentFilterTTL = 30 * time.Second
+
+// contentFilterCache holds the content filter of one streaming subscriber,
+// reloading it when the user's follows, blocks or mutes change. Reloads run
+// under the stream's request context.
+type contentFilterCache struct {
+	ctx       context.Context
+	userStore *stores.UserStore
+	changes   services.ContentFilterChanges
+	userID    string
+	filter    *models.ContentFilter
+	loadedAt  time.Time
+	version   uint64
+}
+
+func newContentFilterCache(ctx context.Context, userStore *stores.UserStore, changes services.ContentFilterChanges, userID string) *contentFilterCache {
+	return &contentFilterCache{ctx: ctx, userStore: userStore, changes: changes, userID: userID}
+}
+
+func (c *contentFilterCache) get() (*models.ContentFilter, error) {
+	version := c.changes.Version(c.userID)
+	if c.filter != nil && version == c.version && time.Since(c.loadedAt) < contentFilterTTL {
+		return c.filter, nil
+	}
+
+	filter, err := (*c.userStore).GetContentFilter(c.ctx, c.userID)
+	if err != nil {
+		return nil, err
+	}
+	c.filter, c.loadedAt, c.version = filter, time.Now(), version
+	return filter, nil
+}
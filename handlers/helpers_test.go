@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, errorStatus(stores.ErrUserNotFound))
+	assert.Equal(t, http.StatusForbidden, errorStatus(stores.ErrBlocked))
+	assert.Equal(t, http.StatusForbidden, errorStatus(stores.ErrProtected))
+	assert.Equal(t, http.StatusConflict, errorStatus(stores.ErrUsernameTaken))
+	assert.Equal(t, http.StatusBadRequest, errorStatus(stores.ErrInvalidCursor))
+	assert.Equal(t, http.StatusTooManyRequests, errorStatus(stores.ErrUsernameChangeLimit))
+	assert.Equal(t, http.StatusInternalServerError, errorStatus(errors.New("connection refused")))
+}
+
+func TestWriteStoreError_ProblemJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/tweets/1", nil)
+
+	w := httptest.NewRecorder()
+	writeStoreError(w, r, stores.NewValidationError("content", "is required"), "Invalid request body")
+
+	var p problem
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, "/api/tweets/1", p.Instance)
+	assert.Equal(t, map[string]string{"content": "is required"}, p.Errors)
+
+	// unexpected errors don't leak their message
+	w = httptest.NewRecorder()
+	writeStoreError(w, r, errors.New("neo4j: connection refused"), "Failed to get tweet")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+	assert.Equal(t, "Failed to get tweet", p.Detail)
+}
+
+func TestValidateBody_FieldNames(t *testing.T) {
+	type body struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	err := validateBody(body{Email: "nope"})
+
+	var validationErr *stores.ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.ErrorIs(t, err, stores.ErrValidation)
+	assert.Equal(t, "must be a valid email", validationErr.Fields["email"])
+	assert.NoError(t, validateBody(body{Email: "a@example.com"}))
+}
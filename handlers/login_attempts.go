@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared against when there is no password to check,
+// so that unknown emails take as long to reject as wrong passwords
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// checkPassword reports whether password is user's. user may be nil, or
+// have no password if they only sign in with a provider; the check then
+// fails but takes just as long.
+func checkPassword(user *models.User, password string) bool {
+	if user == nil || user.Password == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+// loginAccountKey is the throttle key of the account a login names. Emails
+// without an account are throttled the same way, so the responses don't
+// tell them apart.
+func loginAccountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// startLoginAttempt reserves a sign in attempt for the account, or writes a
+// 429 and returns nil if the account or the client have failed to sign in
+// too often recently
+func (h *AuthHandlers) startLoginAttempt(w http.ResponseWriter, r *http.Request, account string) *services.LoginAttempt {
+	attempt, wait := h.throttle.Attempt(account, utils.ClientIP(r))
+	if attempt != nil {
+		return attempt
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, r, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+	return nil
+}
+
+// loginFailed records a failed attempt. When it locks the account of an
+// existing user, they are told by email, since someone may be guessing
+// their password.
+func (h *AuthHandlers) loginFailed(r *http.Request, attempt *services.LoginAttempt, user *models.User) {
+	ip := utils.ClientIP(r)
+	if !attempt.Fail() || user == nil {
+		return
+	}
+
+	h.sendEmail(r.Context(), &services.Email{
+		To:      user.Email,
+		Subject: "Suspicious sign in attempts on your account",
+		Body: "There were many failed attempts to sign in to your account @" + user.Username + ", most recently from " + ip + ".\n\n" +
+			"We have paused sign in to your account for a while. If this wasn't you, " +
+			"your password may be known to someone else: reset it from the sign in page.",
+	})
+}
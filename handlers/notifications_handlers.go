@@ -12,12 +12,16 @@ import (
 type NotificationsHandlers struct {
 	notificationsService services.Notifications
 	notificationsStore   stores.NotificationsStore
+	userStore            *stores.UserStore
+	filterChanges        services.ContentFilterChanges
 }
 
-func NewNotificationsHandlers(notificationsService services.Notifications, notificationsStore stores.NotificationsStore) *NotificationsHandlers {
+func NewNotificationsHandlers(notificationsService services.Notifications, notificationsStore stores.NotificationsStore, userStore *stores.UserStore, filterChanges services.ContentFilterChanges) *NotificationsHandlers {
 	return &NotificationsHandlers{
 		notificationsService: notificationsService,
 		notificationsStore:   notificationsStore,
+		userStore:            userStore,
+		filterChanges:        filterChanges,
 	}
 }
 
@@ -35,9 +39,7 @@ func (h *NotificationsHandlers) StreamNotifications(w http.ResponseWriter, r *ht
 	}
 
 	// set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	setStreamHeaders(w)
 
 	// get flusher
 	flusher, ok := w.(http.Flusher)
@@ -49,7 +51,10 @@ func (h *NotificationsHandlers) StreamNotifications(w http.ResponseWriter, r *ht
 	// subscribe to notifications
 	notificationsChan := h.notificationsService.Subscribe(models.NotificationType(notificationType), userID)
 	defer h.notificationsService.Unsubscribe(models.NotificationType(notificationType), userID)
+	// send the headers right away so the client knows it is subscribed
+	flusher.Flush()
 
+	filters := newContentFilterCache(r.Context(), h.userStore, h.filterChanges, userID)
 	ctx := r.Context()
 
 	for {
@@ -59,9 +64,17 @@ func (h *NotificationsHandlers) StreamNotifications(w http.ResponseWriter, r *ht
 			return
 		case notification, ok := <-notificationsChan:
 			if !ok {
+				// the service is shutting down
+				writeStreamReconnect(w, flusher)
 				return
 			}
 
+			// drop notifications caused by blocked or muted users
+			filter, err := filters.get()
+			if err != nil || !filter.AllowsNotification(&notification) {
+				continue
+			}
+
 			// marshal JSON
 			data, err := json.Marshal(notification)
 			if err != nil {
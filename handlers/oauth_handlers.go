@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/aimrintech/x-backend/utils"
+	nanoid "github.com/matoous/go-nanoid/v2"
+	"golang.org/x/oauth2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// how long a user has to finish signing in at the provider
+const oauthStateExpiry = 10 * time.Minute
+
+const (
+	oauthStateCookie  = "oauth_state"
+	oauthStatePurpose = "oauth-state"
+)
+
+// oauthState is kept in a signed cookie between the redirect to the provider
+// and the callback. State must match the callback's state parameter, which
+// ties the callback to the browser that started the flow, Verifier is the
+// PKCE code verifier and Nonce must be echoed in an OpenID Connect ID token.
+// LinkUserID is set when a signed in user is linking the
+// provider account rather than signing in with it.
+type oauthState struct {
+	Provider   string `json:"provider"`
+	State      string `json:"state"`
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserID string `json:"linkUserID,omitempty"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+// GetOAuthProviders lists the providers users can sign in with
+func (h *AuthHandlers) GetOAuthProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.providers.Names())
+}
+
+// OAuthLogin sends the user to the provider to sign in
+func (h *AuthHandlers) OAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.oauthProvider(w, r)
+	if !ok {
+		return
+	}
+	h.redirectToProvider(w, r, provider, "")
+}
+
+// OAuthLink sends a signed in user to the provider to link their account
+// there to this one
+func (h *AuthHandlers) OAuthLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	provider, ok := h.oauthProvider(w, r)
+	if !ok {
+		return
+	}
+	h.redirectToProvider(w, r, provider, userID)
+}
+
+// oauthProvider returns the provider named in the path
+func (h *AuthHandlers) oauthProvider(w http.ResponseWriter, r *http.Request) (*services.OAuthProvider, bool) {
+	provider, ok := h.providers.Get(constants.AuthProvider(r.PathValue("provider")))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "Unknown sign in provider")
+	}
+	return provider, ok
+}
+
+func (h *AuthHandlers) redirectToProvider(w http.ResponseWriter, r *http.Request, provider *services.OAuthProvider, linkUserID string) {
+	state, err := randomToken()
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to start sign in")
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to start sign in")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	if err := h.setOAuthStateCookie(w, &oauthState{
+		Provider:   string(provider.Name),
+		State:      state,
+		Verifier:   verifier,
+		Nonce:      nonce,
+		LinkUserID: linkUserID,
+		ExpiresAt:  time.Now().Add(oauthStateExpiry).Unix(),
+	}); err != nil {
+		writeStoreError(w, r, err, "Failed to start sign in")
+		return
+	}
+
+	authURL := provider.Config.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	)
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// OAuthCallback finishes signing in at the provider. A provider
+// account signs in the user it is linked to. An unlinked one creates a new
+// user, unless its email belongs to an existing account: that account's
+// owner has to sign in and link the provider explicitly, otherwise anyone
+// controlling the email at the provider could take the account over.
+func (h *AuthHandlers) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.oauthProvider(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := h.readOAuthStateCookie(r)
+	utils.ClearCookie(w, oauthStateCookie, "/api/oauth")
+	if err != nil || state.Provider != string(provider.Name) ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(r.URL.Query().Get("state"))) != 1 {
+		writeError(w, r, http.StatusBadRequest, "Invalid or expired OAuth state")
+		return
+	}
+	if r.URL.Query().Get("error") != "" {
+		h.redirectToFrontend(w, r, "oauth_error", "access_denied")
+		return
+	}
+
+	token, err := provider.Config.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Failed to exchange code for access token")
+		return
+	}
+
+	info, err := provider.UserInfo.UserInfo(r.Context(), token, state.Nonce)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, "Failed to get user info from provider")
+		return
+	}
+
+	if state.LinkUserID != "" {
+		h.linkIdentity(w, r, provider, info, state.LinkUserID)
+		return
+	}
+
+	identity, err := (*h.identityStore).GetIdentity(r.Context(), string(provider.Name), info.Subject)
+	if err == nil {
+		h.signInFromProvider(w, r, identity.UserID)
+		return
+	}
+	if !errors.Is(err, stores.ErrNotFound) {
+		writeStoreError(w, r, err, "Failed to check identity")
+		return
+	}
+
+	user, err := h.userForNewIdentity(r, provider, info)
+	if errors.Is(err, stores.ErrUserExists) {
+		h.redirectToFrontend(w, r, "oauth_error", "account_exists")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to create user")
+		return
+	}
+
+	if _, err := (*h.identityStore).LinkIdentity(r.Context(), &models.AuthIdentity{
+		Provider: string(provider.Name),
+		Subject:  info.Subject,
+		Email:    info.Email,
+		UserID:   user.ID,
+	}); err != nil {
+		writeStoreError(w, r, err, "Failed to link account")
+		return
+	}
+
+	h.signInFromProvider(w, r, user.ID)
+}
+
+// signInFromProvider starts a session and returns to the web app. Users with
+// two-factor authentication return with a challenge to finish signing in.
+func (h *AuthHandlers) signInFromProvider(w http.ResponseWriter, r *http.Request, userID string) {
+	challenge, err := h.newTwoFactorChallenge(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to start session")
+		return
+	}
+	if challenge != "" {
+		h.redirectToFrontend(w, r, "two_factor_challenge", challenge)
+		return
+	}
+
+	if err := h.startSession(w, r, userID); err != nil {
+		writeStoreError(w, r, err, "Failed to start session")
+		return
+	}
+	h.redirectToFrontend(w, r, "", "")
+}
+
+// userForNewIdentity returns the user an unlinked provider account signs in
+// as. That is a new user, or, for accounts created by OAuth sign in before
+// identities were recorded, the passwordless user with the same email.
+func (h *AuthHandlers) userForNewIdentity(r *http.Request, provider *services.OAuthProvider, info *models.OAuthUserInfo) (*models.User, error) {
+	if !info.EmailVerified {
+		return nil, stores.NewValidationError("email", "the provider has not verified this email")
+	}
+
+	existing, err := (*h.userStore).GetUserByEmail(r.Context(), info.Email)
+	if err != nil && !errors.Is(err, stores.ErrNotFound) {
+		return nil, err
+	}
+	if existing != nil {
+		identities, err := (*h.identityStore).ListIdentities(r.Context(), existing.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing.Password == "" && len(identities) == 0 {
+			return existing, nil
+		}
+		return nil, stores.ErrUserExists
+	}
+
+	name := info.Name
+	if name == "" {
+		name, _, _ = strings.Cut(info.Email, "@")
+	}
+	username, err := createUsername(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*h.userStore).CreateUser(r.Context(), &models.User{
+		Name:          name,
+		Email:         info.Email,
+		EmailVerified: true,
+		Username:      username,
+	}, provider.Name)
+}
+
+// linkIdentity links the provider account to the signed in user who started
+// the flow
+func (h *AuthHandlers) linkIdentity(w http.ResponseWriter, r *http.Request, provider *services.OAuthProvider, info *models.OAuthUserInfo, userID string) {
+	// the session must still be the one that asked to link
+	if currentUserID, err := getUserID(r); err != nil || currentUserID != userID {
+		writeError(w, r, http.StatusUnauthorized, "Sign in again to link this account")
+		return
+	}
+
+	_, err := (*h.identityStore).LinkIdentity(r.Context(), &models.AuthIdentity{
+		Provider: string(provider.Name),
+		Subject:  info.Subject,
+		Email:    info.Email,
+		UserID:   userID,
+	})
+	if errors.Is(err, stores.ErrIdentityLinked) {
+		h.redirectToFrontend(w, r, "oauth_error", "identity_in_use")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to link account")
+		return
+	}
+
+	h.redirectToFrontend(w, r, "linked", string(provider.Name))
+}
+
+// GetIdentities lists the provider accounts linked to the user
+func (h *AuthHandlers) GetIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	identities, err := (*h.identityStore).ListIdentities(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get linked accounts")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, identities)
+}
+
+// redirectToFrontend ends a browser flow on the web app, optionally with a
+// query parameter telling it what happened
+func (h *AuthHandlers) redirectToFrontend(w http.ResponseWriter, r *http.Request, key string, value string) {
+	target := h.config.FrontendURL
+	if key != "" {
+		target += "?" + url.Values{key: {value}}.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func (h *AuthHandlers) setOAuthStateCookie(w http.ResponseWriter, state *oauthState) error {
+	value, err := h.signJSON(oauthStatePurpose, state)
+	if err != nil {
+		return err
+	}
+
+	// Lax, so the cookie is sent when the provider redirects back
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/api/oauth",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oauthStateExpiry.Seconds()),
+	})
+	return nil
+}
+
+func (h *AuthHandlers) readOAuthStateCookie(r *http.Request) (*oauthState, error) {
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return nil, err
+	}
+	var state oauthState
+	if err := h.readSignedJSON(oauthStatePurpose, cookie.Value, &state); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > state.ExpiresAt {
+		return nil, errors.New("oauth state expired")
+	}
+	return &state, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// createUsername turns a display name into a valid username with a random
+// suffix. Accented letters keep their base letter and other characters
+// outside ASCII are dropped; names that leave nothing usable get a random
+// username instead.
+func createUsername(name string) (string, error) {
+	suffix, err := nanoid.Generate("0123456789abcdefghijklmnopqrstuvwxyz", 10)
+	if err != nil {
+		return "", err
+	}
+
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		case unicode.IsSpace(r):
+			return '_'
+		}
+		return -1
+	}, norm.NFD.String(name))
+	base = base[:min(len(base), utils.MaxUsernameLength-len("_")-len(suffix))]
+
+	username := base + "_" + suffix
+	if base == "" || utils.ValidateUsername(username) != nil {
+		username = "user_" + suffix
+	}
+	return username, nil
+}
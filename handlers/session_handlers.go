@@ -0,0 +1,65 @@
+package handlers
+
+import "net/http"
+
+// GetSessions lists the devices the user is signed in on
+func (h *AuthHandlers) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID := h.currentSession(r)
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessions, err := (*h.sessionStore).ListSessions(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get sessions")
+		return
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == sessionID
+	}
+
+	writeJSON(w, r, http.StatusOK, sessions)
+}
+
+// RevokeSession signs one of the user's devices out. Revoking the current
+// session is the same as logging out.
+func (h *AuthHandlers) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, currentSessionID := h.currentSession(r)
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessionID := r.PathValue("id")
+	if err := (*h.sessionStore).RevokeSession(r.Context(), userID, sessionID); err != nil {
+		writeStoreError(w, r, err, "Failed to revoke session")
+		return
+	}
+	h.revokeAccessTokens(sessionID)
+
+	if sessionID == currentSessionID {
+		h.clearSessionCookies(w)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs the user out everywhere but the current device
+func (h *AuthHandlers) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID := h.currentSession(r)
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	revoked, err := (*h.sessionStore).RevokeSessions(r.Context(), userID, sessionID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to revoke sessions")
+		return
+	}
+	for _, id := range revoked {
+		h.revokeAccessTokens(id)
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]int{"revoked": len(revoked)})
+}
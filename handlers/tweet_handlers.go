@@ -2,28 +2,40 @@ package handlers
 
 import (
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
 	"github.com/aimrintech/x-backend/stores"
 )
 
+// how far back the ranked timeline looks for candidates, and how many it
+// considers before scoring
+const (
+	forYouWindow         = 72 * time.Hour
+	forYouCandidateLimit = 500
+)
+
 type TweetHandlers struct {
-	tweetStore *stores.TweetStore
+	tweetStore      *stores.TweetStore
+	timelineService services.Timeline
 }
 
-func NewTweetHandlers(tweetStore *stores.TweetStore) *TweetHandlers {
+func NewTweetHandlers(tweetStore *stores.TweetStore, timelineService services.Timeline) *TweetHandlers {
 	return &TweetHandlers{
-		tweetStore: tweetStore,
+		tweetStore:      tweetStore,
+		timelineService: timelineService,
 	}
 }
 
 func (h *TweetHandlers) GetTweets(w http.ResponseWriter, r *http.Request) {
 	limit, offset := extractPaginationParams(r)
 
-	tweets, err := (*h.tweetStore).GetTweets(limit, offset)
+	tweets, err := (*h.tweetStore).GetTweets(r.Context(), limit, offset)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to get tweets")
+		writeStoreError(w, r, err, "Failed to get tweets")
 		return
 	}
 
@@ -39,25 +51,49 @@ func (h *TweetHandlers) GetUsersWithTweets(w http.ResponseWriter, r *http.Reques
 
 	limit, offset := extractPaginationParams(r)
 
-	usersWithTweets, err := (*h.tweetStore).GetUsersWithTweets(userID, limit, offset)
+	usersWithTweets, err := (*h.tweetStore).GetUsersWithTweets(r.Context(), userID, limit, offset)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to get users with tweets")
+		writeStoreError(w, r, err, "Failed to get users with tweets")
 		return
 	}
 
 	writeJSON(w, r, http.StatusOK, usersWithTweets)
 }
 
+func (h *TweetHandlers) GetForYouTimeline(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset := extractPaginationParams(r)
+
+	candidates, err := (*h.tweetStore).GetTimelineCandidates(r.Context(), userID, time.Now().Add(-forYouWindow), forYouCandidateLimit)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get timeline")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, h.timelineService.Rank(candidates, limit, offset))
+}
+
 func (h *TweetHandlers) GetTweetByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
 	tweetID := r.PathValue("id")
 	if tweetID == "" {
 		writeError(w, r, http.StatusBadRequest, "Tweet ID is required")
 		return
 	}
 
-	tweet, err := (*h.tweetStore).GetTweetByID(tweetID)
+	tweet, err := (*h.tweetStore).GetTweetByID(r.Context(), tweetID, userID)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to get tweet")
+		writeStoreError(w, r, err, "Failed to get tweet")
 		return
 	}
 
@@ -96,9 +132,9 @@ func (h *TweetHandlers) CreateTweet(w http.ResponseWriter, r *http.Request) {
 		Content:   req.Content,
 		MediaURLs: req.MediaURLs,
 	}
-	tweet, err := (*h.tweetStore).CreateTweet(&t, userID)
+	tweet, err := (*h.tweetStore).CreateTweet(r.Context(), &t, userID)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to create tweet")
+		writeStoreError(w, r, err, "Failed to create tweet")
 		return
 	}
 
@@ -118,9 +154,9 @@ func (h *TweetHandlers) LikeTweet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = (*h.tweetStore).LikeTweet(tweetID, userID)
+	err = (*h.tweetStore).LikeTweet(r.Context(), tweetID, userID)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to like tweet")
+		writeStoreError(w, r, err, "Failed to like tweet")
 		return
 	}
 
@@ -140,15 +176,135 @@ func (h *TweetHandlers) UnlikeTweet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = (*h.tweetStore).UnlikeTweet(tweetID, userID)
+	err = (*h.tweetStore).UnlikeTweet(r.Context(), tweetID, userID)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to unlike tweet")
+		writeStoreError(w, r, err, "Failed to unlike tweet")
 		return
 	}
 
 	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Tweet unliked"})
 }
 
+func (h *TweetHandlers) ReplyToTweet(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tweetID := r.PathValue("id")
+	if tweetID == "" {
+		writeError(w, r, http.StatusBadRequest, "Tweet ID is required")
+		return
+	}
+
+	type ReplyRequest struct {
+		Content   *string   `json:"content"`
+		MediaURLs *[]string `json:"mediaURLs"`
+	}
+
+	var req ReplyRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Content == nil {
+		writeError(w, r, http.StatusBadRequest, "Content is required")
+		return
+	}
+
+	if len(*req.Content) > 280 {
+		writeError(w, r, http.StatusBadRequest, "Content must be less than 280 characters")
+		return
+	}
+
+	reply, err := (*h.tweetStore).ReplyToTweet(r.Context(), tweetID, userID, &models.Tweet{
+		Content:   req.Content,
+		MediaURLs: req.MediaURLs,
+	})
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to reply to tweet")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, reply)
+}
+
+func (h *TweetHandlers) GetReplies(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tweetID := r.PathValue("id")
+	if tweetID == "" {
+		writeError(w, r, http.StatusBadRequest, "Tweet ID is required")
+		return
+	}
+
+	limit, offset := extractPaginationParams(r)
+
+	replies, err := (*h.tweetStore).GetReplies(r.Context(), tweetID, userID, limit, offset)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get replies")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, replies)
+}
+
+// GetProfileTimeline serves one tab of a user's profile with cursor
+// pagination. The tweets tab starts with the pinned tweet.
+func (h *TweetHandlers) GetProfileTimeline(w http.ResponseWriter, r *http.Request) {
+	currUserID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID := r.PathValue("id")
+	if userID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+
+	// the route matches any segment, only the known tabs exist
+	tab := models.ProfileTab(r.PathValue("tab"))
+	if !slices.Contains(models.ProfileTabs, tab) {
+		writeError(w, r, http.StatusNotFound, "Not found")
+		return
+	}
+
+	limit, _ := extractPaginationParams(r)
+	if limit < 1 {
+		writeStoreError(w, r, stores.NewValidationError("limit", "must be at least 1"), "Invalid limit")
+		return
+	}
+	limit = min(limit, models.MaxTweetPageSize)
+	cursor := r.URL.Query().Get("cursor")
+
+	page, err := (*h.tweetStore).GetProfileTimeline(r.Context(), tab, userID, currUserID, cursor, limit)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get tweets")
+		return
+	}
+
+	if tab == models.ProfileTabTweets && cursor == "" {
+		pinned, err := (*h.tweetStore).GetPinnedTweet(r.Context(), userID, currUserID)
+		if err != nil {
+			writeStoreError(w, r, err, "Failed to get tweets")
+			return
+		}
+		if pinned != nil {
+			page.Tweets = append([]models.TweetProps{*pinned}, page.Tweets...)
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, page)
+}
+
 func extractPaginationParams(r *http.Request) (int, int) {
 	params := r.URL.Query()
 	limit, err := strconv.Atoi(params.Get("limit"))
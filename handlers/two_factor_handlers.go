@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/aimrintech/x-backend/utils"
+)
+
+const (
+	twoFactorChallengePurpose = "two-factor-challenge"
+	totpSecretPurpose         = "totp-secret"
+	totpIssuer                = "X"
+	recoveryCodeCount         = 10
+)
+
+// twoFactorChallenge is handed out by Login in place of a session when the
+// user has two-factor authentication, and traded for one with a code
+type twoFactorChallenge struct {
+	UserID    string `json:"userID"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// secondFactor is a code from the authenticator app or a recovery code
+type secondFactor struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+// GetTwoFactor reports whether the user has two-factor authentication on
+func (h *AuthHandlers) GetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	twoFactor, err := (*h.twoFactorStore).GetTwoFactor(r.Context(), userID)
+	if errors.Is(err, stores.ErrNotFound) {
+		writeJSON(w, r, http.StatusOK, map[string]any{"enabled": false, "recoveryCodesLeft": 0})
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get two-factor authentication")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]any{
+		"enabled":           twoFactor.Enabled,
+		"recoveryCodesLeft": len(twoFactor.RecoveryCodes),
+	})
+}
+
+// EnrollTwoFactor creates a TOTP secret for the user to add to their
+// authenticator app. It isn't used until ConfirmTwoFactor.
+func (h *AuthHandlers) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	user, err := (*h.userStore).GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get user")
+		return
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to create secret")
+		return
+	}
+	encrypted, err := utils.EncryptValue(h.encryptionKey, totpSecretPurpose, secret)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to create secret")
+		return
+	}
+	if err := (*h.twoFactorStore).StartTwoFactor(r.Context(), userID, encrypted); err != nil {
+		writeStoreError(w, r, err, "Failed to set up two-factor authentication")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{
+		"secret":     secret,
+		"otpauthURI": utils.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user proves
+// their app has the secret, and returns the recovery codes. They are only
+// ever shown here.
+func (h *AuthHandlers) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type ConfirmTwoFactorRequestBody struct {
+		Code string `json:"code" validate:"required"`
+	}
+
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var body ConfirmTwoFactorRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	twoFactor, err := (*h.twoFactorStore).GetTwoFactor(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get two-factor authentication")
+		return
+	}
+	if twoFactor.Enabled {
+		writeStoreError(w, r, stores.ErrTwoFactorEnabled, "Two-factor authentication is already enabled")
+		return
+	}
+
+	ok, err := h.verifySecondFactor(r.Context(), twoFactor, &secondFactor{Code: body.Code})
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to verify code")
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to create recovery codes")
+		return
+	}
+	if err := (*h.twoFactorStore).EnableTwoFactor(r.Context(), userID, hashes); err != nil {
+		writeStoreError(w, r, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
+
+// DisableTwoFactor turns two-factor authentication off, given a code
+func (h *AuthHandlers) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := (*h.twoFactorStore).DisableTwoFactor(r.Context(), userID); err != nil {
+		writeStoreError(w, r, err, "Failed to disable two-factor authentication")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, given a code
+func (h *AuthHandlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to create recovery codes")
+		return
+	}
+	if err := (*h.twoFactorStore).SetRecoveryCodes(r.Context(), userID, hashes); err != nil {
+		writeStoreError(w, r, err, "Failed to replace recovery codes")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
+
+// LoginTwoFactor finishes signing in with the challenge from Login and a
+// code
+func (h *AuthHandlers) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type LoginTwoFactorRequestBody struct {
+		Challenge string `json:"challenge" validate:"required"`
+		secondFactor
+	}
+
+	var body LoginTwoFactorRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	var challenge twoFactorChallenge
+	if err := h.readSignedJSON(twoFactorChallengePurpose, body.Challenge, &challenge); err != nil || time.Now().Unix() > challenge.ExpiresAt {
+		writeError(w, r, http.StatusUnauthorized, "Invalid or expired challenge, sign in again")
+		return
+	}
+
+	// codes are guessed per user, whatever email was used to sign in
+	attempt := h.startLoginAttempt(w, r, "user:"+challenge.UserID)
+	if attempt == nil {
+		return
+	}
+	defer attempt.Release()
+
+	twoFactor, err := (*h.twoFactorStore).GetTwoFactor(r.Context(), challenge.UserID)
+	if err != nil && !errors.Is(err, stores.ErrNotFound) {
+		writeStoreError(w, r, err, "Failed to get two-factor authentication")
+		return
+	}
+	// turned off since the challenge was issued
+	if twoFactor == nil || !twoFactor.Enabled {
+		writeError(w, r, http.StatusUnauthorized, "Invalid or expired challenge, sign in again")
+		return
+	}
+
+	ok, err := h.verifySecondFactor(r.Context(), twoFactor, &body.secondFactor)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to verify code")
+		return
+	}
+	if !ok {
+		user, _ := (*h.userStore).GetUserByID(r.Context(), challenge.UserID)
+		h.loginFailed(r, attempt, user)
+		writeError(w, r, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	attempt.Succeed()
+
+	if err := h.startSession(w, r, challenge.UserID); err != nil {
+		writeStoreError(w, r, err, "Failed to start session")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Login successful"})
+}
+
+// newTwoFactorChallenge returns a challenge if the user has two-factor
+// authentication enabled, and "" if the user can be signed in right away
+func (h *AuthHandlers) newTwoFactorChallenge(ctx context.Context, userID string) (string, error) {
+	twoFactor, err := (*h.twoFactorStore).GetTwoFactor(ctx, userID)
+	if errors.Is(err, stores.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !twoFactor.Enabled {
+		return "", nil
+	}
+
+	return h.signJSON(twoFactorChallengePurpose, &twoFactorChallenge{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(constants.TWO_FACTOR_CHALLENGE_EXPIRY).Unix(),
+	})
+}
+
+// requireSecondFactor reads a code from the body and checks it against the
+// signed in user's enabled authenticator, writing an error if it can't. Codes
+// are throttled like those entered at sign in, so a stolen session can't be
+// used to guess one.
+func (h *AuthHandlers) requireSecondFactor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return "", false
+	}
+
+	var body secondFactor
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return "", false
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return "", false
+	}
+
+	twoFactor, err := (*h.twoFactorStore).GetTwoFactor(r.Context(), userID)
+	if err == nil && !twoFactor.Enabled {
+		err = stores.ErrTwoFactorNotFound
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get two-factor authentication")
+		return "", false
+	}
+
+	attempt := h.startLoginAttempt(w, r, "user:"+userID)
+	if attempt == nil {
+		return "", false
+	}
+	defer attempt.Release()
+
+	ok, err := h.verifySecondFactor(r.Context(), twoFactor, &body)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to verify code")
+		return "", false
+	}
+	if !ok {
+		user, _ := (*h.userStore).GetUserByID(r.Context(), userID)
+		h.loginFailed(r, attempt, user)
+		writeError(w, r, http.StatusForbidden, "Invalid code")
+		return "", false
+	}
+	attempt.Succeed()
+	return userID, true
+}
+
+// verifySecondFactor checks a TOTP or recovery code and uses it up. A code
+// that was already used is reported as invalid.
+func (h *AuthHandlers) verifySecondFactor(ctx context.Context, twoFactor *models.TwoFactor, factor *secondFactor) (bool, error) {
+	if factor.RecoveryCode != "" {
+		err := (*h.twoFactorStore).UseRecoveryCode(ctx, twoFactor.UserID, hashRecoveryCode(factor.RecoveryCode))
+		if errors.Is(err, stores.ErrRecoveryCodeInvalid) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	secret, err := utils.DecryptValue(h.encryptionKey, totpSecretPurpose, twoFactor.Secret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := utils.VerifyTOTP(secret, factor.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err = (*h.twoFactorStore).UseTOTPStep(ctx, twoFactor.UserID, step)
+	if errors.Is(err, stores.ErrTOTPCodeUsed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newRecoveryCodes returns recovery codes to show the user, and the hashes
+// to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		secret, err := utils.NewTOTPSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(secret[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code however the user typed it. The
+// codes are random, so a fast hash is enough.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return utils.HashToken("recovery:" + code)
+}
@@ -1,21 +1,31 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
 	"github.com/aimrintech/x-backend/stores"
-	"github.com/go-playground/validator/v10"
+	"github.com/aimrintech/x-backend/utils"
 )
 
 type UserHandlers struct {
-	userStore *stores.UserStore
+	userStore  *stores.UserStore
+	tweetStore *stores.TweetStore
+	// told about every follow, block and mute, so open streams of the users
+	// involved stop showing what they no longer should
+	filterChanges services.ContentFilterChanges
 }
 
-func NewUserHandlers(userStore *stores.UserStore) *UserHandlers {
+func NewUserHandlers(userStore *stores.UserStore, tweetStore *stores.TweetStore, filterChanges services.ContentFilterChanges) *UserHandlers {
 	return &UserHandlers{
-		userStore: userStore,
+		userStore:     userStore,
+		tweetStore:    tweetStore,
+		filterChanges: filterChanges,
 	}
 }
 
@@ -25,47 +35,207 @@ func (h *UserHandlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
-	user, err := (*h.userStore).GetUserByID(userID)
+	user, err := (*h.userStore).GetUserByID(r.Context(), userID)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+		writeStoreError(w, r, err, "Failed to get user")
 		return
 	}
-	user.Password = ""
-	writeJSON(w, r, http.StatusOK, user)
+
+	pinned, err := (*h.tweetStore).GetPinnedTweet(r.Context(), user.ID, userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get pinned tweet")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, models.UserProfile{User: user, PinnedTweet: pinned})
 }
 
 func (h *UserHandlers) GetUserByID(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserID(r)
-	if err != nil {
-		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+	userID := r.PathValue("id")
+	if userID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
 		return
 	}
 
-	user, err := (*h.userStore).GetUserByID(userID)
+	user, err := (*h.userStore).GetUserByID(r.Context(), userID)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+		writeStoreError(w, r, err, "Failed to get user")
 		return
 	}
-	user.Password = ""
-	writeJSON(w, r, http.StatusOK, user)
+	h.writePublicProfile(w, r, user)
 }
 
 func (h *UserHandlers) GetUserByUsername(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
+	username := r.PathValue("username")
 	if username == "" {
 		writeError(w, r, http.StatusBadRequest, "Username is required")
 		return
 	}
 
-	user, err := (*h.userStore).GetUserByUsername(username)
+	user, err := (*h.userStore).GetUserByUsername(r.Context(), username)
+	if errors.Is(err, stores.ErrNotFound) {
+		// old usernames keep resolving to their owner for a grace period
+		previous, err := (*h.userStore).GetUserByPreviousUsername(r.Context(), username)
+		if err != nil {
+			writeStoreError(w, r, err, "Failed to get user")
+			return
+		}
+		http.Redirect(w, r, "/api/users/username/"+url.PathEscape(previous.Username), http.StatusTemporaryRedirect)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get user")
+		return
+	}
+	h.writePublicProfile(w, r, user)
+}
+
+func (h *UserHandlers) CheckUsernameAvailability(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("u")
+	if username == "" {
+		writeError(w, r, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	// signed out callers are checking a name for a new account
+	userID, _ := getUserID(r)
+
+	type availabilityResponse struct {
+		Username  string `json:"username"`
+		Available bool   `json:"available"`
+		Reason    string `json:"reason,omitempty"`
+	}
+
+	if err := utils.ValidateUsername(username); err != nil {
+		writeJSON(w, r, http.StatusOK, availabilityResponse{Username: username, Reason: err.Error()})
+		return
+	}
+
+	available, err := (*h.userStore).IsUsernameAvailable(r.Context(), username, userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to check username")
+		return
+	}
+
+	response := availabilityResponse{Username: username, Available: available}
+	if !available {
+		response.Reason = stores.ErrUsernameTaken.Error()
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+func (h *UserHandlers) ChangeUsername(w http.ResponseWriter, r *http.Request) {
+	type ChangeUsernameRequestBody struct {
+		Username string `json:"username" validate:"required"`
+	}
+
+	var body ChangeUsernameRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	if err := utils.ValidateUsername(body.Username); err != nil {
+		writeStoreError(w, r, stores.NewValidationError("username", err.Error()), "Invalid username")
+		return
+	}
+
+	userID, err := getUserID(r)
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
-	user.Password = ""
+
+	user, err := (*h.userStore).ChangeUsername(r.Context(), userID, body.Username)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to change username")
+		return
+	}
+
 	writeJSON(w, r, http.StatusOK, user)
 }
 
+// writePublicProfile responds with the public profile of user as seen by the
+// caller, who may be signed out
+func (h *UserHandlers) writePublicProfile(w http.ResponseWriter, r *http.Request, user *models.User) {
+	viewerID, _ := getUserID(r)
+	profile := models.NewPublicProfile(user)
+
+	if viewerID != "" && viewerID != user.ID {
+		relationship, err := (*h.userStore).GetRelationship(r.Context(), viewerID, user.ID)
+		if err != nil {
+			writeStoreError(w, r, err, "Failed to get user")
+			return
+		}
+		if relationship.BlockedBy {
+			writeStoreError(w, r, stores.ErrUserNotFound, "User not found")
+			return
+		}
+		profile.IsFollowing = relationship.IsFollowing
+		profile.FollowsYou = relationship.FollowsYou
+		profile.IsBlocked = relationship.IsBlocked
+	}
+
+	pinned, err := (*h.tweetStore).GetPinnedTweet(r.Context(), user.ID, viewerID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get pinned tweet")
+		return
+	}
+	profile.PinnedTweet = pinned
+
+	writeJSON(w, r, http.StatusOK, profile)
+}
+
+func (h *UserHandlers) PinTweet(w http.ResponseWriter, r *http.Request) {
+	type PinTweetRequestBody struct {
+		TweetID string `json:"tweetID" validate:"required"`
+	}
+
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var body PinTweetRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+
+	if err := (*h.tweetStore).PinTweet(r.Context(), body.TweetID, userID); err != nil {
+		writeStoreError(w, r, err, "Failed to pin tweet")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Tweet pinned"})
+}
+
+func (h *UserHandlers) UnpinTweet(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := (*h.tweetStore).UnpinTweet(r.Context(), userID); err != nil {
+		writeStoreError(w, r, err, "Failed to unpin tweet")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Tweet unpinned"})
+}
+
 // func (h *UserHandlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 // 	type CreateUserRequestBody struct {
 // 		Username string `json:"username" validate:"required,min=2,max=32,alphanum"`
@@ -92,7 +262,7 @@ func (h *UserHandlers) GetUserByUsername(w http.ResponseWriter, r *http.Request)
 // 		Password: body.Password,
 // 	}
 
-// 	createdUser, err := (*h.userStore).CreateUser(user)
+// 	createdUser, err := (*h.userStore).CreateUser(r.Context(), user)
 // 	if err != nil {
 // 		http.Error(w, err.Error(), http.StatusInternalServerError)
 // 		return
@@ -110,6 +280,7 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		Location       *string    `json:"location" validate:"omitempty,max=255"`
 		Website        *string    `json:"website" validate:"omitempty,url"`
 		Birthday       *time.Time `json:"birthday" validate:"omitempty"`
+		IsLocked       *bool      `json:"isLocked" validate:"omitempty"`
 		FollowersCount int        `json:"followersCount" validate:"omitempty"`
 		FollowingCount int        `json:"followingCount" validate:"omitempty"`
 		TweetsCount    int        `json:"tweetsCount" validate:"omitempty"`
@@ -121,9 +292,8 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validate := validator.New()
-	if err := validate.Struct(body); err != nil {
-		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
 		return
 	}
 
@@ -133,7 +303,15 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := (*h.userStore).UpdateUser(&models.User{
+	// UpdateUser skips zero values, so unlocking needs its own call
+	if body.IsLocked != nil {
+		if err := (*h.userStore).SetLocked(r.Context(), userID, *body.IsLocked); err != nil {
+			writeStoreError(w, r, err, "Failed to update user")
+			return
+		}
+	}
+
+	user, err := (*h.userStore).UpdateUser(r.Context(), &models.User{
 		ID:             userID,
 		Name:           body.Name,
 		ProfilePicture: body.ProfilePicture,
@@ -144,10 +322,357 @@ func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		Birthday:       body.Birthday,
 	})
 	if err != nil {
-		writeError(w, r, http.StatusInternalServerError, "Failed to update user")
+		writeStoreError(w, r, err, "Failed to update user")
 		return
 	}
 
-	user.Password = ""
 	writeJSON(w, r, http.StatusOK, user)
 }
+
+func (h *UserHandlers) BlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	blockedID := r.PathValue("id")
+	if blockedID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+	if blockedID == userID {
+		writeError(w, r, http.StatusBadRequest, "You cannot block yourself")
+		return
+	}
+
+	if err := (*h.userStore).BlockUser(r.Context(), userID, blockedID); err != nil {
+		writeStoreError(w, r, err, "Failed to block user")
+		return
+	}
+
+	h.filterChanges.Changed(userID, blockedID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "User blocked"})
+}
+
+func (h *UserHandlers) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	blockedID := r.PathValue("id")
+	if blockedID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+
+	if err := (*h.userStore).UnblockUser(r.Context(), userID, blockedID); err != nil {
+		writeStoreError(w, r, err, "Failed to unblock user")
+		return
+	}
+
+	h.filterChanges.Changed(userID, blockedID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "User unblocked"})
+}
+
+func (h *UserHandlers) GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset := extractPaginationParams(r)
+
+	users, err := (*h.userStore).GetBlockedUsers(r.Context(), userID, limit, offset)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get blocked users")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, models.NewPublicProfiles(users))
+}
+
+func (h *UserHandlers) GetMutes(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	mutes, err := (*h.userStore).GetMutes(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get mutes")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, mutes)
+}
+
+func (h *UserHandlers) MuteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	mutedID := r.PathValue("id")
+	if mutedID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+	if mutedID == userID {
+		writeError(w, r, http.StatusBadRequest, "You cannot mute yourself")
+		return
+	}
+
+	if err := (*h.userStore).MuteUser(r.Context(), userID, mutedID); err != nil {
+		writeStoreError(w, r, err, "Failed to mute user")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "User muted"})
+}
+
+func (h *UserHandlers) UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	mutedID := r.PathValue("id")
+	if mutedID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+
+	if err := (*h.userStore).UnmuteUser(r.Context(), userID, mutedID); err != nil {
+		writeStoreError(w, r, err, "Failed to unmute user")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "User unmuted"})
+}
+
+func (h *UserHandlers) AddMutedWord(w http.ResponseWriter, r *http.Request) {
+	type AddMutedWordRequestBody struct {
+		Phrase    string     `json:"phrase" validate:"required,min=1,max=100"`
+		ExpiresAt *time.Time `json:"expiresAt" validate:"omitempty"`
+	}
+
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var body AddMutedWordRequestBody
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateBody(body); err != nil {
+		writeStoreError(w, r, err, "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(body.Phrase) == "" {
+		writeStoreError(w, r, stores.NewValidationError("phrase", "is required"), "Invalid request body")
+		return
+	}
+
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		writeError(w, r, http.StatusBadRequest, "Expiry must be in the future")
+		return
+	}
+
+	word, err := (*h.userStore).AddMutedWord(r.Context(), userID, body.Phrase, body.ExpiresAt)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to mute word")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusCreated, word)
+}
+
+func (h *UserHandlers) RemoveMutedWord(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	wordID := r.PathValue("id")
+	if wordID == "" {
+		writeError(w, r, http.StatusBadRequest, "Word ID is required")
+		return
+	}
+
+	if err := (*h.userStore).RemoveMutedWord(r.Context(), userID, wordID); err != nil {
+		writeStoreError(w, r, err, "Failed to unmute word")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Word unmuted"})
+}
+
+func (h *UserHandlers) MuteConversation(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tweetID := r.PathValue("id")
+	if tweetID == "" {
+		writeError(w, r, http.StatusBadRequest, "Tweet ID is required")
+		return
+	}
+
+	if err := (*h.userStore).MuteConversation(r.Context(), userID, tweetID); err != nil {
+		writeStoreError(w, r, err, "Failed to mute conversation")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Conversation muted"})
+}
+
+func (h *UserHandlers) UnmuteConversation(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tweetID := r.PathValue("id")
+	if tweetID == "" {
+		writeError(w, r, http.StatusBadRequest, "Tweet ID is required")
+		return
+	}
+
+	if err := (*h.userStore).UnmuteConversation(r.Context(), userID, tweetID); err != nil {
+		writeStoreError(w, r, err, "Failed to unmute conversation")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Conversation unmuted"})
+}
+
+func (h *UserHandlers) FollowUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	followingID := r.PathValue("id")
+	if followingID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+	if followingID == userID {
+		writeError(w, r, http.StatusBadRequest, "You cannot follow yourself")
+		return
+	}
+
+	status, err := (*h.userStore).FollowUser(r.Context(), userID, followingID)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to follow user")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusOK, map[string]models.FollowStatus{"status": status})
+}
+
+func (h *UserHandlers) UnfollowUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	followingID := r.PathValue("id")
+	if followingID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+
+	if err := (*h.userStore).UnfollowUser(r.Context(), userID, followingID); err != nil {
+		writeStoreError(w, r, err, "Failed to unfollow user")
+		return
+	}
+
+	h.filterChanges.Changed(userID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "User unfollowed"})
+}
+
+func (h *UserHandlers) GetFollowRequests(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset := extractPaginationParams(r)
+
+	users, err := (*h.userStore).GetFollowRequests(r.Context(), userID, limit, offset)
+	if err != nil {
+		writeStoreError(w, r, err, "Failed to get follow requests")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, models.NewPublicProfiles(users))
+}
+
+func (h *UserHandlers) ApproveFollowRequest(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	requesterID := r.PathValue("id")
+	if requesterID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+
+	if err := (*h.userStore).ApproveFollowRequest(r.Context(), userID, requesterID); err != nil {
+		writeStoreError(w, r, err, "Failed to approve follow request")
+		return
+	}
+
+	h.filterChanges.Changed(requesterID)
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Follow request approved"})
+}
+
+func (h *UserHandlers) DenyFollowRequest(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	requesterID := r.PathValue("id")
+	if requesterID == "" {
+		writeError(w, r, http.StatusBadRequest, "User ID is required")
+		return
+	}
+
+	if err := (*h.userStore).DenyFollowRequest(r.Context(), userID, requesterID); err != nil {
+		writeStoreError(w, r, err, "Failed to deny follow request")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Follow request denied"})
+}
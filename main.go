@@ -2,54 +2,101 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/aimrintech/x-backend/api"
 	"github.com/aimrintech/x-backend/config"
+	"github.com/aimrintech/x-backend/migrations"
 	"github.com/joho/godotenv"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
-	"golang.org/x/oauth2"
 )
 
-var AuthConfig *oauth2.Config
-
 func init() {
-	// Load environment variables
-	err := godotenv.Load()
-
-	if err != nil {
+	// Load environment variables, a .env file is optional outside development
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatalf("Error loading .env file: %v", err)
-		panic(err)
 	}
-
-	// Initialize auth config
-	AuthConfig = config.InitAuthConfig()
 }
 
 func main() {
-	// Database connection
-	dbCtx := context.Background()
-	neo4jUri := os.Getenv("NEO4J_URI")
-	neo4jUser := os.Getenv("NEO4J_USERNAME")
-	neo4jPassword := os.Getenv("NEO4J_PASSWORD")
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
 
-	driver, err := neo4j.NewDriverWithContext(neo4jUri, neo4j.BasicAuth(neo4jUser, neo4jPassword, ""))
+	// Database connection
+	ctx := context.Background()
+	driver, err := neo4j.NewDriverWithContext(cfg.Neo4jURI, neo4j.BasicAuth(cfg.Neo4jUsername, cfg.Neo4jPassword, ""))
 	if err != nil {
 		log.Fatalf("Failed to create Neo4j driver: %v", err)
 	}
-	defer driver.Close(dbCtx)
+	defer driver.Close(ctx)
 
 	// Verify connectivity
-	err = driver.VerifyConnectivity(dbCtx)
+	err = driver.VerifyConnectivity(ctx)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println("Database connection established.")
 
+	// Apply pending schema migrations. `go run . migrate` applies them and exits
+	applied, err := migrations.Run(ctx, driver)
+	if err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+	for _, migration := range applied {
+		fmt.Printf("Applied migration %04d_%s\n", migration.Version, migration.Name)
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		fmt.Printf("Migrations up to date, %d applied.\n", len(applied))
+		return
+	}
+
 	// Init server
-	server := api.NewServer(&driver, &dbCtx, AuthConfig)
-	fmt.Println("Server listening on port 8080")
-	server.Start(":8080")
+	deps := api.NewNeo4jDeps(&driver)
+	deps.Mailer = api.NewMailer(cfg)
+	deps.AccessTokens, err = api.NewAccessTokens(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	deps.AuthProviders, err = api.NewAuthProviders(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure sign in providers: %v", err)
+	}
+	server := api.NewServer(cfg, deps)
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Server listening on port %s\n", cfg.Port)
+		serverErr <- server.Start()
+	}()
+
+	// Shut down on SIGINT or SIGTERM, or if the server fails to start
+	stop, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			log.Printf("Server stopped: %v", err)
+		}
+	case <-stop.Done():
+		fmt.Println("Shutting down...")
+	}
+
+	// Stop catching signals, so a second one exits without draining
+	cancel()
+	shutdownCtx, cancelShutdown := context.WithTimeout(ctx, cfg.ShutdownTimeout.Duration)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+	}
+	if err := driver.Close(shutdownCtx); err != nil {
+		log.Printf("Failed to close Neo4j driver: %v", err)
+	}
+	fmt.Println("Server stopped.")
 }
@@ -0,0 +1,7 @@
+// Timelines and notification lists are ordered by creation time
+CREATE INDEX tweet_created_at IF NOT EXISTS FOR (t:Tweet) ON (t.createdAt);
+CREATE INDEX tweet_conversation_id IF NOT EXISTS FOR (t:Tweet) ON (t.conversationID);
+CREATE INDEX notification_created_at IF NOT EXISTS FOR (n:Notification) ON (n.createdAt);
+
+// Old usernames are looked up when resolving redirects
+CREATE INDEX username_history_username IF NOT EXISTS FOR (h:UsernameHistory) ON (h.username);
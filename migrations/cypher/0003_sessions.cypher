@@ -0,0 +1,2 @@
+// Sessions are looked up by the ID embedded in refresh tokens
+CREATE CONSTRAINT session_id_unique IF NOT EXISTS FOR (s:Session) REQUIRE s.id IS UNIQUE;
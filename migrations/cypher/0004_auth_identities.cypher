@@ -0,0 +1,3 @@
+// An account at a provider can be linked to one user only. The key is the
+// provider and the provider's subject ID, e.g. "google:1234".
+CREATE CONSTRAINT auth_identity_key_unique IF NOT EXISTS FOR (i:AuthIdentity) REQUIRE i.key IS UNIQUE;
@@ -0,0 +1,2 @@
+// API tokens are looked up by the ID embedded in each token
+CREATE CONSTRAINT api_token_id_unique IF NOT EXISTS FOR (t:APIToken) REQUIRE t.id IS UNIQUE;
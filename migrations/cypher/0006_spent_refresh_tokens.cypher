@@ -0,0 +1,5 @@
+// Sessions remember every refresh token they replaced, up to a limit, instead
+// of only the last one
+MATCH (s:Session) WHERE s.previousTokenHash IS NOT NULL
+SET s.spentTokenHashes = coalesce(s.spentTokenHashes, [s.previousTokenHash])
+REMOVE s.previousTokenHash;
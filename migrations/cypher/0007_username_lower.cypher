@@ -0,0 +1,9 @@
+// Usernames are matched case-insensitively through a lower cased copy, which
+// unlike toLower(u.username) can use an index. The constraint also rejects
+// usernames that differ from an existing one only in case; existing duplicates
+// like that must be renamed before this migration can run.
+MATCH (u:User) WHERE u.usernameLower IS NULL SET u.usernameLower = toLower(u.username);
+MATCH (h:UsernameHistory) WHERE h.usernameLower IS NULL SET h.usernameLower = toLower(h.username);
+CREATE CONSTRAINT user_username_lower_unique IF NOT EXISTS FOR (u:User) REQUIRE u.usernameLower IS UNIQUE;
+CREATE INDEX username_history_username_lower IF NOT EXISTS FOR (h:UsernameHistory) ON (h.usernameLower);
+DROP INDEX username_history_username IF EXISTS;
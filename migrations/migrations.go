@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+//go:embed cypher/*.cypher
+var files embed.FS
+
+// Migration is one numbered Cypher file. Files are named NNNN_name.cypher and
+// hold statements separated by semicolons.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.cypher$`)
+
+// Load reads the migrations embedded in the binary, ordered by version
+func Load() ([]Migration, error) {
+	sub, err := fs.Sub(files, "cypher")
+	if err != nil {
+		return nil, err
+	}
+	return loadFS(sub)
+}
+
+func loadFS(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	seen := map[int]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".cypher" {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version:    version,
+			Name:       match[2],
+			Statements: splitStatements(string(content)),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements drops // comments and splits a file on semicolons
+func splitStatements(content string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "//") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	statements := []string{}
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// Run applies every migration that has no :Migration node yet and returns the
+// ones it applied. Neo4j doesn't allow schema changes and writes in the same
+// transaction, so each statement runs on its own; statements must therefore
+// be idempotent (IF NOT EXISTS) for a failed migration to be safely retried.
+func Run(ctx context.Context, driver neo4j.DriverWithContext) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(ctx, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		for _, statement := range migration.Statements {
+			if _, err := neo4j.ExecuteQuery(ctx, driver, statement, nil, neo4j.EagerResultTransformer); err != nil {
+				return done, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		_, err := neo4j.ExecuteQuery(
+			ctx,
+			driver,
+			`MERGE (m:Migration {version: $version})
+			ON CREATE SET m.name = $name, m.appliedAt = datetime()`,
+			map[string]any{"version": migration.Version, "name": migration.Name},
+			neo4j.EagerResultTransformer,
+		)
+		if err != nil {
+			return done, fmt.Errorf("recording migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+func appliedVersions(ctx context.Context, driver neo4j.DriverWithContext) (map[int]bool, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		driver,
+		`MATCH (m:Migration) RETURN m.version AS version`,
+		nil,
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]bool{}
+	for _, record := range res.Records {
+		version, _ := record.Get("version")
+		applied[int(version.(int64))] = true
+	}
+	return applied, nil
+}
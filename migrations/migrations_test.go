@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_EmbeddedMigrations(t *testing.T) {
+	migrations, err := Load()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.NotEmpty(t, migration.Statements, migration.Name)
+		if i > 0 {
+			assert.Greater(t, migration.Version, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestLoadFS_OrdersAndSplits(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.cypher": {Data: []byte("CREATE INDEX a IF NOT EXISTS FOR (n:A) ON (n.a);")},
+		"0001_first.cypher": {Data: []byte(`// a comment; with a semicolon
+CREATE CONSTRAINT x IF NOT EXISTS FOR (n:X) REQUIRE n.id IS UNIQUE;
+
+CREATE CONSTRAINT y IF NOT EXISTS FOR (n:Y) REQUIRE n.id IS UNIQUE;
+`)},
+		"README.md": {Data: []byte("ignored")},
+	}
+
+	migrations, err := loadFS(fsys)
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "first", migrations[0].Name)
+	assert.Len(t, migrations[0].Statements, 2)
+	assert.Equal(t, "second", migrations[1].Name)
+}
+
+func TestLoadFS_RejectsBadNames(t *testing.T) {
+	_, err := loadFS(fstest.MapFS{"first.cypher": {Data: []byte("RETURN 1;")}})
+	assert.Error(t, err)
+
+	_, err = loadFS(fstest.MapFS{
+		"0001_a.cypher": {Data: []byte("RETURN 1;")},
+		"1_b.cypher":    {Data: []byte("RETURN 1;")},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIToken is a personal access token a user created for a script or bot.
+// It acts as the user, but only on the routes its scopes allow. Only the
+// hash of the token is kept; the token itself is shown once, on creation.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// HasScope reports whether the token may be used on routes needing scope
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+func NewAPIToken(userID string, name string, scopes []string) *APIToken {
+	return &APIToken{
+		ID:     uuid.New().String(),
+		UserID: userID,
+		Name:   name,
+		Scopes: scopes,
+	}
+}
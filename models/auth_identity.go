@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// AuthIdentity is an account at an OAuth provider that a user can sign in
+// with. Subject is the provider's stable ID for the account; the email is
+// informational and may change.
+type AuthIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"-"`
+	Email     string    `json:"email"`
+	UserID    string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// OAuthUserInfo is what a provider tells us about the account that signed in
+type OAuthUserInfo struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
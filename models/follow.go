@@ -0,0 +1,10 @@
+package models
+
+// FollowStatus is the outcome of a follow: locked accounts have to approve
+// followers, so following them only creates a request
+type FollowStatus string
+
+const (
+	FollowStatusFollowing FollowStatus = "following"
+	FollowStatusRequested FollowStatus = "requested"
+)
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type MutedWord struct {
+	ID        string     `json:"id" neo4j:"id"`
+	Phrase    string     `json:"phrase" neo4j:"phrase"`
+	ExpiresAt *time.Time `json:"expiresAt" neo4j:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt" neo4j:"createdAt"`
+}
+
+// Mutes lists everything a user has muted
+type Mutes struct {
+	Users           []*PublicProfile `json:"users"`
+	Words           []MutedWord      `json:"words"`
+	ConversationIDs []string         `json:"conversationIDs"`
+}
+
+// ContentFilter is a snapshot of the blocks and mutes of one user, used to
+// filter content that is pushed to them rather than queried
+type ContentFilter struct {
+	UserID               string
+	FollowingUserIDs     map[string]bool // needed to hide tweets of locked accounts
+	BlockedUserIDs       map[string]bool
+	MutedUserIDs         map[string]bool
+	MutedWords           []string // lower cased
+	MutedConversationIDs map[string]bool
+}
+
+// AllowsUser reports whether content by the given user may be shown
+func (f *ContentFilter) AllowsUser(userID string) bool {
+	return !f.BlockedUserIDs[userID] && !f.MutedUserIDs[userID]
+}
+
+// AllowsTweet checks the tweet author and content against the filter
+func (f *ContentFilter) AllowsTweet(tweet *TweetProps) bool {
+	if !f.AllowsUser(tweet.Author.ID) {
+		return false
+	}
+
+	if tweet.Author.IsLocked && tweet.Author.ID != f.UserID && !f.FollowingUserIDs[tweet.Author.ID] {
+		return false
+	}
+
+	content := strings.ToLower(tweet.Content)
+	for _, word := range f.MutedWords {
+		if strings.Contains(content, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsNotification hides notifications caused by blocked or muted users,
+// and those about tweets in muted conversations
+func (f *ContentFilter) AllowsNotification(notification *Notification) bool {
+	if notification.ConversationID != nil && f.MutedConversationIDs[*notification.ConversationID] {
+		return false
+	}
+	return f.AllowsUser(notification.AuthorUserID)
+}
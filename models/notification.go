@@ -14,6 +14,8 @@ const (
 	NotificationTypeRetweet NotificationType = "retweet"
 	NotificationTypeReply   NotificationType = "reply"
 	NotificationTypeMention NotificationType = "mention"
+	// sent to a locked account when someone asks to follow it
+	NotificationTypeFollowRequest NotificationType = "follow_request"
 )
 
 type Notification struct {
@@ -24,6 +26,9 @@ type Notification struct {
 	Type          NotificationType `json:"type"`
 	IsRead        bool             `json:"is_read"`
 	CreatedAt     time.Time        `json:"created_at"`
+	// ConversationID is set on like and reply notifications as they are
+	// published, so the stream can drop those in muted conversations
+	ConversationID *string `json:"-"`
 }
 
 func NewNotification(targetUserID, authorUserID string, targetTweetID *string, notificationType NotificationType) *Notification {
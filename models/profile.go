@@ -0,0 +1,93 @@
+package models
+
+import "time"
+
+// UserProfile is the signed in user as shown on their own profile page
+type UserProfile struct {
+	*User
+	PinnedTweet *TweetProps `json:"pinnedTweet"`
+}
+
+// PublicProfile is what other users may see of a user. It never carries the
+// email or password, and includes how the viewer relates to the user.
+type PublicProfile struct {
+	ID             string      `json:"id"`
+	Username       string      `json:"username"`
+	Name           string      `json:"name"`
+	CreatedAt      time.Time   `json:"createdAt"`
+	Bio            *string     `json:"bio"`
+	Location       *string     `json:"location"`
+	Birthday       *time.Time  `json:"birthday"`
+	Website        *string     `json:"website"`
+	ProfilePicture *string     `json:"profilePicture"`
+	BannerPicture  *string     `json:"bannerPicture"`
+	IsVerified     bool        `json:"isVerified"`
+	FollowersCount int         `json:"followersCount"`
+	FollowingCount int         `json:"followingCount"`
+	TweetsCount    int         `json:"tweetsCount"`
+	IsLocked       bool        `json:"isLocked"`
+	IsFollowing    bool        `json:"isFollowing"`
+	FollowsYou     bool        `json:"followsYou"`
+	IsBlocked      bool        `json:"isBlocked"`
+	PinnedTweet    *TweetProps `json:"pinnedTweet,omitempty"`
+}
+
+// Relationship describes how a viewer relates to another user
+type Relationship struct {
+	IsFollowing bool // viewer follows the user
+	FollowsYou  bool // user follows the viewer
+	IsBlocked   bool // viewer blocks the user
+	BlockedBy   bool // user blocks the viewer
+}
+
+func NewPublicProfile(user *User) *PublicProfile {
+	return &PublicProfile{
+		ID:             user.ID,
+		Username:       user.Username,
+		Name:           user.Name,
+		CreatedAt:      user.CreatedAt,
+		Bio:            user.Bio,
+		Location:       user.Location,
+		Birthday:       user.Birthday,
+		Website:        user.Website,
+		ProfilePicture: user.ProfilePicture,
+		BannerPicture:  user.BannerPicture,
+		IsVerified:     user.IsVerified,
+		FollowersCount: user.FollowersCount,
+		FollowingCount: user.FollowingCount,
+		TweetsCount:    user.TweetsCount,
+		IsLocked:       user.IsLocked,
+	}
+}
+
+func NewPublicProfiles(users []*User) []*PublicProfile {
+	profiles := make([]*PublicProfile, 0, len(users))
+	for _, user := range users {
+		profiles = append(profiles, NewPublicProfile(user))
+	}
+	return profiles
+}
+
+// ProfileTab selects which of a user's tweets a profile timeline lists
+type ProfileTab string
+
+const (
+	ProfileTabTweets  ProfileTab = "tweets"
+	ProfileTabReplies ProfileTab = "replies"
+	ProfileTabMedia   ProfileTab = "media"
+	ProfileTabLikes   ProfileTab = "likes"
+)
+
+// ProfileTabs lists every profile tab, in the order they are shown
+var ProfileTabs = []ProfileTab{ProfileTabTweets, ProfileTabReplies, ProfileTabMedia, ProfileTabLikes}
+
+// MaxTweetPageSize is the most tweets a TweetPage holds, whatever limit was
+// asked for
+const MaxTweetPageSize = 100
+
+// TweetPage is one page of a cursor paginated tweet list. NextCursor is nil
+// on the last page.
+type TweetPage struct {
+	Tweets     []TweetProps `json:"tweets"`
+	NextCursor *string      `json:"nextCursor"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a signed in device. It holds the hash of the device's current
+// refresh token and of the ones it replaced, newest first, so a refresh token
+// that is used twice can be told apart from one that was never issued.
+type Session struct {
+	ID               string     `json:"id"`
+	UserID           string     `json:"-"`
+	TokenHash        string     `json:"-"`
+	SpentTokenHashes []string   `json:"-"`
+	UserAgent        string     `json:"userAgent"`
+	IP               string     `json:"ip"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	LastSeenAt       time.Time  `json:"lastSeenAt"`
+	ExpiresAt        time.Time  `json:"expiresAt"`
+	RevokedAt        *time.Time `json:"-"`
+	// Current is set on the session the request was made with
+	Current bool `json:"current"`
+}
+
+// IsActive reports whether the session can still be refreshed at now
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+func NewSession(userID string, tokenHash string, userAgent string, ip string, expiresAt time.Time) *Session {
+	return &Session{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		TokenHash: tokenHash,
+		UserAgent: userAgent,
+		IP:        ip,
+		ExpiresAt: expiresAt,
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+// TimelineSource describes why a tweet was picked as a "For You" candidate
+type TimelineSource string
+
+const (
+	TimelineSourceFollow     TimelineSource = "follow"     // tweeted by someone the viewer follows
+	TimelineSourceEngagement TimelineSource = "engagement" // liked or retweeted by someone the viewer follows
+	TimelineSourceTrending   TimelineSource = "trending"   // uses a currently trending hashtag
+)
+
+// TimelineCandidate is a tweet that may be shown on the ranked timeline,
+// together with the signals the scorer needs
+type TimelineCandidate struct {
+	Tweet       Tweet            `json:"-"`
+	Props       TweetProps       `json:"tweet"`
+	Sources     []TimelineSource `json:"sources"`
+	SocialProof int              `json:"socialProof"` // followed users who liked or retweeted the tweet
+}
+
+// HasSource reports whether the candidate was produced by the given source
+func (c *TimelineCandidate) HasSource(source TimelineSource) bool {
+	for _, s := range c.Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
@@ -14,6 +14,9 @@ type Tweet struct {
 	QuotesCount   int       `json:"quotesCount" neo4j:"quotesCount"`
 	ViewsCount    int       `json:"viewsCount" neo4j:"viewsCount"`
 	Hashtags      *[]string `json:"hashtags" neo4j:"hashtags"`
+	// set on replies only
+	InReplyToID    *string `json:"inReplyToID" neo4j:"inReplyToID"`
+	ConversationID *string `json:"conversationID" neo4j:"conversationID"`
 }
 
 type TweetProps struct {
@@ -27,14 +30,65 @@ type TweetProps struct {
 	LikesCount    int      `json:"likesCount"`
 	UpdatedAt     string   `json:"updatedAt"`
 	Hashtags      []string `json:"hashtags"`
+	InReplyToID   *string  `json:"inReplyToID"`
 	Author        struct {
 		ID             string  `json:"id"`
 		IsVerified     *bool   `json:"isVerified"`
 		Username       string  `json:"username"`
 		ProfilePicture *string `json:"profilePicture"`
 		Name           *string `json:"name"`
+		IsLocked       bool    `json:"isLocked"`
 	} `json:"author"`
 	IsLiked      bool `json:"isLiked"`
 	IsRetweeted  bool `json:"isRetweeted"`
 	IsBookmarked bool `json:"isBookmarked"`
+	IsPinned     bool `json:"isPinned"`
+}
+
+// NewTweetProps builds the API representation of a tweet by user, as seen by
+// a viewer who may have liked, retweeted or bookmarked it
+func NewTweetProps(tweet *Tweet, user *User, isLiked, isRetweeted, isBookmarked bool) *TweetProps {
+	tweetProps := &TweetProps{
+		CreatedAt:     tweet.CreatedAt.Format(time.RFC3339),
+		RepliesCount:  tweet.RepliesCount,
+		MediaURLs:     []string{},
+		ID:            tweet.ID,
+		RetweetsCount: tweet.RetweetsCount,
+		ViewsCount:    tweet.ViewsCount,
+		Content:       "",
+		LikesCount:    tweet.LikesCount,
+		UpdatedAt:     tweet.UpdatedAt.Format(time.RFC3339),
+		Hashtags:      []string{},
+		Author: struct {
+			ID             string  `json:"id"`
+			IsVerified     *bool   `json:"isVerified"`
+			Username       string  `json:"username"`
+			ProfilePicture *string `json:"profilePicture"`
+			Name           *string `json:"name"`
+			IsLocked       bool    `json:"isLocked"`
+		}{
+			ID:             user.ID,
+			IsVerified:     &user.IsVerified,
+			Username:       user.Username,
+			ProfilePicture: user.ProfilePicture,
+			Name:           &user.Name,
+			IsLocked:       user.IsLocked,
+		},
+		InReplyToID:  tweet.InReplyToID,
+		IsLiked:      isLiked,
+		IsRetweeted:  isRetweeted,
+		IsBookmarked: isBookmarked,
+	}
+
+	if tweet.Content != nil {
+		tweetProps.Content = *tweet.Content
+	}
+	if tweet.Hashtags != nil {
+		tweetProps.Hashtags = *tweet.Hashtags
+	}
+	if tweet.MediaURLs != nil {
+		tweetProps.MediaURLs = *tweet.MediaURLs
+	}
+
+	return tweetProps
 }
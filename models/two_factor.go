@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// TwoFactor is a user's TOTP authenticator. It is pending until the user
+// confirms a code from it, and only enabled ones are asked for at sign in.
+type TwoFactor struct {
+	UserID string `json:"-"`
+	// Secret is the TOTP secret, encrypted
+	Secret  string `json:"-"`
+	Enabled bool   `json:"enabled"`
+	// LastUsedStep is the time step of the last accepted code, so that no
+	// code is accepted twice
+	LastUsedStep int64 `json:"-"`
+	// RecoveryCodes are the hashes of the unused recovery codes
+	RecoveryCodes []string   `json:"-"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	EnabledAt     *time.Time `json:"enabledAt"`
+}
@@ -3,23 +3,25 @@ package models
 import "time"
 
 type User struct {
-	ID        string `json:"id" neo4j:"id"`
-	Username  string `json:"username" neo4j:"username"`
-	Name      string `json:"name" neo4j:"name"`
-	Email     string `json:"email" neo4j:"email"`
-	Password  string `json:"password" neo4j:"password"`
-	CreatedAt time.Time `json:"createdAt" neo4j:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt" neo4j:"updatedAt"`
-	Bio       *string `json:"bio" neo4j:"bio"`
-	Location  *string `json:"location" neo4j:"location"`
-	Birthday  *time.Time `json:"birthday" neo4j:"birthday"`
-	Website   *string `json:"website" neo4j:"website"`
-	ProfilePicture *string `json:"profilePicture" neo4j:"profilePicture"`
-	BannerPicture *string `json:"bannerPicture" neo4j:"bannerPicture"`
-	IsVerified bool `json:"isVerified" neo4j:"isVerified"`
-	FollowersCount int `json:"followersCount" neo4j:"followersCount"`
-	FollowingCount int `json:"followingCount" neo4j:"followingCount"`
-	TweetsCount int `json:"tweetsCount" neo4j:"tweetsCount"`
-	IsLocked bool `json:"isLocked" neo4j:"isLocked"`
+	ID       string `json:"id" neo4j:"id"`
+	Username string `json:"username" neo4j:"username"`
+	Name     string `json:"name" neo4j:"name"`
+	Email    string `json:"email" neo4j:"email"`
+	// never serialized; handlers return PublicProfile for other users
+	Password string `json:"-" neo4j:"password"`
+	// EmailVerified is set once the user proved they own Email
+	EmailVerified  bool       `json:"emailVerified" neo4j:"emailVerified"`
+	CreatedAt      time.Time  `json:"createdAt" neo4j:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt" neo4j:"updatedAt"`
+	Bio            *string    `json:"bio" neo4j:"bio"`
+	Location       *string    `json:"location" neo4j:"location"`
+	Birthday       *time.Time `json:"birthday" neo4j:"birthday"`
+	Website        *string    `json:"website" neo4j:"website"`
+	ProfilePicture *string    `json:"profilePicture" neo4j:"profilePicture"`
+	BannerPicture  *string    `json:"bannerPicture" neo4j:"bannerPicture"`
+	IsVerified     bool       `json:"isVerified" neo4j:"isVerified"`
+	FollowersCount int        `json:"followersCount" neo4j:"followersCount"`
+	FollowingCount int        `json:"followingCount" neo4j:"followingCount"`
+	TweetsCount    int        `json:"tweetsCount" neo4j:"tweetsCount"`
+	IsLocked       bool       `json:"isLocked" neo4j:"isLocked"`
 }
-
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AccessClaims identify the user and session an access token was issued to
+type AccessClaims struct {
+	UserID    string
+	SessionID string
+}
+
+// AccessTokens issues the short-lived tokens browsers authenticate with.
+// They are signed with the key ring's private keys, so other services can
+// verify them with the public keys from JWKS, without sharing a secret.
+type AccessTokens interface {
+	Issue(userID string, sessionID string) (string, error)
+	Verify(token string) (*AccessClaims, error)
+	// JWKS is the public keys in JWK set format
+	JWKS() map[string]any
+}
+
+type AccessTokenService struct {
+	keys     *KeyRing
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func NewAccessTokenService(keys *KeyRing, issuer string, audience string) *AccessTokenService {
+	return &AccessTokenService{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		now:      time.Now,
+	}
+}
+
+// accessTokenClaims are the registered claims plus the session ID
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+}
+
+func (s *AccessTokenService) Issue(userID string, sessionID string) (string, error) {
+	key, err := s.keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now()
+	token := jwt.NewWithClaims(key.Method, accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{s.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(constants.ACCESS_TOKEN_EXPIRY)),
+		},
+		SessionID: sessionID,
+	})
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+func (s *AccessTokenService) Verify(tokenString string) (*AccessClaims, error) {
+	var claims accessTokenClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys.verificationKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// the key decides the algorithm, not the token
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.Private.Public(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(s.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(s.now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.SessionID == "" {
+		return nil, errors.New("access token has no subject or session")
+	}
+	return &AccessClaims{UserID: claims.Subject, SessionID: claims.SessionID}, nil
+}
+
+func (s *AccessTokenService) JWKS() map[string]any {
+	return s.keys.JWKS()
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAccessTokens returns a service on keys whose clock reads *now
+func testAccessTokens(t *testing.T, now *time.Time, keys ...*SigningKey) *AccessTokenService {
+	t.Helper()
+	ring, err := newKeyRing(func() ([]*SigningKey, error) { return keys, nil }, func() time.Time { return *now })
+	require.NoError(t, err)
+	service := NewAccessTokenService(ring, "https://x.example.com", "x-backend")
+	service.now = ring.now
+	return service
+}
+
+func rsaSigningKey(t *testing.T, id string, activeAt time.Time) *SigningKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := NewSigningKey(id, activeAt, private)
+	require.NoError(t, err)
+	return key
+}
+
+// publishedKeys decodes the JWKS the way another service would
+func publishedKeys(t *testing.T, service *AccessTokenService) map[string]jsonWebKey {
+	t.Helper()
+	data, err := json.Marshal(service.JWKS())
+	require.NoError(t, err)
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(data, &set))
+
+	keys := make(map[string]jsonWebKey)
+	for _, key := range set.Keys {
+		keys[key.Kid] = key
+	}
+	return keys
+}
+
+func TestAccessTokens(t *testing.T) {
+	now := time.Now()
+	edKey, err := GenerateSigningKey("ed", now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	for name, key := range map[string]*SigningKey{"EdDSA": edKey, "RS256": rsaSigningKey(t, "rsa", now.Add(-time.Hour))} {
+		t.Run(name, func(t *testing.T) {
+			service := testAccessTokens(t, &now, key)
+			token, err := service.Issue("user-1", "session-1")
+			require.NoError(t, err)
+
+			claims, err := service.Verify(token)
+			require.NoError(t, err)
+			assert.Equal(t, &AccessClaims{UserID: "user-1", SessionID: "session-1"}, claims)
+
+			// another service verifies it with the published key alone
+			jwk, ok := publishedKeys(t, service)[key.ID]
+			require.True(t, ok)
+			assert.Equal(t, name, jwk.Alg)
+			public, err := jwk.publicKey()
+			require.NoError(t, err)
+			parsed := jwt.MapClaims{}
+			header, err := jwt.ParseWithClaims(token, parsed, func(*jwt.Token) (any, error) { return public, nil })
+			require.NoError(t, err)
+			assert.Equal(t, key.ID, header.Header["kid"])
+			assert.Equal(t, "https://x.example.com", parsed["iss"])
+			assert.Equal(t, "user-1", parsed["sub"])
+			assert.Equal(t, []any{"x-backend"}, parsed["aud"])
+			assert.Equal(t, float64(now.Unix()), parsed["iat"])
+		})
+	}
+
+	t.Run("rejects", func(t *testing.T) {
+		service := testAccessTokens(t, &now, edKey)
+		sign := func(change func(claims jwt.MapClaims)) string {
+			claims := jwt.MapClaims{
+				"iss": "https://x.example.com",
+				"sub": "user-1",
+				"aud": "x-backend",
+				"iat": now.Unix(),
+				"exp": now.Add(time.Minute).Unix(),
+				"sid": "session-1",
+			}
+			change(claims)
+			token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+			token.Header["kid"] = edKey.ID
+			signed, err := token.SignedString(edKey.Private)
+			require.NoError(t, err)
+			return signed
+		}
+
+		_, err := service.Verify(sign(func(jwt.MapClaims) {}))
+		require.NoError(t, err)
+
+		tests := map[string]func(claims jwt.MapClaims){
+			"another issuer":   func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+			"another audience": func(claims jwt.MapClaims) { claims["aud"] = "another-service" },
+			"expired":          func(claims jwt.MapClaims) { claims["exp"] = now.Add(-time.Second).Unix() },
+			"no expiry":        func(claims jwt.MapClaims) { delete(claims, "exp") },
+			"issued later":     func(claims jwt.MapClaims) { claims["iat"] = now.Add(time.Hour).Unix() },
+			"no session":       func(claims jwt.MapClaims) { delete(claims, "sid") },
+		}
+		for name, change := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := service.Verify(sign(change))
+				assert.Error(t, err)
+			})
+		}
+
+		t.Run("unknown key", func(t *testing.T) {
+			other, err := GenerateSigningKey("other", now.Add(-time.Hour))
+			require.NoError(t, err)
+			token, err := testAccessTokens(t, &now, other).Issue("user-1", "session-1")
+			require.NoError(t, err)
+			_, err = service.Verify(token)
+			assert.Error(t, err)
+		})
+
+		t.Run("HMAC with the public key", func(t *testing.T) {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user-1", "sid": "session-1"})
+			token.Header["kid"] = edKey.ID
+			signed, err := token.SignedString([]byte(edKey.Private.Public().(ed25519.PublicKey)))
+			require.NoError(t, err)
+			_, err = service.Verify(signed)
+			assert.Error(t, err)
+		})
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	start := time.Now()
+	now := start
+	first, err := GenerateSigningKey("first", start.Add(-time.Hour))
+	require.NoError(t, err)
+	second, err := GenerateSigningKey("second", start.Add(24*time.Hour))
+	require.NoError(t, err)
+	service := testAccessTokens(t, &now, first, second)
+
+	// the next key is published a day before it signs anything
+	assert.Len(t, publishedKeys(t, service), 2)
+	old, err := service.Issue("user-1", "session-1")
+	require.NoError(t, err)
+	kid := func(token string) any {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		require.NoError(t, err)
+		return parsed.Header["kid"]
+	}
+	assert.Equal(t, "first", kid(old))
+
+	// after the rotation, tokens from the old key keep working for the overlap
+	now = start.Add(24*time.Hour + time.Minute)
+	current, err := service.Issue("user-1", "session-1")
+	require.NoError(t, err)
+	assert.Equal(t, "second", kid(current))
+	old = issueAt(t, service, &now, start.Add(24*time.Hour-time.Second))
+	_, err = service.Verify(old)
+	require.NoError(t, err)
+	assert.Len(t, publishedKeys(t, service), 2)
+
+	// then the old key is retired
+	now = start.Add(24*time.Hour + constants.SIGNING_KEY_OVERLAP)
+	_, err = service.Verify(old)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"second"}, keyIDs(publishedKeys(t, service)))
+	_, err = service.Verify(current)
+	require.NoError(t, err)
+}
+
+// keys active from the same date sign in ID order whatever order they load in
+func TestKeyRotationSameDate(t *testing.T) {
+	now := time.Now()
+	activeAt := now.Add(-time.Hour)
+	keys := make([]*SigningKey, 0, 3)
+	for _, id := range []string{"2026-01-01-c", "2026-01-01", "2026-01-01-b"} {
+		key, err := GenerateSigningKey(id, activeAt)
+		require.NoError(t, err)
+		keys = append(keys, key)
+	}
+
+	for range 5 {
+		ring, err := newKeyRing(func() ([]*SigningKey, error) { return keys, nil }, func() time.Time { return now })
+		require.NoError(t, err)
+		key, err := ring.signingKey()
+		require.NoError(t, err)
+		assert.Equal(t, "2026-01-01-c", key.ID)
+		keys[0], keys[1], keys[2] = keys[2], keys[0], keys[1]
+	}
+}
+
+// issueAt issues a token as if at, leaving the clock at *now afterwards.
+// Tokens issued then would still verify now if their key is kept.
+func issueAt(t *testing.T, service *AccessTokenService, now *time.Time, at time.Time) string {
+	t.Helper()
+	saved := *now
+	*now = at
+	defer func() { *now = saved }()
+	token, err := service.Issue("user-1", "session-1")
+	require.NoError(t, err)
+	return token
+}
+
+func keyIDs(keys map[string]jsonWebKey) []string {
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func writeKeyFile(t *testing.T, dir string, name string, private any) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writeKeyFile(t, dir, "2026-01-01.pem", edPrivate)
+	writeKeyFile(t, dir, "2026-02-01-rsa.pem", rsaPrivate)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0o600))
+
+	keys, err := LoadSigningKeys(dir)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	byID := map[string]*SigningKey{}
+	for _, key := range keys {
+		byID[key.ID] = key
+	}
+	assert.Equal(t, jwt.SigningMethodEdDSA, byID["2026-01-01"].Method)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), byID["2026-01-01"].ActiveAt)
+	assert.Equal(t, jwt.SigningMethodRS256, byID["2026-02-01-rsa"].Method)
+
+	t.Run("reload", func(t *testing.T) {
+		now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+		ring, err := newKeyRing(func() ([]*SigningKey, error) { return LoadSigningKeys(dir) }, func() time.Time { return now })
+		require.NoError(t, err)
+
+		key, err := ring.signingKey()
+		require.NoError(t, err)
+		assert.Equal(t, "2026-02-01-rsa", key.ID)
+
+		// a key added for a later date is picked up without a restart
+		_, next, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		writeKeyFile(t, dir, "2026-03-15.pem", next)
+		now = now.Add(signingKeyReloadInterval)
+		_, ok := ring.verificationKey("2026-03-15")
+		assert.True(t, ok)
+		key, err = ring.signingKey()
+		require.NoError(t, err)
+		assert.Equal(t, "2026-02-01-rsa", key.ID, "it only signs from its date")
+
+		// a broken key doesn't take down the ones already loaded
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "2026-04-01.pem"), []byte("broken"), 0o600))
+		now = now.Add(signingKeyReloadInterval)
+		_, err = ring.signingKey()
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := map[string]func(dir string){
+			"no keys":       func(dir string) {},
+			"undated name":  func(dir string) { writeKeyFile(t, dir, "current.pem", edPrivate) },
+			"not a PEM":     func(dir string) { os.WriteFile(filepath.Join(dir, "2026-01-01.pem"), []byte("key"), 0o600) },
+			"small RSA key": func(dir string) { writeKeyFile(t, dir, "2026-01-01.pem", smallRSAKey(t)) },
+		}
+		for name, setup := range tests {
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				setup(dir)
+				_, err := LoadSigningKeys(dir)
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func smallRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	return key
+}
@@ -0,0 +1,44 @@
+package services
+
+import "sync"
+
+// ContentFilterChanges tells open streams that a user's follows, blocks or
+// mutes changed, so they reload the user's content filter right away instead
+// of when it expires
+type ContentFilterChanges interface {
+	// Changed marks the content filters of the given users as stale
+	Changed(userIDs ...string)
+	// Version returns a number that changes whenever Changed is called for
+	// the user
+	Version(userID string) uint64
+}
+
+// ContentFilterChangesService keeps versions in memory. Like the revocation
+// list it is per process; streams on other processes pick changes up when
+// their filter expires.
+type ContentFilterChangesService struct {
+	versions map[string]uint64
+	mu       sync.RWMutex
+}
+
+func NewContentFilterChanges() ContentFilterChanges {
+	return &ContentFilterChangesService{
+		versions: make(map[string]uint64),
+	}
+}
+
+func (s *ContentFilterChangesService) Changed(userIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, userID := range userIDs {
+		s.versions[userID]++
+	}
+}
+
+func (s *ContentFilterChangesService) Version(userID string) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.versions[userID]
+}
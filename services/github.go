@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"golang.org/x/oauth2"
+)
+
+const GitHubAPIURL = "https://api.github.com"
+
+func NewGitHubProvider(config *oauth2.Config, apiURL string) *OAuthProvider {
+	return &OAuthProvider{
+		Name:     constants.AUTH_PROVIDER_GITHUB,
+		Config:   config,
+		UserInfo: &gitHubUserInfoClient{config: config, apiURL: apiURL},
+	}
+}
+
+type gitHubUserInfoClient struct {
+	config *oauth2.Config
+	apiURL string
+}
+
+type gitHubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// UserInfo reads the GitHub user. The profile only has an email if the user
+// made one public, so the email comes from the list of the user's emails.
+func (c *gitHubUserInfoClient) UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*models.OAuthUserInfo, error) {
+	client := c.config.Client(ctx, token)
+
+	var user map[string]any
+	if err := getJSON(ctx, client, c.apiURL+"/user", &user); err != nil {
+		return nil, err
+	}
+	var emails []gitHubEmail
+	if err := getJSON(ctx, client, c.apiURL+"/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	info := &models.OAuthUserInfo{
+		Subject: idClaim(user, "id"),
+		Name:    stringClaim(user, "name"),
+	}
+	if info.Name == "" {
+		info.Name = stringClaim(user, "login")
+	}
+	for _, email := range emails {
+		if email.Primary {
+			info.Email = email.Email
+			info.EmailVerified = email.Verified
+		}
+	}
+	if info.Subject == "" || info.Email == "" {
+		return nil, errors.New("GitHub user has no id or primary email")
+	}
+	return info, nil
+}
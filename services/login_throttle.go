@@ -0,0 +1,299 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// ThrottlePolicy is how failed sign in attempts slow down further attempts.
+// The first FreeAttempts failures cost nothing. After that each failure
+// blocks the next attempt for BaseDelay, doubling with every failure up to
+// MaxDelay, and after LockoutAfter failures attempts are blocked for
+// LockoutDuration. Failures are forgotten ResetAfter the last one.
+type ThrottlePolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	ResetAfter      time.Duration
+}
+
+var (
+	// AccountThrottlePolicy guards a single account against password guessing
+	AccountThrottlePolicy = ThrottlePolicy{
+		FreeAttempts:    5,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		ResetAfter:      24 * time.Hour,
+	}
+	// IPThrottlePolicy guards against one client guessing across many
+	// accounts. It is looser, since many users can share an IP.
+	IPThrottlePolicy = ThrottlePolicy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: time.Hour,
+		ResetAfter:      24 * time.Hour,
+	}
+)
+
+// LoginThrottle tracks failed sign in attempts per account and per client IP
+type LoginThrottle interface {
+	// Attempt reserves an attempt for the account and IP if they may try now,
+	// and otherwise returns how long they have to wait. Until it is resolved
+	// the attempt counts as a failure, so a burst of concurrent attempts
+	// can't all get in before the first of them fails.
+	Attempt(account string, ip string) (*LoginAttempt, time.Duration)
+	// Wait returns how long the account and IP have to wait before the next
+	// attempt, or 0 if they may try now
+	Wait(account string, ip string) time.Duration
+	// Fail records a failed attempt and reports whether it locked the account
+	Fail(account string, ip string) bool
+	// Succeed forgets the account's failures. The IP's stay, or an attacker
+	// could clear them by signing into an account of their own.
+	Succeed(account string)
+}
+
+// LoginThrottleService is an in-memory LoginThrottle. Failures are per
+// process, like the rate limiter's windows.
+type LoginThrottleService struct {
+	accounts *failureCounter
+	ips      *failureCounter
+}
+
+func NewLoginThrottle(accountPolicy ThrottlePolicy, ipPolicy ThrottlePolicy) LoginThrottle {
+	return &LoginThrottleService{
+		accounts: newFailureCounter(accountPolicy),
+		ips:      newFailureCounter(ipPolicy),
+	}
+}
+
+func (s *LoginThrottleService) Attempt(account string, ip string) (*LoginAttempt, time.Duration) {
+	if wait := s.accounts.reserve(account); wait > 0 {
+		return nil, max(wait, s.ips.wait(ip))
+	}
+	if wait := s.ips.reserve(ip); wait > 0 {
+		s.accounts.release(account)
+		return nil, wait
+	}
+	return &LoginAttempt{throttle: s, account: account, ip: ip}, 0
+}
+
+func (s *LoginThrottleService) Wait(account string, ip string) time.Duration {
+	return max(s.accounts.wait(account), s.ips.wait(ip))
+}
+
+func (s *LoginThrottleService) Fail(account string, ip string) bool {
+	s.ips.fail(ip, false)
+	return s.accounts.fail(account, false)
+}
+
+func (s *LoginThrottleService) Succeed(account string) {
+	s.accounts.reset(account, false)
+}
+
+// LoginAttempt is an attempt reserved by LoginThrottle.Attempt. Only the
+// first call to Fail, Succeed or Release counts, so Release can be deferred
+// to give the attempt back on paths that never checked the credentials.
+type LoginAttempt struct {
+	throttle *LoginThrottleService
+	account  string
+	ip       string
+	resolved bool
+}
+
+// Fail records the attempt as failed and reports whether it locked the
+// account
+func (a *LoginAttempt) Fail() bool {
+	if a.resolved {
+		return false
+	}
+	a.resolved = true
+	a.throttle.ips.fail(a.ip, true)
+	return a.throttle.accounts.fail(a.account, true)
+}
+
+// Succeed forgets the account's failures, like LoginThrottle.Succeed
+func (a *LoginAttempt) Succeed() {
+	if a.resolved {
+		return
+	}
+	a.resolved = true
+	a.throttle.ips.release(a.ip)
+	a.throttle.accounts.reset(a.account, true)
+}
+
+// Release drops the attempt without counting it either way
+func (a *LoginAttempt) Release() {
+	if a.resolved {
+		return
+	}
+	a.resolved = true
+	a.throttle.ips.release(a.ip)
+	a.throttle.accounts.release(a.account)
+}
+
+type failures struct {
+	count int
+	last  time.Time
+	// attempts reserved but not resolved yet, and when the last one was
+	pending  int
+	reserved time.Time
+}
+
+// failureCounter counts failures per key under one policy
+type failureCounter struct {
+	policy    ThrottlePolicy
+	failures  map[string]*failures
+	lastPrune time.Time
+	mu        sync.Mutex
+	now       func() time.Time
+}
+
+func newFailureCounter(policy ThrottlePolicy) *failureCounter {
+	return &failureCounter{
+		policy:   policy,
+		failures: make(map[string]*failures),
+		now:      time.Now,
+	}
+}
+
+func (c *failureCounter) wait(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.failures[key]
+	if !ok {
+		return 0
+	}
+	return c.waitFor(f, c.now())
+}
+
+// waitFor counts pending attempts as failures made when the last of them was
+// reserved
+func (c *failureCounter) waitFor(f *failures, now time.Time) time.Duration {
+	until := f.last.Add(c.delay(f.count))
+	if f.pending > 0 {
+		if pendingUntil := f.reserved.Add(c.delay(f.count + f.pending)); pendingUntil.After(until) {
+			until = pendingUntil
+		}
+	}
+	return max(until.Sub(now), 0)
+}
+
+// reserve adds a pending attempt if the key may try now, checking and
+// reserving under one lock, and otherwise returns how long it has to wait
+func (c *failureCounter) reserve(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.prune(now)
+	f, ok := c.failures[key]
+	if !ok {
+		f = &failures{}
+		c.failures[key] = f
+	}
+	if wait := c.waitFor(f, now); wait > 0 {
+		return wait
+	}
+	f.pending++
+	f.reserved = now
+	return 0
+}
+
+// fail records a failure, resolving a pending attempt if reserved, and
+// reports whether it started a lockout
+func (c *failureCounter) fail(key string, reserved bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.prune(now)
+	f, ok := c.failures[key]
+	if !ok {
+		f = &failures{}
+		c.failures[key] = f
+	}
+	if now.Sub(f.last) >= c.policy.ResetAfter {
+		f.count = 0
+	}
+	if reserved {
+		f.pending = max(f.pending-1, 0)
+	}
+	f.count++
+	f.last = now
+	return f.count >= c.policy.LockoutAfter
+}
+
+// reset forgets the key's failures, resolving a pending attempt if reserved.
+// Other attempts still pending keep counting.
+func (c *failureCounter) reset(key string, reserved bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.failures[key]
+	if !ok {
+		return
+	}
+	if reserved {
+		f.pending = max(f.pending-1, 0)
+	}
+	if f.pending == 0 {
+		delete(c.failures, key)
+		return
+	}
+	f.count = 0
+}
+
+// release drops a pending attempt without counting it
+func (c *failureCounter) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.failures[key]
+	if !ok {
+		return
+	}
+	f.pending = max(f.pending-1, 0)
+	if f.count == 0 && f.pending == 0 {
+		delete(c.failures, key)
+	}
+}
+
+// delay is how long after the last of count failures the next attempt is
+// blocked
+func (c *failureCounter) delay(count int) time.Duration {
+	switch {
+	case count >= c.policy.LockoutAfter:
+		return c.policy.LockoutDuration
+	case count < c.policy.FreeAttempts:
+		return 0
+	}
+	delay := c.policy.BaseDelay
+	for range count - c.policy.FreeAttempts {
+		delay *= 2
+		if delay >= c.policy.MaxDelay {
+			return c.policy.MaxDelay
+		}
+	}
+	return min(delay, c.policy.MaxDelay)
+}
+
+// prune drops forgotten failures, at most once a minute, so the map doesn't
+// grow with every email ever guessed
+func (c *failureCounter) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < time.Minute {
+		return
+	}
+	c.lastPrune = now
+	for key, f := range c.failures {
+		if f.pending == 0 && now.Sub(f.last) >= c.policy.ResetAfter {
+			delete(c.failures, key)
+		}
+	}
+}
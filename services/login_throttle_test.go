@@ -0,0 +1,138 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := ThrottlePolicy{
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		LockoutAfter:    6,
+		LockoutDuration: time.Minute,
+		ResetAfter:      time.Hour,
+	}
+	throttle := NewLoginThrottle(policy, ThrottlePolicy{FreeAttempts: 100, LockoutAfter: 100, ResetAfter: time.Hour}).(*LoginThrottleService)
+	throttle.accounts.now = func() time.Time { return now }
+	throttle.ips.now = func() time.Time { return now }
+
+	fail := func() bool {
+		locked := throttle.Fail("alice", "10.0.0.1")
+		now = now.Add(throttle.Wait("alice", "10.0.0.1"))
+		return locked
+	}
+
+	// free attempts, then 1s, 2s, 4s, 4s
+	var delays []time.Duration
+	for range 5 {
+		assert.False(t, throttle.Fail("alice", "10.0.0.1"))
+		delays = append(delays, throttle.Wait("alice", "10.0.0.1"))
+		now = now.Add(delays[len(delays)-1])
+	}
+	assert.Equal(t, []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}, delays)
+	assert.Zero(t, throttle.Wait("bob", "10.0.0.2"), "other accounts and IPs are unaffected")
+
+	assert.True(t, fail(), "the sixth failure locks the account")
+	assert.Zero(t, throttle.Wait("alice", "10.0.0.1"))
+	assert.True(t, fail(), "failing again after the lockout locks again")
+
+	throttle.Succeed("alice")
+	assert.Zero(t, throttle.Wait("alice", "10.0.0.1"))
+	assert.False(t, fail())
+
+	// failures are forgotten after a while
+	for range 3 {
+		fail()
+	}
+	now = now.Add(time.Hour)
+	assert.False(t, throttle.Fail("alice", "10.0.0.1"))
+	assert.Zero(t, throttle.Wait("alice", "10.0.0.1"))
+}
+
+func TestLoginThrottlePerIP(t *testing.T) {
+	throttle := NewLoginThrottle(
+		ThrottlePolicy{FreeAttempts: 100, LockoutAfter: 100, ResetAfter: time.Hour},
+		ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Minute, LockoutAfter: 100, ResetAfter: time.Hour},
+	)
+
+	// one IP guessing across accounts
+	for _, account := range []string{"a", "b", "c"} {
+		assert.Zero(t, throttle.Wait(account, "10.0.0.1"))
+		throttle.Fail(account, "10.0.0.1")
+	}
+	assert.NotZero(t, throttle.Wait("d", "10.0.0.1"))
+	assert.Zero(t, throttle.Wait("d", "10.0.0.2"))
+
+	// signing in doesn't clear the IP's failures
+	throttle.Succeed("c")
+	assert.NotZero(t, throttle.Wait("c", "10.0.0.1"))
+}
+
+func TestLoginThrottleAttempt(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := NewLoginThrottle(
+		ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Minute, LockoutAfter: 100, ResetAfter: time.Hour},
+		ThrottlePolicy{FreeAttempts: 100, LockoutAfter: 100, ResetAfter: time.Hour},
+	).(*LoginThrottleService)
+	throttle.accounts.now = func() time.Time { return now }
+	throttle.ips.now = func() time.Time { return now }
+
+	// pending attempts count as failures, so only the free ones get in
+	first, wait := throttle.Attempt("alice", "10.0.0.1")
+	require.NotNil(t, first)
+	assert.Zero(t, wait)
+	second, _ := throttle.Attempt("alice", "10.0.0.1")
+	require.NotNil(t, second)
+	third, wait := throttle.Attempt("alice", "10.0.0.1")
+	assert.Nil(t, third)
+	assert.Equal(t, time.Minute, wait)
+
+	// giving an attempt back frees its place, resolving it twice does nothing
+	second.Release()
+	second.Fail()
+	third, _ = throttle.Attempt("alice", "10.0.0.1")
+	require.NotNil(t, third)
+
+	first.Fail()
+	third.Fail()
+	_, wait = throttle.Attempt("alice", "10.0.0.1")
+	assert.Equal(t, time.Minute, wait)
+
+	// a success clears the failures
+	now = now.Add(time.Minute)
+	attempt, _ := throttle.Attempt("alice", "10.0.0.1")
+	require.NotNil(t, attempt)
+	attempt.Succeed()
+	assert.Empty(t, throttle.accounts.failures)
+}
+
+func TestLoginThrottleConcurrentAttempts(t *testing.T) {
+	throttle := NewLoginThrottle(
+		ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour, LockoutAfter: 100, ResetAfter: time.Hour},
+		IPThrottlePolicy,
+	)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if attempt, _ := throttle.Attempt("alice", "10.0.0.1"); attempt != nil {
+				allowed.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				attempt.Fail()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 3, allowed.Load())
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogMailer(t *testing.T) {
+	var out bytes.Buffer
+	mailer := NewLogMailer(&out, "X <no-reply@example.com>")
+
+	require.NoError(t, mailer.Send(context.Background(), &Email{
+		To:      "alice@example.com",
+		Subject: "Hello",
+		Body:    "Line one\nLine two",
+	}))
+	assert.Contains(t, out.String(), "From: X <no-reply@example.com>\r\n")
+	assert.Contains(t, out.String(), "To: alice@example.com\r\n")
+	assert.Contains(t, out.String(), "Subject: Hello\r\n")
+	assert.Contains(t, out.String(), "\r\n\r\nLine one\r\nLine two")
+
+	err := mailer.Send(context.Background(), &Email{
+		To:      "alice@example.com",
+		Subject: "Hello\r\nBcc: everyone@example.com",
+	})
+	assert.Error(t, err, "headers can't be injected")
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	mailer := NewFileMailer(path, "no-reply@example.com")
+
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		require.NoError(t, mailer.Send(context.Background(), &Email{To: to, Subject: "Hi", Body: "Hi"}))
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "To: alice@example.com")
+	assert.Contains(t, string(data), "To: bob@example.com")
+}
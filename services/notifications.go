@@ -1,6 +1,8 @@
 package services
 
 import (
+	"sync"
+
 	"github.com/aimrintech/x-backend/models"
 )
 
@@ -8,28 +10,49 @@ type Notifications interface {
 	Subscribe(topic models.NotificationType, userID string) <-chan models.Notification
 	Unsubscribe(topic models.NotificationType, userID string)
 	Publish(topic models.NotificationType, notification *models.Notification)
+	Close()
 }
 
 type NotificationsService struct {
 	sse *SSEService
+	// forwarders tracks the goroutines converting SSE messages, done stops
+	// the ones whose stream is no longer reading
+	forwarders sync.WaitGroup
+	done       chan struct{}
+	mu         sync.Mutex
+	closed     bool
 }
 
 func NewNotificationsService() Notifications {
 	return &NotificationsService{
-		sse: NewSSEService(),
+		sse:  NewSSEService(),
+		done: make(chan struct{}),
 	}
 }
 
 func (s *NotificationsService) Subscribe(topic models.NotificationType, userID string) <-chan models.Notification {
-	ch := s.sse.Subscribe(string(topic), userID)
 	out := make(chan models.Notification)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(out)
+		return out
+	}
+
+	ch := s.sse.Subscribe(string(topic), userID)
+	s.forwarders.Add(1)
 	go func() {
+		defer s.forwarders.Done()
+		defer close(out)
 		for msg := range ch {
 			if notif, ok := msg.(models.Notification); ok {
-				out <- notif
+				select {
+				case out <- notif:
+				case <-s.done:
+					return
+				}
 			}
 		}
-		close(out)
 	}()
 	return out
 }
@@ -41,3 +64,17 @@ func (s *NotificationsService) Unsubscribe(topic models.NotificationType, userID
 func (s *NotificationsService) Publish(topic models.NotificationType, notification *models.Notification) {
 	s.sse.Publish(string(topic), notification.TargetUserID, *notification)
 }
+
+// Close ends every subscription and waits for the forwarding goroutines to
+// exit
+func (s *NotificationsService) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+		s.sse.Close()
+	}
+	s.mu.Unlock()
+
+	s.forwarders.Wait()
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationsService_Close(t *testing.T) {
+	service := NewNotificationsService()
+	open := service.Subscribe(models.NotificationTypeLike, "alice")
+	// nobody reads this one, so its forwarder is blocked sending
+	stalled := service.Subscribe(models.NotificationTypeFollow, "bob")
+	service.Publish(models.NotificationTypeFollow, &models.Notification{TargetUserID: "bob"})
+
+	closed := make(chan struct{})
+	go func() {
+		service.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not drain the forwarders")
+	}
+
+	_, ok := <-open
+	assert.False(t, ok)
+	_, ok = <-stalled
+	assert.False(t, ok)
+
+	// subscribing after Close gets an already closed channel
+	_, ok = <-service.Subscribe(models.NotificationTypeLike, "carol")
+	assert.False(t, ok)
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"golang.org/x/oauth2"
+)
+
+const GoogleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// UserInfoClient fetches the account that signed in at an OAuth provider.
+// nonce is the value sent with the authorization request, which OpenID
+// Connect providers echo in the ID token.
+type UserInfoClient interface {
+	UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*models.OAuthUserInfo, error)
+}
+
+// OAuthProvider is an OAuth 2.0 provider users can sign in with
+type OAuthProvider struct {
+	Name     constants.AuthProvider
+	Config   *oauth2.Config
+	UserInfo UserInfoClient
+}
+
+// AuthProviders is the registry of the providers users can sign in with
+type AuthProviders struct {
+	providers map[constants.AuthProvider]*OAuthProvider
+}
+
+func NewAuthProviders(providers ...*OAuthProvider) *AuthProviders {
+	registry := &AuthProviders{providers: make(map[constants.AuthProvider]*OAuthProvider)}
+	for _, provider := range providers {
+		registry.Register(provider)
+	}
+	return registry
+}
+
+// Register adds a provider, replacing any provider with the same name
+func (p *AuthProviders) Register(provider *OAuthProvider) {
+	p.providers[provider.Name] = provider
+}
+
+func (p *AuthProviders) Get(name constants.AuthProvider) (*OAuthProvider, bool) {
+	provider, ok := p.providers[name]
+	return provider, ok
+}
+
+// Names returns the registered providers' names, sorted
+func (p *AuthProviders) Names() []constants.AuthProvider {
+	names := make([]constants.AuthProvider, 0, len(p.providers))
+	for name := range p.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func NewGoogleProvider(config *oauth2.Config) *OAuthProvider {
+	return &OAuthProvider{
+		Name:     constants.AUTH_PROVIDER_GOOGLE,
+		Config:   config,
+		UserInfo: NewGoogleUserInfoClient(config, GoogleUserInfoURL),
+	}
+}
+
+type googleUserInfoClient struct {
+	config *oauth2.Config
+	url    string
+}
+
+// NewGoogleUserInfoClient reads Google's userinfo endpoint at url. Tests can
+// point it at a fake provider.
+func NewGoogleUserInfoClient(config *oauth2.Config, url string) UserInfoClient {
+	return &googleUserInfoClient{config: config, url: url}
+}
+
+func (c *googleUserInfoClient) UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*models.OAuthUserInfo, error) {
+	var claims map[string]any
+	if err := getJSON(ctx, c.config.Client(ctx, token), c.url, &claims); err != nil {
+		return nil, err
+	}
+
+	info := &models.OAuthUserInfo{
+		Subject:       idClaim(claims, "id"),
+		Email:         stringClaim(claims, "email"),
+		EmailVerified: claims["verified_email"] == true,
+		Name:          stringClaim(claims, "name"),
+	}
+	if info.Subject == "" || info.Email == "" {
+		return nil, errors.New("userinfo response has no id or email")
+	}
+	return info, nil
+}
+
+// getJSON fetches url with client and decodes the JSON response into out
+func getJSON(ctx context.Context, client *http.Client, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	return nil
+}
+
+// stringClaim returns a string field of a provider response, or "" when it
+// is missing or isn't a string
+func stringClaim(claims map[string]any, name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
+
+// idClaim is stringClaim for IDs, which some providers send as numbers
+func idClaim(claims map[string]any, name string) string {
+	if v, ok := claims[name].(float64); ok {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return stringClaim(claims, name)
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// testIssuer serves a discovery document and a JWKS with the keys it signs with
+type testIssuer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	keys map[string]*rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{keys: make(map[string]*rsa.PrivateKey)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		keys := []map[string]string{}
+		for kid, key := range issuer.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	issuer.addKey(t, "key-1")
+	return issuer
+}
+
+func (i *testIssuer) addKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys[kid] = key
+}
+
+func (i *testIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(i.keys[kid])
+	require.NoError(t, err)
+	return signed
+}
+
+func (i *testIssuer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            i.URL,
+		"aud":            "client",
+		"sub":            "subject",
+		"email":          "ada@example.com",
+		"email_verified": "true",
+		"nonce":          "nonce",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func TestOIDCProvider(t *testing.T) {
+	issuer := newTestIssuer(t)
+	ctx := context.Background()
+
+	provider, err := NewOIDCProvider(ctx, issuer.URL+"/", &oauth2.Config{ClientID: "client"})
+	require.NoError(t, err)
+	assert.Equal(t, issuer.URL+"/token", provider.Config.Endpoint.TokenURL)
+
+	userInfo := func(idToken string, nonce string) error {
+		token := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]any{"id_token": idToken})
+		_, err := provider.UserInfo.UserInfo(ctx, token, nonce)
+		return err
+	}
+
+	token := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]any{"id_token": issuer.sign(t, "key-1", issuer.claims())})
+	info, err := provider.UserInfo.UserInfo(ctx, token, "nonce")
+	require.NoError(t, err)
+	assert.Equal(t, "subject", info.Subject)
+	assert.True(t, info.EmailVerified, "email_verified may be a string")
+
+	t.Run("rejects", func(t *testing.T) {
+		tests := map[string]func(claims jwt.MapClaims){
+			"another audience": func(claims jwt.MapClaims) { claims["aud"] = "someone-else" },
+			"another issuer":   func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+			"expired":          func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+			"no expiry":        func(claims jwt.MapClaims) { delete(claims, "exp") },
+			"another nonce":    func(claims jwt.MapClaims) { claims["nonce"] = "replayed" },
+		}
+		for name, change := range tests {
+			t.Run(name, func(t *testing.T) {
+				claims := issuer.claims()
+				change(claims)
+				assert.Error(t, userInfo(issuer.sign(t, "key-1", claims), "nonce"))
+			})
+		}
+
+		t.Run("HMAC with the public key", func(t *testing.T) {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, issuer.claims())
+			token.Header["kid"] = "key-1"
+			signed, err := token.SignedString(issuer.keys["key-1"].N.Bytes())
+			require.NoError(t, err)
+			assert.Error(t, userInfo(signed, "nonce"))
+		})
+
+		t.Run("no ID token", func(t *testing.T) {
+			_, err := provider.UserInfo.UserInfo(ctx, &oauth2.Token{AccessToken: "access"}, "nonce")
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("rotated key", func(t *testing.T) {
+		issuer.addKey(t, "key-2")
+		verifier := provider.UserInfo.(*idTokenVerifier)
+		verifier.keys.mu.Lock()
+		verifier.keys.fetchedAt = time.Time{}
+		verifier.keys.mu.Unlock()
+
+		assert.NoError(t, userInfo(issuer.sign(t, "key-2", issuer.claims()), "nonce"), "an unknown key ID refetches the keys")
+
+		unpublished, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, issuer.claims())
+		token.Header["kid"] = "key-3"
+		signed, err := token.SignedString(unpublished)
+		require.NoError(t, err)
+		assert.Error(t, userInfo(signed, "nonce"), "keys the issuer doesn't publish are rejected")
+	})
+}
+
+func TestOIDCProviderIssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": "https://evil.example.com"})
+	}))
+	defer server.Close()
+
+	_, err := NewOIDCProvider(context.Background(), server.URL, &oauth2.Config{})
+	assert.ErrorContains(t, err, "does not match")
+}
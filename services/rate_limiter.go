@@ -0,0 +1,64 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows at most a fixed number of events per key within a window
+type RateLimiter interface {
+	Allow(key string) bool
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimiterService is an in-memory fixed window rate limiter. Limits are
+// per process, which is enough to keep a single client from hammering an
+// endpoint.
+type RateLimiterService struct {
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+func NewRateLimiter(limit int, window time.Duration) RateLimiter {
+	return &RateLimiterService{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+		now:     time.Now,
+	}
+}
+
+func (s *RateLimiterService) Allow(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	w, ok := s.windows[key]
+	if !ok || now.Sub(w.start) >= s.window {
+		s.evictExpired(now)
+		s.windows[key] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	if w.count >= s.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// evictExpired drops finished windows so the map doesn't grow with every
+// client ever seen
+func (s *RateLimiterService) evictExpired(now time.Time) {
+	for key, w := range s.windows {
+		if now.Sub(w.start) >= s.window {
+			delete(s.windows, key)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, time.Minute).(*RateLimiterService)
+	limiter.now = func() time.Time { return now }
+
+	assert.True(t, limiter.Allow("a"))
+	assert.True(t, limiter.Allow("a"))
+	assert.False(t, limiter.Allow("a"))
+
+	// keys are limited independently
+	assert.True(t, limiter.Allow("b"))
+
+	// a new window resets the count
+	now = now.Add(time.Minute)
+	assert.True(t, limiter.Allow("a"))
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationList holds the sessions that were revoked while access tokens
+// issued to them may still be valid
+type RevocationList interface {
+	// Revoke rejects the session's access tokens until the given time, by
+	// which they have all expired
+	Revoke(sessionID string, until time.Time)
+	IsRevoked(sessionID string) bool
+}
+
+// RevocationListService is an in-memory revocation list. Entries only need
+// to outlive the access tokens, so they are dropped once those expire.
+type RevocationListService struct {
+	revoked map[string]time.Time
+	mu      sync.RWMutex
+	now     func() time.Time
+}
+
+func NewRevocationList() RevocationList {
+	return &RevocationListService{
+		revoked: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (s *RevocationListService) Revoke(sessionID string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, expiresAt := range s.revoked {
+		if !now.Before(expiresAt) {
+			delete(s.revoked, id)
+		}
+	}
+	if until.After(s.revoked[sessionID]) {
+		s.revoked[sessionID] = until
+	}
+}
+
+func (s *RevocationListService) IsRevoked(sessionID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	until, ok := s.revoked[sessionID]
+	return ok && s.now().Before(until)
+}
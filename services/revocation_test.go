@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevocationList(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	list := NewRevocationList().(*RevocationListService)
+	list.now = func() time.Time { return now }
+
+	assert.False(t, list.IsRevoked("a"))
+	list.Revoke("a", now.Add(time.Minute))
+	assert.True(t, list.IsRevoked("a"))
+	assert.False(t, list.IsRevoked("b"))
+
+	// a shorter revocation doesn't cut a longer one short
+	list.Revoke("a", now.Add(time.Second))
+	now = now.Add(30 * time.Second)
+	assert.True(t, list.IsRevoked("a"))
+
+	// entries expire with the tokens they cover and are evicted
+	now = now.Add(time.Minute)
+	assert.False(t, list.IsRevoked("a"))
+	list.Revoke("b", now.Add(time.Minute))
+	assert.NotContains(t, list.revoked, "a")
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// how often the key ring looks for new or removed keys
+const signingKeyReloadInterval = time.Minute
+
+// SigningKey is a private key access tokens are signed with. It signs from
+// ActiveAt until the next key becomes active, and verifies for
+// constants.SIGNING_KEY_OVERLAP after that.
+type SigningKey struct {
+	ID       string
+	ActiveAt time.Time
+	Method   jwt.SigningMethod
+	Private  crypto.Signer
+}
+
+// NewSigningKey signs with RS256 for RSA keys of at least 2048 bits and
+// with EdDSA for Ed25519 keys
+func NewSigningKey(id string, activeAt time.Time, private crypto.Signer) (*SigningKey, error) {
+	key := &SigningKey{ID: id, ActiveAt: activeAt, Private: private}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("signing key %s: RSA keys must have at least 2048 bits", id)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("signing key %s: unsupported key type %T", id, private)
+	}
+	return key, nil
+}
+
+// GenerateSigningKey returns a new Ed25519 key, active from activeAt
+func GenerateSigningKey(id string, activeAt time.Time) (*SigningKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(id, activeAt, private)
+}
+
+// LoadSigningKeys reads every .pem file in dir as a PKCS #8 private key.
+// A file's name, without the extension, is the key's ID and starts with the
+// date the key becomes active, as in 2026-01-01.pem or 2026-01-01-b.pem.
+// Adding a file for a future date schedules a rotation: the key is
+// published right away, so verifiers know it before it signs anything.
+func LoadSigningKeys(dir string) ([]*SigningKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(files))
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".pem")
+		if len(id) < len(time.DateOnly) {
+			return nil, fmt.Errorf("signing key %s: name must start with the date it becomes active", id)
+		}
+		activeAt, err := time.Parse(time.DateOnly, id[:len(time.DateOnly)])
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: name must start with the date it becomes active", id)
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("signing key %s: no PEM block", id)
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", id, err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: unsupported key type %T", id, private)
+		}
+		key, err := NewSigningKey(id, activeAt, signer)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys in %s", dir)
+	}
+	return keys, nil
+}
+
+// KeyRing holds the signing keys and picks the one to sign with. Keys are
+// loaded again every minute, so rotating them needs no restart.
+type KeyRing struct {
+	load func() ([]*SigningKey, error)
+	now  func() time.Time
+
+	mu       sync.Mutex
+	keys     []*SigningKey
+	loadedAt time.Time
+}
+
+// NewKeyRing loads the keys, failing if that fails. Later failures to
+// reload them are logged and the keys already loaded are kept.
+func NewKeyRing(load func() ([]*SigningKey, error)) (*KeyRing, error) {
+	return newKeyRing(load, time.Now)
+}
+
+func newKeyRing(load func() ([]*SigningKey, error), now func() time.Time) (*KeyRing, error) {
+	ring := &KeyRing{load: load, now: now}
+	if err := ring.reload(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// NewStaticKeyRing holds the given keys only
+func NewStaticKeyRing(keys ...*SigningKey) (*KeyRing, error) {
+	return NewKeyRing(func() ([]*SigningKey, error) { return keys, nil })
+}
+
+func (k *KeyRing) reload() error {
+	keys, err := k.load()
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if ids[key.ID] {
+			return fmt.Errorf("duplicate signing key %s", key.ID)
+		}
+		ids[key.ID] = true
+	}
+
+	// keys active from the same date, like 2026-01-01.pem and
+	// 2026-01-01-b.pem, are ordered by ID so the same one signs on every
+	// reload and every server
+	sorted := append([]*SigningKey{}, keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].ActiveAt.Equal(sorted[j].ActiveAt) {
+			return sorted[i].ActiveAt.Before(sorted[j].ActiveAt)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	k.keys = sorted
+	k.loadedAt = k.now()
+	return nil
+}
+
+// usable returns the keys that are active, scheduled or still within their
+// overlap, and the one to sign with, which is nil if none is active yet.
+// Callers must hold the lock.
+func (k *KeyRing) usable() ([]*SigningKey, *SigningKey) {
+	now := k.now()
+	if now.Sub(k.loadedAt) >= signingKeyReloadInterval {
+		if err := k.reload(); err != nil {
+			log.Printf("Failed to reload signing keys, keeping the current ones: %v", err)
+			k.loadedAt = now
+		}
+	}
+
+	var usable []*SigningKey
+	var current *SigningKey
+	for i, key := range k.keys {
+		if !key.ActiveAt.After(now) {
+			current = key
+		}
+		// replaced keys verify until their overlap is over
+		if i+1 < len(k.keys) && !k.keys[i+1].ActiveAt.After(now.Add(-constants.SIGNING_KEY_OVERLAP)) {
+			continue
+		}
+		usable = append(usable, key)
+	}
+	return usable, current
+}
+
+// signingKey returns the key to sign with now
+func (k *KeyRing) signingKey() (*SigningKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, current := k.usable()
+	if current == nil {
+		return nil, errors.New("no signing key is active yet")
+	}
+	return current, nil
+}
+
+// verificationKey returns the key with the ID kid, if tokens it signed are
+// still accepted
+func (k *KeyRing) verificationKey(kid string) (*SigningKey, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys, _ := k.usable()
+	for _, key := range keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// JWKS is the public half of every key that tokens may be signed with, now
+// or soon, in JWK set format
+func (k *KeyRing) JWKS() map[string]any {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys, _ := k.usable()
+	set := make([]jsonWebKey, 0, len(keys))
+	for _, key := range keys {
+		set = append(set, key.publicJWK())
+	}
+	return map[string]any{"keys": set}
+}
+
+func (key *SigningKey) publicJWK() jsonWebKey {
+	jwk := jsonWebKey{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+	switch public := key.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
@@ -9,11 +9,13 @@ type SSE interface {
 	Subscribe(topic string, userID string) <-chan interface{}
 	Unsubscribe(topic string, userID string)
 	Publish(topic string, userID string, message interface{})
+	Close()
 }
 
 type SSEService struct {
 	subscribers map[string]map[string]chan interface{}
 	mu          sync.RWMutex
+	closed      bool
 }
 
 func NewSSEService() *SSEService {
@@ -26,6 +28,12 @@ func (s *SSEService) Subscribe(topic string, userID string) <-chan interface{} {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.closed {
+		ch := make(chan interface{})
+		close(ch)
+		return ch
+	}
+
 	if _, ok := s.subscribers[topic]; !ok {
 		s.subscribers[topic] = make(map[string]chan interface{})
 	}
@@ -78,3 +86,22 @@ func (s *SSEService) Publish(topic string, userID string, message interface{}) {
 
 	log.Printf("Published message to %s for user %s", topic, userID)
 }
+
+// Close ends every subscription, so open streams return, and rejects new ones
+func (s *SSEService) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+
+	for topic, userChans := range s.subscribers {
+		for _, ch := range userChans {
+			close(ch)
+		}
+		delete(s.subscribers, topic)
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+)
+
+// Scorer assigns a relevance score to a timeline candidate. Implementations
+// must be pure so different weightings can be A/B tested side by side.
+type Scorer interface {
+	Score(candidate *models.TimelineCandidate, now time.Time) float64
+}
+
+// WeightedScorer scores candidates with a linear combination of source
+// affinity and engagement, decayed by the age of the tweet
+type WeightedScorer struct {
+	FollowWeight      float64
+	EngagementWeight  float64
+	TrendingWeight    float64
+	SocialProofWeight float64
+	LikeWeight        float64
+	RetweetWeight     float64
+	ReplyWeight       float64
+	QuoteWeight       float64
+	HalfLife          time.Duration
+}
+
+func NewWeightedScorer() *WeightedScorer {
+	return &WeightedScorer{
+		FollowWeight:      3,
+		EngagementWeight:  1.5,
+		TrendingWeight:    1,
+		SocialProofWeight: 0.5,
+		LikeWeight:        1,
+		RetweetWeight:     2,
+		ReplyWeight:       1.5,
+		QuoteWeight:       2,
+		HalfLife:          6 * time.Hour,
+	}
+}
+
+func (s *WeightedScorer) Score(candidate *models.TimelineCandidate, now time.Time) float64 {
+	affinity := 0.0
+	if candidate.HasSource(models.TimelineSourceFollow) {
+		affinity += s.FollowWeight
+	}
+	if candidate.HasSource(models.TimelineSourceEngagement) {
+		affinity += s.EngagementWeight
+	}
+	if candidate.HasSource(models.TimelineSourceTrending) {
+		affinity += s.TrendingWeight
+	}
+	affinity += s.SocialProofWeight * float64(candidate.SocialProof)
+
+	tweet := candidate.Tweet
+	engagement := s.LikeWeight*float64(tweet.LikesCount) +
+		s.RetweetWeight*float64(tweet.RetweetsCount) +
+		s.ReplyWeight*float64(tweet.RepliesCount) +
+		s.QuoteWeight*float64(tweet.QuotesCount)
+
+	// log dampens viral tweets so they don't drown out everything else
+	score := affinity + math.Log1p(engagement)
+
+	return score * s.decay(now.Sub(tweet.CreatedAt))
+}
+
+// decay halves the score every HalfLife
+func (s *WeightedScorer) decay(age time.Duration) float64 {
+	if s.HalfLife <= 0 || age <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(age)/float64(s.HalfLife))
+}
+
+type Timeline interface {
+	Rank(candidates []models.TimelineCandidate, limit int, offset int) []models.TweetProps
+}
+
+type TimelineService struct {
+	scorer Scorer
+	// authorPenalty multiplies the score of each further tweet by an author
+	// already placed on the timeline
+	authorPenalty float64
+	// maxPerAuthor caps how many tweets one author gets in a single page
+	maxPerAuthor int
+	now          func() time.Time
+}
+
+func NewTimelineService(scorer Scorer) Timeline {
+	return &TimelineService{
+		scorer:        scorer,
+		authorPenalty: 0.5,
+		maxPerAuthor:  2,
+		now:           time.Now,
+	}
+}
+
+type scoredCandidate struct {
+	candidate *models.TimelineCandidate
+	score     float64
+}
+
+func (s *TimelineService) Rank(candidates []models.TimelineCandidate, limit int, offset int) []models.TweetProps {
+	now := s.now()
+
+	pending := make([]scoredCandidate, 0, len(candidates))
+	for i := range candidates {
+		pending = append(pending, scoredCandidate{
+			candidate: &candidates[i],
+			score:     s.scorer.Score(&candidates[i], now),
+		})
+	}
+
+	ranked := s.diversify(pending, limit)
+
+	result := make([]models.TweetProps, 0, limit)
+	for i := offset; i < len(ranked) && len(result) < limit; i++ {
+		result = append(result, ranked[i].Props)
+	}
+	return result
+}
+
+// diversify greedily picks the best remaining candidate, penalizing authors
+// that are already on the timeline. Within every page of pageSize items an
+// author appears at most maxPerAuthor times; overflow is pushed to later pages.
+func (s *TimelineService) diversify(pending []scoredCandidate, pageSize int) []*models.TimelineCandidate {
+	if pageSize <= 0 {
+		pageSize = len(pending)
+	}
+
+	ranked := make([]*models.TimelineCandidate, 0, len(pending))
+	placed := map[string]int{}
+	inPage := map[string]int{}
+
+	for len(pending) > 0 {
+		if len(ranked)%pageSize == 0 {
+			inPage = map[string]int{}
+		}
+
+		best := -1
+		bestScore := math.Inf(-1)
+		for i, c := range pending {
+			author := c.candidate.Props.Author.ID
+			if inPage[author] >= s.maxPerAuthor {
+				continue
+			}
+			score := c.score * math.Pow(s.authorPenalty, float64(placed[author]))
+			if score > bestScore || (best != -1 && score == bestScore && pending[best].candidate.Tweet.CreatedAt.Before(c.candidate.Tweet.CreatedAt)) {
+				best, bestScore = i, score
+			}
+		}
+
+		// every remaining author is capped for this page; fill it with the
+		// highest scored leftovers rather than leaving a short page
+		if best == -1 {
+			sort.SliceStable(pending, func(i, j int) bool { return pending[i].score > pending[j].score })
+			best = 0
+		}
+
+		author := pending[best].candidate.Props.Author.ID
+		placed[author]++
+		inPage[author]++
+		ranked = append(ranked, pending[best].candidate)
+		pending = append(pending[:best], pending[best+1:]...)
+	}
+
+	return ranked
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var rankNow = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newCandidate(id string, authorID string, age time.Duration, likes int, sources ...models.TimelineSource) models.TimelineCandidate {
+	c := models.TimelineCandidate{
+		Tweet: models.Tweet{
+			ID:         id,
+			CreatedAt:  rankNow.Add(-age),
+			LikesCount: likes,
+		},
+		Sources: sources,
+	}
+	c.Props.ID = id
+	c.Props.Author.ID = authorID
+	return c
+}
+
+func newTestTimelineService(scorer Scorer) *TimelineService {
+	s := NewTimelineService(scorer).(*TimelineService)
+	s.now = func() time.Time { return rankNow }
+	return s
+}
+
+func rankedIDs(tweets []models.TweetProps) []string {
+	ids := []string{}
+	for _, t := range tweets {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func TestWeightedScorer_RecencyDecay(t *testing.T) {
+	scorer := NewWeightedScorer()
+
+	fresh := newCandidate("fresh", "a", time.Minute, 10, models.TimelineSourceFollow)
+	old := newCandidate("old", "a", 2*scorer.HalfLife, 10, models.TimelineSourceFollow)
+
+	freshScore := scorer.Score(&fresh, rankNow)
+	oldScore := scorer.Score(&old, rankNow)
+
+	assert.Greater(t, freshScore, oldScore)
+	assert.InDelta(t, freshScore/4, oldScore, 0.1)
+}
+
+func TestWeightedScorer_SourcesAndEngagement(t *testing.T) {
+	scorer := NewWeightedScorer()
+
+	followed := newCandidate("followed", "a", time.Hour, 0, models.TimelineSourceFollow)
+	trending := newCandidate("trending", "b", time.Hour, 0, models.TimelineSourceTrending)
+	popular := newCandidate("popular", "b", time.Hour, 100, models.TimelineSourceTrending)
+
+	assert.Greater(t, scorer.Score(&followed, rankNow), scorer.Score(&trending, rankNow))
+	assert.Greater(t, scorer.Score(&popular, rankNow), scorer.Score(&trending, rankNow))
+}
+
+func TestTimelineService_RankUsesScorer(t *testing.T) {
+	// only engagement counts, so the ranking follows likes
+	scorer := &WeightedScorer{LikeWeight: 1}
+	service := newTestTimelineService(scorer)
+
+	candidates := []models.TimelineCandidate{
+		newCandidate("low", "a", time.Hour, 1),
+		newCandidate("high", "b", time.Hour, 50),
+		newCandidate("mid", "c", time.Hour, 10),
+	}
+
+	assert.Equal(t, []string{"high", "mid", "low"}, rankedIDs(service.Rank(candidates, 10, 0)))
+}
+
+func TestTimelineService_RankDiversifiesAuthors(t *testing.T) {
+	scorer := &WeightedScorer{LikeWeight: 1}
+	service := newTestTimelineService(scorer)
+
+	candidates := []models.TimelineCandidate{
+		newCandidate("a1", "a", time.Hour, 1000),
+		newCandidate("a2", "a", time.Hour, 900),
+		newCandidate("a3", "a", time.Hour, 800),
+		newCandidate("a4", "a", time.Hour, 700),
+		newCandidate("b1", "b", time.Hour, 5),
+		newCandidate("c1", "c", time.Hour, 4),
+	}
+
+	page := service.Rank(candidates, 4, 0)
+	assert.Len(t, page, 4)
+
+	perAuthor := map[string]int{}
+	for _, tweet := range page {
+		perAuthor[tweet.Author.ID]++
+	}
+	assert.Equal(t, 2, perAuthor["a"])
+	assert.Equal(t, "a1", page[0].ID)
+
+	// the capped tweets are not lost, they move to the next page
+	next := service.Rank(candidates, 4, 4)
+	assert.Equal(t, []string{"a3", "a4"}, rankedIDs(next))
+}
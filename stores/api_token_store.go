@@ -0,0 +1,173 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// APITokenStore keeps users' personal access tokens
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, token *models.APIToken) (*models.APIToken, error)
+	// UseAPIToken returns the token if tokenHash is its hash, and records
+	// that it was used
+	UseAPIToken(ctx context.Context, tokenID string, tokenHash string) (*models.APIToken, error)
+	// ListAPITokens returns the user's tokens, newest first
+	ListAPITokens(ctx context.Context, userID string) ([]*models.APIToken, error)
+	DeleteAPIToken(ctx context.Context, userID string, tokenID string) error
+	// DeleteAPITokens deletes all of the user's tokens
+	DeleteAPITokens(ctx context.Context, userID string) error
+}
+
+var ErrAPITokenNotFound = newError(ErrNotFound, "API token not found")
+
+type apiTokenStore struct {
+	driver *neo4j.DriverWithContext
+}
+
+func NewAPITokenStore(driver *neo4j.DriverWithContext) APITokenStore {
+	return &apiTokenStore{
+		driver: driver,
+	}
+}
+
+func (s *apiTokenStore) CreateAPIToken(ctx context.Context, token *models.APIToken) (*models.APIToken, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		CREATE (u)-[:HAS_API_TOKEN]->(t:APIToken {
+			id: $tokenID,
+			name: $name,
+			scopes: $scopes,
+			tokenHash: $tokenHash,
+			createdAt: datetime()
+		})
+		RETURN t, u.id AS userID`,
+		map[string]any{
+			"userID":    token.UserID,
+			"tokenID":   token.ID,
+			"name":      token.Name,
+			"scopes":    token.Scopes,
+			"tokenHash": token.TokenHash,
+		},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return extractAPITokenFromRecord(res.Records[0])
+}
+
+func (s *apiTokenStore) UseAPIToken(ctx context.Context, tokenID string, tokenHash string) (*models.APIToken, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User)-[:HAS_API_TOKEN]->(t:APIToken {id: $tokenID})
+		WHERE t.tokenHash = $tokenHash
+		SET t.lastUsedAt = datetime()
+		RETURN t, u.id AS userID`,
+		map[string]any{"tokenID": tokenID, "tokenHash": tokenHash},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrAPITokenNotFound
+	}
+
+	return extractAPITokenFromRecord(res.Records[0])
+}
+
+func (s *apiTokenStore) ListAPITokens(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})-[:HAS_API_TOKEN]->(t:APIToken)
+		RETURN t, u.id AS userID
+		ORDER BY t.createdAt DESC`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*models.APIToken, 0, len(res.Records))
+	for _, record := range res.Records {
+		token, err := extractAPITokenFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func (s *apiTokenStore) DeleteAPIToken(ctx context.Context, userID string, tokenID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_API_TOKEN]->(t:APIToken {id: $tokenID})
+		DETACH DELETE t
+		RETURN count(*) AS deleted`,
+		map[string]any{"userID": userID, "tokenID": tokenID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := res.Records[0].Get("deleted"); deleted == int64(0) {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+func (s *apiTokenStore) DeleteAPITokens(ctx context.Context, userID string) error {
+	_, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_API_TOKEN]->(t:APIToken)
+		DETACH DELETE t`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	return err
+}
+
+func extractAPITokenFromRecord(record *neo4j.Record) (*models.APIToken, error) {
+	node, ok := record.Get("t")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract API token node")
+	}
+	userID, ok := record.Get("userID")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract API token user")
+	}
+	props := node.(neo4j.Node).Props
+
+	token := &models.APIToken{
+		ID:         props["id"].(string),
+		UserID:     userID.(string),
+		Name:       props["name"].(string),
+		TokenHash:  props["tokenHash"].(string),
+		CreatedAt:  props["createdAt"].(time.Time),
+		LastUsedAt: toTimePtr(props["lastUsedAt"]),
+	}
+	scopes, _ := props["scopes"].([]any)
+	token.Scopes = make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		token.Scopes = append(token.Scopes, scope.(string))
+	}
+	return token, nil
+}
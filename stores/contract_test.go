@@ -0,0 +1,57 @@
+package stores_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aimrintech/x-backend/migrations"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/aimrintech/x-backend/stores/storetest"
+	"github.com/joho/godotenv"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// TestContract runs the store contract suite against Neo4j. Each test starts
+// from an empty, migrated database.
+func TestContract(t *testing.T) {
+	_ = godotenv.Load("../.env")
+	dbUri := os.Getenv("NEO4J_URI")
+	if dbUri == "" {
+		t.Skip("NEO4J_URI is not set")
+	}
+
+	ctx := context.Background()
+	driver, err := neo4j.NewDriverWithContext(dbUri, neo4j.BasicAuth(os.Getenv("NEO4J_USERNAME"), os.Getenv("NEO4J_PASSWORD"), ""))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	defer driver.Close(ctx)
+	if err := driver.VerifyConnectivity(ctx); err != nil {
+		t.Skipf("Neo4j is not reachable: %v", err)
+	}
+
+	storetest.Run(t, func(t *testing.T) storetest.Stores {
+		if _, err := neo4j.ExecuteQuery(ctx, driver, "MATCH (n) DETACH DELETE n", nil, neo4j.EagerResultTransformer); err != nil {
+			t.Fatalf("Failed to wipe database: %v", err)
+		}
+		if _, err := migrations.Run(ctx, driver); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		notificationsService := services.NewNotificationsService()
+		feedService := services.NewFeedService()
+		t.Cleanup(feedService.Close)
+
+		return storetest.Stores{
+			Users:         stores.NewUserStore(&driver, notificationsService),
+			Tweets:        stores.NewTweetStore(&driver, notificationsService, feedService),
+			Notifications: stores.NewNotificationsStore(&driver),
+			Sessions:      stores.NewSessionStore(&driver),
+			Identities:    stores.NewIdentityStore(&driver),
+			TwoFactor:     stores.NewTwoFactorStore(&driver),
+			APITokens:     stores.NewAPITokenStore(&driver),
+		}
+	})
+}
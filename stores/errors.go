@@ -0,0 +1,83 @@
+package stores
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Kinds of errors returned by stores. Every error a store returns on purpose
+// wraps one of these, so callers can tell them apart with errors.Is without
+// knowing the exact error.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrForbidden   = errors.New("forbidden")
+	ErrValidation  = errors.New("validation failed")
+	ErrRateLimited = errors.New("rate limited")
+)
+
+var (
+	ErrUserNotFound          = newError(ErrNotFound, "user not found")
+	ErrTweetNotFound         = newError(ErrNotFound, "tweet not found")
+	ErrNotificationNotFound  = newError(ErrNotFound, "notification not found")
+	ErrFollowRequestNotFound = newError(ErrNotFound, "follow request not found")
+	ErrSessionNotFound       = newError(ErrNotFound, "session not found")
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// rotated is presented again. The session has been revoked.
+var ErrRefreshTokenReused = newError(ErrForbidden, "refresh token reused")
+
+// domainError is an error of one of the kinds above with its own message
+type domainError struct {
+	kind    error
+	message string
+}
+
+func newError(kind error, message string) error {
+	return &domainError{kind: kind, message: message}
+}
+
+func (e *domainError) Error() string {
+	return e.message
+}
+
+func (e *domainError) Unwrap() error {
+	return e.kind
+}
+
+// ValidationError reports invalid input, with a message per offending field
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func NewValidationError(field string, message string) *ValidationError {
+	return &ValidationError{Fields: map[string]string{field: message}}
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field, message := range e.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", field, message))
+	}
+	sort.Strings(fields)
+	return ErrValidation.Error() + ": " + strings.Join(fields, ", ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// conflictOnConstraint turns a uniqueness constraint violation into err,
+// and passes any other error through
+func conflictOnConstraint(dbErr error, err error) error {
+	var neo4jErr *neo4j.Neo4jError
+	if errors.As(dbErr, &neo4jErr) && neo4jErr.Code == "Neo.ClientError.Schema.ConstraintValidationFailed" {
+		return err
+	}
+	return dbErr
+}
@@ -8,18 +8,17 @@ import (
 )
 
 type FeedStore interface {
-	GetFeed(userID string) ([]*models.Tweet, error)
+	GetFeed(ctx context.Context, userID string) ([]*models.Tweet, error)
 }
 
 type feedStore struct {
-	db    *neo4j.DriverWithContext
-	dbCtx *context.Context
+	db *neo4j.DriverWithContext
 }
 
-func NewFeedStore(db *neo4j.DriverWithContext, dbCtx *context.Context) *feedStore {
-	return &feedStore{db: db, dbCtx: dbCtx}
+func NewFeedStore(db *neo4j.DriverWithContext) *feedStore {
+	return &feedStore{db: db}
 }
 
-func (s *feedStore) GetFeed(userID string) ([]*models.Tweet, error) {
+func (s *feedStore) GetFeed(ctx context.Context, userID string) ([]*models.Tweet, error) {
 	return nil, nil
 }
@@ -1,9 +1,13 @@
 package stores
 
 import (
+	"encoding/base64"
+	"strings"
 	"time"
 )
 
+var ErrInvalidCursor error = NewValidationError("cursor", "invalid cursor")
+
 func toStringPtr(val any) *string {
 	if val == nil {
 		return nil
@@ -23,3 +27,46 @@ func toTimePtr(val any) *time.Time {
 	}
 	return nil
 }
+
+func toStringSet(val any) map[string]bool {
+	set := map[string]bool{}
+	if list, ok := val.([]any); ok {
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				set[s] = true
+			}
+		}
+	}
+	return set
+}
+
+// EncodeCursor builds an opaque pagination cursor from the sort key and ID
+// of the last item on a page
+func EncodeCursor(sortKey time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortKey.Format(time.RFC3339Nano) + "|" + id))
+}
+
+// DecodeCursor is the inverse of EncodeCursor. An empty cursor decodes to a
+// nil time, meaning the first page.
+func DecodeCursor(cursor string) (*time.Time, string, error) {
+	if cursor == "" {
+		return nil, "", nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	sortKey, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, "", ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, sortKey)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	return &t, id, nil
+}
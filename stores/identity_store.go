@@ -0,0 +1,162 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// IdentityStore links users to their accounts at OAuth providers
+type IdentityStore interface {
+	GetIdentity(ctx context.Context, provider string, subject string) (*models.AuthIdentity, error)
+	// LinkIdentity links the provider account to the user. Linking it again
+	// to the same user does nothing; linking it to another user fails with
+	// ErrIdentityLinked.
+	LinkIdentity(ctx context.Context, identity *models.AuthIdentity) (*models.AuthIdentity, error)
+	ListIdentities(ctx context.Context, userID string) ([]*models.AuthIdentity, error)
+}
+
+var (
+	ErrIdentityNotFound = newError(ErrNotFound, "identity not found")
+	ErrIdentityLinked   = newError(ErrConflict, "identity is linked to another account")
+)
+
+type identityStore struct {
+	driver *neo4j.DriverWithContext
+}
+
+func NewIdentityStore(driver *neo4j.DriverWithContext) IdentityStore {
+	return &identityStore{
+		driver: driver,
+	}
+}
+
+func identityKey(provider string, subject string) string {
+	return provider + ":" + subject
+}
+
+func (s *identityStore) GetIdentity(ctx context.Context, provider string, subject string) (*models.AuthIdentity, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User)-[:HAS_IDENTITY]->(i:AuthIdentity {key: $key})
+		RETURN i, u.id AS userID`,
+		map[string]any{"key": identityKey(provider, subject)},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrIdentityNotFound
+	}
+
+	return extractIdentityFromRecord(res.Records[0])
+}
+
+func (s *identityStore) LinkIdentity(ctx context.Context, identity *models.AuthIdentity) (*models.AuthIdentity, error) {
+	key := identityKey(identity.Provider, identity.Subject)
+	linked, err := executeWrite(ctx, *s.driver, func(tx neo4j.ManagedTransaction) (*models.AuthIdentity, error) {
+		res, err := runInTx(
+			ctx,
+			tx,
+			`MATCH (u:User {id: $userID})
+			OPTIONAL MATCH (owner:User)-[:HAS_IDENTITY]->(i:AuthIdentity {key: $key})
+			RETURN i, owner.id AS userID`,
+			map[string]any{"userID": identity.UserID, "key": key},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Records) == 0 {
+			return nil, ErrUserNotFound
+		}
+		if ownerID, _ := res.Records[0].Get("userID"); ownerID != nil {
+			if ownerID != identity.UserID {
+				return nil, ErrIdentityLinked
+			}
+			return extractIdentityFromRecord(res.Records[0])
+		}
+
+		res, err = runInTx(
+			ctx,
+			tx,
+			`MATCH (u:User {id: $userID})
+			CREATE (u)-[:HAS_IDENTITY]->(i:AuthIdentity {
+				key: $key,
+				provider: $provider,
+				subject: $subject,
+				email: $email,
+				createdAt: datetime()
+			})
+			RETURN i, u.id AS userID`,
+			map[string]any{
+				"userID":   identity.UserID,
+				"key":      key,
+				"provider": identity.Provider,
+				"subject":  identity.Subject,
+				"email":    identity.Email,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return extractIdentityFromRecord(res.Records[0])
+	})
+	if err != nil {
+		// another user linked the identity concurrently
+		return nil, conflictOnConstraint(err, ErrIdentityLinked)
+	}
+
+	return linked, nil
+}
+
+func (s *identityStore) ListIdentities(ctx context.Context, userID string) ([]*models.AuthIdentity, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})-[:HAS_IDENTITY]->(i:AuthIdentity)
+		RETURN i, u.id AS userID
+		ORDER BY i.createdAt`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]*models.AuthIdentity, 0, len(res.Records))
+	for _, record := range res.Records {
+		identity, err := extractIdentityFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, nil
+}
+
+func extractIdentityFromRecord(record *neo4j.Record) (*models.AuthIdentity, error) {
+	node, ok := record.Get("i")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract identity node")
+	}
+	userID, ok := record.Get("userID")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract identity user")
+	}
+	props := node.(neo4j.Node).Props
+
+	identity := &models.AuthIdentity{
+		Provider:  props["provider"].(string),
+		Subject:   props["subject"].(string),
+		UserID:    userID.(string),
+		CreatedAt: props["createdAt"].(time.Time),
+	}
+	identity.Email, _ = props["email"].(string)
+	return identity, nil
+}
@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+)
+
+type apiTokenStore struct {
+	db *DB
+}
+
+func NewAPITokenStore(db *DB) stores.APITokenStore {
+	return &apiTokenStore{
+		db: db,
+	}
+}
+
+func (s *apiTokenStore) CreateAPIToken(ctx context.Context, token *models.APIToken) (*models.APIToken, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[token.UserID]; !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	created := *token
+	created.Scopes = append([]string{}, token.Scopes...)
+	created.CreatedAt = s.db.now()
+	created.LastUsedAt = nil
+	s.db.apiTokens[created.ID] = &created
+	return copyAPIToken(&created), nil
+}
+
+func (s *apiTokenStore) UseAPIToken(ctx context.Context, tokenID string, tokenHash string) (*models.APIToken, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	token, ok := s.db.apiTokens[tokenID]
+	if !ok || token.TokenHash != tokenHash {
+		return nil, stores.ErrAPITokenNotFound
+	}
+	now := s.db.now()
+	token.LastUsedAt = &now
+	return copyAPIToken(token), nil
+}
+
+func (s *apiTokenStore) ListAPITokens(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	tokens := []*models.APIToken{}
+	for _, token := range s.db.apiTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, copyAPIToken(token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (s *apiTokenStore) DeleteAPIToken(ctx context.Context, userID string, tokenID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	token, ok := s.db.apiTokens[tokenID]
+	if !ok || token.UserID != userID {
+		return stores.ErrAPITokenNotFound
+	}
+	delete(s.db.apiTokens, tokenID)
+	return nil
+}
+
+func (s *apiTokenStore) DeleteAPITokens(ctx context.Context, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for id, token := range s.db.apiTokens {
+		if token.UserID == userID {
+			delete(s.db.apiTokens, id)
+		}
+	}
+	return nil
+}
+
+func copyAPIToken(token *models.APIToken) *models.APIToken {
+	c := *token
+	c.Scopes = append([]string{}, token.Scopes...)
+	if token.LastUsedAt != nil {
+		lastUsedAt := *token.LastUsedAt
+		c.LastUsedAt = &lastUsedAt
+	}
+	return &c
+}
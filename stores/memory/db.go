@@ -0,0 +1,202 @@
+// Package memory implements the store interfaces on plain Go maps. It follows
+// the semantics of the Neo4j stores (counters, idempotency, visibility) so
+// handlers and services can be tested without a database.
+package memory
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+)
+
+// edge is a relationship between two nodes, such as a follow or a like
+type edge struct {
+	from string
+	to   string
+}
+
+type usernameChange struct {
+	userID    string
+	username  string
+	changedAt time.Time
+	expiresAt time.Time
+}
+
+type mutedWord struct {
+	userID string
+	word   models.MutedWord
+}
+
+// DB holds the data shared by the in-memory stores. Stores created from the
+// same DB see each other's writes, like stores sharing a Neo4j driver.
+type DB struct {
+	mu sync.RWMutex
+
+	users           map[string]*models.User
+	usernameChanges []usernameChange
+
+	follows            map[edge]time.Time
+	followRequests     map[edge]time.Time
+	blocks             map[edge]time.Time
+	mutes              map[edge]time.Time
+	mutedWords         map[string]*mutedWord
+	mutedConversations map[edge]time.Time
+
+	tweets    map[string]*models.Tweet
+	authors   map[string]string
+	likes     map[edge]time.Time
+	retweets  map[edge]time.Time
+	bookmarks map[edge]time.Time
+	pins      map[string]string
+
+	notifications map[string]*models.Notification
+
+	sessions   map[string]*models.Session
+	identities map[string]*models.AuthIdentity
+	twoFactors map[string]*models.TwoFactor
+	apiTokens  map[string]*models.APIToken
+
+	clock time.Time
+}
+
+func NewDB() *DB {
+	return &DB{
+		users:              make(map[string]*models.User),
+		follows:            make(map[edge]time.Time),
+		followRequests:     make(map[edge]time.Time),
+		blocks:             make(map[edge]time.Time),
+		mutes:              make(map[edge]time.Time),
+		mutedWords:         make(map[string]*mutedWord),
+		mutedConversations: make(map[edge]time.Time),
+		tweets:             make(map[string]*models.Tweet),
+		authors:            make(map[string]string),
+		likes:              make(map[edge]time.Time),
+		retweets:           make(map[edge]time.Time),
+		bookmarks:          make(map[edge]time.Time),
+		pins:               make(map[string]string),
+		notifications:      make(map[string]*models.Notification),
+		sessions:           make(map[string]*models.Session),
+		identities:         make(map[string]*models.AuthIdentity),
+		twoFactors:         make(map[string]*models.TwoFactor),
+		apiTokens:          make(map[string]*models.APIToken),
+	}
+}
+
+// now returns the current time, strictly later than any time it returned
+// before so that ordering by creation time is deterministic. Callers must
+// hold the write lock.
+func (db *DB) now() time.Time {
+	now := time.Now().UTC()
+	if !now.After(db.clock) {
+		now = db.clock.Add(time.Nanosecond)
+	}
+	db.clock = now
+	return now
+}
+
+// blockedEither reports whether either user blocks the other
+func (db *DB) blockedEither(a, b string) bool {
+	_, ab := db.blocks[edge{a, b}]
+	_, ba := db.blocks[edge{b, a}]
+	return ab || ba
+}
+
+func (db *DB) isFollowing(followerID, userID string) bool {
+	_, ok := db.follows[edge{followerID, userID}]
+	return ok
+}
+
+// notProtected mirrors notProtectedPredicate: locked accounts are visible to
+// themselves and their approved followers only
+func (db *DB) notProtected(author *models.User, viewerID string) bool {
+	return !author.IsLocked || author.ID == viewerID || db.isFollowing(viewerID, author.ID)
+}
+
+// notMuted mirrors notMutedPredicate
+func (db *DB) notMuted(authorID string, tweet *models.Tweet, viewerID string) bool {
+	if _, ok := db.mutes[edge{viewerID, authorID}]; ok {
+		return false
+	}
+
+	content := ""
+	if tweet.Content != nil {
+		content = strings.ToLower(*tweet.Content)
+	}
+	for _, phrase := range db.activeMutedWords(viewerID) {
+		if strings.Contains(content, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func (db *DB) activeMutedWords(userID string) []string {
+	now := time.Now()
+	phrases := []string{}
+	for _, w := range db.mutedWords {
+		if w.userID == userID && (w.word.ExpiresAt == nil || w.word.ExpiresAt.After(now)) {
+			phrases = append(phrases, w.word.Phrase)
+		}
+	}
+	return phrases
+}
+
+// visible reports whether viewerID may see the tweet at all
+func (db *DB) visible(tweetID string, viewerID string) bool {
+	author := db.users[db.authors[tweetID]]
+	if author == nil {
+		return false
+	}
+	return !db.blockedEither(viewerID, author.ID) && db.notProtected(author, viewerID)
+}
+
+// tweetProps converts a tweet to its API representation as seen by viewerID
+func (db *DB) tweetProps(tweetID string, viewerID string) *models.TweetProps {
+	tweet := db.tweets[tweetID]
+	_, isLiked := db.likes[edge{viewerID, tweetID}]
+	_, isRetweeted := db.retweets[edge{viewerID, tweetID}]
+	_, isBookmarked := db.bookmarks[edge{viewerID, tweetID}]
+
+	return models.NewTweetProps(copyTweet(tweet), copyUser(db.users[db.authors[tweetID]]), isLiked, isRetweeted, isBookmarked)
+}
+
+func (db *DB) userByUsername(username string) *models.User {
+	for _, user := range db.users {
+		if strings.EqualFold(user.Username, username) {
+			return user
+		}
+	}
+	return nil
+}
+
+func copyUser(user *models.User) *models.User {
+	c := *user
+	return &c
+}
+
+func copyTweet(tweet *models.Tweet) *models.Tweet {
+	c := *tweet
+	if tweet.MediaURLs != nil {
+		mediaURLs := append([]string{}, *tweet.MediaURLs...)
+		c.MediaURLs = &mediaURLs
+	}
+	if tweet.Hashtags != nil {
+		hashtags := append([]string{}, *tweet.Hashtags...)
+		c.Hashtags = &hashtags
+	}
+	return &c
+}
+
+// paginate applies offset and limit to a sorted slice
+func paginate[T any](items []T, limit int, offset int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+)
+
+type identityStore struct {
+	db *DB
+}
+
+func NewIdentityStore(db *DB) stores.IdentityStore {
+	return &identityStore{
+		db: db,
+	}
+}
+
+// identities are keyed by provider and subject, like the Neo4j constraint
+func identityKey(provider string, subject string) string {
+	return provider + ":" + subject
+}
+
+func (s *identityStore) GetIdentity(ctx context.Context, provider string, subject string) (*models.AuthIdentity, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	identity, ok := s.db.identities[identityKey(provider, subject)]
+	if !ok {
+		return nil, stores.ErrIdentityNotFound
+	}
+	c := *identity
+	return &c, nil
+}
+
+func (s *identityStore) LinkIdentity(ctx context.Context, identity *models.AuthIdentity) (*models.AuthIdentity, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[identity.UserID]; !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	key := identityKey(identity.Provider, identity.Subject)
+	if existing, ok := s.db.identities[key]; ok {
+		if existing.UserID != identity.UserID {
+			return nil, stores.ErrIdentityLinked
+		}
+		c := *existing
+		return &c, nil
+	}
+
+	linked := *identity
+	linked.CreatedAt = s.db.now()
+	s.db.identities[key] = &linked
+	c := linked
+	return &c, nil
+}
+
+func (s *identityStore) ListIdentities(ctx context.Context, userID string) ([]*models.AuthIdentity, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	identities := []*models.AuthIdentity{}
+	for _, identity := range s.db.identities {
+		if identity.UserID == userID {
+			c := *identity
+			identities = append(identities, &c)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].CreatedAt.Before(identities[j].CreatedAt) })
+	return identities, nil
+}
@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores/storetest"
+)
+
+func TestContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Stores {
+		db := NewDB()
+		notificationsService := services.NewNotificationsService()
+		feedService := services.NewFeedService()
+		t.Cleanup(feedService.Close)
+
+		return storetest.Stores{
+			Users:         NewUserStore(db, notificationsService),
+			Tweets:        NewTweetStore(db, notificationsService, feedService),
+			Notifications: NewNotificationsStore(db),
+			Sessions:      NewSessionStore(db),
+			Identities:    NewIdentityStore(db),
+			TwoFactor:     NewTwoFactorStore(db),
+			APITokens:     NewAPITokenStore(db),
+		}
+	})
+}
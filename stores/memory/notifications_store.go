@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+)
+
+type notificationsStore struct {
+	db *DB
+}
+
+func NewNotificationsStore(db *DB) stores.NotificationsStore {
+	return &notificationsStore{
+		db: db,
+	}
+}
+
+// GetNotifications hides notifications from blocked and muted users, and
+// replies and likes in muted conversations, like the Neo4j store
+func (s *notificationsStore) GetNotifications(ctx context.Context, userID string, limit int, offset int) ([]*models.Notification, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	matches := []*models.Notification{}
+	for _, n := range s.db.notifications {
+		if n.TargetUserID != userID || s.db.blockedEither(userID, n.AuthorUserID) {
+			continue
+		}
+		if _, ok := s.db.mutes[edge{userID, n.AuthorUserID}]; ok {
+			continue
+		}
+		if (n.Type == models.NotificationTypeReply || n.Type == models.NotificationTypeLike) && n.TargetTweetID != nil {
+			if tweet, ok := s.db.tweets[*n.TargetTweetID]; ok {
+				if _, ok := s.db.mutedConversations[edge{userID, s.db.conversationRoot(tweet)}]; ok {
+					continue
+				}
+			}
+		}
+		matches = append(matches, n)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].CreatedAt.After(matches[j].CreatedAt) })
+
+	notifications := make([]*models.Notification, 0, len(matches))
+	for _, n := range paginate(matches, limit, offset) {
+		c := *n
+		notifications = append(notifications, &c)
+	}
+	return notifications, nil
+}
+
+// CreateNotification stores a notification. Like the Neo4j store it does
+// nothing when the author or target doesn't exist, and drops the tweet
+// reference when the tweet doesn't.
+func (s *notificationsStore) CreateNotification(ctx context.Context, notification *models.Notification) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	_, okAuthor := s.db.users[notification.AuthorUserID]
+	_, okTarget := s.db.users[notification.TargetUserID]
+	if !okAuthor || !okTarget {
+		return nil
+	}
+
+	created := &models.Notification{
+		ID:           notification.ID,
+		TargetUserID: notification.TargetUserID,
+		AuthorUserID: notification.AuthorUserID,
+		Type:         notification.Type,
+		CreatedAt:    s.db.now(),
+	}
+	if notification.TargetTweetID != nil {
+		if _, ok := s.db.tweets[*notification.TargetTweetID]; ok {
+			tweetID := *notification.TargetTweetID
+			created.TargetTweetID = &tweetID
+		}
+	}
+	s.db.notifications[created.ID] = created
+
+	return nil
+}
+
+func (s *notificationsStore) FlagAsRead(ctx context.Context, notificationID string, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	n, ok := s.db.notifications[notificationID]
+	if !ok || n.TargetUserID != userID {
+		return stores.ErrNotificationNotFound
+	}
+	n.IsRead = true
+	return nil
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+)
+
+type sessionStore struct {
+	db *DB
+}
+
+func NewSessionStore(db *DB) stores.SessionStore {
+	return &sessionStore{
+		db: db,
+	}
+}
+
+func (s *sessionStore) CreateSession(ctx context.Context, session *models.Session) (*models.Session, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[session.UserID]; !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	now := s.db.now()
+	created := *session
+	created.SpentTokenHashes = nil
+	created.CreatedAt = now
+	created.LastSeenAt = now
+	created.RevokedAt = nil
+	s.db.sessions[created.ID] = &created
+	return copySession(&created), nil
+}
+
+func (s *sessionStore) GetSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	session, ok := s.db.sessions[sessionID]
+	if !ok {
+		return nil, stores.ErrSessionNotFound
+	}
+	return copySession(session), nil
+}
+
+func (s *sessionStore) RotateSession(ctx context.Context, sessionID string, tokenHash string, newTokenHash string, ip string) (*models.Session, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := s.db.now()
+	session, ok := s.db.sessions[sessionID]
+	if !ok || !session.IsActive(now) {
+		return nil, stores.ErrSessionNotFound
+	}
+
+	if tokenHash == session.TokenHash {
+		spent := append([]string{session.TokenHash}, session.SpentTokenHashes...)
+		if len(spent) > constants.SPENT_REFRESH_TOKENS {
+			spent = spent[:constants.SPENT_REFRESH_TOKENS]
+		}
+		session.SpentTokenHashes = spent
+		session.TokenHash = newTokenHash
+		session.LastSeenAt = now
+		session.IP = ip
+		return copySession(session), nil
+	}
+	if slices.Contains(session.SpentTokenHashes, tokenHash) {
+		session.RevokedAt = &now
+		return nil, stores.ErrRefreshTokenReused
+	}
+	return nil, stores.ErrSessionNotFound
+}
+
+func (s *sessionStore) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	session, ok := s.db.sessions[sessionID]
+	if !ok || session.UserID != userID {
+		return stores.ErrSessionNotFound
+	}
+	if session.RevokedAt == nil {
+		now := s.db.now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (s *sessionStore) ListSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	sessions := []*models.Session{}
+	for _, session := range s.db.sessions {
+		if session.UserID == userID && session.IsActive(now) {
+			sessions = append(sessions, copySession(session))
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (s *sessionStore) RevokeSessions(ctx context.Context, userID string, exceptSessionID string) ([]string, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := s.db.now()
+	ids := []string{}
+	for _, session := range s.db.sessions {
+		if session.UserID != userID || session.ID == exceptSessionID || !session.IsActive(now) {
+			continue
+		}
+		revokedAt := now
+		session.RevokedAt = &revokedAt
+		ids = append(ids, session.ID)
+	}
+	return ids, nil
+}
+
+func copySession(session *models.Session) *models.Session {
+	c := *session
+	c.SpentTokenHashes = slices.Clone(session.SpentTokenHashes)
+	if session.RevokedAt != nil {
+		revokedAt := *session.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+	return &c
+}
@@ -0,0 +1,610 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/google/uuid"
+)
+
+// how many hashtags count as trending for timeline candidates
+const trendingLimit = 10
+
+var hashtagPattern = regexp.MustCompile(`#(\w+)`)
+
+type tweetStore struct {
+	db                   *DB
+	notificationsService services.Notifications
+	feedService          services.Feed
+}
+
+func NewTweetStore(db *DB, notificationsService services.Notifications, feedService services.Feed) stores.TweetStore {
+	return &tweetStore{
+		db:                   db,
+		notificationsService: notificationsService,
+		feedService:          feedService,
+	}
+}
+
+func (s *tweetStore) publish(notification *models.Notification) {
+	if s.notificationsService == nil {
+		return
+	}
+	s.notificationsService.Publish(notification.Type, notification)
+}
+
+func (s *tweetStore) publishFeedEvent(eventType models.FeedEventType, tweetProps *models.TweetProps, userID string, createdAt time.Time) {
+	if s.feedService == nil {
+		return
+	}
+	s.feedService.PublishToAll(&models.FeedEvent{
+		Type:      eventType,
+		Tweet:     *tweetProps,
+		ActorID:   userID,
+		CreatedAt: createdAt,
+	})
+}
+
+// sortedTweetIDs returns the IDs of the tweets matching keep, newest first
+func (db *DB) sortedTweetIDs(keep func(tweet *models.Tweet) bool, ascending bool) []string {
+	ids := []string{}
+	for id, tweet := range db.tweets {
+		if keep(tweet) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := db.tweets[ids[i]].CreatedAt, db.tweets[ids[j]].CreatedAt
+		if ascending {
+			return a.Before(b)
+		}
+		return a.After(b)
+	})
+	return ids
+}
+
+func (s *tweetStore) GetTweets(ctx context.Context, limit int, offset int) ([]*models.Tweet, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	ids := s.db.sortedTweetIDs(func(*models.Tweet) bool { return true }, false)
+	tweets := make([]*models.Tweet, 0, limit)
+	for _, id := range paginate(ids, limit, offset) {
+		tweets = append(tweets, copyTweet(s.db.tweets[id]))
+	}
+	return tweets, nil
+}
+
+func (s *tweetStore) GetTweetByID(ctx context.Context, id string, currUserID string) (*models.Tweet, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	tweet, ok := s.db.tweets[id]
+	if !ok || !s.db.visible(id, currUserID) {
+		return nil, stores.ErrTweetNotFound
+	}
+	return copyTweet(tweet), nil
+}
+
+// createTweet adds a tweet by userID. Callers must hold the write lock.
+func (db *DB) createTweet(tweet *models.Tweet, userID string) (*models.Tweet, error) {
+	if tweet.Content == nil {
+		return nil, stores.NewValidationError("content", "content is required")
+	}
+	author, ok := db.users[userID]
+	if !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	content := *tweet.Content
+	hashtags := hashtagPattern.FindAllString(content, -1)
+	var mediaURLs []string
+	if tweet.MediaURLs != nil {
+		mediaURLs = append(mediaURLs, *tweet.MediaURLs...)
+	}
+
+	now := db.now()
+	created := &models.Tweet{
+		ID:        uuid.New().String(),
+		Content:   &content,
+		CreatedAt: now,
+		UpdatedAt: now,
+		MediaURLs: &mediaURLs,
+		Hashtags:  &hashtags,
+	}
+	db.tweets[created.ID] = created
+	db.authors[created.ID] = userID
+	author.TweetsCount++
+
+	return created, nil
+}
+
+func (s *tweetStore) CreateTweet(ctx context.Context, tweet *models.Tweet, userID string) (*models.TweetProps, error) {
+	s.db.mu.Lock()
+	created, err := s.db.createTweet(tweet, userID)
+	if err != nil {
+		s.db.mu.Unlock()
+		return nil, err
+	}
+	tweetProps := s.db.tweetProps(created.ID, "")
+	s.db.mu.Unlock()
+
+	s.publishFeedEvent(models.FeedEventCreated, tweetProps, userID, created.CreatedAt)
+	return tweetProps, nil
+}
+
+func (s *tweetStore) UpdateTweet(ctx context.Context, tweet *models.Tweet, userID string) (*models.TweetProps, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	existing, ok := s.db.tweets[tweet.ID]
+	if !ok || s.db.authors[tweet.ID] != userID {
+		return nil, stores.ErrTweetNotFound
+	}
+
+	existing.Content = tweet.Content
+	existing.UpdatedAt = s.db.now()
+
+	return s.db.tweetProps(existing.ID, ""), nil
+}
+
+func (s *tweetStore) DeleteTweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.tweets[tweetID]; !ok || s.db.authors[tweetID] != userID {
+		return stores.ErrTweetNotFound
+	}
+
+	delete(s.db.tweets, tweetID)
+	delete(s.db.authors, tweetID)
+	s.db.users[userID].TweetsCount--
+
+	for _, edges := range []map[edge]time.Time{s.db.likes, s.db.retweets, s.db.bookmarks, s.db.mutedConversations} {
+		for e := range edges {
+			if e.to == tweetID {
+				delete(edges, e)
+			}
+		}
+	}
+	if s.db.pins[userID] == tweetID {
+		delete(s.db.pins, userID)
+	}
+
+	return nil
+}
+
+// tweetAuthor mirrors tweetAuthorQuery: it returns the author of a tweet the
+// user may see, or ErrBlocked when they block each other
+func (db *DB) tweetAuthor(tweetID string, userID string) (string, error) {
+	_, okUser := db.users[userID]
+	_, okTweet := db.tweets[tweetID]
+	author, okAuthor := db.users[db.authors[tweetID]]
+	if !okUser || !okTweet || !okAuthor || !db.notProtected(author, userID) {
+		return "", stores.ErrTweetNotFound
+	}
+	if db.blockedEither(userID, author.ID) {
+		return "", stores.ErrBlocked
+	}
+	return author.ID, nil
+}
+
+// engage creates an engagement edge from the user to the tweet, counting it
+// only when it is new, and returns the tweet author
+func (s *tweetStore) engage(edges map[edge]time.Time, tweetID string, userID string, count func(tweet *models.Tweet)) (string, error) {
+	authorID, err := s.db.tweetAuthor(tweetID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := edges[edge{userID, tweetID}]; !ok {
+		edges[edge{userID, tweetID}] = s.db.now()
+		if count != nil {
+			count(s.db.tweets[tweetID])
+		}
+	}
+	return authorID, nil
+}
+
+// disengage removes an engagement edge, uncounting it if it existed
+func (s *tweetStore) disengage(edges map[edge]time.Time, tweetID string, userID string, uncount func(tweet *models.Tweet)) {
+	if _, ok := edges[edge{userID, tweetID}]; !ok {
+		return
+	}
+	delete(edges, edge{userID, tweetID})
+	if uncount != nil {
+		uncount(s.db.tweets[tweetID])
+	}
+}
+
+func (s *tweetStore) LikeTweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	authorID, err := s.engage(s.db.likes, tweetID, userID, func(t *models.Tweet) { t.LikesCount++ })
+	if err != nil {
+		s.db.mu.Unlock()
+		return err
+	}
+	tweetProps := s.db.tweetProps(tweetID, userID)
+	conversationID := s.db.conversationRoot(s.db.tweets[tweetID])
+	s.db.mu.Unlock()
+
+	notification := models.NewNotification(authorID, userID, &tweetID, models.NotificationTypeLike)
+	notification.ConversationID = &conversationID
+	s.publish(notification)
+	s.publishFeedEvent(models.FeedEventLiked, tweetProps, userID, time.Now())
+	return nil
+}
+
+func (s *tweetStore) UnlikeTweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.disengage(s.db.likes, tweetID, userID, func(t *models.Tweet) { t.LikesCount-- })
+	return nil
+}
+
+func (s *tweetStore) Retweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	authorID, err := s.engage(s.db.retweets, tweetID, userID, func(t *models.Tweet) { t.RetweetsCount++ })
+	if err != nil {
+		s.db.mu.Unlock()
+		return err
+	}
+	tweetProps := s.db.tweetProps(tweetID, userID)
+	s.db.mu.Unlock()
+
+	s.publish(models.NewNotification(authorID, userID, &tweetID, models.NotificationTypeRetweet))
+	s.publishFeedEvent(models.FeedEventRetweeted, tweetProps, userID, time.Now())
+	return nil
+}
+
+func (s *tweetStore) Unretweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.disengage(s.db.retweets, tweetID, userID, func(t *models.Tweet) { t.RetweetsCount-- })
+	return nil
+}
+
+func (s *tweetStore) QuoteTweet(ctx context.Context, originalTweetID string, userID string, quotedTweet *models.Tweet) (*models.TweetProps, error) {
+	s.db.mu.Lock()
+	authorID, err := s.db.tweetAuthor(originalTweetID, userID)
+	if err != nil {
+		s.db.mu.Unlock()
+		return nil, err
+	}
+	created, err := s.db.createTweet(quotedTweet, userID)
+	if err != nil {
+		s.db.mu.Unlock()
+		return nil, err
+	}
+	s.db.tweets[originalTweetID].QuotesCount++
+	tweetProps := s.db.tweetProps(created.ID, "")
+	s.db.mu.Unlock()
+
+	s.publishFeedEvent(models.FeedEventCreated, tweetProps, userID, created.CreatedAt)
+	s.publish(models.NewNotification(authorID, userID, &originalTweetID, models.NotificationTypeRetweet))
+	return tweetProps, nil
+}
+
+func (s *tweetStore) BookmarkTweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	_, err := s.engage(s.db.bookmarks, tweetID, userID, nil)
+	return err
+}
+
+func (s *tweetStore) UnbookmarkTweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.disengage(s.db.bookmarks, tweetID, userID, nil)
+	return nil
+}
+
+// listable reports whether a tweet may appear in a list shown to the viewer,
+// applying blocks, locked accounts and mutes
+func (db *DB) listable(tweet *models.Tweet, viewerID string) bool {
+	return db.visible(tweet.ID, viewerID) && db.notMuted(db.authors[tweet.ID], tweet, viewerID)
+}
+
+func (db *DB) tweetPropsList(ids []string, viewerID string) []models.TweetProps {
+	result := make([]models.TweetProps, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, *db.tweetProps(id, viewerID))
+	}
+	return result
+}
+
+func (s *tweetStore) GetUsersWithTweets(ctx context.Context, currUserID string, limit int, offset int) ([]models.TweetProps, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	ids := s.db.sortedTweetIDs(func(t *models.Tweet) bool { return s.db.listable(t, currUserID) }, false)
+	return s.db.tweetPropsList(paginate(ids, limit, offset), currUserID), nil
+}
+
+func (s *tweetStore) ReplyToTweet(ctx context.Context, tweetID string, userID string, reply *models.Tweet) (*models.TweetProps, error) {
+	s.db.mu.Lock()
+	authorID, err := s.db.tweetAuthor(tweetID, userID)
+	if err != nil {
+		s.db.mu.Unlock()
+		return nil, err
+	}
+	created, err := s.db.createTweet(reply, userID)
+	if err != nil {
+		s.db.mu.Unlock()
+		return nil, err
+	}
+
+	parent := s.db.tweets[tweetID]
+	conversationID := s.db.conversationRoot(parent)
+	created.InReplyToID = &tweetID
+	created.ConversationID = &conversationID
+	parent.RepliesCount++
+
+	_, mutedConversation := s.db.mutedConversations[edge{authorID, conversationID}]
+	_, mutedUser := s.db.mutes[edge{authorID, userID}]
+	tweetProps := s.db.tweetProps(created.ID, "")
+	s.db.mu.Unlock()
+
+	s.publishFeedEvent(models.FeedEventCreated, tweetProps, userID, created.CreatedAt)
+	if authorID != userID && !mutedConversation && !mutedUser {
+		notification := models.NewNotification(authorID, userID, &tweetProps.ID, models.NotificationTypeReply)
+		notification.ConversationID = &conversationID
+		s.publish(notification)
+	}
+
+	return tweetProps, nil
+}
+
+func (s *tweetStore) GetReplies(ctx context.Context, tweetID string, currUserID string, limit int, offset int) ([]models.TweetProps, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if _, ok := s.db.tweets[tweetID]; !ok {
+		return []models.TweetProps{}, nil
+	}
+	ids := s.db.sortedTweetIDs(func(t *models.Tweet) bool {
+		return t.InReplyToID != nil && *t.InReplyToID == tweetID && s.db.listable(t, currUserID)
+	}, true)
+	return s.db.tweetPropsList(paginate(ids, limit, offset), currUserID), nil
+}
+
+func (s *tweetStore) PinTweet(ctx context.Context, tweetID string, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.tweets[tweetID]; !ok || s.db.authors[tweetID] != userID {
+		return stores.ErrTweetNotFound
+	}
+	s.db.pins[userID] = tweetID
+	return nil
+}
+
+func (s *tweetStore) UnpinTweet(ctx context.Context, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.pins, userID)
+	return nil
+}
+
+func (s *tweetStore) GetPinnedTweet(ctx context.Context, userID string, currUserID string) (*models.TweetProps, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	tweetID, ok := s.db.pins[userID]
+	if !ok || !s.db.visible(tweetID, currUserID) {
+		return nil, nil
+	}
+
+	tweetProps := s.db.tweetProps(tweetID, currUserID)
+	tweetProps.IsPinned = true
+	return tweetProps, nil
+}
+
+// profileTweet is a tweet on a profile tab with the key it is ordered by
+type profileTweet struct {
+	id      string
+	sortKey time.Time
+}
+
+// profileTab mirrors profileTimelineMatches
+func (db *DB) profileTab(tab models.ProfileTab, userID string) ([]profileTweet, bool) {
+	tweets := []profileTweet{}
+	switch tab {
+	case models.ProfileTabTweets, models.ProfileTabReplies, models.ProfileTabMedia:
+		for id, tweet := range db.tweets {
+			if db.authors[id] != userID {
+				continue
+			}
+			var keep bool
+			switch tab {
+			case models.ProfileTabTweets:
+				keep = tweet.InReplyToID == nil && db.pins[userID] != id
+			case models.ProfileTabReplies:
+				keep = tweet.InReplyToID != nil
+			case models.ProfileTabMedia:
+				keep = tweet.MediaURLs != nil && len(*tweet.MediaURLs) > 0
+			}
+			if keep {
+				tweets = append(tweets, profileTweet{id, tweet.CreatedAt})
+			}
+		}
+	case models.ProfileTabLikes:
+		for e, likedAt := range db.likes {
+			if e.from == userID {
+				if _, ok := db.tweets[e.to]; ok {
+					tweets = append(tweets, profileTweet{e.to, likedAt})
+				}
+			}
+		}
+	default:
+		return nil, false
+	}
+	return tweets, true
+}
+
+func (s *tweetStore) GetProfileTimeline(ctx context.Context, tab models.ProfileTab, userID string, currUserID string, cursor string, limit int) (*models.TweetPage, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	candidates, ok := s.db.profileTab(tab, userID)
+	if !ok {
+		return nil, stores.NewValidationError("tab", fmt.Sprintf("unknown profile tab %q", tab))
+	}
+	if limit < 1 {
+		return nil, stores.NewValidationError("limit", "must be at least 1")
+	}
+	limit = min(limit, models.MaxTweetPageSize)
+
+	cursorTime, cursorID, err := stores.DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	user, ok := s.db.users[userID]
+	if !ok {
+		return nil, stores.ErrUserNotFound
+	}
+	if s.db.blockedEither(currUserID, userID) {
+		return nil, stores.ErrBlocked
+	}
+	if !s.db.notProtected(user, currUserID) {
+		return nil, stores.ErrProtected
+	}
+
+	tweets := []profileTweet{}
+	for _, t := range candidates {
+		if !s.db.visible(t.id, currUserID) {
+			continue
+		}
+		if cursorTime != nil && !t.sortKey.Before(*cursorTime) && !(t.sortKey.Equal(*cursorTime) && t.id < cursorID) {
+			continue
+		}
+		tweets = append(tweets, t)
+	}
+	sort.Slice(tweets, func(i, j int) bool {
+		if !tweets[i].sortKey.Equal(tweets[j].sortKey) {
+			return tweets[i].sortKey.After(tweets[j].sortKey)
+		}
+		return tweets[i].id > tweets[j].id
+	})
+
+	page := &models.TweetPage{Tweets: []models.TweetProps{}}
+	for i, t := range tweets {
+		if i == limit {
+			next := stores.EncodeCursor(tweets[limit-1].sortKey, tweets[limit-1].id)
+			page.NextCursor = &next
+			break
+		}
+		page.Tweets = append(page.Tweets, *s.db.tweetProps(t.id, currUserID))
+	}
+
+	return page, nil
+}
+
+// trendingHashtags mirrors the trending part of GetTimelineCandidates: the
+// most used hashtags in tweets created after since
+func (db *DB) trendingHashtags(since time.Time) map[string]bool {
+	uses := map[string]int{}
+	for _, tweet := range db.tweets {
+		if tweet.CreatedAt.After(since) && tweet.Hashtags != nil {
+			for _, tag := range *tweet.Hashtags {
+				uses[tag]++
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(uses))
+	for tag := range uses {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if uses[tags[i]] != uses[tags[j]] {
+			return uses[tags[i]] > uses[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+
+	trending := map[string]bool{}
+	for _, tag := range paginate(tags, trendingLimit, 0) {
+		trending[tag] = true
+	}
+	return trending
+}
+
+func (s *tweetStore) GetTimelineCandidates(ctx context.Context, currUserID string, since time.Time, limit int) ([]models.TimelineCandidate, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if _, ok := s.db.users[currUserID]; !ok {
+		return []models.TimelineCandidate{}, nil
+	}
+
+	following := map[string]bool{}
+	for e := range s.db.follows {
+		if e.from == currUserID {
+			following[e.to] = true
+		}
+	}
+
+	// followed users who liked or retweeted each tweet
+	engagedBy := map[string]map[string]bool{}
+	for _, edges := range []map[edge]time.Time{s.db.likes, s.db.retweets} {
+		for e := range edges {
+			if following[e.from] {
+				if engagedBy[e.to] == nil {
+					engagedBy[e.to] = map[string]bool{}
+				}
+				engagedBy[e.to][e.from] = true
+			}
+		}
+	}
+
+	trending := s.db.trendingHashtags(since)
+	sources := map[string][]models.TimelineSource{}
+	for id, tweet := range s.db.tweets {
+		if !tweet.CreatedAt.After(since) {
+			continue
+		}
+		if following[s.db.authors[id]] {
+			sources[id] = append(sources[id], models.TimelineSourceFollow)
+		}
+		if len(engagedBy[id]) > 0 {
+			sources[id] = append(sources[id], models.TimelineSourceEngagement)
+		}
+		if tweet.Hashtags != nil {
+			for _, tag := range *tweet.Hashtags {
+				if trending[tag] {
+					sources[id] = append(sources[id], models.TimelineSourceTrending)
+					break
+				}
+			}
+		}
+	}
+
+	ids := s.db.sortedTweetIDs(func(t *models.Tweet) bool {
+		return len(sources[t.ID]) > 0 && s.db.authors[t.ID] != currUserID && s.db.listable(t, currUserID)
+	}, false)
+
+	candidates := make([]models.TimelineCandidate, 0, len(ids))
+	for _, id := range paginate(ids, limit, 0) {
+		candidates = append(candidates, models.TimelineCandidate{
+			Tweet:       *copyTweet(s.db.tweets[id]),
+			Props:       *s.db.tweetProps(id, currUserID),
+			Sources:     sources[id],
+			SocialProof: len(engagedBy[id]),
+		})
+	}
+
+	return candidates, nil
+}
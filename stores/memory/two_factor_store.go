@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+)
+
+type twoFactorStore struct {
+	db *DB
+}
+
+func NewTwoFactorStore(db *DB) stores.TwoFactorStore {
+	return &twoFactorStore{
+		db: db,
+	}
+}
+
+func copyTwoFactor(twoFactor *models.TwoFactor) *models.TwoFactor {
+	c := *twoFactor
+	c.RecoveryCodes = slices.Clone(twoFactor.RecoveryCodes)
+	return &c
+}
+
+func (s *twoFactorStore) GetTwoFactor(ctx context.Context, userID string) (*models.TwoFactor, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	twoFactor, ok := s.db.twoFactors[userID]
+	if !ok {
+		return nil, stores.ErrTwoFactorNotFound
+	}
+	return copyTwoFactor(twoFactor), nil
+}
+
+func (s *twoFactorStore) StartTwoFactor(ctx context.Context, userID string, secret string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return stores.ErrUserNotFound
+	}
+	if existing, ok := s.db.twoFactors[userID]; ok && existing.Enabled {
+		return stores.ErrTwoFactorEnabled
+	}
+
+	s.db.twoFactors[userID] = &models.TwoFactor{
+		UserID:        userID,
+		Secret:        secret,
+		RecoveryCodes: []string{},
+		CreatedAt:     s.db.now(),
+	}
+	return nil
+}
+
+func (s *twoFactorStore) EnableTwoFactor(ctx context.Context, userID string, recoveryCodes []string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	twoFactor, ok := s.db.twoFactors[userID]
+	if !ok {
+		return stores.ErrTwoFactorNotFound
+	}
+	if twoFactor.Enabled {
+		return stores.ErrTwoFactorEnabled
+	}
+
+	now := s.db.now()
+	twoFactor.Enabled = true
+	twoFactor.EnabledAt = &now
+	twoFactor.RecoveryCodes = slices.Clone(recoveryCodes)
+	return nil
+}
+
+func (s *twoFactorStore) DisableTwoFactor(ctx context.Context, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.twoFactors[userID]; !ok {
+		return stores.ErrTwoFactorNotFound
+	}
+	delete(s.db.twoFactors, userID)
+	return nil
+}
+
+func (s *twoFactorStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	twoFactor, ok := s.db.twoFactors[userID]
+	if !ok {
+		return stores.ErrTwoFactorNotFound
+	}
+	if step <= twoFactor.LastUsedStep {
+		return stores.ErrTOTPCodeUsed
+	}
+	twoFactor.LastUsedStep = step
+	return nil
+}
+
+func (s *twoFactorStore) UseRecoveryCode(ctx context.Context, userID string, recoveryCode string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	twoFactor, ok := s.db.twoFactors[userID]
+	if !ok || !twoFactor.Enabled {
+		return stores.ErrTwoFactorNotFound
+	}
+	i := slices.Index(twoFactor.RecoveryCodes, recoveryCode)
+	if i < 0 {
+		return stores.ErrRecoveryCodeInvalid
+	}
+	twoFactor.RecoveryCodes = slices.Delete(twoFactor.RecoveryCodes, i, i+1)
+	return nil
+}
+
+func (s *twoFactorStore) SetRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	twoFactor, ok := s.db.twoFactors[userID]
+	if !ok || !twoFactor.Enabled {
+		return stores.ErrTwoFactorNotFound
+	}
+	twoFactor.RecoveryCodes = slices.Clone(recoveryCodes)
+	return nil
+}
@@ -0,0 +1,726 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/services"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/google/uuid"
+)
+
+type userStore struct {
+	db                   *DB
+	notificationsService services.Notifications
+}
+
+func NewUserStore(db *DB, notificationsService services.Notifications) stores.UserStore {
+	return &userStore{
+		db:                   db,
+		notificationsService: notificationsService,
+	}
+}
+
+func (s *userStore) publish(notification *models.Notification) {
+	if s.notificationsService == nil {
+		return
+	}
+	s.notificationsService.Publish(notification.Type, notification)
+}
+
+func (s *userStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user, ok := s.db.users[id]
+	if !ok {
+		return nil, stores.ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (s *userStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, user := range s.db.users {
+		if user.Email == email {
+			return copyUser(user), nil
+		}
+	}
+	return nil, stores.ErrUserNotFound
+}
+
+func (s *userStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user := s.db.userByUsername(username)
+	if user == nil {
+		return nil, stores.ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (s *userStore) GetUserByPreviousUsername(ctx context.Context, username string) (*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	var latest *usernameChange
+	for i, change := range s.db.usernameChanges {
+		if strings.EqualFold(change.username, username) && change.expiresAt.After(now) &&
+			(latest == nil || change.changedAt.After(latest.changedAt)) {
+			latest = &s.db.usernameChanges[i]
+		}
+	}
+	if latest == nil {
+		return nil, stores.ErrUserNotFound
+	}
+
+	user, ok := s.db.users[latest.userID]
+	if !ok {
+		return nil, stores.ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+// usernameTaken mirrors usernameTakenPredicate
+func (db *DB) usernameTaken(username string, userID string) bool {
+	for _, other := range db.users {
+		if other.ID != userID && strings.EqualFold(other.Username, username) {
+			return true
+		}
+	}
+
+	now := time.Now()
+	for _, change := range db.usernameChanges {
+		if change.userID != userID && strings.EqualFold(change.username, username) && change.expiresAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *userStore) IsUsernameAvailable(ctx context.Context, username string, userID string) (bool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return !s.db.usernameTaken(username, userID), nil
+}
+
+func (s *userStore) ChangeUsername(ctx context.Context, userID string, username string) (*models.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := s.db.users[userID]
+	if !ok {
+		return nil, stores.ErrUserNotFound
+	}
+	if s.db.usernameTaken(username, userID) {
+		return nil, stores.ErrUsernameTaken
+	}
+	if user.Username == username {
+		return copyUser(user), nil
+	}
+
+	now := s.db.now()
+	recentChanges := 0
+	for _, change := range s.db.usernameChanges {
+		if change.userID == userID && change.changedAt.After(now.Add(-constants.USERNAME_CHANGE_WINDOW)) {
+			recentChanges++
+		}
+	}
+	if recentChanges >= constants.USERNAME_CHANGE_LIMIT {
+		return nil, stores.ErrUsernameChangeLimit
+	}
+
+	s.db.usernameChanges = append(s.db.usernameChanges, usernameChange{
+		userID:    userID,
+		username:  user.Username,
+		changedAt: now,
+		expiresAt: now.Add(constants.USERNAME_REDIRECT_GRACE_PERIOD),
+	})
+	user.Username = username
+	user.UpdatedAt = now
+
+	return copyUser(user), nil
+}
+
+func (s *userStore) CreateUser(ctx context.Context, user *models.User, authProvider constants.AuthProvider) (*models.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	// the Neo4j store relies on uniqueness constraints for this
+	for _, other := range s.db.users {
+		if other.Email == user.Email || strings.EqualFold(other.Username, user.Username) {
+			return nil, stores.ErrUserExists
+		}
+	}
+
+	now := s.db.now()
+	created := &models.User{
+		ID:            uuid.New().String(),
+		Name:          user.Name,
+		Email:         user.Email,
+		Password:      user.Password,
+		EmailVerified: user.EmailVerified,
+		Username:      user.Username,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	s.db.users[created.ID] = created
+
+	return copyUser(created), nil
+}
+
+// UpdateUser sets the fields of user that are not zero, like the Neo4j store
+func (s *userStore) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	existing, ok := s.db.users[user.ID]
+	if !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	if user.Name != "" {
+		existing.Name = user.Name
+	}
+	if user.Email != "" {
+		existing.Email = user.Email
+	}
+	if user.Password != "" {
+		existing.Password = user.Password
+	}
+	if user.Username != "" {
+		existing.Username = user.Username
+	}
+	if user.ProfilePicture != nil {
+		existing.ProfilePicture = user.ProfilePicture
+	}
+	if user.BannerPicture != nil {
+		existing.BannerPicture = user.BannerPicture
+	}
+	if user.IsVerified {
+		existing.IsVerified = true
+	}
+	if user.FollowersCount != 0 {
+		existing.FollowersCount = user.FollowersCount
+	}
+	if user.FollowingCount != 0 {
+		existing.FollowingCount = user.FollowingCount
+	}
+	if user.TweetsCount != 0 {
+		existing.TweetsCount = user.TweetsCount
+	}
+	if user.IsLocked {
+		existing.IsLocked = true
+	}
+	if user.Birthday != nil {
+		existing.Birthday = user.Birthday
+	}
+	if user.Website != nil {
+		existing.Website = user.Website
+	}
+	if user.Bio != nil {
+		existing.Bio = user.Bio
+	}
+	if user.Location != nil {
+		existing.Location = user.Location
+	}
+
+	return copyUser(existing), nil
+}
+
+// DeleteUser removes the user with their relationships and username history.
+// Their tweets stay behind without an author, as DETACH DELETE leaves them.
+func (s *userStore) DeleteUser(ctx context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.users, id)
+	delete(s.db.pins, id)
+	delete(s.db.twoFactors, id)
+	for sessionID, session := range s.db.sessions {
+		if session.UserID == id {
+			delete(s.db.sessions, sessionID)
+		}
+	}
+	for key, identity := range s.db.identities {
+		if identity.UserID == id {
+			delete(s.db.identities, key)
+		}
+	}
+	for tokenID, token := range s.db.apiTokens {
+		if token.UserID == id {
+			delete(s.db.apiTokens, tokenID)
+		}
+	}
+
+	history := s.db.usernameChanges[:0]
+	for _, change := range s.db.usernameChanges {
+		if change.userID != id {
+			history = append(history, change)
+		}
+	}
+	s.db.usernameChanges = history
+
+	for _, edges := range []map[edge]time.Time{
+		s.db.follows, s.db.followRequests, s.db.blocks, s.db.mutes,
+		s.db.mutedConversations, s.db.likes, s.db.retweets, s.db.bookmarks,
+	} {
+		for e := range edges {
+			if e.from == id || e.to == id {
+				delete(edges, e)
+			}
+		}
+	}
+	for wordID, word := range s.db.mutedWords {
+		if word.userID == id {
+			delete(s.db.mutedWords, wordID)
+		}
+	}
+
+	return nil
+}
+
+// follow creates a FOLLOWS edge and updates the counters, unless it exists
+func (db *DB) follow(followerID, followingID string, at time.Time) {
+	if db.isFollowing(followerID, followingID) {
+		return
+	}
+	db.follows[edge{followerID, followingID}] = at
+	db.users[followerID].FollowingCount++
+	db.users[followingID].FollowersCount++
+}
+
+// unfollow removes a FOLLOWS edge and updates the counters, if it exists
+func (db *DB) unfollow(followerID, followingID string) {
+	if !db.isFollowing(followerID, followingID) {
+		return
+	}
+	delete(db.follows, edge{followerID, followingID})
+	db.users[followerID].FollowingCount--
+	db.users[followingID].FollowersCount--
+}
+
+func (s *userStore) FollowUser(ctx context.Context, followerID, followingID string) (models.FollowStatus, error) {
+	s.db.mu.Lock()
+
+	follower, okF := s.db.users[followerID]
+	following, okT := s.db.users[followingID]
+	if !okF || !okT {
+		s.db.mu.Unlock()
+		return "", stores.ErrUserNotFound
+	}
+	if s.db.blockedEither(follower.ID, following.ID) {
+		s.db.mu.Unlock()
+		return "", stores.ErrBlocked
+	}
+
+	now := s.db.now()
+	alreadyFollowing := s.db.isFollowing(followerID, followingID)
+	if following.IsLocked && !alreadyFollowing {
+		_, requested := s.db.followRequests[edge{followerID, followingID}]
+		if !requested {
+			s.db.followRequests[edge{followerID, followingID}] = now
+		}
+		s.db.mu.Unlock()
+
+		if !requested {
+			s.publish(models.NewNotification(followingID, followerID, nil, models.NotificationTypeFollowRequest))
+		}
+		return models.FollowStatusRequested, nil
+	}
+
+	s.db.follow(followerID, followingID, now)
+	s.db.mu.Unlock()
+
+	if !alreadyFollowing {
+		s.publish(models.NewNotification(followingID, followerID, nil, models.NotificationTypeFollow))
+	}
+	return models.FollowStatusFollowing, nil
+}
+
+func (s *userStore) UnfollowUser(ctx context.Context, followerID, followingID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.unfollow(followerID, followingID)
+	delete(s.db.followRequests, edge{followerID, followingID})
+	return nil
+}
+
+// usersByEdge returns the users on one end of the given edges, newest edge
+// first
+func (db *DB) usersByEdge(edges map[edge]time.Time, match func(e edge) (string, bool), limit int, offset int) []*models.User {
+	type entry struct {
+		user *models.User
+		at   time.Time
+	}
+	entries := []entry{}
+	for e, at := range edges {
+		id, ok := match(e)
+		if !ok {
+			continue
+		}
+		if user, ok := db.users[id]; ok {
+			entries = append(entries, entry{user, at})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].at.After(entries[j].at) })
+
+	users := []*models.User{}
+	for _, e := range paginate(entries, limit, offset) {
+		user := copyUser(e.user)
+		user.Password = ""
+		users = append(users, user)
+	}
+	return users
+}
+
+func (s *userStore) GetFollowRequests(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return s.db.usersByEdge(s.db.followRequests, func(e edge) (string, bool) {
+		return e.from, e.to == userID
+	}, limit, offset), nil
+}
+
+func (s *userStore) ApproveFollowRequest(ctx context.Context, userID, requesterID string) error {
+	s.db.mu.Lock()
+
+	request := edge{requesterID, userID}
+	if _, ok := s.db.followRequests[request]; !ok {
+		s.db.mu.Unlock()
+		return stores.ErrFollowRequestNotFound
+	}
+	delete(s.db.followRequests, request)
+	if s.db.blockedEither(requesterID, userID) {
+		s.db.mu.Unlock()
+		return stores.ErrBlocked
+	}
+	s.db.follow(requesterID, userID, s.db.now())
+	s.db.mu.Unlock()
+
+	s.publish(models.NewNotification(userID, requesterID, nil, models.NotificationTypeFollow))
+	return nil
+}
+
+func (s *userStore) DenyFollowRequest(ctx context.Context, userID, requesterID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	request := edge{requesterID, userID}
+	if _, ok := s.db.followRequests[request]; !ok {
+		return stores.ErrFollowRequestNotFound
+	}
+	delete(s.db.followRequests, request)
+	return nil
+}
+
+func (s *userStore) SetLocked(ctx context.Context, userID string, locked bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := s.db.users[userID]
+	if !ok {
+		return stores.ErrUserNotFound
+	}
+
+	now := s.db.now()
+	user.IsLocked = locked
+	user.UpdatedAt = now
+
+	if !locked {
+		for request := range s.db.followRequests {
+			if request.to == userID && !s.db.blockedEither(request.from, userID) {
+				delete(s.db.followRequests, request)
+				s.db.follow(request.from, userID, now)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *userStore) SetPassword(ctx context.Context, userID string, password string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := s.db.users[userID]
+	if !ok {
+		return stores.ErrUserNotFound
+	}
+	user.Password = password
+	user.UpdatedAt = s.db.now()
+	return nil
+}
+
+func (s *userStore) SetEmailVerified(ctx context.Context, userID string, email string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := s.db.users[userID]
+	if !ok || user.Email != email {
+		return stores.ErrUserNotFound
+	}
+	user.EmailVerified = true
+	user.UpdatedAt = s.db.now()
+	return nil
+}
+
+func (s *userStore) GetFollowers(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return s.db.usersByEdge(s.db.follows, func(e edge) (string, bool) {
+		return e.from, e.to == userID
+	}, limit, offset), nil
+}
+
+func (s *userStore) GetFollowing(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return s.db.usersByEdge(s.db.follows, func(e edge) (string, bool) {
+		return e.to, e.from == userID
+	}, limit, offset), nil
+}
+
+func (s *userStore) BlockUser(ctx context.Context, blockerID, blockedID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	_, okBlocker := s.db.users[blockerID]
+	_, okBlocked := s.db.users[blockedID]
+	if !okBlocker || !okBlocked {
+		return stores.ErrUserNotFound
+	}
+
+	if _, ok := s.db.blocks[edge{blockerID, blockedID}]; !ok {
+		s.db.blocks[edge{blockerID, blockedID}] = s.db.now()
+	}
+	s.db.unfollow(blockerID, blockedID)
+	s.db.unfollow(blockedID, blockerID)
+	delete(s.db.followRequests, edge{blockerID, blockedID})
+	delete(s.db.followRequests, edge{blockedID, blockerID})
+	return nil
+}
+
+func (s *userStore) UnblockUser(ctx context.Context, blockerID, blockedID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.blocks, edge{blockerID, blockedID})
+	return nil
+}
+
+func (s *userStore) GetBlockedUsers(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return s.db.usersByEdge(s.db.blocks, func(e edge) (string, bool) {
+		return e.to, e.from == userID
+	}, limit, offset), nil
+}
+
+func (s *userStore) IsBlocked(ctx context.Context, userID, otherUserID string) (bool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return s.db.blockedEither(userID, otherUserID), nil
+}
+
+func (s *userStore) GetRelationship(ctx context.Context, viewerID, userID string) (*models.Relationship, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	_, isBlocked := s.db.blocks[edge{viewerID, userID}]
+	_, blockedBy := s.db.blocks[edge{userID, viewerID}]
+
+	return &models.Relationship{
+		IsFollowing: s.db.isFollowing(viewerID, userID),
+		FollowsYou:  s.db.isFollowing(userID, viewerID),
+		IsBlocked:   isBlocked,
+		BlockedBy:   blockedBy,
+	}, nil
+}
+
+func (s *userStore) MuteUser(ctx context.Context, userID, mutedID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	_, okUser := s.db.users[userID]
+	_, okMuted := s.db.users[mutedID]
+	if !okUser || !okMuted {
+		return stores.ErrUserNotFound
+	}
+
+	if _, ok := s.db.mutes[edge{userID, mutedID}]; !ok {
+		s.db.mutes[edge{userID, mutedID}] = s.db.now()
+	}
+	return nil
+}
+
+func (s *userStore) UnmuteUser(ctx context.Context, userID, mutedID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.mutes, edge{userID, mutedID})
+	return nil
+}
+
+func (s *userStore) AddMutedWord(ctx context.Context, userID string, phrase string, expiresAt *time.Time) (*models.MutedWord, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	word := &mutedWord{
+		userID: userID,
+		word: models.MutedWord{
+			ID:        uuid.New().String(),
+			Phrase:    strings.ToLower(strings.TrimSpace(phrase)),
+			ExpiresAt: expiresAt,
+			CreatedAt: s.db.now(),
+		},
+	}
+	s.db.mutedWords[word.word.ID] = word
+
+	created := word.word
+	return &created, nil
+}
+
+func (s *userStore) RemoveMutedWord(ctx context.Context, userID string, wordID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if word, ok := s.db.mutedWords[wordID]; ok && word.userID == userID {
+		delete(s.db.mutedWords, wordID)
+	}
+	return nil
+}
+
+// conversationRoot returns the ID of the first tweet of the conversation the
+// tweet belongs to
+func (db *DB) conversationRoot(tweet *models.Tweet) string {
+	if tweet.ConversationID != nil {
+		return *tweet.ConversationID
+	}
+	return tweet.ID
+}
+
+func (s *userStore) MuteConversation(ctx context.Context, userID string, tweetID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	tweet, ok := s.db.tweets[tweetID]
+	if _, okUser := s.db.users[userID]; !ok || !okUser {
+		return stores.ErrTweetNotFound
+	}
+	root := s.db.conversationRoot(tweet)
+	if _, ok := s.db.tweets[root]; !ok {
+		return stores.ErrTweetNotFound
+	}
+
+	if _, ok := s.db.mutedConversations[edge{userID, root}]; !ok {
+		s.db.mutedConversations[edge{userID, root}] = s.db.now()
+	}
+	return nil
+}
+
+func (s *userStore) UnmuteConversation(ctx context.Context, userID string, tweetID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if tweet, ok := s.db.tweets[tweetID]; ok {
+		delete(s.db.mutedConversations, edge{userID, s.db.conversationRoot(tweet)})
+	}
+	return nil
+}
+
+func (s *userStore) GetMutes(ctx context.Context, userID string) (*models.Mutes, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	mutes := &models.Mutes{
+		Users:           []*models.PublicProfile{},
+		Words:           []models.MutedWord{},
+		ConversationIDs: []string{},
+	}
+	for e := range s.db.mutes {
+		if e.from == userID {
+			if user, ok := s.db.users[e.to]; ok {
+				mutes.Users = append(mutes.Users, models.NewPublicProfile(user))
+			}
+		}
+	}
+	now := time.Now()
+	for _, word := range s.db.mutedWords {
+		if word.userID == userID && (word.word.ExpiresAt == nil || word.word.ExpiresAt.After(now)) {
+			mutes.Words = append(mutes.Words, word.word)
+		}
+	}
+	for e := range s.db.mutedConversations {
+		if e.from == userID {
+			mutes.ConversationIDs = append(mutes.ConversationIDs, e.to)
+		}
+	}
+
+	return mutes, nil
+}
+
+func (s *userStore) GetContentFilter(ctx context.Context, userID string) (*models.ContentFilter, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return nil, stores.ErrUserNotFound
+	}
+
+	filter := &models.ContentFilter{
+		UserID:               userID,
+		FollowingUserIDs:     map[string]bool{},
+		BlockedUserIDs:       map[string]bool{},
+		MutedUserIDs:         map[string]bool{},
+		MutedWords:           s.db.activeMutedWords(userID),
+		MutedConversationIDs: map[string]bool{},
+	}
+	for e := range s.db.follows {
+		if e.from == userID {
+			filter.FollowingUserIDs[e.to] = true
+		}
+	}
+	for e := range s.db.blocks {
+		if e.from == userID {
+			filter.BlockedUserIDs[e.to] = true
+		}
+		if e.to == userID {
+			filter.BlockedUserIDs[e.from] = true
+		}
+	}
+	for e := range s.db.mutes {
+		if e.from == userID {
+			filter.MutedUserIDs[e.to] = true
+		}
+	}
+	for e := range s.db.mutedConversations {
+		if e.from == userID {
+			filter.MutedConversationIDs[e.to] = true
+		}
+	}
+
+	return filter, nil
+}
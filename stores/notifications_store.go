@@ -2,39 +2,44 @@ package stores
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"time"
 
 	"github.com/aimrintech/x-backend/models"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 )
 
 type NotificationsStore interface {
-	GetNotifications(userID string, limit int, offset int) ([]*models.Notification, error)
-	CreateNotification(notification *models.Notification) error
-	FlagAsRead(notificationID string, userID string) error
+	GetNotifications(ctx context.Context, userID string, limit int, offset int) ([]*models.Notification, error)
+	CreateNotification(ctx context.Context, notification *models.Notification) error
+	FlagAsRead(ctx context.Context, notificationID string, userID string) error
 }
 
 type notificationsStore struct {
 	driver *neo4j.DriverWithContext
-	dbCtx  *context.Context
 }
 
-func NewNotificationsStore(driver *neo4j.DriverWithContext, dbCtx *context.Context) NotificationsStore {
+func NewNotificationsStore(driver *neo4j.DriverWithContext) NotificationsStore {
 	return &notificationsStore{
 		driver: driver,
-		dbCtx:  dbCtx,
 	}
 }
 
-func (s *notificationsStore) GetNotifications(userID string, limit int, offset int) ([]*models.Notification, error) {
+func (s *notificationsStore) GetNotifications(ctx context.Context, userID string, limit int, offset int) ([]*models.Notification, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
-		`MATCH (u:User {id: $userID})-[:NOTIFICATIONS]->(n:Notification)
-		LIMIT $limit
-		OFFSET $offset
+		`MATCH (n:Notification)-[:TARGETED]->(u:User {id: $userID})
+		WHERE NOT EXISTS { MATCH (u)-[:BLOCKS]-(:User {id: n.authorUserID}) }
+			AND NOT EXISTS { MATCH (u)-[:MUTES]->(:User {id: n.authorUserID}) }
+			AND NOT EXISTS {
+				MATCH (tweet:Tweet {id: n.targetTweetID})
+				WHERE n.type IN ['reply', 'like']
+					AND (u)-[:MUTES_CONVERSATION]->(:Tweet {id: coalesce(tweet.conversationID, tweet.id)})
+			}
+		RETURN n
 		ORDER BY n.createdAt DESC
-		RETURN n`,
+		SKIP $offset LIMIT $limit`,
 		map[string]any{"userID": userID, "limit": limit, "offset": offset},
 		neo4j.EagerResultTransformer,
 	)
@@ -42,21 +47,21 @@ func (s *notificationsStore) GetNotifications(userID string, limit int, offset i
 		return nil, err
 	}
 
-	notifications := make([]*models.Notification, len(res.Records))
+	notifications := make([]*models.Notification, 0, len(res.Records))
 	for _, record := range res.Records {
 		notification, ok := record.Get("n")
 		if !ok {
-			return nil, errors.New("notification not found")
+			return nil, fmt.Errorf("failed to extract notification node")
 		}
-		notifications = append(notifications, notification.(*models.Notification))
+		notifications = append(notifications, extractNotificationFromNode(notification))
 	}
 
 	return notifications, nil
 }
 
-func (s *notificationsStore) CreateNotification(notification *models.Notification) error {
+func (s *notificationsStore) CreateNotification(ctx context.Context, notification *models.Notification) error {
 	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (author:User {id: $authorUserID})
 		MATCH (target:User {id: $targetUserID})
@@ -89,16 +94,37 @@ func (s *notificationsStore) CreateNotification(notification *models.Notificatio
 	return err
 }
 
-func (s *notificationsStore) FlagAsRead(notificationID string, userID string) error {
-	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+func (s *notificationsStore) FlagAsRead(ctx context.Context, notificationID string, userID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
 		*s.driver,
-		`MATCH (n:Notification {id: $notificationID})
-		WHERE n-[:TARGETED]->(u:User {id: $userID})
-		SET n.isRead = true`,
+		`MATCH (n:Notification {id: $notificationID})-[:TARGETED]->(:User {id: $userID})
+		SET n.isRead = true
+		RETURN n`,
 		map[string]any{"notificationID": notificationID, "userID": userID},
 		neo4j.EagerResultTransformer,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if len(res.Records) == 0 {
+		return ErrNotificationNotFound
+	}
+
+	return nil
+}
+
+func extractNotificationFromNode(notificationNode any) *models.Notification {
+	props := notificationNode.(neo4j.Node).Props
+
+	return &models.Notification{
+		ID:            props["id"].(string),
+		TargetUserID:  props["targetUserID"].(string),
+		TargetTweetID: toStringPtr(props["targetTweetID"]),
+		AuthorUserID:  props["authorUserID"].(string),
+		Type:          models.NotificationType(props["type"].(string)),
+		IsRead:        props["isRead"].(bool),
+		CreatedAt:     props["createdAt"].(time.Time),
+	}
 }
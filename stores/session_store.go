@@ -0,0 +1,240 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *models.Session) (*models.Session, error)
+	GetSession(ctx context.Context, sessionID string) (*models.Session, error)
+	// RotateSession swaps the session's refresh token hash for newTokenHash
+	// if tokenHash is the current one. Presenting any of the session's last
+	// constants.SPENT_REFRESH_TOKENS hashes again means the token was copied,
+	// so the session is revoked and ErrRefreshTokenReused returned.
+	RotateSession(ctx context.Context, sessionID string, tokenHash string, newTokenHash string, ip string) (*models.Session, error)
+	RevokeSession(ctx context.Context, userID string, sessionID string) error
+	// ListSessions returns the user's active sessions, most recently used
+	// first
+	ListSessions(ctx context.Context, userID string) ([]*models.Session, error)
+	// RevokeSessions revokes every active session of the user except
+	// exceptSessionID, which may be empty, and returns the revoked IDs
+	RevokeSessions(ctx context.Context, userID string, exceptSessionID string) ([]string, error)
+}
+
+type sessionStore struct {
+	driver *neo4j.DriverWithContext
+}
+
+func NewSessionStore(driver *neo4j.DriverWithContext) SessionStore {
+	return &sessionStore{
+		driver: driver,
+	}
+}
+
+func (s *sessionStore) CreateSession(ctx context.Context, session *models.Session) (*models.Session, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		CREATE (u)-[:HAS_SESSION]->(s:Session {
+			id: $sessionID,
+			tokenHash: $tokenHash,
+			userAgent: $userAgent,
+			ip: $ip,
+			createdAt: datetime(),
+			lastSeenAt: datetime(),
+			expiresAt: $expiresAt
+		})
+		RETURN s, u.id AS userID`,
+		map[string]any{
+			"userID":    session.UserID,
+			"sessionID": session.ID,
+			"tokenHash": session.TokenHash,
+			"userAgent": session.UserAgent,
+			"ip":        session.IP,
+			"expiresAt": session.ExpiresAt,
+		},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return extractSessionFromRecord(res.Records[0])
+}
+
+func (s *sessionStore) GetSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User)-[:HAS_SESSION]->(s:Session {id: $sessionID})
+		RETURN s, u.id AS userID`,
+		map[string]any{"sessionID": sessionID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	return extractSessionFromRecord(res.Records[0])
+}
+
+func (s *sessionStore) RotateSession(ctx context.Context, sessionID string, tokenHash string, newTokenHash string, ip string) (*models.Session, error) {
+	// one query, so two requests racing with the same token can't both
+	// rotate it
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User)-[:HAS_SESSION]->(s:Session {id: $sessionID})
+		WHERE s.revokedAt IS NULL AND s.expiresAt > datetime()
+		WITH u, s, s.tokenHash = $tokenHash AS current, $tokenHash IN coalesce(s.spentTokenHashes, []) AS reused
+		SET s.spentTokenHashes = CASE WHEN current
+				THEN ([s.tokenHash] + coalesce(s.spentTokenHashes, []))[..$spentLimit]
+				ELSE s.spentTokenHashes END,
+			s.tokenHash = CASE WHEN current THEN $newTokenHash ELSE s.tokenHash END,
+			s.lastSeenAt = CASE WHEN current THEN datetime() ELSE s.lastSeenAt END,
+			s.ip = CASE WHEN current THEN $ip ELSE s.ip END,
+			s.revokedAt = CASE WHEN reused THEN datetime() ELSE s.revokedAt END
+		RETURN s, u.id AS userID, current, reused`,
+		map[string]any{
+			"sessionID":    sessionID,
+			"tokenHash":    tokenHash,
+			"newTokenHash": newTokenHash,
+			"ip":           ip,
+			"spentLimit":   constants.SPENT_REFRESH_TOKENS,
+		},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	record := res.Records[0]
+	if reused, _ := record.Get("reused"); reused == true {
+		return nil, ErrRefreshTokenReused
+	}
+	if current, _ := record.Get("current"); current != true {
+		return nil, ErrSessionNotFound
+	}
+
+	return extractSessionFromRecord(record)
+}
+
+func (s *sessionStore) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_SESSION]->(s:Session {id: $sessionID})
+		SET s.revokedAt = coalesce(s.revokedAt, datetime())
+		RETURN s`,
+		map[string]any{"userID": userID, "sessionID": sessionID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+	if len(res.Records) == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *sessionStore) ListSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})-[:HAS_SESSION]->(s:Session)
+		WHERE s.revokedAt IS NULL AND s.expiresAt > datetime()
+		RETURN s, u.id AS userID
+		ORDER BY s.lastSeenAt DESC`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*models.Session, 0, len(res.Records))
+	for _, record := range res.Records {
+		session, err := extractSessionFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (s *sessionStore) RevokeSessions(ctx context.Context, userID string, exceptSessionID string) ([]string, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_SESSION]->(s:Session)
+		WHERE s.revokedAt IS NULL AND s.expiresAt > datetime() AND s.id <> $exceptSessionID
+		SET s.revokedAt = datetime()
+		RETURN s.id AS id`,
+		map[string]any{"userID": userID, "exceptSessionID": exceptSessionID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(res.Records))
+	for _, record := range res.Records {
+		id, ok := record.Get("id")
+		if !ok {
+			return nil, fmt.Errorf("failed to extract session id")
+		}
+		ids = append(ids, id.(string))
+	}
+
+	return ids, nil
+}
+
+func extractSessionFromRecord(record *neo4j.Record) (*models.Session, error) {
+	node, ok := record.Get("s")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract session node")
+	}
+	userID, ok := record.Get("userID")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract session user")
+	}
+	props := node.(neo4j.Node).Props
+
+	session := &models.Session{
+		ID:         props["id"].(string),
+		UserID:     userID.(string),
+		TokenHash:  props["tokenHash"].(string),
+		CreatedAt:  props["createdAt"].(time.Time),
+		LastSeenAt: props["lastSeenAt"].(time.Time),
+		ExpiresAt:  props["expiresAt"].(time.Time),
+		RevokedAt:  toTimePtr(props["revokedAt"]),
+	}
+	if spent, ok := props["spentTokenHashes"].([]any); ok {
+		for _, hash := range spent {
+			session.SpentTokenHashes = append(session.SpentTokenHashes, hash.(string))
+		}
+	}
+	session.UserAgent, _ = props["userAgent"].(string)
+	session.IP, _ = props["ip"].(string)
+	return session, nil
+}
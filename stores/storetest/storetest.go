@@ -0,0 +1,950 @@
+// Package storetest is a contract test suite for implementations of the
+// store interfaces. Every implementation runs the same tests, so the
+// in-memory stores can stand in for Neo4j wherever a test needs stores.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aimrintech/x-backend/constants"
+	"github.com/aimrintech/x-backend/models"
+	"github.com/aimrintech/x-backend/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Stores is one set of stores sharing the same data
+type Stores struct {
+	Users         stores.UserStore
+	Tweets        stores.TweetStore
+	Notifications stores.NotificationsStore
+	Sessions      stores.SessionStore
+	Identities    stores.IdentityStore
+	TwoFactor     stores.TwoFactorStore
+	APITokens     stores.APITokenStore
+}
+
+// Factory returns empty stores for a single test. It should register any
+// cleanup with t.Cleanup and may skip the test if its backend is unavailable.
+type Factory func(t *testing.T) Stores
+
+var ctx = context.Background()
+
+// Run runs the contract suite against the stores returned by newStores
+func Run(t *testing.T, newStores Factory) {
+	tests := map[string]func(t *testing.T, s Stores){
+		"UserCRUD":            testUserCRUD,
+		"DuplicateUser":       testDuplicateUser,
+		"Credentials":         testCredentials,
+		"FollowCounters":      testFollowCounters,
+		"FollowRequests":      testFollowRequests,
+		"BlockRemovesFollows": testBlockRemovesFollows,
+		"BlockDropsRequests":  testBlockDropsRequests,
+		"Mutes":               testMutes,
+		"ChangeUsername":      testChangeUsername,
+		"TweetCRUD":           testTweetCRUD,
+		"EngagementCounters":  testEngagementCounters,
+		"QuoteTweet":          testQuoteTweet,
+		"Replies":             testReplies,
+		"LockedVisibility":    testLockedVisibility,
+		"BlockedEngagement":   testBlockedEngagement,
+		"PinnedTweet":         testPinnedTweet,
+		"ProfilePaging":       testProfilePaging,
+		"TimelineCandidates":  testTimelineCandidates,
+		"Notifications":       testNotifications,
+		"SessionRotation":     testSessionRotation,
+		"SessionReuse":        testSessionReuse,
+		"SessionRevocation":   testSessionRevocation,
+		"SessionList":         testSessionList,
+		"Identities":          testIdentities,
+		"TwoFactor":           testTwoFactor,
+		"APITokens":           testAPITokens,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStores(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, s Stores, username string) *models.User {
+	t.Helper()
+	user, err := s.Users.CreateUser(ctx, &models.User{
+		Name:     username,
+		Email:    username + "@example.com",
+		Password: "hashedpassword",
+		Username: username,
+	}, constants.AUTH_PROVIDER_CREDS)
+	require.NoError(t, err)
+	return user
+}
+
+func createTweet(t *testing.T, s Stores, userID string, content string) *models.TweetProps {
+	t.Helper()
+	tweet, err := s.Tweets.CreateTweet(ctx, &models.Tweet{Content: &content}, userID)
+	require.NoError(t, err)
+	return tweet
+}
+
+func getUser(t *testing.T, s Stores, id string) *models.User {
+	t.Helper()
+	user, err := s.Users.GetUserByID(ctx, id)
+	require.NoError(t, err)
+	return user
+}
+
+func getTweet(t *testing.T, s Stores, id string, viewerID string) *models.Tweet {
+	t.Helper()
+	tweet, err := s.Tweets.GetTweetByID(ctx, id, viewerID)
+	require.NoError(t, err)
+	return tweet
+}
+
+func testUserCRUD(t *testing.T, s Stores) {
+	created := createUser(t, s, "alice")
+	assert.NotEmpty(t, created.ID)
+	assert.Zero(t, created.FollowersCount)
+
+	byEmail, err := s.Users.GetUserByEmail(ctx, "alice@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, byEmail.ID)
+
+	byUsername, err := s.Users.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, byUsername.ID)
+	byUsername, err = s.Users.GetUserByUsername(ctx, "Alice")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, byUsername.ID)
+
+	bio := "hello"
+	updated, err := s.Users.UpdateUser(ctx, &models.User{ID: created.ID, Name: "Alice", Bio: &bio})
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", updated.Name)
+	assert.Equal(t, "alice", updated.Username)
+	require.NotNil(t, updated.Bio)
+	assert.Equal(t, bio, *updated.Bio)
+
+	_, err = s.Users.UpdateUser(ctx, &models.User{ID: "missing", Name: "Nobody"})
+	assert.ErrorIs(t, err, stores.ErrNotFound)
+
+	require.NoError(t, s.Users.DeleteUser(ctx, created.ID))
+	_, err = s.Users.GetUserByID(ctx, created.ID)
+	assert.ErrorIs(t, err, stores.ErrUserNotFound)
+	_, err = s.Users.GetUserByEmail(ctx, "alice@example.com")
+	assert.ErrorIs(t, err, stores.ErrNotFound)
+}
+
+func testCredentials(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	assert.False(t, alice.EmailVerified)
+
+	require.NoError(t, s.Users.SetPassword(ctx, alice.ID, "new-hash"))
+	assert.Equal(t, "new-hash", getUser(t, s, alice.ID).Password)
+	assert.ErrorIs(t, s.Users.SetPassword(ctx, "missing", "hash"), stores.ErrUserNotFound)
+
+	// only the email the user has now can be verified
+	assert.ErrorIs(t, s.Users.SetEmailVerified(ctx, alice.ID, "old@example.com"), stores.ErrUserNotFound)
+	assert.False(t, getUser(t, s, alice.ID).EmailVerified)
+	require.NoError(t, s.Users.SetEmailVerified(ctx, alice.ID, alice.Email))
+	assert.True(t, getUser(t, s, alice.ID).EmailVerified)
+}
+
+func testDuplicateUser(t *testing.T, s Stores) {
+	createUser(t, s, "alice")
+
+	_, err := s.Users.CreateUser(ctx, &models.User{
+		Name:     "Other",
+		Email:    "alice@example.com",
+		Password: "hashedpassword",
+		Username: "other",
+	}, constants.AUTH_PROVIDER_CREDS)
+	assert.ErrorIs(t, err, stores.ErrConflict)
+
+	// usernames are unique regardless of case
+	_, err = s.Users.CreateUser(ctx, &models.User{
+		Name:     "Other",
+		Email:    "other@example.com",
+		Password: "hashedpassword",
+		Username: "ALICE",
+	}, constants.AUTH_PROVIDER_CREDS)
+	assert.ErrorIs(t, err, stores.ErrConflict)
+}
+
+func testFollowCounters(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	for range 2 {
+		status, err := s.Users.FollowUser(ctx, alice.ID, bob.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.FollowStatusFollowing, status)
+	}
+	assert.Equal(t, 1, getUser(t, s, alice.ID).FollowingCount)
+	assert.Equal(t, 1, getUser(t, s, bob.ID).FollowersCount)
+
+	followers, err := s.Users.GetFollowers(ctx, bob.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, followers, 1)
+	assert.Equal(t, alice.ID, followers[0].ID)
+	assert.Empty(t, followers[0].Password)
+
+	following, err := s.Users.GetFollowing(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, following, 1)
+	assert.Equal(t, bob.ID, following[0].ID)
+
+	for range 2 {
+		require.NoError(t, s.Users.UnfollowUser(ctx, alice.ID, bob.ID))
+	}
+	assert.Zero(t, getUser(t, s, alice.ID).FollowingCount)
+	assert.Zero(t, getUser(t, s, bob.ID).FollowersCount)
+
+	_, err = s.Users.FollowUser(ctx, alice.ID, "missing")
+	assert.ErrorIs(t, err, stores.ErrUserNotFound)
+}
+
+func testFollowRequests(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+	require.NoError(t, s.Users.SetLocked(ctx, bob.ID, true))
+
+	status, err := s.Users.FollowUser(ctx, alice.ID, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.FollowStatusRequested, status)
+	_, err = s.Users.FollowUser(ctx, carol.ID, bob.ID)
+	require.NoError(t, err)
+	assert.Zero(t, getUser(t, s, bob.ID).FollowersCount)
+
+	requests, err := s.Users.GetFollowRequests(ctx, bob.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, requests, 2)
+
+	require.NoError(t, s.Users.ApproveFollowRequest(ctx, bob.ID, alice.ID))
+	assert.ErrorIs(t, s.Users.ApproveFollowRequest(ctx, bob.ID, alice.ID), stores.ErrNotFound)
+	assert.Equal(t, 1, getUser(t, s, bob.ID).FollowersCount)
+
+	relationship, err := s.Users.GetRelationship(ctx, alice.ID, bob.ID)
+	require.NoError(t, err)
+	assert.True(t, relationship.IsFollowing)
+
+	// unlocking approves the remaining request
+	require.NoError(t, s.Users.SetLocked(ctx, bob.ID, false))
+	assert.Equal(t, 2, getUser(t, s, bob.ID).FollowersCount)
+	assert.Equal(t, 1, getUser(t, s, carol.ID).FollowingCount)
+
+	requests, err = s.Users.GetFollowRequests(ctx, bob.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+	assert.ErrorIs(t, s.Users.DenyFollowRequest(ctx, bob.ID, carol.ID), stores.ErrFollowRequestNotFound)
+}
+
+func testBlockRemovesFollows(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	_, err := s.Users.FollowUser(ctx, alice.ID, bob.ID)
+	require.NoError(t, err)
+	_, err = s.Users.FollowUser(ctx, bob.ID, alice.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Users.BlockUser(ctx, alice.ID, bob.ID))
+	require.NoError(t, s.Users.BlockUser(ctx, alice.ID, bob.ID))
+
+	for _, user := range []*models.User{getUser(t, s, alice.ID), getUser(t, s, bob.ID)} {
+		assert.Zero(t, user.FollowersCount)
+		assert.Zero(t, user.FollowingCount)
+	}
+
+	blocked, err := s.Users.IsBlocked(ctx, bob.ID, alice.ID)
+	require.NoError(t, err)
+	assert.True(t, blocked)
+
+	_, err = s.Users.FollowUser(ctx, bob.ID, alice.ID)
+	assert.ErrorIs(t, err, stores.ErrBlocked)
+	assert.ErrorIs(t, err, stores.ErrForbidden)
+
+	blockedUsers, err := s.Users.GetBlockedUsers(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, blockedUsers, 1)
+	assert.Equal(t, bob.ID, blockedUsers[0].ID)
+
+	require.NoError(t, s.Users.UnblockUser(ctx, alice.ID, bob.ID))
+	blocked, err = s.Users.IsBlocked(ctx, alice.ID, bob.ID)
+	require.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+func testBlockDropsRequests(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+	require.NoError(t, s.Users.SetLocked(ctx, bob.ID, true))
+	for _, requester := range []*models.User{alice, carol} {
+		status, err := s.Users.FollowUser(ctx, requester.ID, bob.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.FollowStatusRequested, status)
+	}
+
+	// blocking drops the request whichever side blocks
+	require.NoError(t, s.Users.BlockUser(ctx, bob.ID, alice.ID))
+	require.NoError(t, s.Users.BlockUser(ctx, carol.ID, bob.ID))
+
+	requests, err := s.Users.GetFollowRequests(ctx, bob.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+	assert.ErrorIs(t, s.Users.ApproveFollowRequest(ctx, bob.ID, alice.ID), stores.ErrFollowRequestNotFound)
+
+	require.NoError(t, s.Users.SetLocked(ctx, bob.ID, false))
+	assert.Zero(t, getUser(t, s, bob.ID).FollowersCount)
+	for _, requester := range []*models.User{alice, carol} {
+		relationship, err := s.Users.GetRelationship(ctx, requester.ID, bob.ID)
+		require.NoError(t, err)
+		assert.False(t, relationship.IsFollowing)
+		assert.Zero(t, getUser(t, s, requester.ID).FollowingCount)
+	}
+}
+
+func testMutes(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+	createTweet(t, s, bob.ID, "nothing to see")
+	createTweet(t, s, carol.ID, "Spoilers ahead")
+	plain := createTweet(t, s, carol.ID, "a plain tweet")
+
+	require.NoError(t, s.Users.MuteUser(ctx, alice.ID, bob.ID))
+	_, err := s.Users.AddMutedWord(ctx, alice.ID, "  SPOILERS ", nil)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Hour)
+	_, err = s.Users.AddMutedWord(ctx, alice.ID, "plain", &expired)
+	require.NoError(t, err)
+
+	tweets, err := s.Tweets.GetUsersWithTweets(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, tweets, 1)
+	assert.Equal(t, plain.ID, tweets[0].ID)
+
+	mutes, err := s.Users.GetMutes(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, mutes.Users, 1)
+	assert.Equal(t, bob.ID, mutes.Users[0].ID)
+	require.Len(t, mutes.Words, 1)
+	assert.Equal(t, "spoilers", mutes.Words[0].Phrase)
+
+	filter, err := s.Users.GetContentFilter(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.True(t, filter.MutedUserIDs[bob.ID])
+	assert.Equal(t, []string{"spoilers"}, filter.MutedWords)
+
+	require.NoError(t, s.Users.UnmuteUser(ctx, alice.ID, bob.ID))
+	require.NoError(t, s.Users.RemoveMutedWord(ctx, alice.ID, mutes.Words[0].ID))
+	tweets, err = s.Tweets.GetUsersWithTweets(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, tweets, 3)
+
+	assert.ErrorIs(t, s.Users.MuteConversation(ctx, alice.ID, "missing"), stores.ErrTweetNotFound)
+}
+
+func testChangeUsername(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	_, err := s.Users.ChangeUsername(ctx, alice.ID, "BOB")
+	assert.ErrorIs(t, err, stores.ErrUsernameTaken)
+
+	renamed, err := s.Users.ChangeUsername(ctx, alice.ID, "alice2")
+	require.NoError(t, err)
+	assert.Equal(t, "alice2", renamed.Username)
+
+	previous, err := s.Users.GetUserByPreviousUsername(ctx, "ALICE")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, previous.ID)
+
+	// the old username is held for its owner during the grace period
+	available, err := s.Users.IsUsernameAvailable(ctx, "alice", bob.ID)
+	require.NoError(t, err)
+	assert.False(t, available)
+	available, err = s.Users.IsUsernameAvailable(ctx, "alice", alice.ID)
+	require.NoError(t, err)
+	assert.True(t, available)
+
+	for i := 1; i < constants.USERNAME_CHANGE_LIMIT; i++ {
+		_, err = s.Users.ChangeUsername(ctx, alice.ID, fmt.Sprintf("alice%d", i+2))
+		require.NoError(t, err)
+	}
+	_, err = s.Users.ChangeUsername(ctx, alice.ID, "alice_final")
+	assert.ErrorIs(t, err, stores.ErrRateLimited)
+}
+
+func testTweetCRUD(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	tweet := createTweet(t, s, alice.ID, "hello #go")
+	assert.Equal(t, "hello #go", tweet.Content)
+	assert.Equal(t, alice.ID, tweet.Author.ID)
+	assert.Equal(t, 1, getUser(t, s, alice.ID).TweetsCount)
+
+	_, err := s.Tweets.CreateTweet(ctx, &models.Tweet{}, alice.ID)
+	assert.ErrorIs(t, err, stores.ErrValidation)
+
+	content := "edited"
+	_, err = s.Tweets.UpdateTweet(ctx, &models.Tweet{ID: tweet.ID, Content: &content}, bob.ID)
+	assert.ErrorIs(t, err, stores.ErrTweetNotFound)
+	updated, err := s.Tweets.UpdateTweet(ctx, &models.Tweet{ID: tweet.ID, Content: &content}, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, content, updated.Content)
+	assert.Equal(t, content, *getTweet(t, s, tweet.ID, bob.ID).Content)
+
+	assert.ErrorIs(t, s.Tweets.DeleteTweet(ctx, tweet.ID, bob.ID), stores.ErrTweetNotFound)
+	require.NoError(t, s.Tweets.DeleteTweet(ctx, tweet.ID, alice.ID))
+	assert.Zero(t, getUser(t, s, alice.ID).TweetsCount)
+
+	_, err = s.Tweets.GetTweetByID(ctx, tweet.ID, alice.ID)
+	assert.ErrorIs(t, err, stores.ErrTweetNotFound)
+}
+
+func testEngagementCounters(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	tweet := createTweet(t, s, alice.ID, "engage with me")
+
+	for range 2 {
+		require.NoError(t, s.Tweets.LikeTweet(ctx, tweet.ID, bob.ID))
+		require.NoError(t, s.Tweets.Retweet(ctx, tweet.ID, bob.ID))
+		require.NoError(t, s.Tweets.BookmarkTweet(ctx, tweet.ID, bob.ID))
+	}
+	liked := getTweet(t, s, tweet.ID, bob.ID)
+	assert.Equal(t, 1, liked.LikesCount)
+	assert.Equal(t, 1, liked.RetweetsCount)
+
+	tweets, err := s.Tweets.GetUsersWithTweets(ctx, bob.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, tweets, 1)
+	assert.True(t, tweets[0].IsLiked)
+	assert.True(t, tweets[0].IsRetweeted)
+	assert.True(t, tweets[0].IsBookmarked)
+
+	for range 2 {
+		require.NoError(t, s.Tweets.UnlikeTweet(ctx, tweet.ID, bob.ID))
+		require.NoError(t, s.Tweets.Unretweet(ctx, tweet.ID, bob.ID))
+		require.NoError(t, s.Tweets.UnbookmarkTweet(ctx, tweet.ID, bob.ID))
+	}
+	unliked := getTweet(t, s, tweet.ID, bob.ID)
+	assert.Zero(t, unliked.LikesCount)
+	assert.Zero(t, unliked.RetweetsCount)
+
+	assert.ErrorIs(t, s.Tweets.LikeTweet(ctx, "missing", bob.ID), stores.ErrTweetNotFound)
+}
+
+func testQuoteTweet(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	original := createTweet(t, s, alice.ID, "original")
+
+	content := "quoting"
+	quote, err := s.Tweets.QuoteTweet(ctx, original.ID, bob.ID, &models.Tweet{Content: &content})
+	require.NoError(t, err)
+	assert.Equal(t, content, quote.Content)
+	assert.Equal(t, bob.ID, quote.Author.ID)
+
+	quoted := getTweet(t, s, original.ID, bob.ID)
+	assert.Equal(t, 1, quoted.QuotesCount)
+	assert.Zero(t, quoted.RetweetsCount)
+	assert.Equal(t, 1, getUser(t, s, bob.ID).TweetsCount)
+}
+
+func testReplies(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	root := createTweet(t, s, alice.ID, "root")
+
+	content := "first reply"
+	reply, err := s.Tweets.ReplyToTweet(ctx, root.ID, bob.ID, &models.Tweet{Content: &content})
+	require.NoError(t, err)
+	require.NotNil(t, reply.InReplyToID)
+	assert.Equal(t, root.ID, *reply.InReplyToID)
+
+	content = "nested reply"
+	nested, err := s.Tweets.ReplyToTweet(ctx, reply.ID, alice.ID, &models.Tweet{Content: &content})
+	require.NoError(t, err)
+	nestedTweet := getTweet(t, s, nested.ID, bob.ID)
+	require.NotNil(t, nestedTweet.ConversationID)
+	assert.Equal(t, root.ID, *nestedTweet.ConversationID)
+
+	assert.Equal(t, 1, getTweet(t, s, root.ID, bob.ID).RepliesCount)
+	replies, err := s.Tweets.GetReplies(ctx, root.ID, alice.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, replies, 1)
+	assert.Equal(t, reply.ID, replies[0].ID)
+
+	_, err = s.Tweets.ReplyToTweet(ctx, "missing", bob.ID, &models.Tweet{Content: &content})
+	assert.ErrorIs(t, err, stores.ErrTweetNotFound)
+}
+
+func testLockedVisibility(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	tweet := createTweet(t, s, alice.ID, "for followers only")
+	require.NoError(t, s.Users.SetLocked(ctx, alice.ID, true))
+
+	_, err := s.Tweets.GetTweetByID(ctx, tweet.ID, bob.ID)
+	assert.ErrorIs(t, err, stores.ErrTweetNotFound)
+	assert.ErrorIs(t, s.Tweets.LikeTweet(ctx, tweet.ID, bob.ID), stores.ErrTweetNotFound)
+	_, err = s.Tweets.GetProfileTimeline(ctx, models.ProfileTabTweets, alice.ID, bob.ID, "", 10)
+	assert.ErrorIs(t, err, stores.ErrProtected)
+	tweets, err := s.Tweets.GetUsersWithTweets(ctx, bob.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, tweets)
+
+	// the author always sees their own tweets
+	getTweet(t, s, tweet.ID, alice.ID)
+
+	_, err = s.Users.FollowUser(ctx, bob.ID, alice.ID)
+	require.NoError(t, err)
+	require.NoError(t, s.Users.ApproveFollowRequest(ctx, alice.ID, bob.ID))
+	getTweet(t, s, tweet.ID, bob.ID)
+	page, err := s.Tweets.GetProfileTimeline(ctx, models.ProfileTabTweets, alice.ID, bob.ID, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, page.Tweets, 1)
+}
+
+func testBlockedEngagement(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	tweet := createTweet(t, s, alice.ID, "blocked")
+	require.NoError(t, s.Users.BlockUser(ctx, alice.ID, bob.ID))
+
+	assert.ErrorIs(t, s.Tweets.LikeTweet(ctx, tweet.ID, bob.ID), stores.ErrBlocked)
+	assert.ErrorIs(t, s.Tweets.Retweet(ctx, tweet.ID, bob.ID), stores.ErrBlocked)
+	content := "reply"
+	_, err := s.Tweets.ReplyToTweet(ctx, tweet.ID, bob.ID, &models.Tweet{Content: &content})
+	assert.ErrorIs(t, err, stores.ErrBlocked)
+	_, err = s.Tweets.GetTweetByID(ctx, tweet.ID, bob.ID)
+	assert.ErrorIs(t, err, stores.ErrTweetNotFound)
+	_, err = s.Tweets.GetProfileTimeline(ctx, models.ProfileTabTweets, alice.ID, bob.ID, "", 10)
+	assert.ErrorIs(t, err, stores.ErrBlocked)
+	assert.Zero(t, getTweet(t, s, tweet.ID, alice.ID).LikesCount)
+}
+
+func testPinnedTweet(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	first := createTweet(t, s, alice.ID, "first")
+	second := createTweet(t, s, alice.ID, "second")
+
+	assert.ErrorIs(t, s.Tweets.PinTweet(ctx, first.ID, bob.ID), stores.ErrTweetNotFound)
+	require.NoError(t, s.Tweets.PinTweet(ctx, first.ID, alice.ID))
+	require.NoError(t, s.Tweets.PinTweet(ctx, second.ID, alice.ID))
+
+	pinned, err := s.Tweets.GetPinnedTweet(ctx, alice.ID, bob.ID)
+	require.NoError(t, err)
+	require.NotNil(t, pinned)
+	assert.Equal(t, second.ID, pinned.ID)
+	assert.True(t, pinned.IsPinned)
+
+	// the pinned tweet is left out of the tweets tab
+	page, err := s.Tweets.GetProfileTimeline(ctx, models.ProfileTabTweets, alice.ID, bob.ID, "", 10)
+	require.NoError(t, err)
+	require.Len(t, page.Tweets, 1)
+	assert.Equal(t, first.ID, page.Tweets[0].ID)
+
+	require.NoError(t, s.Tweets.UnpinTweet(ctx, alice.ID))
+	pinned, err = s.Tweets.GetPinnedTweet(ctx, alice.ID, bob.ID)
+	require.NoError(t, err)
+	assert.Nil(t, pinned)
+}
+
+func testProfilePaging(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	ids := []string{}
+	for i := range 5 {
+		tweet := createTweet(t, s, alice.ID, fmt.Sprintf("tweet %d", i))
+		ids = append([]string{tweet.ID}, ids...)
+		require.NoError(t, s.Tweets.LikeTweet(ctx, tweet.ID, bob.ID))
+	}
+
+	for _, tab := range []models.ProfileTab{models.ProfileTabTweets, models.ProfileTabLikes} {
+		userID := alice.ID
+		if tab == models.ProfileTabLikes {
+			userID = bob.ID
+		}
+
+		seen := []string{}
+		cursor := ""
+		for {
+			page, err := s.Tweets.GetProfileTimeline(ctx, tab, userID, alice.ID, cursor, 2)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(page.Tweets), 2)
+			for _, tweet := range page.Tweets {
+				seen = append(seen, tweet.ID)
+			}
+			if page.NextCursor == nil {
+				break
+			}
+			cursor = *page.NextCursor
+		}
+		assert.Equal(t, ids, seen, "tab %s", tab)
+	}
+
+	_, err := s.Tweets.GetProfileTimeline(ctx, "unknown", alice.ID, bob.ID, "", 2)
+	assert.ErrorIs(t, err, stores.ErrValidation)
+	_, err = s.Tweets.GetProfileTimeline(ctx, models.ProfileTabTweets, alice.ID, bob.ID, "not a cursor!", 2)
+	assert.ErrorIs(t, err, stores.ErrValidation)
+	for _, limit := range []int{0, -1} {
+		_, err = s.Tweets.GetProfileTimeline(ctx, models.ProfileTabTweets, alice.ID, bob.ID, "", limit)
+		assert.ErrorIs(t, err, stores.ErrValidation, "limit %d", limit)
+	}
+}
+
+func testTimelineCandidates(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+	since := time.Now().Add(-time.Hour)
+
+	_, err := s.Users.FollowUser(ctx, alice.ID, bob.ID)
+	require.NoError(t, err)
+	followed := createTweet(t, s, bob.ID, "from bob")
+	engaged := createTweet(t, s, carol.ID, "liked by bob")
+	require.NoError(t, s.Tweets.LikeTweet(ctx, engaged.ID, bob.ID))
+	createTweet(t, s, alice.ID, "my own tweet")
+
+	candidates, err := s.Tweets.GetTimelineCandidates(ctx, alice.ID, since, 10)
+	require.NoError(t, err)
+
+	byID := map[string]*models.TimelineCandidate{}
+	for _, candidate := range candidates {
+		byID[candidate.Props.ID] = &candidate
+	}
+	assert.Len(t, byID, 2)
+	assert.True(t, byID[followed.ID].HasSource(models.TimelineSourceFollow))
+	assert.True(t, byID[engaged.ID].HasSource(models.TimelineSourceEngagement))
+	assert.Equal(t, 1, byID[engaged.ID].SocialProof)
+}
+
+func testNotifications(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	tweet := createTweet(t, s, alice.ID, "notify me")
+
+	notification := models.NewNotification(alice.ID, bob.ID, &tweet.ID, models.NotificationTypeLike)
+	require.NoError(t, s.Notifications.CreateNotification(ctx, notification))
+
+	notifications, err := s.Notifications.GetNotifications(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, notification.ID, notifications[0].ID)
+	assert.False(t, notifications[0].IsRead)
+
+	assert.ErrorIs(t, s.Notifications.FlagAsRead(ctx, notification.ID, bob.ID), stores.ErrNotFound)
+	assert.ErrorIs(t, s.Notifications.FlagAsRead(ctx, "missing", alice.ID), stores.ErrNotificationNotFound)
+	require.NoError(t, s.Notifications.FlagAsRead(ctx, notification.ID, alice.ID))
+
+	notifications, err = s.Notifications.GetNotifications(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.True(t, notifications[0].IsRead)
+
+	// and so are likes in muted conversations
+	muted := createTweet(t, s, alice.ID, "muted thread")
+	require.NoError(t, s.Notifications.CreateNotification(ctx, models.NewNotification(alice.ID, bob.ID, &muted.ID, models.NotificationTypeLike)))
+	require.NoError(t, s.Users.MuteConversation(ctx, alice.ID, muted.ID))
+	notifications, err = s.Notifications.GetNotifications(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, notification.ID, notifications[0].ID)
+
+	// notifications from muted users are hidden
+	require.NoError(t, s.Users.MuteUser(ctx, alice.ID, bob.ID))
+	notifications, err = s.Notifications.GetNotifications(ctx, alice.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, notifications)
+}
+
+func createSession(t *testing.T, s Stores, userID string, tokenHash string) *models.Session {
+	t.Helper()
+	session, err := s.Sessions.CreateSession(ctx, models.NewSession(userID, tokenHash, "test agent", "127.0.0.1", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	return session
+}
+
+func testSessionRotation(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	session := createSession(t, s, alice.ID, "hash-1")
+	assert.Equal(t, alice.ID, session.UserID)
+	assert.Equal(t, "test agent", session.UserAgent)
+	assert.False(t, session.CreatedAt.IsZero())
+
+	_, err := s.Sessions.CreateSession(ctx, models.NewSession("missing", "hash", "", "", time.Now().Add(time.Hour)))
+	assert.ErrorIs(t, err, stores.ErrUserNotFound)
+
+	rotated, err := s.Sessions.RotateSession(ctx, session.ID, "hash-1", "hash-2", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "hash-2", rotated.TokenHash)
+	assert.Equal(t, "10.0.0.1", rotated.IP)
+	assert.False(t, rotated.LastSeenAt.Before(session.LastSeenAt))
+
+	// a token that was never issued is rejected without touching the session
+	_, err = s.Sessions.RotateSession(ctx, session.ID, "forged", "hash-3", "")
+	assert.ErrorIs(t, err, stores.ErrSessionNotFound)
+	_, err = s.Sessions.RotateSession(ctx, "missing", "hash-2", "hash-3", "")
+	assert.ErrorIs(t, err, stores.ErrSessionNotFound)
+
+	// presenting the rotated token again revokes the session for everyone
+	_, err = s.Sessions.RotateSession(ctx, session.ID, "hash-1", "hash-3", "")
+	assert.ErrorIs(t, err, stores.ErrRefreshTokenReused)
+	_, err = s.Sessions.RotateSession(ctx, session.ID, "hash-2", "hash-3", "")
+	assert.ErrorIs(t, err, stores.ErrSessionNotFound)
+
+	revoked, err := s.Sessions.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.False(t, revoked.IsActive(time.Now()))
+}
+
+func testSessionReuse(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	session := createSession(t, s, alice.ID, "hash-1")
+	_, err := s.Sessions.RotateSession(ctx, session.ID, "hash-1", "hash-2", "")
+	require.NoError(t, err)
+	_, err = s.Sessions.RotateSession(ctx, session.ID, "hash-2", "hash-3", "")
+	require.NoError(t, err)
+
+	// a token from two rotations back is just as much a copy as the last one
+	_, err = s.Sessions.RotateSession(ctx, session.ID, "hash-1", "hash-4", "")
+	assert.ErrorIs(t, err, stores.ErrRefreshTokenReused)
+	_, err = s.Sessions.RotateSession(ctx, session.ID, "hash-3", "hash-4", "")
+	assert.ErrorIs(t, err, stores.ErrSessionNotFound)
+
+	// only the last constants.SPENT_REFRESH_TOKENS hashes are remembered
+	other := createSession(t, s, alice.ID, "other-0")
+	for i := range constants.SPENT_REFRESH_TOKENS + 1 {
+		_, err := s.Sessions.RotateSession(ctx, other.ID, fmt.Sprintf("other-%d", i), fmt.Sprintf("other-%d", i+1), "")
+		require.NoError(t, err)
+	}
+	_, err = s.Sessions.RotateSession(ctx, other.ID, "other-0", "forged", "")
+	assert.ErrorIs(t, err, stores.ErrSessionNotFound)
+	_, err = s.Sessions.RotateSession(ctx, other.ID, "other-1", "forged", "")
+	assert.ErrorIs(t, err, stores.ErrRefreshTokenReused)
+}
+
+func testSessionRevocation(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	session := createSession(t, s, alice.ID, "hash-1")
+
+	// only the owner can revoke a session
+	assert.ErrorIs(t, s.Sessions.RevokeSession(ctx, bob.ID, session.ID), stores.ErrSessionNotFound)
+	assert.ErrorIs(t, s.Sessions.RevokeSession(ctx, alice.ID, "missing"), stores.ErrSessionNotFound)
+
+	require.NoError(t, s.Sessions.RevokeSession(ctx, alice.ID, session.ID))
+	require.NoError(t, s.Sessions.RevokeSession(ctx, alice.ID, session.ID))
+	_, err := s.Sessions.RotateSession(ctx, session.ID, "hash-1", "hash-2", "")
+	assert.ErrorIs(t, err, stores.ErrSessionNotFound)
+
+	// expired sessions can't be refreshed either
+	expired, err := s.Sessions.CreateSession(ctx, models.NewSession(alice.ID, "hash-1", "", "", time.Now().Add(-time.Minute)))
+	require.NoError(t, err)
+	_, err = s.Sessions.RotateSession(ctx, expired.ID, "hash-1", "hash-2", "")
+	assert.ErrorIs(t, err, stores.ErrSessionNotFound)
+}
+
+func testSessionList(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	laptop := createSession(t, s, alice.ID, "laptop")
+	phone := createSession(t, s, alice.ID, "phone")
+	tablet := createSession(t, s, alice.ID, "tablet")
+	createSession(t, s, bob.ID, "bob")
+
+	// using the laptop makes it the most recent
+	_, err := s.Sessions.RotateSession(ctx, laptop.ID, "laptop", "laptop-2", "10.0.0.1")
+	require.NoError(t, err)
+
+	sessions, err := s.Sessions.ListSessions(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3)
+	assert.Equal(t, laptop.ID, sessions[0].ID)
+	assert.Equal(t, "10.0.0.1", sessions[0].IP)
+
+	require.NoError(t, s.Sessions.RevokeSession(ctx, alice.ID, tablet.ID))
+	revoked, err := s.Sessions.RevokeSessions(ctx, alice.ID, laptop.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{phone.ID}, revoked, "only active sessions are revoked")
+
+	sessions, err = s.Sessions.ListSessions(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, laptop.ID, sessions[0].ID)
+
+	sessions, err = s.Sessions.ListSessions(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 1, "other users' sessions are untouched")
+
+	revoked, err = s.Sessions.RevokeSessions(ctx, alice.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{laptop.ID}, revoked)
+}
+
+func testIdentities(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	_, err := s.Identities.GetIdentity(ctx, "google", "1")
+	assert.ErrorIs(t, err, stores.ErrIdentityNotFound)
+
+	linked, err := s.Identities.LinkIdentity(ctx, &models.AuthIdentity{Provider: "google", Subject: "1", Email: "alice@gmail.com", UserID: alice.ID})
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, linked.UserID)
+	assert.False(t, linked.CreatedAt.IsZero())
+
+	// linking again is a no-op, linking to someone else is a conflict
+	_, err = s.Identities.LinkIdentity(ctx, &models.AuthIdentity{Provider: "google", Subject: "1", UserID: alice.ID})
+	require.NoError(t, err)
+	_, err = s.Identities.LinkIdentity(ctx, &models.AuthIdentity{Provider: "google", Subject: "1", UserID: bob.ID})
+	assert.ErrorIs(t, err, stores.ErrIdentityLinked)
+	_, err = s.Identities.LinkIdentity(ctx, &models.AuthIdentity{Provider: "google", Subject: "2", UserID: "missing"})
+	assert.ErrorIs(t, err, stores.ErrUserNotFound)
+
+	// the same subject at another provider is another identity
+	_, err = s.Identities.LinkIdentity(ctx, &models.AuthIdentity{Provider: "github", Subject: "1", UserID: bob.ID})
+	require.NoError(t, err)
+
+	found, err := s.Identities.GetIdentity(ctx, "google", "1")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, found.UserID)
+	assert.Equal(t, "alice@gmail.com", found.Email)
+
+	identities, err := s.Identities.ListIdentities(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "google", identities[0].Provider)
+
+	// deleting the user frees the identity
+	require.NoError(t, s.Users.DeleteUser(ctx, alice.ID))
+	_, err = s.Identities.GetIdentity(ctx, "google", "1")
+	assert.ErrorIs(t, err, stores.ErrIdentityNotFound)
+	_, err = s.Identities.LinkIdentity(ctx, &models.AuthIdentity{Provider: "google", Subject: "1", UserID: bob.ID})
+	require.NoError(t, err)
+}
+
+func testTwoFactor(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+
+	_, err := s.TwoFactor.GetTwoFactor(ctx, alice.ID)
+	assert.ErrorIs(t, err, stores.ErrTwoFactorNotFound)
+	assert.ErrorIs(t, s.TwoFactor.EnableTwoFactor(ctx, alice.ID, []string{"a"}), stores.ErrTwoFactorNotFound)
+	assert.ErrorIs(t, s.TwoFactor.StartTwoFactor(ctx, "missing", "secret"), stores.ErrUserNotFound)
+
+	// a pending secret can be replaced until it is enabled
+	require.NoError(t, s.TwoFactor.StartTwoFactor(ctx, alice.ID, "first"))
+	require.NoError(t, s.TwoFactor.StartTwoFactor(ctx, alice.ID, "second"))
+	pending, err := s.TwoFactor.GetTwoFactor(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "second", pending.Secret)
+	assert.False(t, pending.Enabled)
+	assert.ErrorIs(t, s.TwoFactor.UseRecoveryCode(ctx, alice.ID, "a"), stores.ErrTwoFactorNotFound, "pending authenticators have no recovery codes")
+
+	require.NoError(t, s.TwoFactor.UseTOTPStep(ctx, alice.ID, 100))
+	require.NoError(t, s.TwoFactor.EnableTwoFactor(ctx, alice.ID, []string{"a", "b"}))
+	assert.ErrorIs(t, s.TwoFactor.EnableTwoFactor(ctx, alice.ID, []string{"c"}), stores.ErrTwoFactorEnabled)
+	assert.ErrorIs(t, s.TwoFactor.StartTwoFactor(ctx, alice.ID, "third"), stores.ErrTwoFactorEnabled)
+
+	enabled, err := s.TwoFactor.GetTwoFactor(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.True(t, enabled.Enabled)
+	assert.NotNil(t, enabled.EnabledAt)
+	assert.Equal(t, "second", enabled.Secret)
+	assert.ElementsMatch(t, []string{"a", "b"}, enabled.RecoveryCodes)
+
+	// codes work once, and never for an earlier step
+	assert.ErrorIs(t, s.TwoFactor.UseTOTPStep(ctx, alice.ID, 100), stores.ErrTOTPCodeUsed)
+	assert.ErrorIs(t, s.TwoFactor.UseTOTPStep(ctx, alice.ID, 99), stores.ErrTOTPCodeUsed)
+	require.NoError(t, s.TwoFactor.UseTOTPStep(ctx, alice.ID, 101))
+
+	require.NoError(t, s.TwoFactor.UseRecoveryCode(ctx, alice.ID, "a"))
+	assert.ErrorIs(t, s.TwoFactor.UseRecoveryCode(ctx, alice.ID, "a"), stores.ErrRecoveryCodeInvalid)
+	require.NoError(t, s.TwoFactor.SetRecoveryCodes(ctx, alice.ID, []string{"x", "y", "z"}))
+	assert.ErrorIs(t, s.TwoFactor.UseRecoveryCode(ctx, alice.ID, "b"), stores.ErrRecoveryCodeInvalid)
+	require.NoError(t, s.TwoFactor.UseRecoveryCode(ctx, alice.ID, "y"))
+	enabled, err = s.TwoFactor.GetTwoFactor(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"x", "z"}, enabled.RecoveryCodes)
+
+	require.NoError(t, s.TwoFactor.DisableTwoFactor(ctx, alice.ID))
+	assert.ErrorIs(t, s.TwoFactor.DisableTwoFactor(ctx, alice.ID), stores.ErrTwoFactorNotFound)
+	_, err = s.TwoFactor.GetTwoFactor(ctx, alice.ID)
+	assert.ErrorIs(t, err, stores.ErrTwoFactorNotFound)
+
+	// deleting the user deletes the authenticator
+	require.NoError(t, s.TwoFactor.StartTwoFactor(ctx, alice.ID, "secret"))
+	require.NoError(t, s.Users.DeleteUser(ctx, alice.ID))
+	_, err = s.TwoFactor.GetTwoFactor(ctx, alice.ID)
+	assert.ErrorIs(t, err, stores.ErrTwoFactorNotFound)
+}
+
+func testAPITokens(t *testing.T, s Stores) {
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	_, err := s.APITokens.CreateAPIToken(ctx, models.NewAPIToken("missing", "bot", []string{constants.SCOPE_READ}))
+	assert.ErrorIs(t, err, stores.ErrUserNotFound)
+
+	first := models.NewAPIToken(alice.ID, "reader", []string{constants.SCOPE_READ})
+	first.TokenHash = "hash-1"
+	created, err := s.APITokens.CreateAPIToken(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, created.UserID)
+	assert.Equal(t, []string{constants.SCOPE_READ}, created.Scopes)
+	assert.False(t, created.CreatedAt.IsZero())
+	assert.Nil(t, created.LastUsedAt)
+
+	second := models.NewAPIToken(alice.ID, "poster", []string{constants.SCOPE_READ, constants.SCOPE_TWEET_WRITE})
+	second.TokenHash = "hash-2"
+	_, err = s.APITokens.CreateAPIToken(ctx, second)
+	require.NoError(t, err)
+
+	// the hash has to match
+	_, err = s.APITokens.UseAPIToken(ctx, first.ID, "hash-2")
+	assert.ErrorIs(t, err, stores.ErrAPITokenNotFound)
+	_, err = s.APITokens.UseAPIToken(ctx, "missing", "hash-1")
+	assert.ErrorIs(t, err, stores.ErrAPITokenNotFound)
+	used, err := s.APITokens.UseAPIToken(ctx, first.ID, "hash-1")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, used.UserID)
+	assert.NotNil(t, used.LastUsedAt)
+
+	tokens, err := s.APITokens.ListAPITokens(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, second.ID, tokens[0].ID, "newest first")
+	assert.ElementsMatch(t, []string{constants.SCOPE_READ, constants.SCOPE_TWEET_WRITE}, tokens[0].Scopes)
+	assert.NotNil(t, tokens[1].LastUsedAt)
+
+	// only the owner can delete a token
+	assert.ErrorIs(t, s.APITokens.DeleteAPIToken(ctx, bob.ID, first.ID), stores.ErrAPITokenNotFound)
+	require.NoError(t, s.APITokens.DeleteAPIToken(ctx, alice.ID, first.ID))
+	assert.ErrorIs(t, s.APITokens.DeleteAPIToken(ctx, alice.ID, first.ID), stores.ErrAPITokenNotFound)
+	_, err = s.APITokens.UseAPIToken(ctx, first.ID, "hash-1")
+	assert.ErrorIs(t, err, stores.ErrAPITokenNotFound)
+
+	// deleting all of a user's tokens leaves other users' tokens alone
+	third := models.NewAPIToken(bob.ID, "bob's", []string{constants.SCOPE_READ})
+	third.TokenHash = "hash-3"
+	_, err = s.APITokens.CreateAPIToken(ctx, third)
+	require.NoError(t, err)
+	require.NoError(t, s.APITokens.DeleteAPITokens(ctx, bob.ID))
+	_, err = s.APITokens.UseAPIToken(ctx, third.ID, "hash-3")
+	assert.ErrorIs(t, err, stores.ErrAPITokenNotFound)
+	_, err = s.APITokens.UseAPIToken(ctx, second.ID, "hash-2")
+	require.NoError(t, err)
+
+	// deleting the user deletes their tokens
+	require.NoError(t, s.Users.DeleteUser(ctx, alice.ID))
+	_, err = s.APITokens.UseAPIToken(ctx, second.ID, "hash-2")
+	assert.ErrorIs(t, err, stores.ErrAPITokenNotFound)
+}
@@ -13,43 +13,46 @@ import (
 )
 
 type TweetStore interface {
-	GetTweets(limit int, offset int) ([]*models.Tweet, error)
-
-	GetTweetByID(id string) (*models.Tweet, error)
-	CreateTweet(tweet *models.Tweet, userID string) (*models.TweetProps, error)
-	UpdateTweet(tweet *models.Tweet, userID string) (*models.TweetProps, error)
-	DeleteTweet(tweetID string, userID string) error
-	LikeTweet(tweetID string, userID string) error
-	UnlikeTweet(tweetID string, userID string) error
-	Retweet(tweetID string, userID string) error
-	Unretweet(tweetID string, userID string) error
-	QuoteTweet(originalTweetID string, userID string, quotedTweet *models.Tweet) (*models.TweetProps, error)
-	BookmarkTweet(tweetID string, userID string) error
-	UnbookmarkTweet(tweetID string, userID string) error
-	GetUsersWithTweets(currUserID string, limit int, offset int) ([]models.TweetProps, error)
-	// ReplyToTweet(tweetID string, userID string, content string) (*models.Tweet, error)
-	// GetReplies(tweetID string, limit int, offset int) ([]*models.Tweet, error)
+	GetTweets(ctx context.Context, limit int, offset int) ([]*models.Tweet, error)
+
+	GetTweetByID(ctx context.Context, id string, currUserID string) (*models.Tweet, error)
+	CreateTweet(ctx context.Context, tweet *models.Tweet, userID string) (*models.TweetProps, error)
+	UpdateTweet(ctx context.Context, tweet *models.Tweet, userID string) (*models.TweetProps, error)
+	DeleteTweet(ctx context.Context, tweetID string, userID string) error
+	LikeTweet(ctx context.Context, tweetID string, userID string) error
+	UnlikeTweet(ctx context.Context, tweetID string, userID string) error
+	Retweet(ctx context.Context, tweetID string, userID string) error
+	Unretweet(ctx context.Context, tweetID string, userID string) error
+	QuoteTweet(ctx context.Context, originalTweetID string, userID string, quotedTweet *models.Tweet) (*models.TweetProps, error)
+	BookmarkTweet(ctx context.Context, tweetID string, userID string) error
+	UnbookmarkTweet(ctx context.Context, tweetID string, userID string) error
+	GetUsersWithTweets(ctx context.Context, currUserID string, limit int, offset int) ([]models.TweetProps, error)
+	GetTimelineCandidates(ctx context.Context, currUserID string, since time.Time, limit int) ([]models.TimelineCandidate, error)
+	ReplyToTweet(ctx context.Context, tweetID string, userID string, reply *models.Tweet) (*models.TweetProps, error)
+	GetReplies(ctx context.Context, tweetID string, currUserID string, limit int, offset int) ([]models.TweetProps, error)
+	PinTweet(ctx context.Context, tweetID string, userID string) error
+	UnpinTweet(ctx context.Context, userID string) error
+	GetPinnedTweet(ctx context.Context, userID string, currUserID string) (*models.TweetProps, error)
+	GetProfileTimeline(ctx context.Context, tab models.ProfileTab, userID string, currUserID string, cursor string, limit int) (*models.TweetPage, error)
 }
 
 type tweetStore struct {
 	driver               *neo4j.DriverWithContext
-	dbCtx                *context.Context
 	notificationsService services.Notifications
 	feedService          services.Feed
 }
 
-func NewTweetStore(driver *neo4j.DriverWithContext, dbCtx *context.Context, notificationsService services.Notifications, feedService services.Feed) TweetStore {
+func NewTweetStore(driver *neo4j.DriverWithContext, notificationsService services.Notifications, feedService services.Feed) TweetStore {
 	return &tweetStore{
 		driver:               driver,
-		dbCtx:                dbCtx,
 		notificationsService: notificationsService,
 		feedService:          feedService,
 	}
 }
 
-func (s *tweetStore) GetTweets(limit int, offset int) ([]*models.Tweet, error) {
+func (s *tweetStore) GetTweets(ctx context.Context, limit int, offset int) ([]*models.Tweet, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (t:Tweet) LIMIT $limit OFFSET $offset RETURN t`,
 		map[string]any{"limit": limit, "offset": offset},
@@ -72,12 +75,14 @@ func (s *tweetStore) GetTweets(limit int, offset int) ([]*models.Tweet, error) {
 	return tweets, nil
 }
 
-func (s *tweetStore) GetTweetByID(id string) (*models.Tweet, error) {
+func (s *tweetStore) GetTweetByID(ctx context.Context, id string, currUserID string) (*models.Tweet, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
-		`MATCH (t:Tweet {id: $id}) RETURN t`,
-		map[string]any{"id": id},
+		`MATCH (u:User)-[:TWEETS]->(t:Tweet {id: $id})
+		WHERE `+notBlockedPredicate+` AND `+notProtectedPredicate+`
+		RETURN t`,
+		map[string]any{"id": id, "currUserID": currUserID},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
@@ -92,11 +97,32 @@ func (s *tweetStore) GetTweetByID(id string) (*models.Tweet, error) {
 	return tweet, nil
 }
 
-func (s *tweetStore) CreateTweet(tweet *models.Tweet, userID string) (*models.TweetProps, error) {
+func (s *tweetStore) CreateTweet(ctx context.Context, tweet *models.Tweet, userID string) (*models.TweetProps, error) {
+	var createdTweet *models.Tweet
+	tweetProps, err := executeWrite(ctx, *s.driver, func(tx neo4j.ManagedTransaction) (*models.TweetProps, error) {
+		var err error
+		var props *models.TweetProps
+		createdTweet, props, err = createTweetInTx(ctx, tx, tweet, userID)
+		return props, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishTweetCreated(createdTweet, tweetProps, userID)
+	return tweetProps, nil
+}
+
+// createTweetInTx creates a tweet authored by userID as part of a larger unit
+// of work
+func createTweetInTx(ctx context.Context, tx neo4j.ManagedTransaction, tweet *models.Tweet, userID string) (*models.Tweet, *models.TweetProps, error) {
+	if tweet.Content == nil {
+		return nil, nil, NewValidationError("content", "content is required")
+	}
 	hashtags := extractHashtagsFromContent(*tweet.Content)
-	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
-		*s.driver,
+	res, err := runInTx(
+		ctx,
+		tx,
 		`
 		MATCH (u:User {id: $userID})
 		WITH u
@@ -114,47 +140,48 @@ func (s *tweetStore) CreateTweet(tweet *models.Tweet, userID string) (*models.Tw
 			mediaURLs: $mediaURLs
 		})
 		MERGE (u)-[:TWEETS]->(t)
+		SET u.tweetsCount = u.tweetsCount + 1
 		RETURN t, u
 		`,
 		map[string]any{"id": uuid.New().String(), "content": tweet.Content, "hashtags": hashtags, "mediaURLs": tweet.MediaURLs, "userID": userID},
-		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(res.Records) == 0 {
-		return nil, fmt.Errorf("no tweet created")
+		return nil, nil, ErrUserNotFound
 	}
 
 	tweetNode, okT := res.Records[0].Get("t")
 	userNode, okU := res.Records[0].Get("u")
 	if !okT || !okU {
-		return nil, fmt.Errorf("failed to extract tweet or user node")
+		return nil, nil, fmt.Errorf("failed to extract tweet or user node")
 	}
 
 	createdTweet := extractTweetFromNode(tweetNode)
 	user := extractUserFromNode(userNode)
 
-	// Convert to TweetProps using utility function
-	tweetProps := convertTweetToProps(createdTweet, user, false, false, false)
+	return createdTweet, models.NewTweetProps(createdTweet, user, false, false, false), nil
+}
 
-	// Publish feed event for tweet creation (to author only for now)
-	if s.feedService != nil {
-		event := &models.FeedEvent{
-			Type:      models.FeedEventCreated,
-			Tweet:     *tweetProps,
-			ActorID:   userID,
-			CreatedAt: createdTweet.CreatedAt,
-		}
-		s.feedService.PublishToAll(event)
-	}
-	return tweetProps, nil
+// publishTweetCreated publishes the feed event for a committed tweet (to
+// author only for now)
+func (s *tweetStore) publishTweetCreated(createdTweet *models.Tweet, tweetProps *models.TweetProps, userID string) {
+	if s.feedService == nil {
+		return
+	}
+	s.feedService.PublishToAll(&models.FeedEvent{
+		Type:      models.FeedEventCreated,
+		Tweet:     *tweetProps,
+		ActorID:   userID,
+		CreatedAt: createdTweet.CreatedAt,
+	})
 }
 
-func (s *tweetStore) UpdateTweet(tweet *models.Tweet, userID string) (*models.TweetProps, error) {
+func (s *tweetStore) UpdateTweet(ctx context.Context, tweet *models.Tweet, userID string) (*models.TweetProps, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (u:User {id: $userID})-[:TWEETS]->(t:Tweet {id: $id}) 
 		SET t.content = $content, t.updatedAt = datetime() 
@@ -167,7 +194,7 @@ func (s *tweetStore) UpdateTweet(tweet *models.Tweet, userID string) (*models.Tw
 	}
 
 	if len(res.Records) == 0 {
-		return nil, fmt.Errorf("no tweet found or user not authorized")
+		return nil, ErrTweetNotFound
 	}
 
 	tweetNode, okT := res.Records[0].Get("t")
@@ -179,47 +206,49 @@ func (s *tweetStore) UpdateTweet(tweet *models.Tweet, userID string) (*models.Tw
 	updatedTweet := extractTweetFromNode(tweetNode)
 	user := extractUserFromNode(userNode)
 
-	// Convert to TweetProps using utility function
-	tweetProps := convertTweetToProps(updatedTweet, user, false, false, false)
+	tweetProps := models.NewTweetProps(updatedTweet, user, false, false, false)
 
 	return tweetProps, nil
 }
 
-func (s *tweetStore) DeleteTweet(tweetID string, userID string) error {
-	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+func (s *tweetStore) DeleteTweet(ctx context.Context, tweetID string, userID string) error {
+	// DETACH also removes the PINNED relationship, which unpins the tweet
+	res, err := neo4j.ExecuteQuery(
+		ctx,
 		*s.driver,
-		`MATCH (t:Tweet {id: $id}) DETACH DELETE t`,
-		map[string]any{"id": tweetID},
+		`MATCH (u:User {id: $userID})-[:TWEETS]->(t:Tweet {id: $id})
+		SET u.tweetsCount = u.tweetsCount - 1
+		DETACH DELETE t
+		RETURN u.id AS id`,
+		map[string]any{"id": tweetID, "userID": userID},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
 		return err
 	}
 
+	if len(res.Records) == 0 {
+		return ErrTweetNotFound
+	}
+
 	return nil
 }
 
-func (s *tweetStore) LikeTweet(tweetID string, userID string) error {
-	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
-		*s.driver,
-		`
-		MATCH (u:User {id: $userID}), (t:Tweet {id: $tweetID})
-		MERGE (u)-[l:LIKES]->(t)
-		SET t.likesCount = t.likesCount + 1
-		`,
-		map[string]any{"userID": userID, "tweetID": tweetID},
-		neo4j.EagerResultTransformer,
-	)
+func (s *tweetStore) LikeTweet(ctx context.Context, tweetID string, userID string) error {
+	authorID, conversationID, err := s.engageWithTweet(ctx, tweetID, userID, `
+		MERGE (curr)-[l:LIKES]->(t)
+		ON CREATE SET l.createdAt = datetime(), t.likesCount = t.likesCount + 1
+	`)
 	if err != nil {
 		return err
 	}
 
-	s.notificationsService.Publish(models.NotificationTypeLike, models.NewNotification(userID, tweetID, nil, models.NotificationTypeLike))
+	notification := models.NewNotification(authorID, userID, &tweetID, models.NotificationTypeLike)
+	notification.ConversationID = &conversationID
+	s.notificationsService.Publish(models.NotificationTypeLike, notification)
 	// Publish feed event for like (to tweet author)
 	if s.feedService != nil {
-		tweetProps, err := s.getTweetPropsWithUser(tweetID, userID)
+		tweetProps, err := s.getTweetPropsWithUser(ctx, tweetID, userID)
 		if err == nil && tweetProps != nil {
 			event := &models.FeedEvent{
 				Type:      models.FeedEventLiked,
@@ -235,9 +264,9 @@ func (s *tweetStore) LikeTweet(tweetID string, userID string) error {
 	return nil
 }
 
-func (s *tweetStore) UnlikeTweet(tweetID string, userID string) error {
+func (s *tweetStore) UnlikeTweet(ctx context.Context, tweetID string, userID string) error {
 	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (u:User {id: $userID})-[l:LIKES]->(t:Tweet {id: $tweetID}) DELETE l
 		SET t.likesCount = t.likesCount - 1
@@ -252,25 +281,19 @@ func (s *tweetStore) UnlikeTweet(tweetID string, userID string) error {
 	return nil
 }
 
-func (s *tweetStore) Retweet(tweetID string, userID string) error {
-	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
-		*s.driver,
-		`MATCH (u:User {id: $userID}), (t:Tweet {id: $tweetID})
-		MERGE (u)-[r:RETWEETS]->(t)
-		SET t.retweetsCount = t.retweetsCount + 1
-		`,
-		map[string]any{"userID": userID, "tweetID": tweetID},
-		neo4j.EagerResultTransformer,
-	)
+func (s *tweetStore) Retweet(ctx context.Context, tweetID string, userID string) error {
+	authorID, _, err := s.engageWithTweet(ctx, tweetID, userID, `
+		MERGE (curr)-[r:RETWEETS]->(t)
+		ON CREATE SET r.createdAt = datetime(), t.retweetsCount = t.retweetsCount + 1
+	`)
 	if err != nil {
 		return err
 	}
 
-	s.notificationsService.Publish(models.NotificationTypeRetweet, models.NewNotification(userID, tweetID, nil, models.NotificationTypeRetweet))
+	s.notificationsService.Publish(models.NotificationTypeRetweet, models.NewNotification(authorID, userID, &tweetID, models.NotificationTypeRetweet))
 	// Publish feed event for retweet (to tweet author)
 	if s.feedService != nil {
-		tweetProps, err := s.getTweetPropsWithUser(tweetID, userID)
+		tweetProps, err := s.getTweetPropsWithUser(ctx, tweetID, userID)
 		if err == nil && tweetProps != nil {
 			event := &models.FeedEvent{
 				Type:      models.FeedEventRetweeted,
@@ -286,9 +309,9 @@ func (s *tweetStore) Retweet(tweetID string, userID string) error {
 	return nil
 }
 
-func (s *tweetStore) Unretweet(tweetID string, userID string) error {
+func (s *tweetStore) Unretweet(ctx context.Context, tweetID string, userID string) error {
 	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (u:User {id: $userID})-[r:RETWEETS]->(t:Tweet {id: $tweetID}) DELETE r
 		SET t.retweetsCount = t.retweetsCount - 1
@@ -304,41 +327,212 @@ func (s *tweetStore) Unretweet(tweetID string, userID string) error {
 	return nil
 }
 
-func (s *tweetStore) QuoteTweet(originalTweetID string, userID string, quotedTweet *models.Tweet) (*models.TweetProps, error) {
-	// create the new tweet (the quote tweet)
-	createdTweet, err := s.CreateTweet(quotedTweet, userID)
+// QuoteTweet creates the quote and links it to the original in one
+// transaction, so a failed link doesn't leave a stray tweet behind
+func (s *tweetStore) QuoteTweet(ctx context.Context, originalTweetID string, userID string, quotedTweet *models.Tweet) (*models.TweetProps, error) {
+	var authorID string
+	var createdTweet *models.Tweet
+	tweetProps, err := executeWrite(ctx, *s.driver, func(tx neo4j.ManagedTransaction) (*models.TweetProps, error) {
+		res, err := runInTx(ctx, tx, tweetAuthorQuery, map[string]any{"currUserID": userID, "tweetID": originalTweetID})
+		if err != nil {
+			return nil, err
+		}
+		authorID, err = extractAuthorIDFromEagerResult(res)
+		if err != nil {
+			return nil, err
+		}
+
+		// create the new tweet (the quote tweet)
+		var props *models.TweetProps
+		createdTweet, props, err = createTweetInTx(ctx, tx, quotedTweet, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		// create a QUOTES relationship from the new tweet to the original tweet
+		_, err = runInTx(
+			ctx,
+			tx,
+			`MATCH (qt:Tweet {id: $quoteTweetID}), (ot:Tweet {id: $originalTweetID})
+			MERGE (qt)-[:QUOTES]->(ot)
+			SET ot.quotesCount = ot.quotesCount + 1
+			`,
+			map[string]any{"quoteTweetID": props.ID, "originalTweetID": originalTweetID},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return props, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// create a QUOTES relationship from the new tweet to the original tweet
-	_, err = neo4j.ExecuteQuery(
-		*s.dbCtx,
+	s.publishTweetCreated(createdTweet, tweetProps, userID)
+	s.notificationsService.Publish(models.NotificationTypeRetweet, models.NewNotification(authorID, userID, &originalTweetID, models.NotificationTypeRetweet))
+
+	return tweetProps, nil
+}
+
+func (s *tweetStore) BookmarkTweet(ctx context.Context, tweetID string, userID string) error {
+	_, _, err := s.engageWithTweet(ctx, tweetID, userID, `
+		MERGE (curr)-[b:BOOKMARKS]->(t)
+		ON CREATE SET b.createdAt = datetime(), t.bookmarksCount = coalesce(t.bookmarksCount, 0) + 1
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *tweetStore) UnbookmarkTweet(ctx context.Context, tweetID string, userID string) error {
+	_, err := neo4j.ExecuteQuery(
+		ctx,
 		*s.driver,
-		`MATCH (qt:Tweet {id: $quoteTweetID}), (ot:Tweet {id: $originalTweetID})
-		MERGE (qt)-[:QUOTES]->(ot)
-		SET ot.retweetsCount = ot.retweetsCount + 1
+		`MATCH (u:User {id: $userID})-[b:BOOKMARKS]->(t:Tweet {id: $tweetID}) DELETE b
+		SET t.bookmarksCount = t.bookmarksCount - 1
 		`,
-		map[string]any{"quoteTweetID": createdTweet.ID, "originalTweetID": originalTweetID},
+		map[string]any{"userID": userID, "tweetID": tweetID},
 		neo4j.EagerResultTransformer,
 	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *tweetStore) GetUsersWithTweets(ctx context.Context, currUserID string, limit int, offset int) ([]models.TweetProps, error) {
+	query := `
+		MATCH (u:User)-[:TWEETS]->(t:Tweet)
+		WHERE ` + notBlockedPredicate + ` AND ` + notProtectedPredicate + ` AND ` + notMutedPredicate + `
+		OPTIONAL MATCH (curr:User {id: $currUserID})
+		OPTIONAL MATCH (curr)-[l:LIKES]->(t)
+		OPTIONAL MATCH (curr)-[r:RETWEETS]->(t)
+		OPTIONAL MATCH (curr)-[b:BOOKMARKS]->(t)
+		WITH u, t, l, r, b
+		ORDER BY t.createdAt DESC
+		SKIP $offset LIMIT $limit
+		RETURN u, t, l IS NOT NULL AS isLiked, r IS NOT NULL AS isRetweeted, b IS NOT NULL AS isBookmarked
+	`
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		query,
+		map[string]any{"currUserID": currUserID, "limit": limit, "offset": offset},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return extractTweetPropsFromEagerResult(res), nil
+}
+
+func (s *tweetStore) ReplyToTweet(ctx context.Context, tweetID string, userID string, reply *models.Tweet) (*models.TweetProps, error) {
+	var createdTweet *models.Tweet
+	var res *neo4j.EagerResult
+	tweetProps, err := executeWrite(ctx, *s.driver, func(tx neo4j.ManagedTransaction) (*models.TweetProps, error) {
+		authorRes, err := runInTx(ctx, tx, tweetAuthorQuery, map[string]any{"currUserID": userID, "tweetID": tweetID})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := extractAuthorIDFromEagerResult(authorRes); err != nil {
+			return nil, err
+		}
+
+		// create the reply as a regular tweet
+		var props *models.TweetProps
+		createdTweet, props, err = createTweetInTx(ctx, tx, reply, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		// link it into the conversation of the parent tweet
+		res, err = runInTx(
+			ctx,
+			tx,
+			`MATCH (u:User {id: $userID})-[:TWEETS]->(t:Tweet {id: $replyID})
+			MATCH (author:User)-[:TWEETS]->(parent:Tweet {id: $parentID})
+			MERGE (t)-[:REPLIES_TO]->(parent)
+			SET t.inReplyToID = parent.id,
+				t.conversationID = coalesce(parent.conversationID, parent.id),
+				parent.repliesCount = parent.repliesCount + 1
+			RETURN author.id AS authorID, t.conversationID AS conversationID,
+				EXISTS { MATCH (author)-[:MUTES_CONVERSATION]->(:Tweet {id: t.conversationID}) } OR
+				EXISTS { MATCH (author)-[:MUTES]->(u) } AS muted`,
+			map[string]any{"userID": userID, "replyID": props.ID, "parentID": tweetID},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Records) == 0 {
+			return nil, ErrTweetNotFound
+		}
+
+		return props, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	s.notificationsService.Publish(models.NotificationTypeRetweet, models.NewNotification(userID, originalTweetID, nil, models.NotificationTypeRetweet))
+	tweetProps.InReplyToID = &tweetID
+	s.publishTweetCreated(createdTweet, tweetProps, userID)
+
+	authorID, _ := res.Records[0].Get("authorID")
+	conversationID, _ := res.Records[0].Get("conversationID")
+	muted, _ := res.Records[0].Get("muted")
+	if authorID.(string) != userID && !muted.(bool) {
+		notification := models.NewNotification(authorID.(string), userID, &tweetProps.ID, models.NotificationTypeReply)
+		notification.ConversationID = toStringPtr(conversationID)
+		s.notificationsService.Publish(models.NotificationTypeReply, notification)
+	}
+
+	return tweetProps, nil
+}
+
+func (s *tweetStore) GetReplies(ctx context.Context, tweetID string, currUserID string, limit int, offset int) ([]models.TweetProps, error) {
+	query := `
+		MATCH (u:User)-[:TWEETS]->(t:Tweet)-[:REPLIES_TO]->(:Tweet {id: $tweetID})
+		WHERE ` + notBlockedPredicate + ` AND ` + notProtectedPredicate + ` AND ` + notMutedPredicate + `
+		OPTIONAL MATCH (curr:User {id: $currUserID})
+		OPTIONAL MATCH (curr)-[l:LIKES]->(t)
+		OPTIONAL MATCH (curr)-[r:RETWEETS]->(t)
+		OPTIONAL MATCH (curr)-[b:BOOKMARKS]->(t)
+		WITH u, t, l, r, b
+		ORDER BY t.createdAt ASC
+		SKIP $offset LIMIT $limit
+		RETURN u, t, l IS NOT NULL AS isLiked, r IS NOT NULL AS isRetweeted, b IS NOT NULL AS isBookmarked
+	`
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		query,
+		map[string]any{"tweetID": tweetID, "currUserID": currUserID, "limit": limit, "offset": offset},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	return createdTweet, nil
+	return extractTweetPropsFromEagerResult(res), nil
 }
 
-func (s *tweetStore) BookmarkTweet(tweetID string, userID string) error {
-	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+// PinTweet pins one of the user's own tweets to their profile, replacing any
+// previously pinned tweet
+func (s *tweetStore) PinTweet(ctx context.Context, tweetID string, userID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
 		*s.driver,
-		`MATCH (u:User {id: $userID}), (t:Tweet {id: $tweetID})
-		MERGE (u)-[b:BOOKMARKS]->(t)
-		SET t.bookmarksCount = t.bookmarksCount + 1
-		`,
+		`MATCH (u:User {id: $userID})-[:TWEETS]->(t:Tweet {id: $tweetID})
+		OPTIONAL MATCH (u)-[old:PINNED]->(:Tweet)
+		DELETE old
+		WITH u, t
+		MERGE (u)-[p:PINNED]->(t)
+		ON CREATE SET p.createdAt = datetime()
+		RETURN t.id AS id`,
 		map[string]any{"userID": userID, "tweetID": tweetID},
 		neo4j.EagerResultTransformer,
 	)
@@ -346,17 +540,19 @@ func (s *tweetStore) BookmarkTweet(tweetID string, userID string) error {
 		return err
 	}
 
+	if len(res.Records) == 0 {
+		return ErrTweetNotFound
+	}
+
 	return nil
 }
 
-func (s *tweetStore) UnbookmarkTweet(tweetID string, userID string) error {
+func (s *tweetStore) UnpinTweet(ctx context.Context, userID string) error {
 	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
-		`MATCH (u:User {id: $userID})-[b:BOOKMARKS]->(t:Tweet {id: $tweetID}) DELETE b
-		SET t.bookmarksCount = t.bookmarksCount - 1
-		`,
-		map[string]any{"userID": userID, "tweetID": tweetID},
+		`MATCH (:User {id: $userID})-[p:PINNED]->(:Tweet) DELETE p`,
+		map[string]any{"userID": userID},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
@@ -366,49 +562,231 @@ func (s *tweetStore) UnbookmarkTweet(tweetID string, userID string) error {
 	return nil
 }
 
-func (s *tweetStore) GetUsersWithTweets(currUserID string, limit int, offset int) ([]models.TweetProps, error) {
+// GetPinnedTweet returns the tweet pinned by userID as seen by currUserID,
+// or nil when nothing is pinned or the viewer may not see it
+func (s *tweetStore) GetPinnedTweet(ctx context.Context, userID string, currUserID string) (*models.TweetProps, error) {
 	query := `
-		MATCH (u:User)-[:TWEETS]->(t:Tweet)
+		MATCH (u:User {id: $userID})-[:PINNED]->(t:Tweet)
+		WHERE ` + notBlockedPredicate + ` AND ` + notProtectedPredicate + `
 		OPTIONAL MATCH (curr:User {id: $currUserID})
 		OPTIONAL MATCH (curr)-[l:LIKES]->(t)
 		OPTIONAL MATCH (curr)-[r:RETWEETS]->(t)
 		OPTIONAL MATCH (curr)-[b:BOOKMARKS]->(t)
-		WITH u, t, l, r, b
-		ORDER BY t.createdAt DESC
-		SKIP $offset LIMIT $limit
 		RETURN u, t, l IS NOT NULL AS isLiked, r IS NOT NULL AS isRetweeted, b IS NOT NULL AS isBookmarked
 	`
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		query,
-		map[string]any{"currUserID": currUserID, "limit": limit, "offset": offset},
+		map[string]any{"userID": userID, "currUserID": currUserID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tweets := extractTweetPropsFromEagerResult(res)
+	if len(tweets) == 0 {
+		return nil, nil
+	}
+
+	tweets[0].IsPinned = true
+	return &tweets[0], nil
+}
+
+// profileTimelineMatches selects the tweets of each profile tab, binding the
+// author to u, the tweet to t and the ordering key to sortKey
+var profileTimelineMatches = map[models.ProfileTab]string{
+	models.ProfileTabTweets: `
+		MATCH (u:User {id: $userID})-[:TWEETS]->(t:Tweet)
+		WHERE t.inReplyToID IS NULL AND NOT (u)-[:PINNED]->(t)
+		WITH u, t, t.createdAt AS sortKey`,
+	models.ProfileTabReplies: `
+		MATCH (u:User {id: $userID})-[:TWEETS]->(t:Tweet)
+		WHERE t.inReplyToID IS NOT NULL
+		WITH u, t, t.createdAt AS sortKey`,
+	models.ProfileTabMedia: `
+		MATCH (u:User {id: $userID})-[:TWEETS]->(t:Tweet)
+		WHERE size(coalesce(t.mediaURLs, [])) > 0
+		WITH u, t, t.createdAt AS sortKey`,
+	models.ProfileTabLikes: `
+		MATCH (:User {id: $userID})-[liked:LIKES]->(t:Tweet)<-[:TWEETS]-(u:User)
+		WITH u, t, coalesce(liked.createdAt, t.createdAt) AS sortKey`,
+}
+
+// GetProfileTimeline lists one tab of a user's profile, newest first. The
+// pinned tweet is not part of the tweets tab; use GetPinnedTweet for it.
+func (s *tweetStore) GetProfileTimeline(ctx context.Context, tab models.ProfileTab, userID string, currUserID string, cursor string, limit int) (*models.TweetPage, error) {
+	match, ok := profileTimelineMatches[tab]
+	if !ok {
+		return nil, NewValidationError("tab", fmt.Sprintf("unknown profile tab %q", tab))
+	}
+	if limit < 1 {
+		return nil, NewValidationError("limit", "must be at least 1")
+	}
+	limit = min(limit, models.MaxTweetPageSize)
+
+	cursorTime, cursorID, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkProfileVisible(ctx, userID, currUserID); err != nil {
+		return nil, err
+	}
+
+	query := match + `
+		WHERE ` + notBlockedPredicate + ` AND ` + notProtectedPredicate + `
+			AND ($cursorTime IS NULL OR sortKey < $cursorTime OR (sortKey = $cursorTime AND t.id < $cursorID))
+		OPTIONAL MATCH (curr:User {id: $currUserID})
+		OPTIONAL MATCH (curr)-[l:LIKES]->(t)
+		OPTIONAL MATCH (curr)-[r:RETWEETS]->(t)
+		OPTIONAL MATCH (curr)-[b:BOOKMARKS]->(t)
+		WITH u, t, sortKey, l, r, b
+		ORDER BY sortKey DESC, t.id DESC
+		LIMIT $limit
+		RETURN u, t, sortKey, l IS NOT NULL AS isLiked, r IS NOT NULL AS isRetweeted, b IS NOT NULL AS isBookmarked
+	`
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		query,
+		map[string]any{
+			"userID":     userID,
+			"currUserID": currUserID,
+			"cursorTime": cursorTime,
+			"cursorID":   cursorID,
+			// one extra row tells whether there is a next page
+			"limit": limit + 1,
+		},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]models.TweetProps, 0, limit)
+	page := &models.TweetPage{Tweets: extractTweetPropsFromEagerResult(res)}
+	if len(page.Tweets) > limit {
+		page.Tweets = page.Tweets[:limit]
+		last := res.Records[limit-1]
+		sortKey, _ := last.Get("sortKey")
+		next := EncodeCursor(sortKey.(time.Time), page.Tweets[limit-1].ID)
+		page.NextCursor = &next
+	}
+
+	return page, nil
+}
+
+// checkProfileVisible returns ErrBlocked or ErrProtected when the viewer may
+// not see the user's profile timelines
+func (s *tweetStore) checkProfileVisible(ctx context.Context, userID string, currUserID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		RETURN NOT (`+notBlockedPredicate+`) AS blocked, NOT `+notProtectedPredicate+` AS protected`,
+		map[string]any{"userID": userID, "currUserID": currUserID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrUserNotFound
+	}
+	if blocked, _ := res.Records[0].Get("blocked"); blocked.(bool) {
+		return ErrBlocked
+	}
+	if protected, _ := res.Records[0].Get("protected"); protected.(bool) {
+		return ErrProtected
+	}
+
+	return nil
+}
+
+// GetTimelineCandidates collects tweets for the ranked timeline from three
+// sources: the follow graph, tweets liked or retweeted by followed users and
+// tweets using hashtags that are trending since the given time
+func (s *tweetStore) GetTimelineCandidates(ctx context.Context, currUserID string, since time.Time, limit int) ([]models.TimelineCandidate, error) {
+	query := `
+		OPTIONAL MATCH (recent:Tweet)
+		WHERE recent.createdAt > $since
+		UNWIND coalesce(recent.hashtags, []) AS tag
+		WITH tag, count(*) AS uses
+		ORDER BY uses DESC
+		LIMIT $trendingLimit
+		WITH collect(tag) AS trending
+		MATCH (curr:User {id: $currUserID})
+		CALL {
+			WITH curr
+			MATCH (curr)-[:FOLLOWS]->(:User)-[:TWEETS]->(t:Tweet)
+			WHERE t.createdAt > $since
+			RETURN t, 'follow' AS source
+			UNION
+			WITH curr
+			MATCH (curr)-[:FOLLOWS]->(:User)-[:LIKES|RETWEETS]->(t:Tweet)
+			WHERE t.createdAt > $since
+			RETURN t, 'engagement' AS source
+			UNION
+			WITH curr, trending
+			MATCH (t:Tweet)
+			WHERE t.createdAt > $since AND any(tag IN coalesce(t.hashtags, []) WHERE tag IN trending)
+			RETURN t, 'trending' AS source
+		}
+		WITH curr, t, collect(DISTINCT source) AS sources
+		MATCH (u:User)-[:TWEETS]->(t)
+		WHERE u <> curr AND ` + notBlockedPredicate + ` AND ` + notProtectedPredicate + ` AND ` + notMutedPredicate + `
+		OPTIONAL MATCH (curr)-[:FOLLOWS]->(f:User)-[:LIKES|RETWEETS]->(t)
+		WITH curr, u, t, sources, count(DISTINCT f) AS socialProof
+		ORDER BY t.createdAt DESC
+		LIMIT $limit
+		OPTIONAL MATCH (curr)-[l:LIKES]->(t)
+		OPTIONAL MATCH (curr)-[r:RETWEETS]->(t)
+		OPTIONAL MATCH (curr)-[b:BOOKMARKS]->(t)
+		RETURN u, t, sources, socialProof, l IS NOT NULL AS isLiked, r IS NOT NULL AS isRetweeted, b IS NOT NULL AS isBookmarked
+	`
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		query,
+		map[string]any{"currUserID": currUserID, "since": since, "limit": limit, "trendingLimit": 10},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
 
+	candidates := make([]models.TimelineCandidate, 0, len(res.Records))
 	for _, record := range res.Records {
 		userNode, okU := record.Get("u")
 		tweetNode, okT := record.Get("t")
-		isLiked, _ := record.Get("isLiked")
-		isRetweeted, _ := record.Get("isRetweeted")
-		isBookmarked, _ := record.Get("isBookmarked")
 		if !okU || !okT {
 			continue
 		}
+		rawSources, _ := record.Get("sources")
+		socialProof, _ := record.Get("socialProof")
+		isLiked, _ := record.Get("isLiked")
+		isRetweeted, _ := record.Get("isRetweeted")
+		isBookmarked, _ := record.Get("isBookmarked")
+
 		user := extractUserFromNode(userNode)
 		tweet := extractTweetFromNode(tweetNode)
 
-		// Use utility function to convert to TweetProps
-		tp := convertTweetToProps(tweet, user, isLiked.(bool), isRetweeted.(bool), isBookmarked.(bool))
-		result = append(result, *tp)
+		sources := []models.TimelineSource{}
+		for _, source := range rawSources.([]any) {
+			sources = append(sources, models.TimelineSource(source.(string)))
+		}
+
+		candidates = append(candidates, models.TimelineCandidate{
+			Tweet:       *tweet,
+			Props:       *models.NewTweetProps(tweet, user, isLiked.(bool), isRetweeted.(bool), isBookmarked.(bool)),
+			Sources:     sources,
+			SocialProof: int(socialProof.(int64)),
+		})
 	}
 
-	return result, nil
+	return candidates, nil
 }
 
 func extractTweetFromNode(tweetNode any) *models.Tweet {
@@ -431,76 +809,35 @@ func extractTweetFromNode(tweetNode any) *models.Tweet {
 	}
 
 	return &models.Tweet{
-		ID:            props["id"].(string),
-		Content:       toStringPtr(props["content"]),
-		CreatedAt:     props["createdAt"].(time.Time),
-		UpdatedAt:     props["updatedAt"].(time.Time),
-		LikesCount:    int(props["likesCount"].(int64)),
-		RepliesCount:  int(props["repliesCount"].(int64)),
-		RetweetsCount: int(props["retweetsCount"].(int64)),
-		QuotesCount:   int(props["quotesCount"].(int64)),
-		ViewsCount:    int(props["viewsCount"].(int64)),
-		Hashtags:      &hashtags,
-		MediaURLs:     &mediaURLs,
-	}
-}
-
-// convertTweetToProps converts a models.Tweet and models.User to models.TweetProps
-func convertTweetToProps(tweet *models.Tweet, user *models.User, isLiked, isRetweeted, isBookmarked bool) *models.TweetProps {
-	tweetProps := &models.TweetProps{
-		CreatedAt:     tweet.CreatedAt.Format(time.RFC3339),
-		RepliesCount:  tweet.RepliesCount,
-		MediaURLs:     []string{},
-		ID:            tweet.ID,
-		RetweetsCount: tweet.RetweetsCount,
-		ViewsCount:    tweet.ViewsCount,
-		Content:       "",
-		LikesCount:    tweet.LikesCount,
-		UpdatedAt:     tweet.UpdatedAt.Format(time.RFC3339),
-		Hashtags:      []string{},
-		Author: struct {
-			ID             string  `json:"id"`
-			IsVerified     *bool   `json:"isVerified"`
-			Username       string  `json:"username"`
-			ProfilePicture *string `json:"profilePicture"`
-			Name           *string `json:"name"`
-		}{
-			ID:             user.ID,
-			IsVerified:     &user.IsVerified,
-			Username:       user.Username,
-			ProfilePicture: user.ProfilePicture,
-			Name:           &user.Name,
-		},
-		IsLiked:      isLiked,
-		IsRetweeted:  isRetweeted,
-		IsBookmarked: isBookmarked,
-	}
-
-	if tweet.Content != nil {
-		tweetProps.Content = *tweet.Content
-	}
-	if tweet.Hashtags != nil {
-		tweetProps.Hashtags = *tweet.Hashtags
-	}
-	if tweet.MediaURLs != nil {
-		tweetProps.MediaURLs = *tweet.MediaURLs
+		ID:             props["id"].(string),
+		Content:        toStringPtr(props["content"]),
+		CreatedAt:      props["createdAt"].(time.Time),
+		UpdatedAt:      props["updatedAt"].(time.Time),
+		LikesCount:     int(props["likesCount"].(int64)),
+		RepliesCount:   int(props["repliesCount"].(int64)),
+		RetweetsCount:  int(props["retweetsCount"].(int64)),
+		QuotesCount:    int(props["quotesCount"].(int64)),
+		ViewsCount:     int(props["viewsCount"].(int64)),
+		Hashtags:       &hashtags,
+		MediaURLs:      &mediaURLs,
+		InReplyToID:    toStringPtr(props["inReplyToID"]),
+		ConversationID: toStringPtr(props["conversationID"]),
 	}
-
-	return tweetProps
 }
 
 // getTweetPropsWithUser gets a tweet by ID and converts it to TweetProps by also fetching user information
-func (s *tweetStore) getTweetPropsWithUser(tweetID string, currentUserID string) (*models.TweetProps, error) {
+func (s *tweetStore) getTweetPropsWithUser(ctx context.Context, tweetID string, currUserID string) (*models.TweetProps, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (u:User)-[:TWEETS]->(t:Tweet {id: $tweetID})
-		OPTIONAL MATCH (curr:User {id: $currentUserID})
+		WHERE `+notBlockedPredicate+` AND `+notProtectedPredicate+`
+		OPTIONAL MATCH (curr:User {id: $currUserID})
 		OPTIONAL MATCH (curr)-[l:LIKES]->(t)
 		OPTIONAL MATCH (curr)-[r:RETWEETS]->(t)
 		OPTIONAL MATCH (curr)-[b:BOOKMARKS]->(t)
 		RETURN u, t, l IS NOT NULL AS isLiked, r IS NOT NULL AS isRetweeted, b IS NOT NULL AS isBookmarked`,
-		map[string]any{"tweetID": tweetID, "currentUserID": currentUserID},
+		map[string]any{"tweetID": tweetID, "currUserID": currUserID},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
@@ -508,7 +845,7 @@ func (s *tweetStore) getTweetPropsWithUser(tweetID string, currentUserID string)
 	}
 
 	if len(res.Records) == 0 {
-		return nil, fmt.Errorf("tweet not found")
+		return nil, ErrTweetNotFound
 	}
 
 	userNode, okU := res.Records[0].Get("u")
@@ -524,12 +861,87 @@ func (s *tweetStore) getTweetPropsWithUser(tweetID string, currentUserID string)
 	user := extractUserFromNode(userNode)
 	tweet := extractTweetFromNode(tweetNode)
 
-	return convertTweetToProps(tweet, user, isLiked.(bool), isRetweeted.(bool), isBookmarked.(bool)), nil
+	return models.NewTweetProps(tweet, user, isLiked.(bool), isRetweeted.(bool), isBookmarked.(bool)), nil
+}
+
+// engageWithTweet runs write (which may use curr for the user and t for the
+// tweet) unless the user and the tweet author block each other, and returns
+// the author's ID and the tweet's conversation. Tweets the user cannot see
+// are reported as not found.
+func (s *tweetStore) engageWithTweet(ctx context.Context, tweetID string, userID string, write string) (string, string, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (curr:User {id: $currUserID}), (u:User)-[:TWEETS]->(t:Tweet {id: $tweetID})
+		WHERE `+notProtectedPredicate+`
+		WITH curr, t, u, EXISTS { MATCH (curr)-[:BLOCKS]-(u) } AS blocked
+		FOREACH (_ IN CASE WHEN blocked THEN [] ELSE [1] END |
+			`+write+`
+		)
+		RETURN u.id AS authorID, coalesce(t.conversationID, t.id) AS conversationID, blocked`,
+		map[string]any{"currUserID": userID, "tweetID": tweetID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	authorID, err := extractAuthorIDFromEagerResult(res)
+	if err != nil {
+		return "", "", err
+	}
+
+	conversationID, _ := res.Records[0].Get("conversationID")
+	return authorID, conversationID.(string), nil
+}
+
+// tweetAuthorQuery returns the author of $tweetID and whether they and
+// $currUserID block each other
+const tweetAuthorQuery = `MATCH (curr:User {id: $currUserID}), (u:User)-[:TWEETS]->(t:Tweet {id: $tweetID})
+WHERE ` + notProtectedPredicate + `
+RETURN u.id AS authorID, EXISTS { MATCH (curr)-[:BLOCKS]-(u) } AS blocked`
+
+func extractAuthorIDFromEagerResult(res *neo4j.EagerResult) (string, error) {
+	if len(res.Records) == 0 {
+		return "", ErrTweetNotFound
+	}
+
+	blocked, _ := res.Records[0].Get("blocked")
+	if blocked.(bool) {
+		return "", ErrBlocked
+	}
+
+	authorID, _ := res.Records[0].Get("authorID")
+	return authorID.(string), nil
+}
+
+// extractTweetPropsFromEagerResult converts records of the form
+// (u, t, isLiked, isRetweeted, isBookmarked) to TweetProps
+func extractTweetPropsFromEagerResult(res *neo4j.EagerResult) []models.TweetProps {
+	result := make([]models.TweetProps, 0, len(res.Records))
+
+	for _, record := range res.Records {
+		userNode, okU := record.Get("u")
+		tweetNode, okT := record.Get("t")
+		isLiked, _ := record.Get("isLiked")
+		isRetweeted, _ := record.Get("isRetweeted")
+		isBookmarked, _ := record.Get("isBookmarked")
+		if !okU || !okT {
+			continue
+		}
+		user := extractUserFromNode(userNode)
+		tweet := extractTweetFromNode(tweetNode)
+
+		tp := models.NewTweetProps(tweet, user, isLiked.(bool), isRetweeted.(bool), isBookmarked.(bool))
+		result = append(result, *tp)
+	}
+
+	return result
 }
 
 func extractTweetFromEagerResult(res *neo4j.EagerResult) (*models.Tweet, error) {
 	if len(res.Records) == 0 {
-		return nil, fmt.Errorf("no tweet found")
+		return nil, ErrTweetNotFound
 
 	}
 
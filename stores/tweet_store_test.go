@@ -2,56 +2,31 @@ package stores
 
 import (
 	"context"
-	"log"
-	"os"
-	"path"
 	"testing"
 
 	"github.com/aimrintech/x-backend/constants"
 	"github.com/aimrintech/x-backend/models"
 	"github.com/aimrintech/x-backend/services"
-	"github.com/joho/godotenv"
-	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 	"github.com/stretchr/testify/assert"
 )
 
-func init() {
-	cwd, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("Error getting current working directory: %v", err)
-	}
-	err = godotenv.Load(path.Join(cwd, "../.env"))
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
-}
-
 func setupTestTweetStore(t *testing.T) (TweetStore, *models.User, func()) {
-	var (
-		dbUri      = os.Getenv("NEO4J_URI")
-		dbUser     = os.Getenv("NEO4J_USERNAME")
-		dbPassword = os.Getenv("NEO4J_PASSWORD")
-	)
-	driver, err := neo4j.NewDriverWithContext(dbUri, neo4j.BasicAuth(dbUser, dbPassword, ""))
-	if err != nil {
-		t.Fatalf("Failed to create driver: %v", err)
-	}
-	err = wipeDatabase(driver)
+	driver := newTestDriver(t)
+	err := wipeDatabase(driver)
 	if err != nil {
 		t.Fatalf("Failed to wipe database: %v", err)
 	}
-	ctx := context.Background()
 	notificationsService := services.NewNotificationsService()
 	feedService := services.NewFeedService()
-	store := NewTweetStore(&driver, &ctx, notificationsService, feedService)
-	userStore := NewUserStore(&driver, &ctx, notificationsService)
+	store := NewTweetStore(&driver, notificationsService, feedService)
+	userStore := NewUserStore(&driver, notificationsService)
 	user := &models.User{
 		Name:     "Tweet User",
 		Email:    "tweetuser@example.com",
 		Password: "hashedpassword",
 		Username: "tweetuser",
 	}
-	createdUser, err := userStore.CreateUser(user, constants.AUTH_PROVIDER_CREDS)
+	createdUser, err := userStore.CreateUser(testCtx, user, constants.AUTH_PROVIDER_CREDS)
 	if err != nil {
 		t.Fatalf("Failed to create user: %v", err)
 	}
@@ -75,7 +50,7 @@ func TestTweetStore_CRUD(t *testing.T) {
 	}
 
 	// Create
-	created, err := store.CreateTweet(tweet, user.ID)
+	created, err := store.CreateTweet(testCtx, tweet, user.ID)
 	assert.NoError(t, err)
 	assert.NotNil(t, created)
 	assert.Equal(t, content, created.Content)
@@ -83,7 +58,7 @@ func TestTweetStore_CRUD(t *testing.T) {
 	assert.ElementsMatch(t, media, created.MediaURLs)
 
 	// Get by ID
-	fetched, err := store.GetTweetByID(created.ID)
+	fetched, err := store.GetTweetByID(testCtx, created.ID, user.ID)
 	assert.NoError(t, err)
 	if fetched != nil {
 		assert.Equal(t, created.ID, fetched.ID)
@@ -91,18 +66,18 @@ func TestTweetStore_CRUD(t *testing.T) {
 
 	// Update
 	newContent := "Updated tweet content"
-	updated, err := store.UpdateTweet(&models.Tweet{Content: &newContent}, user.ID)
+	updated, err := store.UpdateTweet(testCtx, &models.Tweet{Content: &newContent}, user.ID)
 	assert.NoError(t, err)
 	if updated != nil {
 		assert.Equal(t, newContent, updated.Content)
 	}
 
 	// Delete
-	err = store.DeleteTweet(created.ID, user.ID)
+	err = store.DeleteTweet(testCtx, created.ID, user.ID)
 	assert.NoError(t, err)
 
 	// Get after delete
-	deleted, err := store.GetTweetByID(created.ID)
+	deleted, err := store.GetTweetByID(testCtx, created.ID, user.ID)
 	assert.Error(t, err)
 	assert.Nil(t, deleted)
 }
@@ -119,16 +94,16 @@ func TestTweetStore_LikeUnlike(t *testing.T) {
 		Hashtags:  &hashtags,
 		MediaURLs: &media,
 	}
-	created, err := store.CreateTweet(tweet, user.ID)
+	created, err := store.CreateTweet(testCtx, tweet, user.ID)
 	assert.NoError(t, err)
 	assert.NotNil(t, created)
 
 	// Like
-	err = store.LikeTweet(created.ID, user.ID)
+	err = store.LikeTweet(testCtx, created.ID, user.ID)
 	assert.NoError(t, err)
 
 	// Unlike
-	err = store.UnlikeTweet(created.ID, user.ID)
+	err = store.UnlikeTweet(testCtx, created.ID, user.ID)
 	assert.NoError(t, err)
 }
 
@@ -144,16 +119,16 @@ func TestTweetStore_RetweetUnretweet(t *testing.T) {
 		Hashtags:  &hashtags,
 		MediaURLs: &media,
 	}
-	created, err := store.CreateTweet(tweet, user.ID)
+	created, err := store.CreateTweet(testCtx, tweet, user.ID)
 	assert.NoError(t, err)
 	assert.NotNil(t, created)
 
 	// Retweet
-	err = store.Retweet(created.ID, user.ID)
+	err = store.Retweet(testCtx, created.ID, user.ID)
 	assert.NoError(t, err)
 
 	// Unretweet
-	err = store.Unretweet(created.ID, user.ID)
+	err = store.Unretweet(testCtx, created.ID, user.ID)
 	assert.NoError(t, err)
 }
 
@@ -169,7 +144,7 @@ func TestTweetStore_QuoteTweet(t *testing.T) {
 		Hashtags:  &hashtags,
 		MediaURLs: &media,
 	}
-	created, err := store.CreateTweet(original, user.ID)
+	created, err := store.CreateTweet(testCtx, original, user.ID)
 	assert.NoError(t, err)
 	assert.NotNil(t, created)
 
@@ -181,7 +156,7 @@ func TestTweetStore_QuoteTweet(t *testing.T) {
 		Hashtags:  &quoteHashtags,
 		MediaURLs: &quoteMedia,
 	}
-	quoted, err := store.QuoteTweet(created.ID, user.ID, quote)
+	quoted, err := store.QuoteTweet(testCtx, created.ID, user.ID, quote)
 	assert.NoError(t, err)
 	assert.NotNil(t, quoted)
 	assert.Equal(t, quoteContent, quoted.Content)
@@ -199,15 +174,94 @@ func TestTweetStore_BookmarkUnbookmark(t *testing.T) {
 		Hashtags:  &hashtags,
 		MediaURLs: &media,
 	}
-	created, err := store.CreateTweet(tweet, user.ID)
+	created, err := store.CreateTweet(testCtx, tweet, user.ID)
 	assert.NoError(t, err)
 	assert.NotNil(t, created)
 
 	// Bookmark
-	err = store.BookmarkTweet(created.ID, user.ID)
+	err = store.BookmarkTweet(testCtx, created.ID, user.ID)
 	assert.NoError(t, err)
 
 	// Unbookmark
-	err = store.UnbookmarkTweet(created.ID, user.ID)
+	err = store.UnbookmarkTweet(testCtx, created.ID, user.ID)
+	assert.NoError(t, err)
+}
+
+func TestTweetStore_PinUnpin(t *testing.T) {
+	store, user, cleanup := setupTestTweetStore(t)
+	defer cleanup()
+
+	content := "Pin test"
+	created, err := store.CreateTweet(testCtx, &models.Tweet{Content: &content}, user.ID)
+	assert.NoError(t, err)
+
+	// Pin
+	err = store.PinTweet(testCtx, created.ID, user.ID)
+	assert.NoError(t, err)
+
+	pinned, err := store.GetPinnedTweet(testCtx, user.ID, user.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, pinned) {
+		assert.Equal(t, created.ID, pinned.ID)
+	}
+
+	// Unpin
+	err = store.UnpinTweet(testCtx, user.ID)
+	assert.NoError(t, err)
+
+	pinned, err = store.GetPinnedTweet(testCtx, user.ID, user.ID)
 	assert.NoError(t, err)
+	assert.Nil(t, pinned)
+
+	// Deleting a pinned tweet clears the pin
+	err = store.PinTweet(testCtx, created.ID, user.ID)
+	assert.NoError(t, err)
+	err = store.DeleteTweet(testCtx, created.ID, user.ID)
+	assert.NoError(t, err)
+
+	pinned, err = store.GetPinnedTweet(testCtx, user.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, pinned)
+}
+
+func TestTweetStore_ProfileTimeline(t *testing.T) {
+	store, user, cleanup := setupTestTweetStore(t)
+	defer cleanup()
+
+	for _, content := range []string{"first", "second", "third"} {
+		c := content
+		_, err := store.CreateTweet(testCtx, &models.Tweet{Content: &c}, user.ID)
+		assert.NoError(t, err)
+	}
+	withMedia := "with media"
+	media := []string{"http://example.com/image.png"}
+	_, err := store.CreateTweet(testCtx, &models.Tweet{Content: &withMedia, MediaURLs: &media}, user.ID)
+	assert.NoError(t, err)
+
+	// Cursor pagination over the tweets tab
+	page, err := store.GetProfileTimeline(testCtx, models.ProfileTabTweets, user.ID, user.ID, "", 3)
+	assert.NoError(t, err)
+	assert.Len(t, page.Tweets, 3)
+	assert.NotNil(t, page.NextCursor)
+
+	next, err := store.GetProfileTimeline(testCtx, models.ProfileTabTweets, user.ID, user.ID, *page.NextCursor, 3)
+	assert.NoError(t, err)
+	assert.Len(t, next.Tweets, 1)
+	assert.Nil(t, next.NextCursor)
+
+	// Media tab
+	mediaPage, err := store.GetProfileTimeline(testCtx, models.ProfileTabMedia, user.ID, user.ID, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, mediaPage.Tweets, 1)
+
+	// Likes tab
+	err = store.LikeTweet(testCtx, page.Tweets[0].ID, user.ID)
+	assert.NoError(t, err)
+	likes, err := store.GetProfileTimeline(testCtx, models.ProfileTabLikes, user.ID, user.ID, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, likes.Tweets, 1)
+
+	// Invalid cursor
+	_, err = store.GetProfileTimeline(testCtx, models.ProfileTabTweets, user.ID, user.ID, "not a cursor", 3)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
 }
@@ -0,0 +1,243 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aimrintech/x-backend/models"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// TwoFactorStore keeps users' TOTP authenticators and recovery codes
+type TwoFactorStore interface {
+	GetTwoFactor(ctx context.Context, userID string) (*models.TwoFactor, error)
+	// StartTwoFactor stores a pending authenticator with the given secret,
+	// replacing any pending one. It fails with ErrTwoFactorEnabled if the
+	// user already has one enabled.
+	StartTwoFactor(ctx context.Context, userID string, secret string) error
+	// EnableTwoFactor enables the pending authenticator, with the hashes of
+	// its recovery codes
+	EnableTwoFactor(ctx context.Context, userID string, recoveryCodes []string) error
+	DisableTwoFactor(ctx context.Context, userID string) error
+	// UseTOTPStep records that the code of a time step was accepted. It fails
+	// with ErrTOTPCodeUsed unless step is later than every step used before,
+	// which makes each code single use.
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode removes the recovery code with the given hash, or
+	// fails with ErrRecoveryCodeInvalid
+	UseRecoveryCode(ctx context.Context, userID string, recoveryCode string) error
+	SetRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error
+}
+
+var (
+	ErrTwoFactorNotFound   = newError(ErrNotFound, "two-factor authentication is not set up")
+	ErrTwoFactorEnabled    = newError(ErrConflict, "two-factor authentication is already enabled")
+	ErrTOTPCodeUsed        = newError(ErrForbidden, "code was already used")
+	ErrRecoveryCodeInvalid = newError(ErrForbidden, "invalid recovery code")
+)
+
+type twoFactorStore struct {
+	driver *neo4j.DriverWithContext
+}
+
+func NewTwoFactorStore(driver *neo4j.DriverWithContext) TwoFactorStore {
+	return &twoFactorStore{
+		driver: driver,
+	}
+}
+
+func (s *twoFactorStore) GetTwoFactor(ctx context.Context, userID string) (*models.TwoFactor, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})-[:HAS_TWO_FACTOR]->(t:TwoFactor)
+		RETURN t, u.id AS userID`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrTwoFactorNotFound
+	}
+
+	return extractTwoFactorFromRecord(res.Records[0])
+}
+
+func (s *twoFactorStore) StartTwoFactor(ctx context.Context, userID string, secret string) error {
+	_, err := executeWrite(ctx, *s.driver, func(tx neo4j.ManagedTransaction) (any, error) {
+		res, err := runInTx(
+			ctx,
+			tx,
+			`MATCH (u:User {id: $userID})
+			OPTIONAL MATCH (u)-[:HAS_TWO_FACTOR]->(t:TwoFactor)
+			RETURN coalesce(t.enabled, false) AS enabled`,
+			map[string]any{"userID": userID},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Records) == 0 {
+			return nil, ErrUserNotFound
+		}
+		if enabled, _ := res.Records[0].Get("enabled"); enabled == true {
+			return nil, ErrTwoFactorEnabled
+		}
+
+		_, err = runInTx(
+			ctx,
+			tx,
+			`MATCH (u:User {id: $userID})
+			MERGE (u)-[:HAS_TWO_FACTOR]->(t:TwoFactor)
+			SET t.secret = $secret,
+				t.enabled = false,
+				t.lastUsedStep = 0,
+				t.recoveryCodes = [],
+				t.createdAt = datetime(),
+				t.enabledAt = null`,
+			map[string]any{"userID": userID, "secret": secret},
+		)
+		return nil, err
+	})
+	return err
+}
+
+func (s *twoFactorStore) EnableTwoFactor(ctx context.Context, userID string, recoveryCodes []string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_TWO_FACTOR]->(t:TwoFactor)
+		WITH t, t.enabled AS wasEnabled
+		FOREACH (_ IN CASE WHEN wasEnabled THEN [] ELSE [1] END |
+			SET t.enabled = true, t.enabledAt = datetime(), t.recoveryCodes = $recoveryCodes
+		)
+		RETURN wasEnabled`,
+		map[string]any{"userID": userID, "recoveryCodes": recoveryCodes},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+	if len(res.Records) == 0 {
+		return ErrTwoFactorNotFound
+	}
+	if wasEnabled, _ := res.Records[0].Get("wasEnabled"); wasEnabled == true {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+func (s *twoFactorStore) DisableTwoFactor(ctx context.Context, userID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_TWO_FACTOR]->(t:TwoFactor)
+		DETACH DELETE t
+		RETURN count(*) AS deleted`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := res.Records[0].Get("deleted"); deleted == int64(0) {
+		return ErrTwoFactorNotFound
+	}
+	return nil
+}
+
+func (s *twoFactorStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_TWO_FACTOR]->(t:TwoFactor)
+		WITH t, t.lastUsedStep < $step AS fresh
+		FOREACH (_ IN CASE WHEN fresh THEN [1] ELSE [] END | SET t.lastUsedStep = $step)
+		RETURN fresh`,
+		map[string]any{"userID": userID, "step": step},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+	if len(res.Records) == 0 {
+		return ErrTwoFactorNotFound
+	}
+	if fresh, _ := res.Records[0].Get("fresh"); fresh != true {
+		return ErrTOTPCodeUsed
+	}
+	return nil
+}
+
+func (s *twoFactorStore) UseRecoveryCode(ctx context.Context, userID string, recoveryCode string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_TWO_FACTOR]->(t:TwoFactor {enabled: true})
+		WITH t, $code IN t.recoveryCodes AS found
+		FOREACH (_ IN CASE WHEN found THEN [1] ELSE [] END |
+			SET t.recoveryCodes = [c IN t.recoveryCodes WHERE c <> $code]
+		)
+		RETURN found`,
+		map[string]any{"userID": userID, "code": recoveryCode},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+	if len(res.Records) == 0 {
+		return ErrTwoFactorNotFound
+	}
+	if found, _ := res.Records[0].Get("found"); found != true {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+func (s *twoFactorStore) SetRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:HAS_TWO_FACTOR]->(t:TwoFactor {enabled: true})
+		SET t.recoveryCodes = $recoveryCodes
+		RETURN t`,
+		map[string]any{"userID": userID, "recoveryCodes": recoveryCodes},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+	if len(res.Records) == 0 {
+		return ErrTwoFactorNotFound
+	}
+	return nil
+}
+
+func extractTwoFactorFromRecord(record *neo4j.Record) (*models.TwoFactor, error) {
+	node, ok := record.Get("t")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract two-factor node")
+	}
+	userID, ok := record.Get("userID")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract two-factor user")
+	}
+	props := node.(neo4j.Node).Props
+
+	twoFactor := &models.TwoFactor{
+		UserID:       userID.(string),
+		Secret:       props["secret"].(string),
+		Enabled:      props["enabled"].(bool),
+		LastUsedStep: props["lastUsedStep"].(int64),
+		CreatedAt:    props["createdAt"].(time.Time),
+		EnabledAt:    toTimePtr(props["enabledAt"]),
+	}
+	codes, _ := props["recoveryCodes"].([]any)
+	twoFactor.RecoveryCodes = make([]string, 0, len(codes))
+	for _, code := range codes {
+		twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes, code.(string))
+	}
+	return twoFactor, nil
+}
@@ -0,0 +1,42 @@
+package stores
+
+import (
+	"context"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// executeWrite runs work as a single unit of work: every query it runs
+// commits together, or rolls back if work returns an error. The driver
+// retries work on transient errors, so work must have no side effects besides
+// its queries; publish events once executeWrite returns.
+func executeWrite[T any](ctx context.Context, driver neo4j.DriverWithContext, work func(tx neo4j.ManagedTransaction) (T, error)) (T, error) {
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	return neo4j.ExecuteWrite(ctx, session, work)
+}
+
+// runInTx runs a query in tx and collects its result the way ExecuteQuery
+// with EagerResultTransformer does, so the extract helpers work on both
+func runInTx(ctx context.Context, tx neo4j.ManagedTransaction, query string, params map[string]any) (*neo4j.EagerResult, error) {
+	res, err := tx.Run(ctx, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := res.Keys()
+	if err != nil {
+		return nil, err
+	}
+	records, err := res.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := res.Consume(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &neo4j.EagerResult{Keys: keys, Records: records, Summary: summary}, nil
+}
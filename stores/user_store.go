@@ -3,6 +3,7 @@ package stores
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aimrintech/x-backend/constants"
@@ -13,35 +14,76 @@ import (
 )
 
 type UserStore interface {
-	GetUserByID(id string) (*models.User, error)
-	GetUserByEmail(email string) (*models.User, error)
-	GetUserByUsername(username string) (*models.User, error)
-	CreateUser(user *models.User, authProvider constants.AuthProvider) (*models.User, error)
-	UpdateUser(user *models.User) (*models.User, error)
-	DeleteUser(id string) error
-	FollowUser(followerID, followingID string) error
-	UnfollowUser(followerID, followingID string) error
-	GetFollowers(userID string, limit int, offset int) ([]*models.User, error)
-	GetFollowing(userID string, limit int, offset int) ([]*models.User, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	GetUserByPreviousUsername(ctx context.Context, username string) (*models.User, error)
+	IsUsernameAvailable(ctx context.Context, username string, userID string) (bool, error)
+	ChangeUsername(ctx context.Context, userID string, username string) (*models.User, error)
+	CreateUser(ctx context.Context, user *models.User, authProvider constants.AuthProvider) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.User) (*models.User, error)
+	DeleteUser(ctx context.Context, id string) error
+	FollowUser(ctx context.Context, followerID, followingID string) (models.FollowStatus, error)
+	UnfollowUser(ctx context.Context, followerID, followingID string) error
+	GetFollowRequests(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error)
+	ApproveFollowRequest(ctx context.Context, userID, requesterID string) error
+	DenyFollowRequest(ctx context.Context, userID, requesterID string) error
+	SetLocked(ctx context.Context, userID string, locked bool) error
+	SetPassword(ctx context.Context, userID string, password string) error
+	SetEmailVerified(ctx context.Context, userID string, email string) error
+	GetFollowers(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error)
+	GetFollowing(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error)
+	BlockUser(ctx context.Context, blockerID, blockedID string) error
+	UnblockUser(ctx context.Context, blockerID, blockedID string) error
+	GetBlockedUsers(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error)
+	IsBlocked(ctx context.Context, userID, otherUserID string) (bool, error)
+	GetRelationship(ctx context.Context, viewerID, userID string) (*models.Relationship, error)
+	MuteUser(ctx context.Context, userID, mutedID string) error
+	UnmuteUser(ctx context.Context, userID, mutedID string) error
+	AddMutedWord(ctx context.Context, userID string, phrase string, expiresAt *time.Time) (*models.MutedWord, error)
+	RemoveMutedWord(ctx context.Context, userID string, wordID string) error
+	MuteConversation(ctx context.Context, userID string, tweetID string) error
+	UnmuteConversation(ctx context.Context, userID string, tweetID string) error
+	GetMutes(ctx context.Context, userID string) (*models.Mutes, error)
+	GetContentFilter(ctx context.Context, userID string) (*models.ContentFilter, error)
 }
 
+var (
+	ErrUserExists          = newError(ErrConflict, "user already exists")
+	ErrUsernameTaken       = newError(ErrConflict, "username is taken")
+	ErrUsernameChangeLimit = newError(ErrRateLimited, "username changed too often")
+)
+
+// usernameTakenPredicate is true when $usernameLower belongs to a user other
+// than u, either currently or as an old username still within its grace
+// period. Usernames are compared case-insensitively, through the lower cased
+// usernameLower copy so the lookups can use its index.
+const usernameTakenPredicate = `(
+	EXISTS {
+		MATCH (other:User {usernameLower: $usernameLower})
+		WHERE other.id <> u.id
+	}
+	OR EXISTS {
+		MATCH (other:User)-[:PREVIOUSLY_KNOWN_AS]->(h:UsernameHistory {usernameLower: $usernameLower})
+		WHERE other.id <> u.id AND h.expiresAt > datetime()
+	}
+)`
+
 type userStore struct {
 	driver               *neo4j.DriverWithContext
-	dbCtx                *context.Context
 	notificationsService services.Notifications
 }
 
-func NewUserStore(driver *neo4j.DriverWithContext, dbCtx *context.Context, notificationsService services.Notifications) UserStore {
+func NewUserStore(driver *neo4j.DriverWithContext, notificationsService services.Notifications) UserStore {
 	return &userStore{
 		driver:               driver,
-		dbCtx:                dbCtx,
 		notificationsService: notificationsService,
 	}
 }
 
-func (s *userStore) GetUserByID(id string) (*models.User, error) {
+func (s *userStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (u:User {id: $id}) RETURN u`,
 		map[string]any{"id": id},
@@ -54,9 +96,9 @@ func (s *userStore) GetUserByID(id string) (*models.User, error) {
 	return extractUserFromEagerResult(res)
 }
 
-func (s *userStore) GetUserByEmail(email string) (*models.User, error) {
+func (s *userStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (u:User {email: $email}) RETURN u`,
 		map[string]any{"email": email},
@@ -69,12 +111,33 @@ func (s *userStore) GetUserByEmail(email string) (*models.User, error) {
 	return extractUserFromEagerResult(res)
 }
 
-func (s *userStore) GetUserByUsername(username string) (*models.User, error) {
+func (s *userStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {usernameLower: $usernameLower}) RETURN u`,
+		map[string]any{"usernameLower": strings.ToLower(username)},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return extractUserFromEagerResult(res)
+}
+
+// GetUserByPreviousUsername finds the user who gave up username within the
+// redirect grace period
+func (s *userStore) GetUserByPreviousUsername(ctx context.Context, username string) (*models.User, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
-		`MATCH (u:User {username: $username}) RETURN u`,
-		map[string]any{"username": username},
+		`MATCH (u:User)-[:PREVIOUSLY_KNOWN_AS]->(h:UsernameHistory {usernameLower: $usernameLower})
+		WHERE h.expiresAt > datetime()
+		RETURN u
+		ORDER BY h.changedAt DESC
+		LIMIT 1`,
+		map[string]any{"usernameLower": strings.ToLower(username)},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
@@ -84,18 +147,99 @@ func (s *userStore) GetUserByUsername(username string) (*models.User, error) {
 	return extractUserFromEagerResult(res)
 }
 
-func (s *userStore) CreateUser(user *models.User, authProvider constants.AuthProvider) (*models.User, error) {
+// IsUsernameAvailable reports whether username is free for userID, who may be
+// empty for a new account. A user can always take back their own old usernames.
+func (s *userStore) IsUsernameAvailable(ctx context.Context, username string, userID string) (bool, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`WITH {id: $userID} AS u
+		RETURN NOT `+usernameTakenPredicate+` AS available`,
+		map[string]any{"usernameLower": strings.ToLower(username), "userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return false, err
+	}
+	if len(res.Records) == 0 {
+		return false, fmt.Errorf("failed to check username")
+	}
+
+	available, _ := res.Records[0].Get("available")
+	return available.(bool), nil
+}
+
+// ChangeUsername renames a user, keeping the old username in their history so
+// it redirects to them during the grace period
+func (s *userStore) ChangeUsername(ctx context.Context, userID string, username string) (*models.User, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		OPTIONAL MATCH (u)-[:PREVIOUSLY_KNOWN_AS]->(recent:UsernameHistory)
+		WHERE recent.changedAt > datetime() - duration({seconds: $changeWindow})
+		WITH u, count(recent) AS recentChanges
+		WITH u, recentChanges, `+usernameTakenPredicate+` AS taken
+		WITH u, recentChanges, taken,
+			NOT taken AND recentChanges < $changeLimit AND u.username <> $username AS allowed
+		FOREACH (_ IN CASE WHEN allowed THEN [1] ELSE [] END |
+			CREATE (u)-[:PREVIOUSLY_KNOWN_AS]->(:UsernameHistory {
+				username: u.username,
+				usernameLower: u.usernameLower,
+				changedAt: datetime(),
+				expiresAt: datetime() + duration({seconds: $gracePeriod})
+			})
+			SET u.username = $username, u.usernameLower = $usernameLower, u.updatedAt = datetime()
+		)
+		RETURN u, taken, recentChanges`,
+		map[string]any{
+			"userID":        userID,
+			"username":      username,
+			"usernameLower": strings.ToLower(username),
+			"changeLimit":   constants.USERNAME_CHANGE_LIMIT,
+			"changeWindow":  int64(constants.USERNAME_CHANGE_WINDOW.Seconds()),
+			"gracePeriod":   int64(constants.USERNAME_REDIRECT_GRACE_PERIOD.Seconds()),
+		},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, conflictOnConstraint(err, ErrUsernameTaken)
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	record := res.Records[0]
+	taken, _ := record.Get("taken")
+	if taken.(bool) {
+		return nil, ErrUsernameTaken
+	}
+	user, err := extractUserFromEagerResult(res)
+	if err != nil {
+		return nil, err
+	}
+	recentChanges, _ := record.Get("recentChanges")
+	if user.Username != username && recentChanges.(int64) >= constants.USERNAME_CHANGE_LIMIT {
+		return nil, ErrUsernameChangeLimit
+	}
+
+	return user, nil
+}
+
+func (s *userStore) CreateUser(ctx context.Context, user *models.User, authProvider constants.AuthProvider) (*models.User, error) {
 	userID := uuid.New().String()
 
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`CREATE (u:User {
 			id: $id, 
 			name: $name, 
 			email: $email, 
 			password: $password,
+			emailVerified: $emailVerified,
 			username: $username,
+			usernameLower: $usernameLower,
 			profilePicture: null,
 			bannerPicture: null,
 			createdAt: datetime(),
@@ -112,23 +256,26 @@ func (s *userStore) CreateUser(user *models.User, authProvider constants.AuthPro
 			authProvider: $authProvider
 		}) RETURN u`,
 		map[string]any{
-			"id":           userID,
-			"name":         user.Name,
-			"email":        user.Email,
-			"password":     user.Password,
-			"username":     user.Username,
-			"authProvider": authProvider,
+			"id":            userID,
+			"name":          user.Name,
+			"email":         user.Email,
+			"password":      user.Password,
+			"emailVerified": user.EmailVerified,
+			"username":      user.Username,
+			"usernameLower": strings.ToLower(user.Username),
+			"authProvider":  authProvider,
 		},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
-		return nil, err
+		// the email or username was taken by a concurrent registration
+		return nil, conflictOnConstraint(err, ErrUserExists)
 	}
 
 	return extractUserFromEagerResult(res)
 }
 
-func (s *userStore) UpdateUser(user *models.User) (*models.User, error) {
+func (s *userStore) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
 	// Build SET clauses and params dynamically
 	setClauses := []string{}
 	params := map[string]any{
@@ -148,8 +295,9 @@ func (s *userStore) UpdateUser(user *models.User) (*models.User, error) {
 		params["password"] = user.Password
 	}
 	if user.Username != "" {
-		setClauses = append(setClauses, "u.username = $username")
+		setClauses = append(setClauses, "u.username = $username", "u.usernameLower = $usernameLower")
 		params["username"] = user.Username
+		params["usernameLower"] = strings.ToLower(user.Username)
 	}
 	if user.ProfilePicture != nil {
 		setClauses = append(setClauses, "u.profilePicture = $profilePicture")
@@ -198,13 +346,13 @@ func (s *userStore) UpdateUser(user *models.User) (*models.User, error) {
 
 	if len(setClauses) == 0 {
 		// Nothing to update
-		return s.GetUserByID(user.ID)
+		return s.GetUserByID(ctx, user.ID)
 	}
 
 	query := fmt.Sprintf("MATCH (u:User {id: $id}) SET %s RETURN u", joinClauses(setClauses, ", "))
 
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		query,
 		params,
@@ -222,11 +370,17 @@ func (s *userStore) UpdateUser(user *models.User) (*models.User, error) {
 	return u, nil
 }
 
-func (s *userStore) DeleteUser(id string) error {
+func (s *userStore) DeleteUser(ctx context.Context, id string) error {
 	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
-		`MATCH (u:User {id: $id}) DETACH DELETE u`,
+		`MATCH (u:User {id: $id})
+		OPTIONAL MATCH (u)-[:PREVIOUSLY_KNOWN_AS]->(h:UsernameHistory)
+		OPTIONAL MATCH (u)-[:HAS_SESSION]->(s:Session)
+		OPTIONAL MATCH (u)-[:HAS_IDENTITY]->(i:AuthIdentity)
+		OPTIONAL MATCH (u)-[:HAS_TWO_FACTOR]->(t:TwoFactor)
+		OPTIONAL MATCH (u)-[:HAS_API_TOKEN]->(a:APIToken)
+		DETACH DELETE u, h, s, i, t, a`,
 		map[string]any{"id": id},
 		neo4j.EagerResultTransformer,
 	)
@@ -237,11 +391,67 @@ func (s *userStore) DeleteUser(id string) error {
 	return nil
 }
 
-func (s *userStore) FollowUser(followerID, followingID string) error {
+// FollowUser follows a user right away, or sends a follow request when the
+// user's account is locked. Only a new follow or request notifies the user.
+func (s *userStore) FollowUser(ctx context.Context, followerID, followingID string) (models.FollowStatus, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (f:User {id: $followerID}), (t:User {id: $followingID})
+		WITH f, t,
+			EXISTS { MATCH (f)-[:BLOCKS]-(t) } AS blocked,
+			EXISTS { MATCH (f)-[:FOLLOWS]->(t) } AS following,
+			EXISTS { MATCH (f)-[:FOLLOW_REQUEST]->(t) } AS requested
+		WITH f, t, blocked, following, requested, coalesce(t.isLocked, false) AND NOT following AS requiresApproval
+		FOREACH (_ IN CASE WHEN NOT blocked AND NOT requiresApproval THEN [1] ELSE [] END |
+			MERGE (f)-[r:FOLLOWS]->(t)
+			ON CREATE SET f.followingCount = f.followingCount + 1, t.followersCount = t.followersCount + 1
+		)
+		FOREACH (_ IN CASE WHEN NOT blocked AND requiresApproval THEN [1] ELSE [] END |
+			MERGE (f)-[r:FOLLOW_REQUEST]->(t)
+			ON CREATE SET r.createdAt = datetime()
+		)
+		RETURN blocked, requiresApproval,
+			CASE WHEN requiresApproval THEN NOT requested ELSE NOT following END AS created`,
+		map[string]any{"followerID": followerID, "followingID": followingID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if len(res.Records) == 0 {
+		return "", ErrUserNotFound
+	}
+	if blocked, _ := res.Records[0].Get("blocked"); blocked.(bool) {
+		return "", ErrBlocked
+	}
+
+	created, _ := res.Records[0].Get("created")
+	if requiresApproval, _ := res.Records[0].Get("requiresApproval"); requiresApproval.(bool) {
+		if created.(bool) {
+			s.notificationsService.Publish(models.NotificationTypeFollowRequest, models.NewNotification(followingID, followerID, nil, models.NotificationTypeFollowRequest))
+		}
+		return models.FollowStatusRequested, nil
+	}
+
+	if created.(bool) {
+		s.notificationsService.Publish(models.NotificationTypeFollow, models.NewNotification(followingID, followerID, nil, models.NotificationTypeFollow))
+	}
+
+	return models.FollowStatusFollowing, nil
+}
+
+// UnfollowUser removes a follow, or withdraws a pending follow request
+func (s *userStore) UnfollowUser(ctx context.Context, followerID, followingID string) error {
 	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
-		`MATCH (f:User {id: $followerID}), (t:User {id: $followingID}) CREATE (f)-[:FOLLOWS]->(t)`,
+		`MATCH (f:User {id: $followerID})-[r:FOLLOWS|FOLLOW_REQUEST]->(t:User {id: $followingID})
+		FOREACH (_ IN CASE WHEN type(r) = 'FOLLOWS' THEN [1] ELSE [] END |
+			SET f.followingCount = f.followingCount - 1, t.followersCount = t.followersCount - 1
+		)
+		DELETE r`,
 		map[string]any{"followerID": followerID, "followingID": followingID},
 		neo4j.EagerResultTransformer,
 	)
@@ -249,29 +459,172 @@ func (s *userStore) FollowUser(followerID, followingID string) error {
 		return err
 	}
 
-	s.notificationsService.Publish(models.NotificationTypeFollow, models.NewNotification(followingID, followerID, nil, models.NotificationTypeFollow))
+	return nil
+}
+
+func (s *userStore) GetFollowRequests(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (f:User)-[r:FOLLOW_REQUEST]->(:User {id: $userID})
+		RETURN f
+		ORDER BY r.createdAt DESC
+		SKIP $offset LIMIT $limit`,
+		map[string]any{"userID": userID, "limit": limit, "offset": offset},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []*models.User{}
+	for _, record := range res.Records {
+		userNode, ok := record.Get("f")
+		if !ok {
+			return nil, fmt.Errorf("failed to extract user node")
+		}
+		user := extractUserFromNode(userNode)
+		user.Password = ""
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// ApproveFollowRequest turns a pending request into a follow. A request
+// between users who have since blocked each other is dropped instead.
+func (s *userStore) ApproveFollowRequest(ctx context.Context, userID, requesterID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (f:User {id: $requesterID})-[r:FOLLOW_REQUEST]->(t:User {id: $userID})
+		WITH f, t, r, EXISTS { MATCH (f)-[:BLOCKS]-(t) } AS blocked
+		DELETE r
+		FOREACH (_ IN CASE WHEN blocked THEN [] ELSE [1] END |
+			MERGE (f)-[:FOLLOWS]->(t)
+			ON CREATE SET f.followingCount = f.followingCount + 1, t.followersCount = t.followersCount + 1
+		)
+		RETURN blocked`,
+		map[string]any{"userID": userID, "requesterID": requesterID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrFollowRequestNotFound
+	}
+
+	if blocked, _ := res.Records[0].Get("blocked"); blocked.(bool) {
+		return ErrBlocked
+	}
+
+	s.notificationsService.Publish(models.NotificationTypeFollow, models.NewNotification(userID, requesterID, nil, models.NotificationTypeFollow))
 
 	return nil
 }
 
-func (s *userStore) UnfollowUser(followerID, followingID string) error {
-	_, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+func (s *userStore) DenyFollowRequest(ctx context.Context, userID, requesterID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
 		*s.driver,
-		`MATCH (f:User {id: $followerID})-[r:FOLLOWS]->(t:User {id: $followingID}) DELETE r`,
-		map[string]any{"followerID": followerID, "followingID": followingID},
+		`MATCH (f:User {id: $requesterID})-[r:FOLLOW_REQUEST]->(:User {id: $userID})
+		DELETE r
+		RETURN f.id AS id`,
+		map[string]any{"userID": userID, "requesterID": requesterID},
 		neo4j.EagerResultTransformer,
 	)
 	if err != nil {
 		return err
 	}
 
+	if len(res.Records) == 0 {
+		return ErrFollowRequestNotFound
+	}
+
 	return nil
 }
 
-func (s *userStore) GetFollowers(userID string, limit int, offset int) ([]*models.User, error) {
+// SetLocked locks or unlocks an account. Unlocking approves every pending
+// follow request, since they would no longer need approval, except those
+// from users on either side of a block.
+func (s *userStore) SetLocked(ctx context.Context, userID string, locked bool) error {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		SET u.isLocked = $locked, u.updatedAt = datetime()
+		WITH u
+		OPTIONAL MATCH (f:User)-[r:FOLLOW_REQUEST]->(u)
+		WHERE NOT $locked AND NOT EXISTS { MATCH (f)-[:BLOCKS]-(u) }
+		FOREACH (_ IN CASE WHEN r IS NULL THEN [] ELSE [1] END |
+			DELETE r
+			MERGE (f)-[:FOLLOWS]->(u)
+			ON CREATE SET f.followingCount = f.followingCount + 1, u.followersCount = u.followersCount + 1
+		)
+		RETURN DISTINCT u.id AS id`,
+		map[string]any{"userID": userID, "locked": locked},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetPassword replaces the user's password hash
+func (s *userStore) SetPassword(ctx context.Context, userID string, password string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		SET u.password = $password, u.updatedAt = datetime()
+		RETURN u.id AS id`,
+		map[string]any{"userID": userID, "password": password},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetEmailVerified marks the user's email as verified, as long as it is
+// still email. A user who changed their email has to verify the new one.
+func (s *userStore) SetEmailVerified(ctx context.Context, userID string, email string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID, email: $email})
+		SET u.emailVerified = true, u.updatedAt = datetime()
+		RETURN u.id AS id`,
+		map[string]any{"userID": userID, "email": email},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *userStore) GetFollowers(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
 		*s.driver,
 		`MATCH (f:User)-[:FOLLOWS]->(u:User {id: $userID}) LIMIT $limit OFFSET $offset RETURN f`,
 		map[string]any{"userID": userID, "limit": limit, "offset": offset},
@@ -294,9 +647,9 @@ func (s *userStore) GetFollowers(userID string, limit int, offset int) ([]*model
 	return users, nil
 }
 
-func (s *userStore) GetFollowing(userID string, limit int, offset int) ([]*models.User, error) {
+func (s *userStore) GetFollowing(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
 	res, err := neo4j.ExecuteQuery(
-		*s.dbCtx,
+		ctx,
 		*s.driver,
 		`MATCH (u:User {id: $userID})-[:FOLLOWS]->(f:User) LIMIT $limit OFFSET $offset RETURN f`,
 		map[string]any{"userID": userID, "limit": limit, "offset": offset},
@@ -319,6 +672,368 @@ func (s *userStore) GetFollowing(userID string, limit int, offset int) ([]*model
 	return users, nil
 }
 
+// BlockUser creates a BLOCKS relationship and removes any follow or pending
+// follow request between the two users, in either direction
+func (s *userStore) BlockUser(ctx context.Context, blockerID, blockedID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (blocker:User {id: $blockerID}), (blocked:User {id: $blockedID})
+		MERGE (blocker)-[b:BLOCKS]->(blocked)
+		ON CREATE SET b.createdAt = datetime()
+		WITH blocker, blocked
+		OPTIONAL MATCH (blocker)-[request:FOLLOW_REQUEST]-(blocked)
+		DELETE request
+		WITH DISTINCT blocker, blocked
+		OPTIONAL MATCH (blocker)-[outgoing:FOLLOWS]->(blocked)
+		OPTIONAL MATCH (blocked)-[incoming:FOLLOWS]->(blocker)
+		FOREACH (_ IN CASE WHEN outgoing IS NULL THEN [] ELSE [1] END |
+			SET blocker.followingCount = blocker.followingCount - 1, blocked.followersCount = blocked.followersCount - 1
+		)
+		FOREACH (_ IN CASE WHEN incoming IS NULL THEN [] ELSE [1] END |
+			SET blocked.followingCount = blocked.followingCount - 1, blocker.followersCount = blocker.followersCount - 1
+		)
+		DELETE outgoing, incoming
+		RETURN blocked.id AS id`,
+		map[string]any{"blockerID": blockerID, "blockedID": blockedID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *userStore) UnblockUser(ctx context.Context, blockerID, blockedID string) error {
+	_, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $blockerID})-[b:BLOCKS]->(:User {id: $blockedID}) DELETE b`,
+		map[string]any{"blockerID": blockerID, "blockedID": blockedID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *userStore) GetBlockedUsers(ctx context.Context, userID string, limit int, offset int) ([]*models.User, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[b:BLOCKS]->(f:User)
+		RETURN f
+		ORDER BY b.createdAt DESC
+		SKIP $offset LIMIT $limit`,
+		map[string]any{"userID": userID, "limit": limit, "offset": offset},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []*models.User{}
+	for _, record := range res.Records {
+		userNode, ok := record.Get("f")
+		if !ok {
+			return nil, fmt.Errorf("failed to extract user node")
+		}
+		user := extractUserFromNode(userNode)
+		user.Password = ""
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// IsBlocked reports whether either user blocks the other
+func (s *userStore) IsBlocked(ctx context.Context, userID, otherUserID string) (bool, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`RETURN EXISTS { MATCH (:User {id: $userID})-[:BLOCKS]-(:User {id: $otherUserID}) } AS blocked`,
+		map[string]any{"userID": userID, "otherUserID": otherUserID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	blocked, _ := res.Records[0].Get("blocked")
+	return blocked.(bool), nil
+}
+
+func (s *userStore) GetRelationship(ctx context.Context, viewerID, userID string) (*models.Relationship, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`OPTIONAL MATCH (viewer:User {id: $viewerID})
+		OPTIONAL MATCH (u:User {id: $userID})
+		RETURN
+			EXISTS { MATCH (viewer)-[:FOLLOWS]->(u) } AS isFollowing,
+			EXISTS { MATCH (u)-[:FOLLOWS]->(viewer) } AS followsYou,
+			EXISTS { MATCH (viewer)-[:BLOCKS]->(u) } AS isBlocked,
+			EXISTS { MATCH (u)-[:BLOCKS]->(viewer) } AS blockedBy`,
+		map[string]any{"viewerID": viewerID, "userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	record := res.Records[0]
+	isFollowing, _ := record.Get("isFollowing")
+	followsYou, _ := record.Get("followsYou")
+	isBlocked, _ := record.Get("isBlocked")
+	blockedBy, _ := record.Get("blockedBy")
+
+	return &models.Relationship{
+		IsFollowing: isFollowing.(bool),
+		FollowsYou:  followsYou.(bool),
+		IsBlocked:   isBlocked.(bool),
+		BlockedBy:   blockedBy.(bool),
+	}, nil
+}
+
+func (s *userStore) MuteUser(ctx context.Context, userID, mutedID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID}), (m:User {id: $mutedID})
+		MERGE (u)-[r:MUTES]->(m)
+		ON CREATE SET r.createdAt = datetime()
+		RETURN m.id AS id`,
+		map[string]any{"userID": userID, "mutedID": mutedID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *userStore) UnmuteUser(ctx context.Context, userID, mutedID string) error {
+	_, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[r:MUTES]->(:User {id: $mutedID}) DELETE r`,
+		map[string]any{"userID": userID, "mutedID": mutedID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddMutedWord mutes a word or phrase, optionally until expiresAt. Phrases
+// are matched case-insensitively so they are stored lower cased.
+func (s *userStore) AddMutedWord(ctx context.Context, userID string, phrase string, expiresAt *time.Time) (*models.MutedWord, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		CREATE (w:MutedWord {
+			id: $id,
+			phrase: $phrase,
+			expiresAt: $expiresAt,
+			createdAt: datetime()
+		})
+		MERGE (u)-[:MUTES_WORD]->(w)
+		RETURN w`,
+		map[string]any{
+			"userID":    userID,
+			"id":        uuid.New().String(),
+			"phrase":    strings.ToLower(strings.TrimSpace(phrase)),
+			"expiresAt": expiresAt,
+		},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Records) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	wordNode, ok := res.Records[0].Get("w")
+	if !ok {
+		return nil, fmt.Errorf("failed to extract muted word node")
+	}
+
+	return extractMutedWordFromNode(wordNode), nil
+}
+
+func (s *userStore) RemoveMutedWord(ctx context.Context, userID string, wordID string) error {
+	_, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (:User {id: $userID})-[:MUTES_WORD]->(w:MutedWord {id: $wordID}) DETACH DELETE w`,
+		map[string]any{"userID": userID, "wordID": wordID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MuteConversation mutes the whole conversation the tweet belongs to, which
+// stops reply notifications from it
+func (s *userStore) MuteConversation(ctx context.Context, userID string, tweetID string) error {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID}), (t:Tweet {id: $tweetID})
+		MATCH (root:Tweet {id: coalesce(t.conversationID, t.id)})
+		MERGE (u)-[r:MUTES_CONVERSATION]->(root)
+		ON CREATE SET r.createdAt = datetime()
+		RETURN root.id AS id`,
+		map[string]any{"userID": userID, "tweetID": tweetID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(res.Records) == 0 {
+		return ErrTweetNotFound
+	}
+
+	return nil
+}
+
+func (s *userStore) UnmuteConversation(ctx context.Context, userID string, tweetID string) error {
+	_, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (t:Tweet {id: $tweetID})
+		MATCH (:User {id: $userID})-[r:MUTES_CONVERSATION]->(:Tweet {id: coalesce(t.conversationID, t.id)})
+		DELETE r`,
+		map[string]any{"userID": userID, "tweetID": tweetID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *userStore) GetMutes(ctx context.Context, userID string) (*models.Mutes, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		RETURN
+			[(u)-[:MUTES]->(m:User) | m] AS users,
+			[(u)-[:MUTES_WORD]->(w:MutedWord) WHERE w.expiresAt IS NULL OR w.expiresAt > datetime() | w] AS words,
+			[(u)-[:MUTES_CONVERSATION]->(c:Tweet) | c.id] AS conversations`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Records) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	record := res.Records[0]
+	rawUsers, _ := record.Get("users")
+	rawWords, _ := record.Get("words")
+	rawConversations, _ := record.Get("conversations")
+
+	mutes := &models.Mutes{
+		Users:           []*models.PublicProfile{},
+		Words:           []models.MutedWord{},
+		ConversationIDs: []string{},
+	}
+	for _, userNode := range rawUsers.([]any) {
+		mutes.Users = append(mutes.Users, models.NewPublicProfile(extractUserFromNode(userNode)))
+	}
+	for _, wordNode := range rawWords.([]any) {
+		mutes.Words = append(mutes.Words, *extractMutedWordFromNode(wordNode))
+	}
+	for _, id := range rawConversations.([]any) {
+		mutes.ConversationIDs = append(mutes.ConversationIDs, id.(string))
+	}
+
+	return mutes, nil
+}
+
+// GetContentFilter loads the follows, blocks in both directions and active
+// mutes of a user
+func (s *userStore) GetContentFilter(ctx context.Context, userID string) (*models.ContentFilter, error) {
+	res, err := neo4j.ExecuteQuery(
+		ctx,
+		*s.driver,
+		`MATCH (u:User {id: $userID})
+		RETURN
+			[(u)-[:FOLLOWS]->(f:User) | f.id] AS following,
+			[(u)-[:BLOCKS]-(b:User) | b.id] AS blocked,
+			[(u)-[:MUTES]->(m:User) | m.id] AS muted,
+			[(u)-[:MUTES_WORD]->(w:MutedWord) WHERE w.expiresAt IS NULL OR w.expiresAt > datetime() | w.phrase] AS words,
+			[(u)-[:MUTES_CONVERSATION]->(c:Tweet) | c.id] AS conversations`,
+		map[string]any{"userID": userID},
+		neo4j.EagerResultTransformer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Records) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	record := res.Records[0]
+	following, _ := record.Get("following")
+	blocked, _ := record.Get("blocked")
+	muted, _ := record.Get("muted")
+	words, _ := record.Get("words")
+	conversations, _ := record.Get("conversations")
+
+	filter := &models.ContentFilter{
+		UserID:               userID,
+		FollowingUserIDs:     toStringSet(following),
+		BlockedUserIDs:       toStringSet(blocked),
+		MutedUserIDs:         toStringSet(muted),
+		MutedWords:           []string{},
+		MutedConversationIDs: toStringSet(conversations),
+	}
+	for _, word := range words.([]any) {
+		filter.MutedWords = append(filter.MutedWords, word.(string))
+	}
+
+	return filter, nil
+}
+
+func extractMutedWordFromNode(wordNode any) *models.MutedWord {
+	props := wordNode.(neo4j.Node).Props
+
+	return &models.MutedWord{
+		ID:        props["id"].(string),
+		Phrase:    props["phrase"].(string),
+		ExpiresAt: toTimePtr(props["expiresAt"]),
+		CreatedAt: props["createdAt"].(time.Time),
+	}
+}
+
 func extractUserFromNode(userNode any) *models.User {
 	props := userNode.(neo4j.Node).Props
 
@@ -327,6 +1042,7 @@ func extractUserFromNode(userNode any) *models.User {
 		Name:           props["name"].(string),
 		Email:          props["email"].(string),
 		Password:       props["password"].(string),
+		EmailVerified:  props["emailVerified"] == true,
 		Username:       props["username"].(string),
 		ProfilePicture: toStringPtr(props["profilePicture"]),
 		BannerPicture:  toStringPtr(props["bannerPicture"]),
@@ -346,8 +1062,7 @@ func extractUserFromNode(userNode any) *models.User {
 
 func extractUserFromEagerResult(res *neo4j.EagerResult) (*models.User, error) {
 	if len(res.Records) == 0 {
-		return nil, fmt.Errorf("no user found")
-
+		return nil, ErrUserNotFound
 	}
 
 	user, ok := res.Records[0].Get("u")
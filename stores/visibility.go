@@ -0,0 +1,32 @@
+package stores
+
+// ErrBlocked is returned when an action is refused because one of the two
+// users involved blocks the other
+var ErrBlocked = newError(ErrForbidden, "user is blocked")
+
+// ErrProtected is returned when a locked account's content is requested by
+// someone who doesn't follow it
+var ErrProtected = newError(ErrForbidden, "account is protected")
+
+// Cypher predicates shared by every read path that returns tweets. They
+// expect the tweet author to be bound to `u`, the tweet to `t` and the
+// viewer's ID to be passed as $currUserID.
+
+// notBlockedPredicate hides authors that block, or are blocked by, the viewer
+const notBlockedPredicate = `NOT EXISTS { MATCH (:User {id: $currUserID})-[:BLOCKS]-(u) }`
+
+// notProtectedPredicate hides the tweets of locked accounts from everyone
+// but the author and their approved followers
+const notProtectedPredicate = `(NOT coalesce(u.isLocked, false)
+	OR u.id = $currUserID
+	OR EXISTS { MATCH (:User {id: $currUserID})-[:FOLLOWS]->(u) })`
+
+// notMutedPredicate hides muted authors and tweets containing a muted word
+// that has not expired. Timelines and reply lists apply it, direct lookups
+// don't.
+const notMutedPredicate = `NOT EXISTS { MATCH (:User {id: $currUserID})-[:MUTES]->(u) }
+	AND NOT EXISTS {
+		MATCH (:User {id: $currUserID})-[:MUTES_WORD]->(w:MutedWord)
+		WHERE (w.expiresAt IS NULL OR w.expiresAt > datetime())
+			AND toLower(coalesce(t.content, '')) CONTAINS w.phrase
+	}`
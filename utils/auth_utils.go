@@ -1,70 +1,86 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"net"
 	"net/http"
-	"os"
-	"time"
+	"strings"
 
 	"github.com/aimrintech/x-backend/constants"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateJWT(userID string) (string, error) {
-	jwtSecret := loadJWTSecret()
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(constants.AUTH_TOKEN_EXPIRY).Unix(),
+// NewRefreshToken returns a refresh token for a session, and the hash to
+// store in its place. The token names the session, so it can be looked up
+// without scanning every session's hash.
+func NewRefreshToken(sessionID string) (token string, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	return sessionID + "." + encoded, HashToken(encoded), nil
+}
 
-	return token.SignedString(jwtSecret)
+// ParseRefreshToken splits a refresh token into its session ID and the hash
+// of its secret
+func ParseRefreshToken(token string) (sessionID string, hash string, err error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", errors.New("malformed refresh token")
+	}
+	return sessionID, HashToken(secret), nil
 }
 
-func ValidateJWT(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return loadJWTSecret(), nil
-	})
+// NewAPIToken returns a personal access token and the hash to store in its
+// place. Like refresh tokens, it names the token it belongs to.
+func NewAPIToken(tokenID string) (token string, hash string, err error) {
+	token, hash, err = NewRefreshToken(tokenID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			return "", errors.New("user_id not found in token")
-		}
-		return userID, nil
+	return constants.API_TOKEN_PREFIX + token, hash, nil
+}
+
+// ParseAPIToken splits a personal access token into its ID and the hash of
+// its secret
+func ParseAPIToken(token string) (tokenID string, hash string, err error) {
+	token, ok := strings.CutPrefix(token, constants.API_TOKEN_PREFIX)
+	if !ok {
+		return "", "", errors.New("malformed API token")
 	}
-	return "", errors.New("invalid token")
+	return ParseRefreshToken(token)
 }
 
-func VerifyJWT(tokenString string) (bool, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return loadJWTSecret(), nil
-	})
-	if err != nil {
-		return false, err
+// GetBearerToken returns the token in the Authorization header, or false if
+// the request has none
+func GetBearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
 	}
-	return token.Valid, nil
+	return token, true
+}
+
+// HashToken hashes a random token for storage. Tokens are long and random,
+// so unlike passwords they don't need a slow hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func SetAuthCookie(w http.ResponseWriter, token string) {
-	// w.Header().Set("Set-Cookie", "token="+token+"; HttpOnly; SameSite=Strict; Max-Age="+strconv.Itoa(int(constants.AUTH_TOKEN_EXPIRY.Seconds())))
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",
 		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   int(constants.AUTH_TOKEN_EXPIRY.Seconds()),
+		MaxAge:   int(constants.ACCESS_TOKEN_EXPIRY.Seconds()),
 	})
-
 }
 
 func GetAuthCookie(r *http.Request) (string, error) {
@@ -75,10 +91,6 @@ func GetAuthCookie(r *http.Request) (string, error) {
 	return cookie.Value, nil
 }
 
-func loadJWTSecret() []byte {
-	return []byte(os.Getenv("JWT_SECRET"))
-}
-
 func ClearAuthCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",
@@ -89,3 +101,83 @@ func ClearAuthCookie(w http.ResponseWriter) {
 		MaxAge:   -1,
 	})
 }
+
+// the refresh token is only ever sent to the auth routes
+const refreshCookiePath = "/api/auth"
+
+func SetRefreshCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    token,
+		Path:     refreshCookiePath,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(constants.REFRESH_TOKEN_EXPIRY.Seconds()),
+	})
+}
+
+func GetRefreshCookie(r *http.Request) (string, error) {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+func ClearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Path:     refreshCookiePath,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// ClearCookie deletes a cookie set on path
+func ClearCookie(w http.ResponseWriter, name string, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     path,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// ClientIP is the address the request came from. Proxy headers are ignored,
+// since anyone can set them.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// CSRFHeader carries a copy of the CSRF cookie on state-changing requests.
+// Other sites can make a browser send the cookie, but can't read it to copy
+// it into the header.
+const CSRFHeader = "X-CSRF-Token"
+
+// the CSRF cookie is not HttpOnly: the web app reads it when it is served
+// from the API's origin
+func SetCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    token,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(constants.REFRESH_TOKEN_EXPIRY.Seconds()),
+	})
+}
+
+func GetCSRFCookie(r *http.Request) (string, error) {
+	cookie, err := r.Cookie("csrf_token")
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
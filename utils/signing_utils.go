@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// DeriveKey derives a 32 byte key for purpose from secret with HKDF, so one
+// secret can key several uses without any two of them sharing a key
+func DeriveKey(secret []byte, purpose string) []byte {
+	key, err := hkdf.Key(sha256.New, secret, nil, purpose, 32)
+	if err != nil {
+		// HKDF only fails for keys longer than 255 hashes
+		panic(err)
+	}
+	return key
+}
+
+// SignValue appends an HMAC to value so it can be handed to the client and
+// trusted when it comes back. The key is derived from secret and purpose, so
+// a value signed for one use can't be replayed for another.
+func SignValue(secret []byte, purpose string, value string) string {
+	return value + "." + base64.RawURLEncoding.EncodeToString(signature(secret, purpose, value))
+}
+
+// VerifySignedValue returns the value SignValue signed for purpose
+func VerifySignedValue(secret []byte, purpose string, signed string) (string, error) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", ErrInvalidSignature
+	}
+	value := signed[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil || !hmac.Equal(sig, signature(secret, purpose, value)) {
+		return "", ErrInvalidSignature
+	}
+	return value, nil
+}
+
+func signature(secret []byte, purpose string, value string) []byte {
+	mac := hmac.New(sha256.New, DeriveKey(secret, "signing:"+purpose))
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// EncryptValue encrypts value for storage, with a key derived from secret
+// and purpose
+func EncryptValue(secret []byte, purpose string, value string) (string, error) {
+	aead, err := newAEAD(secret, purpose)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// DecryptValue returns the value EncryptValue encrypted for purpose
+func DecryptValue(secret []byte, purpose string, encrypted string) (string, error) {
+	aead, err := newAEAD(secret, purpose)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+	value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("invalid encrypted value")
+	}
+	return string(value), nil
+}
+
+func newAEAD(secret []byte, purpose string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(DeriveKey(secret, "encryption:"+purpose))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the SHA1 test vectors of RFC 6238, truncated to six digits
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, "at %d", unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+
+	code, err := TOTPCode(secret, TOTPStep(now.Add(-TOTPPeriod)))
+	require.NoError(t, err)
+	step, ok := VerifyTOTP(secret, code, now)
+	assert.True(t, ok, "the previous code is still accepted")
+	assert.Equal(t, TOTPStep(now)-1, step)
+
+	_, ok = VerifyTOTP(secret, code[:3]+" "+code[3:], now)
+	assert.True(t, ok, "spaces are ignored")
+
+	_, ok = VerifyTOTP(secret, code, now.Add(2*TOTPPeriod))
+	assert.False(t, ok, "old codes expire")
+	_, ok = VerifyTOTP(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("X", "alice@example.com", "SECRET")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/X:alice@example.com?"), uri)
+	assert.Contains(t, uri, "secret=SECRET")
+	assert.Contains(t, uri, "issuer=X")
+}
+
+func TestEncryptValue(t *testing.T) {
+	secret := []byte("secret")
+	encrypted, err := EncryptValue(secret, "totp", "value")
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "value")
+
+	value, err := DecryptValue(secret, "totp", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	_, err = DecryptValue(secret, "other", encrypted)
+	assert.Error(t, err, "the purpose is part of the key")
+	_, err = DecryptValue([]byte("other"), "totp", encrypted)
+	assert.Error(t, err)
+}
+
+func TestDeriveKey(t *testing.T) {
+	secret := []byte("secret")
+	key := DeriveKey(secret, "signing")
+	assert.Len(t, key, 32)
+	assert.Equal(t, key, DeriveKey(secret, "signing"))
+	assert.NotEqual(t, key, DeriveKey(secret, "encryption"))
+	assert.NotEqual(t, key, DeriveKey([]byte("other"), "signing"))
+
+	// a value signed for one purpose doesn't verify for another
+	signed := SignValue(key, "email", "value")
+	value, err := VerifySignedValue(key, "email", signed)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	_, err = VerifySignedValue(key, "oauth", signed)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
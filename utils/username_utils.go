@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aimrintech/x-backend/constants"
+)
+
+var (
+	ErrInvalidUsername  = errors.New("username must be 2-32 letters, numbers or underscores")
+	ErrReservedUsername = errors.New("username is reserved")
+)
+
+// MaxUsernameLength is the longest username ValidateUsername accepts
+const MaxUsernameLength = 32
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{2,` + strconv.Itoa(MaxUsernameLength) + `}$`)
+
+// ValidateUsername checks the format of a username and that it isn't
+// reserved. It does not check availability.
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	if constants.RESERVED_USERNAMES[strings.ToLower(username)] {
+		return ErrReservedUsername
+	}
+	return nil
+}